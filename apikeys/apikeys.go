@@ -0,0 +1,162 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"github.com/mattn/go-sqlite3"
+	"log"
+	"strings"
+	"time"
+)
+
+//go:embed create-table-api-keys.sql
+var createTableApiKeys string
+
+// Prefix is used at the start of every key to tell them apart from MJWT tokens
+const Prefix = "violet_"
+
+// ErrInvalidKey is returned when a key doesn't exist
+var ErrInvalidKey = errors.New("invalid api key")
+
+// ErrKeyExists is returned when creating a key with a name which is in use
+var ErrKeyExists = errors.New("api key already exists")
+
+// Keys stores long-lived API keys, only the SHA-256 hash of each key is saved.
+type Keys struct {
+	db *sql.DB
+}
+
+// Key is the information stored about an API key, the key itself is only
+// available when it is created.
+type Key struct {
+	Id       int64     `json:"id"`
+	Name     string    `json:"name"`
+	Subject  string    `json:"subject"`
+	Perms    []string  `json:"perms"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used,omitempty"`
+}
+
+// New creates the key store and initialises the api_keys table.
+func New(db *sql.DB) *Keys {
+	_, err := db.Exec(createTableApiKeys)
+	if err != nil {
+		log.Printf("[WARN] Failed to generate 'api_keys' table\n")
+		return nil
+	}
+	return &Keys{db: db}
+}
+
+// hashKey returns the hex encoded SHA-256 hash of the key
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// Create generates a new key with the permissions and returns the key, the
+// subject defaults to "key:<name>".
+func (k *Keys) Create(name, subject string, perms []string) (string, Key, error) {
+	if subject == "" {
+		subject = "key:" + name
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", Key{}, err
+	}
+	token := Prefix + hex.EncodeToString(b)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	exec, err := k.db.Exec(`INSERT INTO api_keys (name, subject, hash, perms, created) VALUES (?, ?, ?, ?, ?)`, name, subject, hashKey(token), strings.Join(perms, " "), now.Unix())
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		// the name is unique so a second key with the same name fails to insert
+		return "", Key{}, ErrKeyExists
+	}
+	if err != nil {
+		return "", Key{}, err
+	}
+	id, err := exec.LastInsertId()
+	if err != nil {
+		return "", Key{}, err
+	}
+	return token, Key{Id: id, Name: name, Subject: subject, Perms: perms, Created: now}, nil
+}
+
+// Lookup finds the key and updates the time it was last used.
+func (k *Keys) Lookup(token string) (Key, error) {
+	if !strings.HasPrefix(token, Prefix) {
+		return Key{}, ErrInvalidKey
+	}
+	hash := hashKey(token)
+	key, err := scanKey(k.db.QueryRow(`SELECT id, name, subject, perms, created, last_used FROM api_keys WHERE hash = ?`, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Key{}, ErrInvalidKey
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	if _, err := k.db.Exec(`UPDATE api_keys SET last_used = ? WHERE id = ?`, time.Now().Unix(), key.Id); err != nil {
+		log.Printf("[ApiKeys] Failed to update last used time: %s\n", err)
+	}
+	return key, nil
+}
+
+// List returns every key ordered by id.
+func (k *Keys) List() ([]Key, error) {
+	rows, err := k.db.Query(`SELECT id, name, subject, perms, created, last_used FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]Key, 0)
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, key)
+	}
+	return list, rows.Err()
+}
+
+// Delete revokes the key with the name, sql.ErrNoRows is returned if it doesn't
+// exist.
+func (k *Keys) Delete(name string) error {
+	exec, err := k.db.Exec(`DELETE FROM api_keys WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	n, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanKey(s scanner) (Key, error) {
+	var key Key
+	var perms string
+	var created, lastUsed int64
+	if err := s.Scan(&key.Id, &key.Name, &key.Subject, &perms, &created, &lastUsed); err != nil {
+		return Key{}, err
+	}
+	key.Perms = strings.Fields(perms)
+	key.Created = time.Unix(created, 0).UTC()
+	if lastUsed != 0 {
+		key.LastUsed = time.Unix(lastUsed, 0).UTC()
+	}
+	return key, nil
+}
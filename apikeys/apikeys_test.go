@@ -0,0 +1,47 @@
+package apikeys
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestKeys_Lookup(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestKeys_Lookup?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	k := New(db)
+
+	token, key, err := k.Create("deploy", "", []string{"violet:route", "violet:redirect"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, Prefix))
+	assert.Equal(t, "key:deploy", key.Subject)
+
+	// only the hash is stored
+	var hash string
+	assert.NoError(t, db.QueryRow(`SELECT hash FROM api_keys WHERE id = ?`, key.Id).Scan(&hash))
+	assert.NotContains(t, hash, strings.TrimPrefix(token, Prefix))
+
+	found, err := k.Lookup(token)
+	assert.NoError(t, err)
+	assert.Equal(t, key.Id, found.Id)
+	assert.Equal(t, []string{"violet:route", "violet:redirect"}, found.Perms)
+
+	_, err = k.Lookup(Prefix + "0000")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = k.Lookup("not-a-key")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, _, err = k.Create("deploy", "", nil)
+	assert.ErrorIs(t, err, ErrKeyExists)
+
+	assert.NoError(t, k.Delete("deploy"))
+	assert.ErrorIs(t, k.Delete("deploy"), sql.ErrNoRows)
+	_, err = k.Lookup(token)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	list, err := k.List()
+	assert.NoError(t, err)
+	assert.Len(t, list, 0)
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    name      TEXT UNIQUE,
+    subject   TEXT,
+    hash      TEXT UNIQUE,
+    perms     TEXT DEFAULT '',
+    created   INTEGER,
+    last_used INTEGER DEFAULT 0
+);
@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"database/sql"
+	_ "embed"
+	"log"
+	"strings"
+	"time"
+)
+
+//go:embed create-table-audit.sql
+var createTableAudit string
+
+// likeEscaper escapes the wildcard characters in LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Log stores a record of each change made using the API.
+type Log struct {
+	db *sql.DB
+}
+
+// Entry is a single change made using the API, Old and New contain the value
+// before the change and the request body if available.
+type Entry struct {
+	Id     int64     `json:"id"`
+	Actor  string    `json:"actor"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	Old    string    `json:"old,omitempty"`
+	New    string    `json:"new,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Filter limits the entries returned by List, empty fields match every entry
+type Filter struct {
+	Actor  string    // exact actor
+	Method string    // exact method
+	Path   string    // path prefix
+	Since  time.Time // entries at or after this time
+	Until  time.Time // entries before this time
+}
+
+// execer is implemented by both sql.DB and sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// New creates the audit log and initialises the api_audit table.
+func New(db *sql.DB) *Log {
+	_, err := db.Exec(createTableAudit)
+	if err != nil {
+		log.Printf("[WARN] Failed to generate 'api_audit' table\n")
+		return nil
+	}
+	return &Log{db: db}
+}
+
+// Record adds the entry to the log, the current time is used if the entry
+// doesn't have a time.
+func (l *Log) Record(e Entry) error {
+	return record(l.db, e)
+}
+
+// RecordTx adds the entry using the transaction of the change it describes, so
+// the entry is only kept if the change is committed.
+func (l *Log) RecordTx(tx *sql.Tx, e Entry) error {
+	return record(tx, e)
+}
+
+func record(db execer, e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	_, err := db.Exec(`INSERT INTO api_audit (actor, method, path, status, old, new, time) VALUES (?, ?, ?, ?, ?, ?, ?)`, e.Actor, e.Method, e.Path, e.Status, e.Old, e.New, e.Time.Unix())
+	return err
+}
+
+// List returns the entries matching the filter with the newest first and the
+// total number of matching entries for pagination.
+func (l *Log) List(f Filter, offset, limit int) ([]Entry, int, error) {
+	clauses := make([]string, 0, 4)
+	args := make([]any, 0, 4)
+	if f.Actor != "" {
+		clauses = append(clauses, `actor = ?`)
+		args = append(args, f.Actor)
+	}
+	if f.Method != "" {
+		clauses = append(clauses, `method = ?`)
+		args = append(args, f.Method)
+	}
+	if f.Path != "" {
+		clauses = append(clauses, `path LIKE ? ESCAPE '\'`)
+		args = append(args, likeEscaper.Replace(f.Path)+"%")
+	}
+	if !f.Since.IsZero() {
+		clauses = append(clauses, `time >= ?`)
+		args = append(args, f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		clauses = append(clauses, `time < ?`)
+		args = append(args, f.Until.Unix())
+	}
+	where := ""
+	if len(clauses) > 0 {
+		where = " WHERE " + strings.Join(clauses, " AND ")
+	}
+
+	var total int
+	if err := l.db.QueryRow(`SELECT COUNT(*) FROM api_audit`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := l.db.Query(`SELECT id, actor, method, path, status, old, new, time FROM api_audit`+where+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	list := make([]Entry, 0)
+	for rows.Next() {
+		var e Entry
+		var t int64
+		if err := rows.Scan(&e.Id, &e.Actor, &e.Method, &e.Path, &e.Status, &e.Old, &e.New, &t); err != nil {
+			return nil, 0, err
+		}
+		e.Time = time.Unix(t, 0).UTC()
+		list = append(list, e)
+	}
+	return list, total, rows.Err()
+}
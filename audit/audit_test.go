@@ -0,0 +1,37 @@
+package audit
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLog_List(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestLog_List?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	l := New(db)
+
+	start := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, l.Record(Entry{Actor: "abc", Method: "PUT", Path: "/domain/example.com", Status: 200, Time: start}))
+	assert.NoError(t, l.Record(Entry{Actor: "abc", Method: "PATCH", Path: "/route/1", Status: 200, Old: `{"dst":"a"}`, New: `{"dst":"b"}`, Time: start.Add(time.Hour)}))
+	assert.NoError(t, l.Record(Entry{Actor: "other", Method: "POST", Path: "/route", Status: 200, Time: start.Add(2 * time.Hour)}))
+
+	list, total, err := l.List(Filter{}, 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []Entry{
+		{Id: 3, Actor: "other", Method: "POST", Path: "/route", Status: 200, Time: start.Add(2 * time.Hour)},
+		{Id: 2, Actor: "abc", Method: "PATCH", Path: "/route/1", Status: 200, Old: `{"dst":"a"}`, New: `{"dst":"b"}`, Time: start.Add(time.Hour)},
+	}, list)
+
+	list, total, err = l.List(Filter{Actor: "abc", Path: "/route"}, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, int64(2), list[0].Id)
+
+	_, total, err = l.List(Filter{Since: start.Add(time.Hour), Until: start.Add(2 * time.Hour)}, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+}
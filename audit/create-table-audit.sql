@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_audit
+(
+    id     INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor  TEXT,
+    method TEXT,
+    path   TEXT,
+    status INTEGER,
+    old    TEXT DEFAULT '',
+    new    TEXT DEFAULT '',
+    time   INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS api_audit_time ON api_audit (time);
@@ -0,0 +1,76 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/MrMelon54/certgen"
+	"sort"
+)
+
+// certList stores the certificates loaded for a single name, only one
+// certificate is kept for each public key algorithm.
+//
+// The list is ordered with the preferred certificate first.
+type certList []*tls.Certificate
+
+// add returns the list with the certificate added, replacing any existing
+// certificate with the same public key algorithm.
+func (l certList) add(cert *tls.Certificate) certList {
+	algo := keyAlgorithm(cert)
+	for i, j := range l {
+		if keyAlgorithm(j) == algo {
+			l[i] = cert
+			return l
+		}
+	}
+	l = append(l, cert)
+	sort.SliceStable(l, func(i, j int) bool {
+		return keyPreference(keyAlgorithm(l[i])) < keyPreference(keyAlgorithm(l[j]))
+	})
+	return l
+}
+
+// first returns the preferred certificate or nil if the list is empty.
+func (l certList) first() *tls.Certificate {
+	if len(l) == 0 {
+		return nil
+	}
+	return l[0]
+}
+
+// forHello returns the preferred certificate supported by the client, if the
+// client supports none of the certificates then the last certificate is used
+// as it has the widest compatibility.
+func (l certList) forHello(info *tls.ClientHelloInfo) *tls.Certificate {
+	for _, cert := range l {
+		if info.SupportsCertificate(cert) == nil {
+			return cert
+		}
+	}
+	if len(l) == 0 {
+		return nil
+	}
+	return l[len(l)-1]
+}
+
+// keyAlgorithm returns the public key algorithm of the leaf
+func keyAlgorithm(cert *tls.Certificate) x509.PublicKeyAlgorithm {
+	if leaf := certgen.TlsLeaf(cert); leaf != nil {
+		return leaf.PublicKeyAlgorithm
+	}
+	return x509.UnknownPublicKeyAlgorithm
+}
+
+// keyPreference orders ECDSA before Ed25519 as few clients support Ed25519,
+// RSA is last for legacy clients
+func keyPreference(algo x509.PublicKeyAlgorithm) int {
+	switch algo {
+	case x509.ECDSA:
+		return 0
+	case x509.Ed25519:
+		return 1
+	case x509.RSA:
+		return 2
+	}
+	return 3
+}
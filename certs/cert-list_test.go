@@ -0,0 +1,47 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCerts_GetCertForHello(t *testing.T) {
+	rsaTls := genTestServerCert(t, "example.com")
+
+	// generate an ecdsa certificate using the self-signed generator
+	gen := New(fstest.MapFS{}, fstest.MapFS{}, false)
+	gen.EnableSelfSignedFallback()
+	assert.NoError(t, gen.SetSelfSignedKeyType(KeyTypeEcdsa))
+	ecdsaCert := gen.GetCertForDomain("example.com")
+
+	certs := New(fstest.MapFS{}, fstest.MapFS{}, false)
+	rsaCert, err := tls.X509KeyPair(rsaTls.GetCertPem(), rsaTls.GetKeyPem())
+	assert.NoError(t, err)
+	saveCert(certs.m, &rsaCert)
+	saveCert(certs.m, ecdsaCert)
+	assert.Len(t, certs.m["example.com"], 2)
+
+	// modern clients get the ecdsa certificate
+	cert := certs.GetCertForHello(&tls.ClientHelloInfo{
+		ServerName:        "example.com",
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256},
+	})
+	assert.Equal(t, x509.ECDSA, keyAlgorithm(cert))
+
+	// legacy clients get the rsa certificate
+	cert = certs.GetCertForHello(&tls.ClientHelloInfo{
+		ServerName:        "example.com",
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.PSSWithSHA256},
+	})
+	assert.Equal(t, x509.RSA, keyAlgorithm(cert))
+
+	// loading another rsa certificate replaces the existing one
+	saveCert(certs.m, &rsaCert)
+	assert.Len(t, certs.m["example.com"], 2)
+	assert.Len(t, certs.GetAllCerts(), 2)
+}
@@ -0,0 +1,64 @@
+package certs
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+)
+
+// SetCertbotLive sets the certbot live directory, each sub-directory contains
+// the fullchain.pem and privkey.pem files for a certificate. These are loaded
+// alongside the certificates from the certificate directory or secret store.
+func (c *Certs) SetCertbotLive(live fs.FS) {
+	c.live = live
+}
+
+// internalCompileCertbot is a hidden internal method for loading the
+// certificates from the certbot live directory
+func (c *Certs) internalCompileCertbot(m map[string]certList, d map[*tls.Certificate][]string) error {
+	dirs, err := fs.ReadDir(c.live, ".")
+	if err != nil {
+		return fmt.Errorf("failed to read certbot live dir: %w", err)
+	}
+
+	for _, i := range dirs {
+		// certbot stores a README next to the certificate directories
+		if !i.IsDir() {
+			continue
+		}
+		name := i.Name()
+		chainName := path.Join(name, "fullchain.pem")
+		keyName := path.Join(name, "privkey.pem")
+
+		// try to read the full chain, skip directories without one
+		certData, err := fs.ReadFile(c.live, chainName)
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Printf("[Certs] Skipping certbot directory '%s' without fullchain.pem\n", name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read cert file '%s': %w", chainName, err)
+		}
+
+		// try to read key file
+		keyData, err := c.readKeyFile(c.live, keyName)
+		if err != nil {
+			return fmt.Errorf("failed to read key file '%s': %w", keyName, err)
+		}
+
+		// load key pair
+		pair, err := c.loadKeyPair(certData, keyData)
+		if err != nil {
+			return fmt.Errorf("failed to load x509 key pair '%s + %s': %w", chainName, keyName, err)
+		}
+
+		// the directory name can have a numeric suffix so the names are not
+		// checked against it
+		saveCert(m, &pair)
+		d[&pair] = diagnoseCert(chainName, "", &pair)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package certs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCerts_SetCertbotLive(t *testing.T) {
+	serverTls := genTestServerCert(t, "example.com")
+	certs := New(nil, nil, false)
+	certs.SetCertbotLive(fstest.MapFS{
+		"README":                       {Data: []byte("certbot readme")},
+		"example.com/fullchain.pem":    {Data: serverTls.GetCertPem()},
+		"example.com/privkey.pem":      {Data: serverTls.GetKeyPem()},
+		"example.com/cert.pem":         {Data: serverTls.GetCertPem()},
+		"empty.example.com/README.txt": {Data: []byte("no certificate")},
+	})
+	assert.NoError(t, certs.internalCompile(certs.m, certs.d))
+	assert.NotNil(t, certs.GetCertForDomain("example.com"))
+	assert.Nil(t, certs.GetCertForDomain("empty.example.com"))
+
+	// missing private key
+	certs.SetCertbotLive(fstest.MapFS{
+		"example.com/fullchain.pem": {Data: serverTls.GetCertPem()},
+	})
+	assert.Error(t, certs.internalCompile(certs.m, certs.d))
+}
@@ -2,40 +2,72 @@ package certs
 
 import (
 	"crypto/tls"
-	"crypto/x509/pkix"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/MrMelon54/certgen"
 	"github.com/MrMelon54/rescheduler"
 	"github.com/MrMelon54/violet/utils"
+	"golang.org/x/sync/singleflight"
 	"io/fs"
 	"log"
-	"math/big"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+var (
+	ErrReadOnly      = errors.New("certificate directories are read only")
+	ErrInvalidDomain = errors.New("invalid certificate domain")
+	ErrInvalidCert   = errors.New("invalid certificate")
+)
+
 // Certs is the certificate loader and management system.
 type Certs struct {
 	cDir fs.FS
 	kDir fs.FS
+	st   SecretStore
+	live fs.FS
+	rs   *RemoteSigner
+	kp   []byte
+	p12  map[string]string
+	def  *tls.Certificate
 	ss   bool
 	s    *sync.RWMutex
-	m    map[string]*tls.Certificate
-	ca   *certgen.CertGen
-	sn   atomic.Int64
+	m    map[string]certList
+	d    map[*tls.Certificate][]string
 	r    *rescheduler.Rescheduler
+
+	// changes made by the last compile and the subscribers to new changes
+	diff    CompileDiff
+	subLock *sync.RWMutex
+	subs    map[chan CompileDiff]struct{}
+
+	// self-signed certificates generated on demand
+	ssFallback bool
+	ssKey      KeyType
+	ssLock     *sync.RWMutex
+	ssMap      map[string]*tls.Certificate
+	ssGroup    singleflight.Group
+	ca         *certgen.CertGen
+	sn         atomic.Int64
 }
 
 // New creates a new cert list
 func New(certDir fs.FS, keyDir fs.FS, selfCert bool) *Certs {
 	c := &Certs{
-		cDir: certDir,
-		kDir: keyDir,
-		ss:   selfCert,
-		s:    &sync.RWMutex{},
-		m:    make(map[string]*tls.Certificate),
+		cDir:    certDir,
+		kDir:    keyDir,
+		ss:      selfCert,
+		s:       &sync.RWMutex{},
+		m:       make(map[string]certList),
+		d:       make(map[*tls.Certificate][]string),
+		subLock: &sync.RWMutex{},
+		subs:    make(map[chan CompileDiff]struct{}),
+		ssLock:  &sync.RWMutex{},
+		ssMap:   make(map[string]*tls.Certificate),
 	}
 
 	// the rescheduler isn't even used in self cert mode so why initialise it
@@ -45,59 +77,90 @@ func New(certDir fs.FS, keyDir fs.FS, selfCert bool) *Certs {
 
 	// in self-signed mode generate a CA certificate to sign other certificates
 	if c.ss {
-		ca, err := certgen.MakeCaTls(4096, pkix.Name{
-			Country:            []string{"GB"},
-			Organization:       []string{"Violet"},
-			OrganizationalUnit: []string{"Development"},
-			SerialNumber:       "0",
-			CommonName:         fmt.Sprintf("%d.violet.test", time.Now().Unix()),
-		}, big.NewInt(0), func(now time.Time) time.Time {
-			return now.AddDate(10, 0, 0)
-		})
-		if err != nil {
-			log.Fatalln("Failed to generate CA cert for self-signed mode:", err)
-		}
-		c.ca = ca
+		c.genCa()
 	}
 	return c
 }
 
+// NewFromStore creates a new cert list which loads the certificates and keys
+// from a secret store instead of the filesystem.
+func NewFromStore(store SecretStore) *Certs {
+	c := New(nil, nil, false)
+	c.st = store
+	return c
+}
+
 func (c *Certs) GetCertForDomain(domain string) *tls.Certificate {
-	// safety read lock
-	c.s.RLock()
-	defer c.s.RUnlock()
+	if cert := c.getLoadedCerts(domain).first(); cert != nil {
+		return cert
+	}
 
-	// lookup and return cert
-	if cert, ok := c.m[domain]; ok {
+	// if self-signed certificates are enabled then generate a certificate
+	if c.ss || c.ssFallback {
+		return c.getSelfSignedCert(domain)
+	}
+
+	// no cert found
+	return nil
+}
+
+// GetCertForHello returns the certificate for the server name in the client
+// hello, when multiple certificates are loaded for the name the preferred
+// certificate supported by the client is selected.
+func (c *Certs) GetCertForHello(info *tls.ClientHelloInfo) *tls.Certificate {
+	if cert := c.getLoadedCerts(info.ServerName).forHello(info); cert != nil {
 		return cert
 	}
 
-	// if self-signed certificate is enabled then generate a certificate
-	if c.ss {
-		sn := c.sn.Add(1)
-		serverTls, err := certgen.MakeServerTls(c.ca, 4096, pkix.Name{
-			Country:            []string{"GB"},
-			Organization:       []string{domain},
-			OrganizationalUnit: []string{domain},
-			SerialNumber:       fmt.Sprintf("%d", sn),
-			CommonName:         domain,
-		}, big.NewInt(sn), func(now time.Time) time.Time {
-			return now.AddDate(10, 0, 0)
-		}, []string{domain}, nil)
-		if err != nil {
-			return nil
-		}
+	// if self-signed certificates are enabled then generate a certificate
+	if c.ss || c.ssFallback {
+		return c.getSelfSignedCert(info.ServerName)
+	}
+
+	// no cert found
+	return nil
+}
+
+// SetDefaultCert loads the PEM encoded certificate and private key used when
+// no other certificate is available.
+func (c *Certs) SetDefaultCert(certPem, keyPem []byte) error {
+	pair, err := c.loadKeyPair(certPem, keyPem)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCert, err)
+	}
+	c.def = &pair
+	return nil
+}
+
+// GetDefaultCert returns the default certificate or nil if it is not set.
+func (c *Certs) GetDefaultCert() *tls.Certificate {
+	return c.def
+}
 
-		// save the generated leaf for loading if the domain is requested again
-		leaf := serverTls.GetTlsLeaf()
-		c.m[domain] = &leaf
-		return &leaf
+// GetCertProblems returns the problems found with the certificate chain when
+// it was loaded.
+func (c *Certs) GetCertProblems(cert *tls.Certificate) []string {
+	c.s.RLock()
+	defer c.s.RUnlock()
+	return c.d[cert]
+}
+
+// getLoadedCerts returns the loaded certificates or wildcard certificates for
+// the domain.
+func (c *Certs) getLoadedCerts(domain string) certList {
+	// safety read lock
+	c.s.RLock()
+	defer c.s.RUnlock()
+
+	// lookup and return cert
+	if certs, ok := c.m[domain]; ok {
+		return certs
 	}
 
 	// lookup and return wildcard cert
 	if wildcardDomain, ok := utils.ReplaceSubdomainWithWildcard(domain); ok {
-		if cert, ok := c.m[wildcardDomain]; ok {
-			return cert
+		if certs, ok := c.m[wildcardDomain]; ok {
+			return certs
 		}
 	}
 
@@ -105,6 +168,84 @@ func (c *Certs) GetCertForDomain(domain string) *tls.Certificate {
 	return nil
 }
 
+// GetAllCerts returns each loaded certificate once, sorted by the first DNS
+// name in the leaf.
+func (c *Certs) GetAllCerts() []*tls.Certificate {
+	// safety read lock
+	c.s.RLock()
+	defer c.s.RUnlock()
+
+	// certificates are stored under each dns name so remove duplicates
+	seen := make(map[*tls.Certificate]struct{}, len(c.m))
+	all := make([]*tls.Certificate, 0, len(c.m))
+	for _, certs := range c.m {
+		for _, cert := range certs {
+			if _, ok := seen[cert]; ok {
+				continue
+			}
+			seen[cert] = struct{}{}
+			all = append(all, cert)
+		}
+	}
+
+	// include the generated self-signed certificates
+	c.ssLock.RLock()
+	for _, cert := range c.ssMap {
+		all = append(all, cert)
+	}
+	c.ssLock.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		return firstDnsName(all[i]) < firstDnsName(all[j])
+	})
+	return all
+}
+
+// firstDnsName returns the first DNS name in the leaf or an empty string.
+func firstDnsName(cert *tls.Certificate) string {
+	if leaf := certgen.TlsLeaf(cert); leaf != nil && len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames[0]
+	}
+	return ""
+}
+
+// CheckExpiry returns the leaf of each served certificate which expires within
+// the provided duration, in self-signed mode this includes the CA certificate.
+func (c *Certs) CheckExpiry(within time.Duration) []*x509.Certificate {
+	all := c.GetAllCerts()
+	if c.ca != nil {
+		caTls := c.ca.GetTlsLeaf()
+		all = append(all, &caTls)
+	}
+
+	deadline := time.Now().Add(within)
+	expiring := make([]*x509.Certificate, 0)
+	for _, cert := range all {
+		leaf := certgen.TlsLeaf(cert)
+		if leaf != nil && leaf.NotAfter.Before(deadline) {
+			expiring = append(expiring, leaf)
+		}
+	}
+	return expiring
+}
+
+// WatchExpiry logs a warning for each certificate which expires within the
+// provided duration, the check is repeated every interval. If notify is not nil
+// it is called with each expiring certificate.
+func (c *Certs) WatchExpiry(within, interval time.Duration, notify func(leaf *x509.Certificate)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		for _, leaf := range c.CheckExpiry(within) {
+			log.Printf("[Certs] WARNING: certificate '%s' for %v expires at %s\n", leaf.Subject.CommonName, leaf.DNSNames, leaf.NotAfter.Format(time.RFC3339))
+			if notify != nil {
+				notify(leaf)
+			}
+		}
+		<-t.C
+	}
+}
+
 // Compile loads the certificates and keys from the directories.
 //
 // This method makes use of the rescheduler instead of just ignoring multiple
@@ -118,25 +259,56 @@ func (c *Certs) Compile() {
 }
 
 func (c *Certs) threadCompile() {
+	if err := c.compileNow(); err != nil {
+		log.Printf("[Certs] Compile failed: %s\n", err)
+	}
+}
+
+// CompileSync loads the certificates and keys and waits for the compile to
+// finish.
+func (c *Certs) CompileSync() error {
+	// don't bother compiling in self-signed mode
+	if c.ss {
+		return nil
+	}
+	return c.compileNow()
+}
+
+// compileNow loads the certificates and keys and replaces the current maps
+func (c *Certs) compileNow() error {
 	// new map
-	certMap := make(map[string]*tls.Certificate)
+	certMap := make(map[string]certList)
+	diagMap := make(map[*tls.Certificate][]string)
 
 	// compile map and check errors
-	err := c.internalCompile(certMap)
+	err := c.internalCompile(certMap, diagMap)
 	if err != nil {
-		log.Printf("[Certs] Compile failed: %s\n", err)
-		return
+		return err
 	}
 
 	// lock while replacing the map
 	c.s.Lock()
+	diff := diffCertMaps(c.m, certMap)
 	c.m = certMap
+	c.d = diagMap
+	c.diff = diff
 	c.s.Unlock()
+
+	c.publishDiff(diff)
+	return nil
 }
 
 // internalCompile is a hidden internal method for loading the certificate and
 // key files
-func (c *Certs) internalCompile(m map[string]*tls.Certificate) error {
+func (c *Certs) internalCompile(m map[string]certList, d map[*tls.Certificate][]string) error {
+	if c.live != nil {
+		if err := c.internalCompileCertbot(m, d); err != nil {
+			return err
+		}
+	}
+	if c.st != nil {
+		return c.internalCompileStore(m, d)
+	}
 	if c.cDir == nil {
 		return nil
 	}
@@ -158,6 +330,20 @@ func (c *Certs) internalCompile(m map[string]*tls.Certificate) error {
 
 		// get file name and extension
 		name := i.Name()
+		if isPkcs12Name(name) {
+			// try to read and decode the bundle
+			bundleData, err := fs.ReadFile(c.cDir, name)
+			if err != nil {
+				return fmt.Errorf("failed to read pkcs12 file '%s': %w", name, err)
+			}
+			cert, err := c.loadPkcs12(name, bundleData)
+			if err != nil {
+				return fmt.Errorf("failed to load pkcs12 file '%s': %w", name, err)
+			}
+			saveCert(m, cert)
+			d[cert] = diagnoseCert(name, "", cert)
+			continue
+		}
 		if !strings.HasSuffix(name, ".cert.pem") {
 			continue
 		}
@@ -170,27 +356,158 @@ func (c *Certs) internalCompile(m map[string]*tls.Certificate) error {
 		}
 
 		// try to read key file
-		keyData, err := fs.ReadFile(c.kDir, keyName)
+		keyData, err := c.readKeyFile(c.kDir, keyName)
 		if err != nil {
 			return fmt.Errorf("failed to read key file '%s': %w", keyName, err)
 		}
 
 		// load key pair
-		pair, err := tls.X509KeyPair(certData, keyData)
+		pair, err := c.loadKeyPair(certData, keyData)
 		if err != nil {
 			return fmt.Errorf("failed to load x509 key pair '%s + %s': %w", name, keyName, err)
 		}
 
-		// load tls leaf
-		cert := &pair
-		leaf := certgen.TlsLeaf(cert)
+		saveCert(m, &pair)
+		d[&pair] = diagnoseCert(name, strings.TrimSuffix(name, ".cert.pem"), &pair)
+	}
+
+	// well no errors happened
+	return nil
+}
+
+// internalCompileStore is a hidden internal method for loading the
+// certificates and keys from the secret store
+func (c *Certs) internalCompileStore(m map[string]certList, d map[*tls.Certificate][]string) error {
+	names, err := c.st.ListCerts()
+	if err != nil {
+		return fmt.Errorf("failed to list secret store: %w", err)
+	}
+
+	log.Printf("[Certs] Compiling lookup table for %d certificates\n", len(names))
 
-		// save in map under each dns name
-		for _, j := range leaf.DNSNames {
-			m[j] = cert
+	for _, name := range names {
+		certData, keyData, err := c.st.GetCert(name)
+		if err != nil {
+			return fmt.Errorf("failed to read secret '%s': %w", name, err)
+		}
+
+		// load key pair
+		pair, err := c.loadKeyPair(certData, keyData)
+		if err != nil {
+			return fmt.Errorf("failed to load x509 key pair from secret '%s': %w", name, err)
 		}
+		saveCert(m, &pair)
+		d[&pair] = diagnoseCert(name, name, &pair)
 	}
+	return nil
+}
 
-	// well no errors happened
+// saveCert stores the certificate in the map under each dns name in the leaf,
+// certificates with a different key type are stored alongside each other
+func saveCert(m map[string]certList, cert *tls.Certificate) {
+	leaf := certgen.TlsLeaf(cert)
+	for _, j := range leaf.DNSNames {
+		m[j] = m[j].add(cert)
+	}
+}
+
+// CompileEvery calls Compile repeatedly with the provided interval, this is
+// used to refresh certificates from a secret store.
+func (c *Certs) CompileEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		c.Compile()
+	}
+}
+
+// PutCert validates the PEM encoded certificate chain and private key then
+// saves them to the certificate and key directories or the secret store.
+//
+// The private key must match the leaf certificate, the leaf must be valid for
+// the domain and each certificate in the chain must be signed by the next.
+func (c *Certs) PutCert(domain string, certPem, keyPem []byte) error {
+	w, err := c.writer(domain)
+	if err != nil {
+		return err
+	}
+
+	// load key pair, this checks the private key matches the leaf, encrypted
+	// keys are saved without decrypting
+	pair, err := c.loadKeyPair(certPem, keyPem)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCert, err)
+	}
+	if err := verifyChain(domain, pair.Certificate); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCert, err)
+	}
+	return w.PutCert(domain, certPem, keyPem)
+}
+
+// DeleteCert removes the certificate and key for the domain.
+func (c *Certs) DeleteCert(domain string) error {
+	w, err := c.writer(domain)
+	if err != nil {
+		return err
+	}
+	return w.DeleteCert(domain)
+}
+
+// writer checks the domain is a valid name and returns the secret store or
+// the writable certificate and key directories.
+func (c *Certs) writer(domain string) (SecretWriter, error) {
+	if !validCertName(domain) {
+		return nil, ErrInvalidDomain
+	}
+	if c.st != nil {
+		if w, ok := c.st.(SecretWriter); ok {
+			return w, nil
+		}
+		return nil, ErrReadOnly
+	}
+	cw, ok := c.cDir.(WriteFS)
+	if !ok {
+		return nil, ErrReadOnly
+	}
+	kw, ok := c.kDir.(WriteFS)
+	if !ok {
+		return nil, ErrReadOnly
+	}
+	return &dirWriter{cw, kw}, nil
+}
+
+// validCertName returns true if the domain is safe to use in a file name
+func validCertName(domain string) bool {
+	return domain != "" && !strings.ContainsAny(domain, "/\\") && !strings.HasPrefix(domain, ".")
+}
+
+// verifyChain parses the raw certificate chain and checks the leaf is valid
+// for the domain and each certificate is signed by the next in the chain.
+func verifyChain(domain string, chain [][]byte) error {
+	certs := make([]*x509.Certificate, len(chain))
+	for i, raw := range chain {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate %d: %w", i, err)
+		}
+		certs[i] = cert
+	}
+
+	// check the leaf
+	leaf := certs[0]
+	if err := leaf.VerifyHostname(domain); err != nil {
+		return err
+	}
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate is not valid at the current time")
+	}
+
+	// check the signatures in the chain
+	for i := 0; i < len(certs)-1; i++ {
+		if err := certs[i].CheckSignatureFrom(certs[i+1]); err != nil {
+			return fmt.Errorf("certificate %d is not signed by certificate %d: %w", i, i+1, err)
+		}
+	}
 	return nil
 }
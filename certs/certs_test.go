@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/MrMelon54/certgen"
 	"github.com/stretchr/testify/assert"
+	"io/fs"
 	"math/big"
 	"testing"
 	"testing/fstest"
@@ -53,7 +54,7 @@ func TestCertsNew_Lookup(t *testing.T) {
 	}
 
 	certs := New(certDir, keyDir, false)
-	assert.NoError(t, certs.internalCompile(certs.m))
+	assert.NoError(t, certs.internalCompile(certs.m, certs.d))
 	cc := certs.GetCertForDomain("example.com")
 	leaf := certgen.TlsLeaf(cc)
 	assert.Equal(t, []string{"example.com"}, leaf.DNSNames)
@@ -72,3 +73,91 @@ func TestCertsNew_SelfSigned(t *testing.T) {
 	leaf2 := certgen.TlsLeaf(cc2)
 	assert.Equal(t, []string{"notexample.com"}, leaf2.DNSNames)
 }
+
+func genTestServerCert(t *testing.T, domain string) *certgen.CertGen {
+	ca, err := certgen.MakeCaTls(2048, pkix.Name{
+		Country:      []string{"GB"},
+		Organization: []string{"Violet"},
+		SerialNumber: "0",
+		CommonName:   "ca.violet.test",
+	}, big.NewInt(0), func(now time.Time) time.Time {
+		return now.AddDate(1, 0, 0)
+	})
+	assert.NoError(t, err)
+	serverTls, err := certgen.MakeServerTls(ca, 2048, pkix.Name{
+		Country:      []string{"GB"},
+		Organization: []string{domain},
+		SerialNumber: "1",
+		CommonName:   domain,
+	}, big.NewInt(1), func(now time.Time) time.Time {
+		return now.AddDate(1, 0, 0)
+	}, []string{domain}, nil)
+	assert.NoError(t, err)
+	return serverTls
+}
+
+func TestCerts_PutCert(t *testing.T) {
+	certDir := DirFS(t.TempDir())
+	keyDir := DirFS(t.TempDir())
+	certs := New(certDir, keyDir, false)
+
+	serverTls := genTestServerCert(t, "example.com")
+	assert.NoError(t, certs.PutCert("example.com", serverTls.GetCertPem(), serverTls.GetKeyPem()))
+	assert.NoError(t, certs.internalCompile(certs.m, certs.d))
+	assert.NotNil(t, certs.GetCertForDomain("example.com"))
+
+	// wrong domain
+	assert.ErrorIs(t, certs.PutCert("notexample.com", serverTls.GetCertPem(), serverTls.GetKeyPem()), ErrInvalidCert)
+
+	// mismatched key
+	otherTls := genTestServerCert(t, "example.com")
+	assert.ErrorIs(t, certs.PutCert("example.com", serverTls.GetCertPem(), otherTls.GetKeyPem()), ErrInvalidCert)
+
+	// invalid file names
+	assert.ErrorIs(t, certs.PutCert("../example.com", serverTls.GetCertPem(), serverTls.GetKeyPem()), ErrInvalidDomain)
+
+	// read only directories
+	readOnly := New(fstest.MapFS{}, fstest.MapFS{}, false)
+	assert.ErrorIs(t, readOnly.PutCert("example.com", serverTls.GetCertPem(), serverTls.GetKeyPem()), ErrReadOnly)
+}
+
+func TestCerts_DeleteCert(t *testing.T) {
+	certDir := DirFS(t.TempDir())
+	keyDir := DirFS(t.TempDir())
+	certs := New(certDir, keyDir, false)
+
+	serverTls := genTestServerCert(t, "example.com")
+	assert.NoError(t, certs.PutCert("example.com", serverTls.GetCertPem(), serverTls.GetKeyPem()))
+	assert.NoError(t, certs.DeleteCert("example.com"))
+
+	m := make(map[string]certList)
+	assert.NoError(t, certs.internalCompile(m, certs.d))
+	assert.Len(t, m, 0)
+
+	// the certificate no longer exists
+	assert.ErrorIs(t, certs.DeleteCert("example.com"), fs.ErrNotExist)
+}
+
+func TestCerts_GetAllCerts(t *testing.T) {
+	certs := New(nil, nil, true)
+	certs.GetCertForDomain("example.com")
+	certs.GetCertForDomain("notexample.com")
+
+	all := certs.GetAllCerts()
+	assert.Len(t, all, 2)
+	assert.Equal(t, []string{"example.com"}, certgen.TlsLeaf(all[0]).DNSNames)
+	assert.Equal(t, []string{"notexample.com"}, certgen.TlsLeaf(all[1]).DNSNames)
+}
+
+func TestCerts_CheckExpiry(t *testing.T) {
+	certs := New(DirFS(t.TempDir()), DirFS(t.TempDir()), false)
+	serverTls := genTestServerCert(t, "example.com")
+	assert.NoError(t, certs.PutCert("example.com", serverTls.GetCertPem(), serverTls.GetKeyPem()))
+	assert.NoError(t, certs.internalCompile(certs.m, certs.d))
+
+	// the test certificate expires in one year
+	assert.Len(t, certs.CheckExpiry(30*24*time.Hour), 0)
+	expiring := certs.CheckExpiry(400 * 24 * time.Hour)
+	assert.Len(t, expiring, 1)
+	assert.Equal(t, []string{"example.com"}, expiring[0].DNSNames)
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS certificates
+(
+    id     INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain TEXT UNIQUE,
+    cert   TEXT,
+    key    BLOB
+);
@@ -0,0 +1,184 @@
+package certs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/MrMelon54/certgen"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CtAlert describes a certificate found in the certificate transparency logs
+// which was not loaded by Violet.
+type CtAlert struct {
+	Domain       string    `json:"domain"`
+	Id           int64     `json:"id"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+}
+
+// CtMonitor searches the certificate transparency logs using the crt.sh JSON
+// api for certificates issued for the managed domains.
+//
+// Only certificates issued after the monitor was created are reported, an
+// alert is logged and sent to the webhook if one is set.
+type CtMonitor struct {
+	Address string
+	Webhook string
+	Client  *http.Client
+
+	start time.Time
+	lock  *sync.Mutex
+	known map[string]struct{}
+	seen  map[int64]struct{}
+}
+
+// NewCtMonitor creates a monitor using crt.sh which sends alerts to the
+// webhook, an empty webhook only logs the alerts.
+func NewCtMonitor(webhook string) *CtMonitor {
+	return &CtMonitor{
+		Address: "https://crt.sh",
+		Webhook: webhook,
+		Client:  &http.Client{Timeout: time.Minute},
+		start:   time.Now(),
+		lock:    &sync.Mutex{},
+		known:   make(map[string]struct{}),
+		seen:    make(map[int64]struct{}),
+	}
+}
+
+// crtShEntry is a single result from the crt.sh JSON api
+type crtShEntry struct {
+	Id           int64  `json:"id"`
+	IssuerName   string `json:"issuer_name"`
+	SerialNumber string `json:"serial_number"`
+	NotBefore    string `json:"not_before"`
+	NotAfter     string `json:"not_after"`
+}
+
+// crtShTime is the time format used by crt.sh, the times are in UTC
+const crtShTime = "2006-01-02T15:04:05"
+
+// WatchCertTransparency checks the certificate transparency logs for the
+// domains with a loaded certificate, the check is repeated every interval.
+func (c *Certs) WatchCertTransparency(m *CtMonitor, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		for _, alert := range c.CheckCertTransparency(m) {
+			m.sendAlert(alert)
+		}
+		<-t.C
+	}
+}
+
+// CheckCertTransparency returns the certificates found in the logs which do
+// not match a certificate loaded now or previously, each certificate is only
+// returned once.
+func (c *Certs) CheckCertTransparency(m *CtMonitor) []CtAlert {
+	// remember the serial numbers of loaded certificates and find the names to
+	// search for, wildcard names are searched using the parent domain
+	names := make(map[string]struct{})
+	c.s.RLock()
+	m.lock.Lock()
+	for k, v := range c.m {
+		names[strings.TrimPrefix(k, "*.")] = struct{}{}
+		for _, cert := range v {
+			if leaf := certgen.TlsLeaf(cert); leaf != nil {
+				m.known[normaliseSerial(leaf.SerialNumber.Text(16))] = struct{}{}
+			}
+		}
+	}
+	m.lock.Unlock()
+	c.s.RUnlock()
+
+	alerts := make([]CtAlert, 0)
+	for name := range names {
+		entries, err := m.search(name)
+		if err != nil {
+			log.Printf("[Certs] Failed to search certificate transparency logs for '%s': %s\n", name, err)
+			continue
+		}
+
+		m.lock.Lock()
+		for _, e := range entries {
+			if _, ok := m.seen[e.Id]; ok {
+				continue
+			}
+			m.seen[e.Id] = struct{}{}
+			if _, ok := m.known[normaliseSerial(e.SerialNumber)]; ok {
+				continue
+			}
+			notBefore, err := time.Parse(crtShTime, e.NotBefore)
+			if err != nil || notBefore.Before(m.start) {
+				continue
+			}
+			notAfter, _ := time.Parse(crtShTime, e.NotAfter)
+			alerts = append(alerts, CtAlert{
+				Domain:       name,
+				Id:           e.Id,
+				Issuer:       e.IssuerName,
+				SerialNumber: e.SerialNumber,
+				NotBefore:    notBefore,
+				NotAfter:     notAfter,
+			})
+		}
+		m.lock.Unlock()
+	}
+	return alerts
+}
+
+// search requests the unexpired certificates for the name from crt.sh
+func (m *CtMonitor) search(name string) ([]crtShEntry, error) {
+	u, err := url.Parse(m.Address)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = url.Values{"q": {name}, "output": {"json"}, "exclude": {"expired"}}.Encode()
+	resp, err := m.Client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	var entries []crtShEntry
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	return entries, err
+}
+
+// sendAlert logs the alert and sends it to the webhook
+func (m *CtMonitor) sendAlert(alert CtAlert) {
+	log.Printf("[Certs] WARNING: unexpected certificate for '%s' issued by '%s' (crt.sh id %d)\n", alert.Domain, alert.Issuer, alert.Id)
+	if m.Webhook == "" {
+		return
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+	resp, err := m.Client.Post(m.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[Certs] Failed to send certificate transparency alert: %s\n", err)
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[Certs] Failed to send certificate transparency alert: %s\n", resp.Status)
+	}
+}
+
+// normaliseSerial removes leading zeros and separators from the hex encoded
+// serial number
+func normaliseSerial(serial string) string {
+	serial = strings.ToLower(strings.ReplaceAll(serial, ":", ""))
+	return strings.TrimLeft(serial, "0")
+}
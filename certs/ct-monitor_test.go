@@ -0,0 +1,58 @@
+package certs
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCerts_CheckCertTransparency(t *testing.T) {
+	serverTls := genTestServerCert(t, "example.com")
+	certs := New(fstest.MapFS{
+		"example.com.cert.pem": {Data: serverTls.GetCertPem()},
+	}, fstest.MapFS{
+		"example.com.key.pem": {Data: serverTls.GetKeyPem()},
+	}, false)
+	assert.NoError(t, certs.internalCompile(certs.m, certs.d))
+
+	now := time.Now().UTC()
+	crtSh := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "example.com", req.URL.Query().Get("q"))
+		_ = json.NewEncoder(rw).Encode([]crtShEntry{
+			// loaded certificate
+			{Id: 1, IssuerName: "CN=ca.violet.test", SerialNumber: "01", NotBefore: now.Add(time.Hour).Format(crtShTime)},
+			// unknown certificate
+			{Id: 2, IssuerName: "CN=Other CA", SerialNumber: "abcd", NotBefore: now.Add(time.Hour).Format(crtShTime), NotAfter: now.AddDate(0, 3, 0).Format(crtShTime)},
+			// issued before monitoring started
+			{Id: 3, IssuerName: "CN=Other CA", SerialNumber: "1234", NotBefore: now.AddDate(0, -1, 0).Format(crtShTime)},
+		})
+	}))
+	defer crtSh.Close()
+
+	var got []CtAlert
+	webhook := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var alert CtAlert
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&alert))
+		got = append(got, alert)
+	}))
+	defer webhook.Close()
+
+	m := NewCtMonitor(webhook.URL)
+	m.Address = crtSh.URL
+	alerts := certs.CheckCertTransparency(m)
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, int64(2), alerts[0].Id)
+	assert.Equal(t, "example.com", alerts[0].Domain)
+	assert.Equal(t, "CN=Other CA", alerts[0].Issuer)
+
+	// alerts are only reported once
+	assert.Empty(t, certs.CheckCertTransparency(m))
+
+	m.sendAlert(alerts[0])
+	assert.Len(t, got, 1)
+	assert.Equal(t, "abcd", got[0].SerialNumber)
+}
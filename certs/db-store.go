@@ -0,0 +1,152 @@
+package certs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+//go:embed create-table-certificates.sql
+var createTableCertificates string
+
+// DBStore implements SecretStore and SecretWriter using the certificates
+// table in the database.
+//
+// If an encryption secret is provided then private keys are encrypted with
+// AES-256-GCM before being saved.
+type DBStore struct {
+	db  *sql.DB
+	gcm cipher.AEAD
+}
+
+var (
+	_ SecretStore  = &DBStore{}
+	_ SecretWriter = &DBStore{}
+)
+
+var ErrDecryptKey = errors.New("failed to decrypt private key from database")
+
+// NewDBStore creates a new database certificate store and initialises the
+// certificates table, an empty secret disables private key encryption.
+func NewDBStore(db *sql.DB, secret string) (*DBStore, error) {
+	d := &DBStore{db: db}
+	if secret != "" {
+		// derive a 256-bit key from the secret
+		key := sha256.Sum256([]byte(secret))
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+		d.gcm, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+	}
+
+	// init certificates table
+	_, err := d.db.Exec(createTableCertificates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate 'certificates' table: %w", err)
+	}
+	return d, nil
+}
+
+// ListCerts returns the domain of each certificate in the database.
+func (d *DBStore) ListCerts() ([]string, error) {
+	rows, err := d.db.Query(`SELECT domain FROM certificates`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// GetCert returns the certificate and decrypted private key for the domain,
+// fs.ErrNotExist is returned if the certificate doesn't exist.
+func (d *DBStore) GetCert(name string) ([]byte, []byte, error) {
+	var certPem string
+	var keyData []byte
+	err := d.db.QueryRow(`SELECT cert, key FROM certificates WHERE domain = ?`, name).Scan(&certPem, &keyData)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPem, err := d.decrypt(keyData)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(certPem), keyPem, nil
+}
+
+// PutCert saves the certificate and encrypted private key for the domain.
+func (d *DBStore) PutCert(name string, certPem, keyPem []byte) error {
+	keyData, err := d.encrypt(keyPem)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(`INSERT INTO certificates (domain, cert, key) VALUES (?, ?, ?) ON CONFLICT(domain) DO UPDATE SET cert = excluded.cert, key = excluded.key`, name, string(certPem), keyData)
+	return err
+}
+
+// DeleteCert removes the certificate for the domain, fs.ErrNotExist is
+// returned if the certificate doesn't exist.
+func (d *DBStore) DeleteCert(name string) error {
+	res, err := d.db.Exec(`DELETE FROM certificates WHERE domain = ?`, name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// encrypt seals the private key with a random nonce prepended
+func (d *DBStore) encrypt(keyPem []byte) ([]byte, error) {
+	if d.gcm == nil {
+		return keyPem, nil
+	}
+	nonce := make([]byte, d.gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return d.gcm.Seal(nonce, nonce, keyPem, nil), nil
+}
+
+// decrypt opens the private key sealed by encrypt
+func (d *DBStore) decrypt(keyData []byte) ([]byte, error) {
+	if d.gcm == nil {
+		return keyData, nil
+	}
+	n := d.gcm.NonceSize()
+	if len(keyData) < n {
+		return nil, ErrDecryptKey
+	}
+	keyPem, err := d.gcm.Open(nil, keyData[:n], keyData[n:], nil)
+	if err != nil {
+		return nil, ErrDecryptKey
+	}
+	return keyPem, nil
+}
@@ -0,0 +1,40 @@
+package certs
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"testing"
+)
+
+func TestDBStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	assert.NoError(t, err)
+
+	store, err := NewDBStore(db, "hunter2")
+	assert.NoError(t, err)
+	certs := NewFromStore(store)
+
+	serverTls := genTestServerCert(t, "example.com")
+	assert.NoError(t, certs.PutCert("example.com", serverTls.GetCertPem(), serverTls.GetKeyPem()))
+	assert.NoError(t, certs.internalCompile(certs.m, certs.d))
+	assert.NotNil(t, certs.GetCertForDomain("example.com"))
+
+	// private keys are encrypted at rest
+	var keyData []byte
+	assert.NoError(t, db.QueryRow(`SELECT key FROM certificates WHERE domain = ?`, "example.com").Scan(&keyData))
+	assert.NotEqual(t, serverTls.GetKeyPem(), keyData)
+
+	// the wrong secret can't decrypt the key
+	wrongStore, err := NewDBStore(db, "hunter3")
+	assert.NoError(t, err)
+	_, _, err = wrongStore.GetCert("example.com")
+	assert.ErrorIs(t, err, ErrDecryptKey)
+
+	assert.NoError(t, certs.DeleteCert("example.com"))
+	assert.ErrorIs(t, certs.DeleteCert("example.com"), fs.ErrNotExist)
+	names, err := store.ListCerts()
+	assert.NoError(t, err)
+	assert.Len(t, names, 0)
+}
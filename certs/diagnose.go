@@ -0,0 +1,88 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// diagnoseCert checks the certificate chain for problems which would cause
+// clients to reject the certificate, each problem is logged and returned.
+//
+// The source is used in the log messages and the domain is the name the
+// certificate was configured for, an empty domain skips the name check.
+func diagnoseCert(source, domain string, cert *tls.Certificate) []string {
+	problems := chainProblems(domain, cert)
+	for _, i := range problems {
+		log.Printf("[Certs] WARNING: '%s': %s\n", source, i)
+	}
+	return problems
+}
+
+// chainProblems returns a message for each problem found with the chain
+func chainProblems(domain string, cert *tls.Certificate) []string {
+	problems := make([]string, 0)
+
+	// parse each certificate in the chain
+	chain := make([]*x509.Certificate, len(cert.Certificate))
+	for i, raw := range cert.Certificate {
+		c, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return append(problems, fmt.Sprintf("certificate %d failed to parse: %s", i, err))
+		}
+		chain[i] = c
+	}
+	if len(chain) == 0 {
+		return append(problems, "chain is empty")
+	}
+	leaf := chain[0]
+
+	// check the private key matches the leaf
+	if signer, ok := cert.PrivateKey.(crypto.Signer); ok {
+		if pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool }); ok && !pub.Equal(leaf.PublicKey) {
+			problems = append(problems, "private key does not match the leaf")
+		}
+	}
+
+	// check the leaf covers the configured domain
+	if domain != "" && leaf.VerifyHostname(domain) != nil {
+		problems = append(problems, fmt.Sprintf("leaf does not cover the configured domain '%s'", domain))
+	}
+
+	// check the leaf is valid at the current time
+	now := time.Now()
+	if now.Before(leaf.NotBefore) {
+		problems = append(problems, fmt.Sprintf("leaf is not valid until %s", leaf.NotBefore.Format(time.RFC3339)))
+	}
+	if now.After(leaf.NotAfter) {
+		problems = append(problems, fmt.Sprintf("leaf expired at %s", leaf.NotAfter.Format(time.RFC3339)))
+	}
+
+	// check each certificate is signed by the next in the chain
+	for i := 0; i < len(chain)-1; i++ {
+		if chain[i].CheckSignatureFrom(chain[i+1]) != nil {
+			problems = append(problems, fmt.Sprintf("certificate %d is not signed by the next certificate in the chain", i))
+		}
+	}
+
+	// check the chain leads to a trusted root, this fails when intermediates
+	// are missing from the chain
+	intermediates := x509.NewCertPool()
+	for _, i := range chain[1:] {
+		intermediates.AddCert(i)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) {
+		problems = append(problems, "chain does not lead to a trusted root, intermediates may be missing")
+	}
+	return problems
+}
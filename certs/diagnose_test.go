@@ -0,0 +1,46 @@
+package certs
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+func TestChainProblems(t *testing.T) {
+	serverTls := genTestServerCert(t, "example.com")
+	pair, err := tls.X509KeyPair(serverTls.GetCertPem(), serverTls.GetKeyPem())
+	assert.NoError(t, err)
+
+	// the test ca is not trusted so only the authority is reported
+	assert.Equal(t, []string{
+		"chain does not lead to a trusted root, intermediates may be missing",
+	}, chainProblems("example.com", &pair))
+
+	// domain not covered by the leaf
+	assert.Contains(t, chainProblems("notexample.com", &pair), "leaf does not cover the configured domain 'notexample.com'")
+
+	// private key from another certificate
+	otherTls := genTestServerCert(t, "example.com")
+	otherPair, err := tls.X509KeyPair(otherTls.GetCertPem(), otherTls.GetKeyPem())
+	assert.NoError(t, err)
+	pair.PrivateKey = otherPair.PrivateKey
+	assert.Contains(t, chainProblems("", &pair), "private key does not match the leaf")
+
+	// empty chain
+	assert.Equal(t, []string{"chain is empty"}, chainProblems("", &tls.Certificate{}))
+}
+
+func TestCerts_GetCertProblems(t *testing.T) {
+	serverTls := genTestServerCert(t, "example.com")
+	certs := New(fstest.MapFS{
+		"notexample.com.cert.pem": {Data: serverTls.GetCertPem()},
+	}, fstest.MapFS{
+		"notexample.com.key.pem": {Data: serverTls.GetKeyPem()},
+	}, false)
+	assert.NoError(t, certs.internalCompile(certs.m, certs.d))
+
+	cert := certs.GetCertForDomain("example.com")
+	assert.NotNil(t, cert)
+	assert.Contains(t, certs.GetCertProblems(cert), "leaf does not cover the configured domain 'notexample.com'")
+}
@@ -0,0 +1,119 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CompileDiff lists the domains which gained, lost or changed certificates
+// between two compiles.
+type CompileDiff struct {
+	Time    time.Time `json:"time"`
+	Added   []string  `json:"added"`
+	Removed []string  `json:"removed"`
+	Changed []string  `json:"changed"`
+}
+
+// Empty returns true when no domains were changed
+func (d CompileDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a short summary for logging
+func (d CompileDiff) String() string {
+	return "added [" + strings.Join(d.Added, ", ") + "], removed [" + strings.Join(d.Removed, ", ") + "], changed [" + strings.Join(d.Changed, ", ") + "]"
+}
+
+// diffCertMaps compares the leaf certificates loaded for each domain
+func diffCertMaps(prev, next map[string]certList) CompileDiff {
+	d := CompileDiff{
+		Time:    time.Now(),
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+		Changed: make([]string, 0),
+	}
+	for k, v := range next {
+		p, ok := prev[k]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, k)
+		case !sameCertList(p, v):
+			d.Changed = append(d.Changed, k)
+		}
+	}
+	for k := range prev {
+		if _, ok := next[k]; !ok {
+			d.Removed = append(d.Removed, k)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d
+}
+
+// sameCertList returns true when both lists contain the same leaf certificates
+func sameCertList(a, b certList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(leafBytes(a[i]), leafBytes(b[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// leafBytes returns the raw leaf certificate or nil
+func leafBytes(cert *tls.Certificate) []byte {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil
+	}
+	return cert.Certificate[0]
+}
+
+// LastCompileDiff returns the changes made by the most recent compile
+func (c *Certs) LastCompileDiff() CompileDiff {
+	c.s.RLock()
+	defer c.s.RUnlock()
+	return c.diff
+}
+
+// SubscribeCompileDiff returns a channel which receives the changes made by
+// each compile, the returned function must be called to unsubscribe.
+//
+// Slow subscribers miss events instead of blocking the compile.
+func (c *Certs) SubscribeCompileDiff() (<-chan CompileDiff, func()) {
+	ch := make(chan CompileDiff, 8)
+	c.subLock.Lock()
+	c.subs[ch] = struct{}{}
+	c.subLock.Unlock()
+	return ch, func() {
+		c.subLock.Lock()
+		delete(c.subs, ch)
+		c.subLock.Unlock()
+	}
+}
+
+// publishDiff logs the compile diff and sends it to all subscribers
+func (c *Certs) publishDiff(d CompileDiff) {
+	if d.Empty() {
+		log.Printf("[Certs] Compile finished with no changes\n")
+	} else {
+		log.Printf("[Certs] Compile finished: %s\n", d)
+	}
+
+	c.subLock.RLock()
+	defer c.subLock.RUnlock()
+	for ch := range c.subs {
+		select {
+		case ch <- d:
+		default:
+		}
+	}
+}
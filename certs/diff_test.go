@@ -0,0 +1,55 @@
+package certs
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDiffCertMaps(t *testing.T) {
+	a := &tls.Certificate{Certificate: [][]byte{{1}}}
+	b := &tls.Certificate{Certificate: [][]byte{{2}}}
+	diff := diffCertMaps(map[string]certList{
+		"example.com":     {a},
+		"old.example.com": {a},
+		"www.example.com": {a},
+	}, map[string]certList{
+		"example.com":     {&tls.Certificate{Certificate: [][]byte{{1}}}},
+		"new.example.com": {b},
+		"www.example.com": {b},
+	})
+	assert.Equal(t, []string{"new.example.com"}, diff.Added)
+	assert.Equal(t, []string{"old.example.com"}, diff.Removed)
+	assert.Equal(t, []string{"www.example.com"}, diff.Changed)
+	assert.False(t, diff.Empty())
+	assert.True(t, diffCertMaps(nil, nil).Empty())
+}
+
+func TestCerts_SubscribeCompileDiff(t *testing.T) {
+	serverTls := genTestServerCert(t, "example.com")
+	certDir := fstest.MapFS{
+		"example.com.cert.pem": {Data: serverTls.GetCertPem()},
+	}
+	certs := New(certDir, fstest.MapFS{
+		"example.com.key.pem": {Data: serverTls.GetKeyPem()},
+	}, false)
+
+	ch, cancel := certs.SubscribeCompileDiff()
+	defer cancel()
+
+	// first compile adds the domain
+	certs.threadCompile()
+	diff := <-ch
+	assert.Equal(t, []string{"example.com"}, diff.Added)
+	assert.Equal(t, diff, certs.LastCompileDiff())
+
+	// second compile makes no changes
+	certs.threadCompile()
+	assert.True(t, (<-ch).Empty())
+
+	// removing the certificate is reported
+	delete(certDir, "example.com.cert.pem")
+	certs.threadCompile()
+	assert.Equal(t, []string{"example.com"}, (<-ch).Removed)
+}
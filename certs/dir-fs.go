@@ -0,0 +1,75 @@
+package certs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WriteFS is an fs.FS which also allows writing and removing files, this is
+// required for certificates to be uploaded and deleted remotely.
+type WriteFS interface {
+	fs.FS
+	WriteFile(name string, data []byte) error
+	Remove(name string) error
+}
+
+// writeDirFS implements WriteFS for a directory on the local filesystem
+type writeDirFS struct {
+	fs.FS
+	dir string
+}
+
+// DirFS returns a WriteFS for the files in the provided directory, reading is
+// handled by os.DirFS.
+func DirFS(dir string) WriteFS {
+	return &writeDirFS{FS: os.DirFS(dir), dir: dir}
+}
+
+// WriteFile writes data to the named file with owner only permissions as the
+// directory may contain private keys.
+func (w *writeDirFS) WriteFile(name string, data []byte) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.WriteFile(filepath.Join(w.dir, name), data, 0600)
+}
+
+// Remove removes the named file.
+func (w *writeDirFS) Remove(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Remove(filepath.Join(w.dir, name))
+}
+
+// dirWriter implements SecretWriter for the certificate and key directories
+type dirWriter struct {
+	cw, kw WriteFS
+}
+
+// PutCert writes the certificate and key files for the domain
+func (d *dirWriter) PutCert(domain string, certPem, keyPem []byte) error {
+	// write the key first so the cert is never loaded without a key
+	if err := d.kw.WriteFile(domain+".key.pem", keyPem); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := d.cw.WriteFile(domain+".cert.pem", certPem); err != nil {
+		return fmt.Errorf("failed to write cert file: %w", err)
+	}
+	return nil
+}
+
+// DeleteCert removes the certificate and key files for the domain
+func (d *dirWriter) DeleteCert(domain string) error {
+	// remove the cert first so the key is never loaded without a cert
+	if err := d.cw.Remove(domain + ".cert.pem"); err != nil {
+		return fmt.Errorf("failed to remove cert file: %w", err)
+	}
+	if err := d.kw.Remove(domain + ".key.pem"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove key file: %w", err)
+	}
+	return nil
+}
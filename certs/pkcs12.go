@@ -0,0 +1,43 @@
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"software.sslmate.com/src/go-pkcs12"
+	"strings"
+)
+
+// SetPkcs12Passwords sets the passwords used to decode PKCS#12 bundles, the
+// map key is the bundle file name. Bundles without a password in the map use
+// the key passphrase.
+func (c *Certs) SetPkcs12Passwords(passwords map[string]string) {
+	c.p12 = passwords
+}
+
+// isPkcs12Name returns true if the file name has a PKCS#12 extension
+func isPkcs12Name(name string) bool {
+	return strings.HasSuffix(name, ".p12") || strings.HasSuffix(name, ".pfx")
+}
+
+// loadPkcs12 decodes the PKCS#12 bundle and outputs the certificate chain and
+// private key.
+func (c *Certs) loadPkcs12(name string, data []byte) (*tls.Certificate, error) {
+	password, ok := c.p12[name]
+	if !ok {
+		password = string(c.kp)
+	}
+	key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode pkcs12 bundle: %w", err)
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, i := range chain {
+		cert.Certificate = append(cert.Certificate, i.Raw)
+	}
+	return cert, nil
+}
@@ -0,0 +1,35 @@
+package certs
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"software.sslmate.com/src/go-pkcs12"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCerts_Pkcs12(t *testing.T) {
+	serverTls := genTestServerCert(t, "example.com")
+	keyBlock, _ := pem.Decode(serverTls.GetKeyPem())
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	assert.NoError(t, err)
+	certBlock, _ := pem.Decode(serverTls.GetCertPem())
+	leaf, err := x509.ParseCertificate(certBlock.Bytes)
+	assert.NoError(t, err)
+
+	bundle, err := pkcs12.Modern.Encode(key, leaf, nil, "hunter2")
+	assert.NoError(t, err)
+
+	certs := New(fstest.MapFS{
+		"example.pfx": {Data: bundle},
+	}, fstest.MapFS{}, false)
+
+	// wrong password
+	assert.Error(t, certs.internalCompile(certs.m, certs.d))
+
+	// password from the map
+	certs.SetPkcs12Passwords(map[string]string{"example.pfx": "hunter2"})
+	assert.NoError(t, certs.internalCompile(certs.m, certs.d))
+	assert.NotNil(t, certs.GetCertForDomain("example.com"))
+}
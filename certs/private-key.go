@@ -0,0 +1,71 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/youmark/pkcs8"
+)
+
+var ErrMissingPassphrase = errors.New("private key is encrypted but no passphrase is set")
+
+// SetKeyPassphrase sets the passphrase used to decrypt encrypted private keys.
+func (c *Certs) SetKeyPassphrase(passphrase []byte) {
+	c.kp = passphrase
+}
+
+// loadKeyPair decrypts the private key if required and loads the key pair, an
+// empty private key uses the remote signer if enabled.
+func (c *Certs) loadKeyPair(certPem, keyPem []byte) (tls.Certificate, error) {
+	if c.rs != nil && len(bytes.TrimSpace(keyPem)) == 0 {
+		return c.rs.keyPair(certPem)
+	}
+	keyPem, err := decryptKeyPem(keyPem, c.kp)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPem, keyPem)
+}
+
+// decryptKeyPem outputs the PEM encoded private key with any encryption
+// removed. Both PKCS#8 "ENCRYPTED PRIVATE KEY" blocks and legacy RFC 1423
+// encrypted blocks are supported, unencrypted keys are returned unchanged.
+func decryptKeyPem(keyPem []byte, passphrase []byte) ([]byte, error) {
+	rest := keyPem
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			// no encrypted blocks were found
+			return keyPem, nil
+		}
+
+		switch {
+		case block.Type == "ENCRYPTED PRIVATE KEY":
+			if passphrase == nil {
+				return nil, ErrMissingPassphrase
+			}
+			key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode private key: %w", err)
+			}
+			return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+		case x509.IsEncryptedPEMBlock(block):
+			if passphrase == nil {
+				return nil, ErrMissingPassphrase
+			}
+			der, err := x509.DecryptPEMBlock(block, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+			}
+			return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/youmark/pkcs8"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCerts_SetKeyPassphrase(t *testing.T) {
+	serverTls := genTestServerCert(t, "example.com")
+	keyBlock, _ := pem.Decode(serverTls.GetKeyPem())
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	assert.NoError(t, err)
+
+	// PKCS#8 encrypted key
+	pkcs8Der, err := pkcs8.MarshalPrivateKey(key, []byte("hunter2"), nil)
+	assert.NoError(t, err)
+	pkcs8Pem := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: pkcs8Der})
+
+	// legacy RFC 1423 encrypted key
+	legacyBlock, err := x509.EncryptPEMBlock(rand.Reader, keyBlock.Type, keyBlock.Bytes, []byte("hunter2"), x509.PEMCipherAES256)
+	assert.NoError(t, err)
+	legacyPem := pem.EncodeToMemory(legacyBlock)
+
+	for _, keyPem := range [][]byte{pkcs8Pem, legacyPem} {
+		certs := New(fstest.MapFS{
+			"example.com.cert.pem": {Data: serverTls.GetCertPem()},
+		}, fstest.MapFS{
+			"example.com.key.pem": {Data: keyPem},
+		}, false)
+
+		// missing passphrase
+		assert.ErrorIs(t, certs.internalCompile(make(map[string]certList), make(map[*tls.Certificate][]string)), ErrMissingPassphrase)
+
+		// wrong passphrase
+		certs.SetKeyPassphrase([]byte("hunter3"))
+		assert.Error(t, certs.internalCompile(make(map[string]certList), make(map[*tls.Certificate][]string)))
+
+		certs.SetKeyPassphrase([]byte("hunter2"))
+		assert.NoError(t, certs.internalCompile(certs.m, certs.d))
+		assert.NotNil(t, certs.GetCertForDomain("example.com"))
+	}
+}
@@ -0,0 +1,104 @@
+package certs
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// ReloadCert reloads the certificate and key for a single domain without
+// recompiling the rest of the certificates, the previous certificate with the
+// same key type is replaced under all of its names.
+//
+// fs.ErrNotExist is returned if the domain has no certificate to load.
+func (c *Certs) ReloadCert(domain string) error {
+	if !validCertName(domain) {
+		return ErrInvalidDomain
+	}
+	source, certData, keyData, err := c.readDomainPair(domain)
+	if err != nil {
+		return err
+	}
+	pair, err := c.loadKeyPair(certData, keyData)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCert, err)
+	}
+	problems := diagnoseCert(source, domain, &pair)
+
+	c.s.Lock()
+
+	// find the certificate being replaced
+	var prev *tls.Certificate
+	algo := keyAlgorithm(&pair)
+	for _, i := range c.m[domain] {
+		if keyAlgorithm(i) == algo {
+			prev = i
+		}
+	}
+
+	// copy the lookup tables without the previous certificate, the lists are
+	// copied as they are still used by readers of the old map
+	certMap := make(map[string]certList, len(c.m))
+	for k, v := range c.m {
+		l := make(certList, 0, len(v))
+		for _, i := range v {
+			if i != prev {
+				l = append(l, i)
+			}
+		}
+		if len(l) > 0 {
+			certMap[k] = l
+		}
+	}
+	diagMap := make(map[*tls.Certificate][]string, len(c.d))
+	for k, v := range c.d {
+		if k != prev {
+			diagMap[k] = v
+		}
+	}
+	saveCert(certMap, &pair)
+	diagMap[&pair] = problems
+
+	diff := diffCertMaps(c.m, certMap)
+	c.m = certMap
+	c.d = diagMap
+	c.diff = diff
+	c.s.Unlock()
+
+	c.publishDiff(diff)
+	return nil
+}
+
+// readDomainPair reads the certificate and key for the domain from the secret
+// store, the certificate directories or the certbot live directory
+func (c *Certs) readDomainPair(domain string) (source string, certData, keyData []byte, err error) {
+	if c.st != nil {
+		certData, keyData, err = c.st.GetCert(domain)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) || c.live == nil {
+			return domain, certData, keyData, err
+		}
+	} else if c.cDir != nil {
+		certName, keyName := domain+".cert.pem", domain+".key.pem"
+		certData, err = fs.ReadFile(c.cDir, certName)
+		if err == nil {
+			keyData, err = c.readKeyFile(c.kDir, keyName)
+			return certName, certData, keyData, err
+		}
+		if !errors.Is(err, fs.ErrNotExist) || c.live == nil {
+			return certName, nil, nil, err
+		}
+	}
+	if c.live == nil {
+		return domain, nil, nil, fs.ErrNotExist
+	}
+
+	chainName := path.Join(domain, "fullchain.pem")
+	certData, err = fs.ReadFile(c.live, chainName)
+	if err != nil {
+		return chainName, nil, nil, err
+	}
+	keyData, err = c.readKeyFile(c.live, path.Join(domain, "privkey.pem"))
+	return chainName, certData, keyData, err
+}
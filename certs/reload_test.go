@@ -0,0 +1,36 @@
+package certs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCerts_ReloadCert(t *testing.T) {
+	firstTls := genTestServerCert(t, "example.com")
+	certDir := fstest.MapFS{"example.com.cert.pem": {Data: firstTls.GetCertPem()}}
+	keyDir := fstest.MapFS{"example.com.key.pem": {Data: firstTls.GetKeyPem()}}
+	certs := New(certDir, keyDir, false)
+	certs.threadCompile()
+	first := certs.GetCertForDomain("example.com")
+	assert.NotNil(t, first)
+
+	// replace the files and reload only this domain
+	secondTls := genTestServerCert(t, "example.com")
+	certDir["example.com.cert.pem"] = &fstest.MapFile{Data: secondTls.GetCertPem()}
+	keyDir["example.com.key.pem"] = &fstest.MapFile{Data: secondTls.GetKeyPem()}
+	assert.NoError(t, certs.ReloadCert("example.com"))
+	second := certs.GetCertForDomain("example.com")
+	assert.NotEqual(t, first.Certificate[0], second.Certificate[0])
+	assert.Len(t, certs.GetAllCerts(), 1)
+	assert.Equal(t, []string{"example.com"}, certs.LastCompileDiff().Changed)
+
+	assert.ErrorIs(t, certs.ReloadCert("missing.example.com"), fs.ErrNotExist)
+	assert.ErrorIs(t, certs.ReloadCert("../example.com"), ErrInvalidDomain)
+
+	// invalid key pair leaves the current certificate loaded
+	keyDir["example.com.key.pem"] = &fstest.MapFile{Data: firstTls.GetKeyPem()}
+	assert.ErrorIs(t, certs.ReloadCert("example.com"), ErrInvalidCert)
+	assert.Equal(t, second, certs.GetCertForDomain("example.com"))
+}
@@ -0,0 +1,151 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+var ErrRemoteSigner = errors.New("remote signer failed")
+
+// RemoteSigner delegates signing during the TLS handshake to a remote keyless
+// signing service so private keys never reside on the proxy host.
+//
+// The digest is sent to the address as a JSON POST request containing the key
+// id, which is the hex encoded SHA-256 hash of the leaf public key, the hash
+// function name, a flag for RSA-PSS and the base64 encoded digest. The service
+// responds with the base64 encoded signature.
+type RemoteSigner struct {
+	Address string
+	Token   string
+	Client  *http.Client
+}
+
+// NewRemoteSigner creates a remote signer for the service at the address, the
+// token is sent as a bearer token with each request.
+func NewRemoteSigner(address, token string) *RemoteSigner {
+	return &RemoteSigner{
+		Address: address,
+		Token:   token,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SetRemoteSigner sets the remote signer used for certificates without a
+// private key.
+func (c *Certs) SetRemoteSigner(signer *RemoteSigner) {
+	c.rs = signer
+}
+
+// readKeyFile reads the private key file, a missing file is allowed when the
+// remote signer is enabled
+func (c *Certs) readKeyFile(fsys fs.FS, name string) ([]byte, error) {
+	keyData, err := fs.ReadFile(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) && c.rs != nil {
+		return nil, nil
+	}
+	return keyData, err
+}
+
+// keyPair loads the PEM encoded certificate chain with a private key which is
+// signed remotely
+func (r *RemoteSigner) keyPair(certPem []byte) (tls.Certificate, error) {
+	var cert tls.Certificate
+	for {
+		var block *pem.Block
+		block, certPem = pem.Decode(certPem)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return tls.Certificate{}, errors.New("failed to find certificate PEM data")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to parse leaf: %w", err)
+	}
+	keyId := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	cert.Leaf = leaf
+	cert.PrivateKey = &remoteKey{rs: r, pub: leaf.PublicKey, id: hex.EncodeToString(keyId[:])}
+	return cert, nil
+}
+
+// remoteKey implements crypto.Signer for a single key held by the remote
+// signer
+type remoteKey struct {
+	rs  *RemoteSigner
+	pub crypto.PublicKey
+	id  string
+}
+
+type remoteSignRequest struct {
+	KeyId  string `json:"key_id"`
+	Hash   string `json:"hash"` // empty for Ed25519 which signs the message
+	Pss    bool   `json:"pss"`
+	Digest string `json:"digest"`
+}
+
+type remoteSignResponse struct {
+	Signature string `json:"signature"`
+}
+
+// Public returns the public key from the leaf
+func (k *remoteKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// Sign sends the digest to the remote signer and returns the signature
+func (k *remoteKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	body := remoteSignRequest{KeyId: k.id, Digest: base64.StdEncoding.EncodeToString(digest)}
+	if h := opts.HashFunc(); h != 0 {
+		body.Hash = h.String()
+	}
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		body.Pss = true
+	}
+	bodyRaw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, k.rs.Address, bytes.NewReader(bodyRaw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create remote signer request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if k.rs.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.rs.Token)
+	}
+
+	resp, err := k.rs.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRemoteSigner, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrRemoteSigner, resp.Status)
+	}
+
+	var out remoteSignResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRemoteSigner, err)
+	}
+	return base64.StdEncoding.DecodeString(out.Signature)
+}
@@ -0,0 +1,72 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCerts_SetRemoteSigner(t *testing.T) {
+	serverTls := genTestServerCert(t, "example.com")
+	pair, err := tls.X509KeyPair(serverTls.GetCertPem(), serverTls.GetKeyPem())
+	assert.NoError(t, err)
+	key := pair.PrivateKey.(*rsa.PrivateKey)
+
+	// fake keyless signing service holding the private key
+	signerSrv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "Bearer abc", req.Header.Get("Authorization"))
+		var body remoteSignRequest
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		digest, err := base64.StdEncoding.DecodeString(body.Digest)
+		assert.NoError(t, err)
+
+		hashes := map[string]crypto.Hash{"SHA-256": crypto.SHA256, "SHA-384": crypto.SHA384, "SHA-512": crypto.SHA512}
+		var sig []byte
+		if body.Pss {
+			sig, err = rsa.SignPSS(rand.Reader, key, hashes[body.Hash], digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, key, hashes[body.Hash], digest)
+		}
+		assert.NoError(t, err)
+		_ = json.NewEncoder(rw).Encode(remoteSignResponse{Signature: base64.StdEncoding.EncodeToString(sig)})
+	}))
+	defer signerSrv.Close()
+
+	// certificate without a private key file
+	certs := New(fstest.MapFS{
+		"example.com.cert.pem": {Data: serverTls.GetCertPem()},
+	}, fstest.MapFS{}, false)
+	certs.SetRemoteSigner(NewRemoteSigner(signerSrv.URL, "abc"))
+	assert.NoError(t, certs.internalCompile(certs.m, certs.d))
+	cert := certs.GetCertForDomain("example.com")
+	assert.NotNil(t, cert)
+
+	// complete a handshake using the remote signer, the client checks the
+	// handshake signature even when skipping the chain verification
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}})
+	assert.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_ = conn.(*tls.Conn).Handshake()
+		_ = conn.Close()
+	}()
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: "example.com", InsecureSkipVerify: true})
+	assert.NoError(t, err)
+	_ = conn.Close()
+
+	// missing key files are an error without the remote signer
+	certs.SetRemoteSigner(nil)
+	assert.Error(t, certs.internalCompile(certs.m, certs.d))
+}
@@ -0,0 +1,120 @@
+package certs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// SecretStore provides PEM encoded certificates and private keys from an
+// external secret store so private keys do not need to be saved to disk.
+type SecretStore interface {
+	// ListCerts returns the name of each secret containing a certificate
+	ListCerts() ([]string, error)
+	// GetCert returns the PEM encoded certificate chain and private key
+	GetCert(name string) (certPem, keyPem []byte, err error)
+}
+
+// SecretWriter is implemented by secret stores which allow certificates and
+// private keys to be saved and removed.
+type SecretWriter interface {
+	PutCert(name string, certPem, keyPem []byte) error
+	DeleteCert(name string) error
+}
+
+// VaultStore implements SecretStore for the HashiCorp Vault KV version 2
+// secrets engine.
+//
+// Each secret under the path contains a `cert` and `key` field.
+type VaultStore struct {
+	Address string // address of the vault server
+	Token   string // vault token with read and list access
+	Mount   string // mount path of the kv engine
+	Path    string // path to the certificate secrets inside the mount
+	Client  *http.Client
+}
+
+var _ SecretStore = &VaultStore{}
+
+var ErrVaultStatus = errors.New("unexpected vault response status")
+
+// NewVaultStore creates a VaultStore with a default http client.
+func NewVaultStore(address, token, mount, p string) *VaultStore {
+	return &VaultStore{
+		Address: address,
+		Token:   token,
+		Mount:   mount,
+		Path:    p,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// ListCerts lists the secret names using the metadata endpoint.
+func (v *VaultStore) ListCerts() ([]string, error) {
+	var body struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	err := v.request("LIST", path.Join(v.Mount, "metadata", v.Path), &body)
+	if err != nil {
+		return nil, err
+	}
+
+	// ignore sub-folders which end with a slash
+	names := make([]string, 0, len(body.Data.Keys))
+	for _, i := range body.Data.Keys {
+		if len(i) > 0 && i[len(i)-1] != '/' {
+			names = append(names, i)
+		}
+	}
+	return names, nil
+}
+
+// GetCert reads the latest version of the secret.
+func (v *VaultStore) GetCert(name string) ([]byte, []byte, error) {
+	var body struct {
+		Data struct {
+			Data struct {
+				Cert string `json:"cert"`
+				Key  string `json:"key"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	err := v.request(http.MethodGet, path.Join(v.Mount, "data", v.Path, name), &body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(body.Data.Data.Cert), []byte(body.Data.Data.Key), nil
+}
+
+// request sends a request to the vault api and decodes the JSON response.
+func (v *VaultStore) request(method, p string, out any) error {
+	u, err := url.JoinPath(v.Address, "v1", p)
+	if err != nil {
+		return fmt.Errorf("failed to generate vault url: %w", err)
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send vault request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", fs.ErrNotExist, p)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrVaultStatus, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
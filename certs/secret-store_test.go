@@ -0,0 +1,52 @@
+package certs
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVaultStore(t *testing.T) {
+	serverTls := genTestServerCert(t, "example.com")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "abc" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case req.Method == "LIST" && req.URL.Path == "/v1/secret/metadata/violet/certs":
+			_ = json.NewEncoder(rw).Encode(map[string]any{
+				"data": map[string]any{"keys": []string{"example.com", "old/"}},
+			})
+		case req.Method == http.MethodGet && req.URL.Path == "/v1/secret/data/violet/certs/example.com":
+			_ = json.NewEncoder(rw).Encode(map[string]any{
+				"data": map[string]any{
+					"data": map[string]string{
+						"cert": string(serverTls.GetCertPem()),
+						"key":  string(serverTls.GetKeyPem()),
+					},
+				},
+			})
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	store := NewVaultStore(srv.URL, "abc", "secret", "violet/certs")
+	names, err := store.ListCerts()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, names)
+
+	certs := NewFromStore(store)
+	assert.NoError(t, certs.internalCompile(certs.m, certs.d))
+	assert.NotNil(t, certs.GetCertForDomain("example.com"))
+
+	// invalid token
+	store.Token = "def"
+	_, err = store.ListCerts()
+	assert.ErrorIs(t, err, ErrVaultStatus)
+}
@@ -0,0 +1,217 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"github.com/MrMelon54/certgen"
+	"io/fs"
+	"log"
+	"math/big"
+	"time"
+)
+
+// KeyType is the type of private key used for generated certificates
+type KeyType string
+
+const (
+	KeyTypeRsa     KeyType = "rsa"
+	KeyTypeEcdsa   KeyType = "ecdsa"
+	KeyTypeEd25519 KeyType = "ed25519"
+)
+
+var ErrInvalidKeyType = errors.New("invalid key type")
+
+// SetSelfSignedKeyType sets the type of private key used for generated
+// self-signed certificates, the default is RSA.
+func (c *Certs) SetSelfSignedKeyType(keyType KeyType) error {
+	switch keyType {
+	case KeyTypeRsa, KeyTypeEcdsa, KeyTypeEd25519:
+		c.ssKey = keyType
+		return nil
+	case "":
+		c.ssKey = KeyTypeRsa
+		return nil
+	}
+	return ErrInvalidKeyType
+}
+
+// EnableSelfSignedFallback generates self-signed certificates for domains
+// without a loaded certificate instead of failing the handshake.
+func (c *Certs) EnableSelfSignedFallback() {
+	if c.ca == nil {
+		c.genCa()
+	}
+	c.ssFallback = true
+}
+
+// genCa generates a CA certificate to sign the self-signed certificates.
+func (c *Certs) genCa() {
+	ca, err := certgen.MakeCaTls(4096, pkix.Name{
+		Country:            []string{"GB"},
+		Organization:       []string{"Violet"},
+		OrganizationalUnit: []string{"Development"},
+		SerialNumber:       "0",
+		CommonName:         fmt.Sprintf("%d.violet.test", time.Now().Unix()),
+	}, big.NewInt(0), func(now time.Time) time.Time {
+		return now.AddDate(10, 0, 0)
+	})
+	if err != nil {
+		log.Fatalln("Failed to generate CA cert for self-signed mode:", err)
+	}
+	c.ca = ca
+}
+
+// LoadSelfSignedCa loads the CA used to sign self-signed certificates from the
+// directory, a new CA is saved to the directory if one doesn't exist yet. This
+// allows clients to trust a single root for all self-signed certificates
+// across restarts.
+func (c *Certs) LoadSelfSignedCa(dir WriteFS) error {
+	certPem, certErr := fs.ReadFile(dir, "ca.cert.pem")
+	keyPem, keyErr := fs.ReadFile(dir, "ca.key.pem")
+	switch {
+	case certErr == nil && keyErr == nil:
+		ca, err := certgen.LoadCertGen(certPem, keyPem)
+		if err != nil {
+			return fmt.Errorf("failed to load self-signed CA: %w", err)
+		}
+		c.ca = ca
+	case errors.Is(certErr, fs.ErrNotExist) && errors.Is(keyErr, fs.ErrNotExist):
+		if c.ca == nil {
+			c.genCa()
+		}
+		// write the key first so the cert is never loaded without a key
+		if err := dir.WriteFile("ca.key.pem", c.ca.GetKeyPem()); err != nil {
+			return fmt.Errorf("failed to save self-signed CA key: %w", err)
+		}
+		if err := dir.WriteFile("ca.cert.pem", c.ca.GetCertPem()); err != nil {
+			return fmt.Errorf("failed to save self-signed CA cert: %w", err)
+		}
+	case certErr != nil:
+		return fmt.Errorf("failed to read self-signed CA cert: %w", certErr)
+	default:
+		return fmt.Errorf("failed to read self-signed CA key: %w", keyErr)
+	}
+
+	// clients reject reused serial numbers from the same issuer so continue
+	// from the current time instead of zero
+	c.sn.Store(time.Now().UnixNano())
+
+	// remove certificates signed by the previous CA
+	c.ssLock.Lock()
+	c.ssMap = make(map[string]*tls.Certificate)
+	c.ssLock.Unlock()
+	return nil
+}
+
+// GetSelfSignedCa returns the PEM encoded CA certificate used to sign
+// self-signed certificates or nil if self-signed certificates are disabled.
+func (c *Certs) GetSelfSignedCa() []byte {
+	if c.ca == nil {
+		return nil
+	}
+	return c.ca.GetCertPem()
+}
+
+// getSelfSignedCert returns the cached self-signed certificate for the domain
+// or generates a new one on the first handshake using the domain.
+//
+// Concurrent handshakes for the same domain share a single generation.
+func (c *Certs) getSelfSignedCert(domain string) *tls.Certificate {
+	c.ssLock.RLock()
+	cert, ok := c.ssMap[domain]
+	c.ssLock.RUnlock()
+	if ok {
+		return cert
+	}
+
+	v, err, _ := c.ssGroup.Do(domain, func() (any, error) {
+		// check again in case another call finished generating
+		c.ssLock.RLock()
+		cert, ok := c.ssMap[domain]
+		c.ssLock.RUnlock()
+		if ok {
+			return cert, nil
+		}
+
+		cert, err := c.genLeaf(domain, c.sn.Add(1))
+		if err != nil {
+			return nil, err
+		}
+
+		// save the generated leaf for loading if the domain is requested again
+		c.ssLock.Lock()
+		c.ssMap[domain] = cert
+		c.ssLock.Unlock()
+		return cert, nil
+	})
+	if err != nil {
+		log.Printf("[Certs] Failed to generate self-signed certificate for '%s': %s\n", domain, err)
+		return nil
+	}
+	return v.(*tls.Certificate)
+}
+
+// genLeaf generates a certificate for the domain signed by the CA using the
+// configured key type.
+func (c *Certs) genLeaf(domain string, sn int64) (*tls.Certificate, error) {
+	key, err := generateKey(c.ssKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(sn),
+		Subject: pkix.Name{
+			Country:            []string{"GB"},
+			Organization:       []string{domain},
+			OrganizationalUnit: []string{domain},
+			SerialNumber:       fmt.Sprintf("%d", sn),
+			CommonName:         domain,
+		},
+		DNSNames:    []string{domain},
+		NotBefore:   now,
+		NotAfter:    now.AddDate(10, 0, 0),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}
+
+	// sign with the CA
+	caTls := c.ca.GetTlsLeaf()
+	der, err := x509.CreateCertificate(rand.Reader, template, certgen.TlsLeaf(&caTls), key.Public(), caTls.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated certificate: %w", err)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// generateKey generates a private key of the requested type
+func generateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRsa, "":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyTypeEcdsa:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, ErrInvalidKeyType
+}
@@ -0,0 +1,103 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/MrMelon54/certgen"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCerts_EnableSelfSignedFallback(t *testing.T) {
+	certs := New(fstest.MapFS{}, fstest.MapFS{}, false)
+	assert.NoError(t, certs.internalCompile(certs.m, certs.d))
+	assert.Nil(t, certs.GetCertForDomain("example.com"))
+
+	certs.EnableSelfSignedFallback()
+
+	// concurrent handshakes should share the same generated certificate
+	var wg sync.WaitGroup
+	got := make([]*tls.Certificate, 4)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i] = certs.GetCertForDomain("example.com")
+		}(i)
+	}
+	wg.Wait()
+	for _, i := range got {
+		assert.Same(t, got[0], i)
+	}
+	assert.Equal(t, []string{"example.com"}, certgen.TlsLeaf(got[0]).DNSNames)
+
+	// each hostname gets a separate certificate
+	other := certs.GetCertForDomain("www.example.com")
+	assert.Equal(t, []string{"www.example.com"}, certgen.TlsLeaf(other).DNSNames)
+}
+
+func TestCerts_SetSelfSignedKeyType(t *testing.T) {
+	certs := New(fstest.MapFS{}, fstest.MapFS{}, false)
+	certs.EnableSelfSignedFallback()
+	assert.ErrorIs(t, certs.SetSelfSignedKeyType("dsa"), ErrInvalidKeyType)
+
+	for _, i := range []struct {
+		keyType KeyType
+		algo    x509.PublicKeyAlgorithm
+	}{
+		{KeyTypeEcdsa, x509.ECDSA},
+		{KeyTypeEd25519, x509.Ed25519},
+	} {
+		assert.NoError(t, certs.SetSelfSignedKeyType(i.keyType))
+		domain := string(i.keyType) + ".example.com"
+		cert := certs.GetCertForDomain(domain)
+		leaf := certgen.TlsLeaf(cert)
+		assert.Equal(t, i.algo, leaf.PublicKeyAlgorithm)
+
+		// the loader should accept the generated certificate and key
+		keyDer, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		assert.NoError(t, err)
+		loader := New(fstest.MapFS{
+			domain + ".cert.pem": {Data: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})},
+		}, fstest.MapFS{
+			domain + ".key.pem": {Data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})},
+		}, false)
+		assert.NoError(t, loader.internalCompile(loader.m, loader.d))
+		assert.NotNil(t, loader.GetCertForDomain(domain))
+	}
+}
+
+func TestCerts_LoadSelfSignedCa(t *testing.T) {
+	caDir := DirFS(t.TempDir())
+
+	// first load saves the generated CA
+	certs := New(nil, nil, true)
+	assert.NoError(t, certs.LoadSelfSignedCa(caDir))
+	caPem := certs.GetSelfSignedCa()
+	savedPem, err := fs.ReadFile(caDir, "ca.cert.pem")
+	assert.NoError(t, err)
+	assert.Equal(t, caPem, savedPem)
+
+	// next load uses the saved CA to sign certificates
+	certs = New(nil, nil, true)
+	assert.NotEqual(t, caPem, certs.GetSelfSignedCa())
+	assert.NoError(t, certs.LoadSelfSignedCa(caDir))
+	assert.Equal(t, caPem, certs.GetSelfSignedCa())
+
+	block, _ := pem.Decode(caPem)
+	ca, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	leaf := certgen.TlsLeaf(certs.GetCertForDomain("example.com"))
+	assert.NoError(t, leaf.CheckSignatureFrom(ca))
+
+	// missing key is an error
+	assert.NoError(t, caDir.Remove("ca.key.pem"))
+	assert.ErrorIs(t, certs.LoadSelfSignedCa(caDir), fs.ErrNotExist)
+
+	// disabled self-signed certificates have no CA
+	assert.Nil(t, New(nil, nil, false).GetSelfSignedCa())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/MrMelon54/violet/apikeys"
+	"github.com/google/subcommands"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type apiKeysCmd struct {
+	configPath string
+	create     string
+	subject    string
+	perms      string
+	revoke     string
+}
+
+func (a *apiKeysCmd) Name() string     { return "api-keys" }
+func (a *apiKeysCmd) Synopsis() string { return "Create, list or revoke api keys" }
+func (a *apiKeysCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&a.configPath, "conf", "", "/path/to/config.json : path to the config file")
+	f.StringVar(&a.create, "create", "", "Name of the api key to create")
+	f.StringVar(&a.subject, "subject", "", "Subject used for domain ownership (defaults to key:<name>)")
+	f.StringVar(&a.perms, "perms", "", "Comma separated permissions for the new api key")
+	f.StringVar(&a.revoke, "revoke", "", "Name of the api key to revoke")
+}
+func (a *apiKeysCmd) Usage() string {
+	return `api-keys -conf <config file> [-create <name> [-subject <subject>] [-perms a,b] | -revoke <name>]
+  Create, list or revoke long-lived api keys, the keys are listed when no
+  action is chosen. A created key is only output once.
+`
+}
+
+func (a *apiKeysCmd) Execute(_ context.Context, _ *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if a.configPath == "" {
+		log.Println("[Violet] Error: config flag is missing")
+		return subcommands.ExitUsageError
+	}
+	if a.create != "" && a.revoke != "" {
+		log.Println("[Violet] Error: only one of the create or revoke flags can be used")
+		return subcommands.ExitUsageError
+	}
+
+	// working directory is the parent of the config file
+	wd := filepath.Dir(a.configPath)
+	db, err := sql.Open("sqlite3", filepath.Join(wd, "violet.db.sqlite"))
+	if err != nil {
+		log.Println("[Violet] Error: failed to open database: ", err)
+		return subcommands.ExitFailure
+	}
+	defer db.Close()
+	keys := apikeys.New(db)
+	if keys == nil {
+		return subcommands.ExitFailure
+	}
+
+	switch {
+	case a.create != "":
+		var perms []string
+		for _, perm := range strings.Split(a.perms, ",") {
+			if perm = strings.TrimSpace(perm); perm != "" {
+				perms = append(perms, perm)
+			}
+		}
+		token, key, err := keys.Create(a.create, a.subject, perms)
+		if err != nil {
+			log.Println("[Violet] Error: failed to create api key: ", err)
+			return subcommands.ExitFailure
+		}
+		log.Printf("[Violet] Created api key '%s' with subject '%s'\n", key.Name, key.Subject)
+		fmt.Println(token)
+	case a.revoke != "":
+		if err := keys.Delete(a.revoke); err != nil {
+			log.Println("[Violet] Error: failed to revoke api key: ", err)
+			return subcommands.ExitFailure
+		}
+		log.Printf("[Violet] Revoked api key '%s'\n", a.revoke)
+	default:
+		list, err := keys.List()
+		if err != nil {
+			log.Println("[Violet] Error: failed to list api keys: ", err)
+			return subcommands.ExitFailure
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(list)
+	}
+	return subcommands.ExitSuccess
+}
@@ -1,15 +1,151 @@
 package main
 
+import "github.com/MrMelon54/violet/webhooks"
+
 type startUpConfig struct {
-	SelfSigned    bool         `json:"self_signed"`
-	ErrorPagePath string       `json:"error_page_path"`
-	Listen        listenConfig `json:"listen"`
-	InkscapeCmd   string       `json:"inkscape"`
-	RateLimit     uint64       `json:"rate_limit"`
+	SelfSigned    bool                `json:"self_signed"`
+	SelfFallback  bool                `json:"self_signed_fallback"`
+	SelfKeyType   string              `json:"self_signed_key_type,omitempty"` // rsa, ecdsa or ed25519
+	ErrorPagePath string              `json:"error_page_path"`
+	ErrorPageDb   bool                `json:"error_pages_database"`       // store error pages in the database and manage them using the api
+	ErrorTheme    *errorThemeConfig   `json:"error_page_theme,omitempty"` // style of the built-in error pages which replace plain text responses
+	Listen        listenConfig        `json:"listen"`
+	InkscapeCmd   string              `json:"inkscape"` // inkscape path used when svg_converter is not set, empty uses the built-in svg rasterizer
+	SvgConverter  *svgConverterConfig `json:"svg_converter,omitempty"`
+	IconFormats   []iconFormatConfig  `json:"favicon_formats,omitempty"` // webp or avif renditions served to browsers which accept them
+	IconDiscover  uint64              `json:"favicon_discovery_minutes"` // find icons in the html of hosts without favicons and keep them for this many minutes
+	IconCache     string              `json:"favicon_cache,omitempty"`   // Cache-Control header of served favicons, defaults to one day
+	IconDefault   *defaultIconConfig  `json:"default_favicon,omitempty"` // served for hosts without a favicon
+	IconWorkers   int                 `json:"favicon_workers"`           // favicons generated at the same time while compiling, defaults to the number of CPUs
+	RateLimit     uint64              `json:"rate_limit"`
+	MaxBodyBytes  int64               `json:"max_body_bytes"`   // larger request bodies receive the 413 error page, zero is unlimited
+	MaxHeaderSize int                 `json:"max_header_bytes"` // larger request headers receive the 431 error page, zero keeps the server limit
+	CertExpiry    uint64              `json:"cert_expiry_days"`
+	CertDatabase  *certDatabaseConfig `json:"cert_database,omitempty"`
+	Vault         *vaultConfig        `json:"vault,omitempty"`
+	KeyPass       string              `json:"key_passphrase,omitempty"`      // defaults to the VIOLET_KEY_PASSPHRASE environment variable
+	KeyPassFile   string              `json:"key_passphrase_file,omitempty"` // file containing the key passphrase
+	Pkcs12Pass    map[string]string   `json:"pkcs12_passwords,omitempty"`    // password for each pkcs12 bundle file name
+	DefaultCert   *defaultCertConfig  `json:"default_cert,omitempty"`
+	RejectSni     bool                `json:"reject_unknown_sni"`
+	CertbotLive   string              `json:"certbot_live,omitempty"` // certbot live directory, e.g. /etc/letsencrypt/live
+	RemoteSigner  *remoteSignerConfig `json:"remote_signer,omitempty"`
+	CtMonitor     *ctMonitorConfig    `json:"ct_monitor,omitempty"`
+	DomainVerify  *domainVerifyConfig `json:"domain_verification,omitempty"`
+	AutoRegister  bool                `json:"auto_register_domains"`     // register the host of new routes and redirects under owned domains
+	DomainHooks   []string            `json:"domain_webhooks,omitempty"` // receive signed JSON POSTs when domains are added, removed or toggled, same as a webhook with only the domain.change event
+	ApiCors       *apiCorsConfig      `json:"api_cors,omitempty"`
+	ApiTls        *apiTlsConfig       `json:"api_tls,omitempty"`
+	ApiSocket     *apiSocketConfig    `json:"api_socket,omitempty"`
+	ApiLimits     *apiLimitsConfig    `json:"api_limits,omitempty"`
+	ApiRateLimit  uint64              `json:"api_rate_limit"`           // api requests per minute for each address
+	ApiAuthFails  uint64              `json:"api_auth_failures"`        // failed api authentication attempts before an address is blocked for 15 minutes
+	ApiAccessLog  string              `json:"api_access_log,omitempty"` // file receiving a JSON line for every api request, `-` uses stdout
+	ApiIfMatch    bool                `json:"api_require_if_match"`     // reject updates of routes and redirects by id without an If-Match header
+	RecycleDays   uint64              `json:"recycle_retention_days"`   // days deleted routes and redirects are kept, defaults to 30
+	GenHeader     bool                `json:"generation_header"`        // add the X-Violet-Generation header to proxied responses
+	Webhooks      []webhooks.Hook     `json:"webhooks,omitempty"`       // receive signed JSON POSTs for route changes, domain changes, compiles and certificate events
+}
+
+// errorThemeConfig is rendered by the built-in error pages, the colors are hex
+// colors and the logo is a url or absolute path
+type errorThemeConfig struct {
+	SiteName   string `json:"site_name,omitempty"`
+	LogoUrl    string `json:"logo_url,omitempty"`
+	Background string `json:"background_color,omitempty"`
+	Text       string `json:"text_color,omitempty"`
+	Accent     string `json:"accent_color,omitempty"`
 }
 
 type listenConfig struct {
 	Api   string `json:"api"`
 	Http  string `json:"http"`
 	Https string `json:"https"`
+	Grpc  string `json:"grpc,omitempty"` // grpc management api, uses the api tls config
+}
+
+type svgConverterConfig struct {
+	Type     string   `json:"type"`                      // builtin, inkscape, rsvg-convert or resvg
+	Path     string   `json:"path,omitempty"`            // defaults to the program name
+	Args     []string `json:"args,omitempty"`            // replaces the default arguments, `{size}` is replaced with the size
+	Size     int      `json:"size,omitempty"`            // pixel size used for `{size}`, defaults to 256
+	Timeout  uint64   `json:"timeout_seconds,omitempty"` // defaults to 30 seconds
+	MaxInput uint64   `json:"max_input_kb,omitempty"`    // largest svg passed to the program, defaults to 4096
+	Memory   uint64   `json:"max_memory_mb,omitempty"`   // address space limit of the program and the favicon format encoders on linux
+	CPU      uint64   `json:"max_cpu_seconds,omitempty"` // processor time limit of the program and the favicon format encoders on linux
+}
+
+// defaultIconConfig contains a url or file path for each favicon format and
+// the app name and theme color used in site.webmanifest and browserconfig.xml
+type defaultIconConfig struct {
+	Svg        string `json:"svg,omitempty"`
+	Png        string `json:"png,omitempty"`
+	Ico        string `json:"ico,omitempty"`
+	DarkSvg    string `json:"dark_svg,omitempty"`
+	Name       string `json:"name,omitempty"`
+	ThemeColor string `json:"theme_color,omitempty"`
+}
+
+type iconFormatConfig struct {
+	Type    string   `json:"type"`                      // webp or avif
+	Path    string   `json:"path,omitempty"`            // defaults to cwebp for webp and magick for avif
+	Args    []string `json:"args,omitempty"`            // replaces the default arguments
+	Timeout uint64   `json:"timeout_seconds,omitempty"` // defaults to 30 seconds
+}
+
+type vaultConfig struct {
+	Address string `json:"address"`
+	Token   string `json:"token"` // defaults to the VAULT_TOKEN environment variable
+	Mount   string `json:"mount"`
+	Path    string `json:"path"`
+	Refresh uint64 `json:"refresh_minutes"`
+}
+
+type defaultCertConfig struct {
+	Cert string `json:"cert"` // path to the PEM encoded certificate chain
+	Key  string `json:"key"`  // path to the PEM encoded private key
+}
+
+type certDatabaseConfig struct {
+	Secret string `json:"secret"` // encrypts private keys, defaults to the VIOLET_CERT_DB_SECRET environment variable
+}
+
+type remoteSignerConfig struct {
+	Address string `json:"address"` // keyless signing service url
+	Token   string `json:"token"`   // defaults to the VIOLET_REMOTE_SIGNER_TOKEN environment variable
+}
+
+type ctMonitorConfig struct {
+	Webhook  string `json:"webhook"` // receives a JSON POST for each unexpected certificate
+	Interval uint64 `json:"interval_hours"`
+}
+
+type apiCorsConfig struct {
+	Origins []string `json:"origins"`           // origins allowed to call the API, `*` allows any origin
+	Methods []string `json:"methods,omitempty"` // defaults to the methods used by the API
+}
+
+type apiTlsConfig struct {
+	Cert     string `json:"cert,omitempty"`      // path to the PEM encoded certificate chain, defaults to the cert store
+	Key      string `json:"key,omitempty"`       // path to the PEM encoded private key
+	ClientCa string `json:"client_ca,omitempty"` // require client certificates signed by this PEM encoded CA
+}
+
+// apiLimitsConfig overrides the limits of the api servers, zero values keep
+// the defaults of one minute and 2500 header bytes
+type apiLimitsConfig struct {
+	ReadTimeout       uint64 `json:"read_timeout_seconds,omitempty"`
+	ReadHeaderTimeout uint64 `json:"read_header_timeout_seconds,omitempty"`
+	WriteTimeout      uint64 `json:"write_timeout_seconds,omitempty"`
+	IdleTimeout       uint64 `json:"idle_timeout_seconds,omitempty"`
+	MaxHeaderBytes    int    `json:"max_header_bytes,omitempty"` // raise for large tokens from single sign-on providers
+}
+
+type apiSocketConfig struct {
+	Path        string   `json:"path"`                   // unix socket path for the API
+	TrustedUids []uint32 `json:"trusted_uids,omitempty"` // local users allowed without a token
+}
+
+type domainVerifyConfig struct {
+	CnameTarget string `json:"cname_target,omitempty"` // use CNAME challenges pointing under this domain instead of TXT
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/MrMelon54/violet/domains"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/google/subcommands"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type domainsCmd struct {
+	configPath string
+	export     bool
+	imports    bool
+	format     string
+}
+
+func (d *domainsCmd) Name() string     { return "domains" }
+func (d *domainsCmd) Synopsis() string { return "Import or export the domain list" }
+func (d *domainsCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&d.configPath, "conf", "", "/path/to/config.json : path to the config file")
+	f.BoolVar(&d.export, "export", false, "Export the domain list")
+	f.BoolVar(&d.imports, "import", false, "Import the domain list")
+	f.StringVar(&d.format, "format", "", "json or csv (defaults to the file extension or json)")
+}
+func (d *domainsCmd) Usage() string {
+	return `domains -conf <config file> (-export | -import) [-format json|csv] [file]
+  Import or export the domain list with settings, the file defaults to stdin
+  or stdout. Imported domains are added or replaced in a single transaction.
+`
+}
+
+func (d *domainsCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if d.configPath == "" {
+		log.Println("[Violet] Error: config flag is missing")
+		return subcommands.ExitUsageError
+	}
+	if d.export == d.imports {
+		log.Println("[Violet] Error: exactly one of the export or import flags is required")
+		return subcommands.ExitUsageError
+	}
+	file := f.Arg(0)
+	format := d.format
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(file), ".")
+	}
+	if format != "csv" {
+		format = "json"
+	}
+
+	// working directory is the parent of the config file
+	wd := filepath.Dir(d.configPath)
+	db, err := sql.Open("sqlite3", filepath.Join(wd, "violet.db.sqlite"))
+	if err != nil {
+		log.Println("[Violet] Error: failed to open database: ", err)
+		return subcommands.ExitFailure
+	}
+	defer db.Close()
+	allowedDomains := domains.New(db)
+	if allowedDomains == nil {
+		return subcommands.ExitFailure
+	}
+
+	if d.export {
+		err = exportDomains(allowedDomains, file, format)
+	} else {
+		err = importDomains(allowedDomains, file, format)
+	}
+	if err != nil {
+		log.Println("[Violet] Error: ", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+func exportDomains(allowedDomains *domains.Domains, file, format string) error {
+	list, err := allowedDomains.Export()
+	if err != nil {
+		return fmt.Errorf("failed to export domains: %w", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if file != "" && file != "-" {
+		create, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer create.Close()
+		w = create
+	}
+
+	if format == "csv" {
+		return domains.WriteCsv(w, list)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(list)
+}
+
+func importDomains(allowedDomains *domains.Domains, file, format string) error {
+	var r io.Reader = os.Stdin
+	if file != "" && file != "-" {
+		open, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer open.Close()
+		r = open
+	}
+
+	var list []utils.DomainRecord
+	var err error
+	if format == "csv" {
+		list, err = domains.ReadCsv(r)
+	} else {
+		list, err = domains.ReadJson(r)
+	}
+	if err != nil {
+		return err
+	}
+	if err := allowedDomains.Import(list); err != nil {
+		return fmt.Errorf("failed to import domains: %w", err)
+	}
+	log.Printf("[Violet] Imported %d domains\n", len(list))
+	return nil
+}
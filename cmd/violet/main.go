@@ -15,6 +15,8 @@ func main() {
 	subcommands.Register(subcommands.CommandsCommand(), "")
 	subcommands.Register(&serveCmd{}, "")
 	subcommands.Register(&setupCmd{}, "")
+	subcommands.Register(&domainsCmd{}, "")
+	subcommands.Register(&apiKeysCmd{}, "")
 
 	flag.Parse()
 	ctx := context.Background()
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/MrMelon54/mjwt"
+	"github.com/MrMelon54/violet/apikeys"
+	"github.com/MrMelon54/violet/audit"
 	"github.com/MrMelon54/violet/certs"
 	"github.com/MrMelon54/violet/domains"
 	errorPages "github.com/MrMelon54/violet/error-pages"
@@ -17,13 +21,16 @@ import (
 	"github.com/MrMelon54/violet/servers/api"
 	"github.com/MrMelon54/violet/servers/conf"
 	"github.com/MrMelon54/violet/utils"
+	"github.com/MrMelon54/violet/webhooks"
 	"github.com/google/subcommands"
+	"google.golang.org/grpc"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -97,10 +104,14 @@ func normalLoad(startUp startUpConfig, wd string) {
 		}
 	}
 
-	// load the MJWT RSA public key from a pem encoded file
-	mJwtVerify, err := mjwt.NewMJwtVerifierFromFile(filepath.Join(wd, "signer.public.pem"))
-	if err != nil {
-		log.Fatalf("[Violet] Failed to load MJWT verifier public key from file '%s': %s", filepath.Join(wd, "signer.public.pem"), err)
+	// load the MJWT RSA public key from a pem encoded file, without the file
+	// only api keys and trusted local users can use the api
+	var mJwtVerify mjwt.Verifier
+	signerPath := filepath.Join(wd, "signer.public.pem")
+	if _, err := os.Stat(signerPath); errors.Is(err, fs.ErrNotExist) {
+		log.Printf("[Violet] MJWT verifier public key '%s' not found, only api keys are accepted\n", signerPath)
+	} else if mJwtVerify, err = mjwt.NewMJwtVerifierFromFile(signerPath); err != nil {
+		log.Fatalf("[Violet] Failed to load MJWT verifier public key from file '%s': %s", signerPath, err)
 	}
 
 	// open sqlite database
@@ -109,52 +120,320 @@ func normalLoad(startUp startUpConfig, wd string) {
 		log.Fatal("[Violet] Failed to open database")
 	}
 
-	certDir := os.DirFS(filepath.Join(wd, "certs"))
-	keyDir := os.DirFS(filepath.Join(wd, "keys"))
+	certDir := certs.DirFS(filepath.Join(wd, "certs"))
+	keyDir := certs.DirFS(filepath.Join(wd, "keys"))
 
 	allowedDomains := domains.New(db)                              // load allowed domains
 	acmeChallenges := utils.NewAcmeChallenge()                     // load acme challenge store
 	allowedCerts := certs.New(certDir, keyDir, startUp.SelfSigned) // load certificate manager
 	hybridTransport := proxy.NewHybridTransport()                  // load reverse proxy
-	dynamicFavicons := favicons.New(db, startUp.InkscapeCmd)       // load dynamic favicon provider
 	dynamicErrorPages := errorPages.New(errorPageDir)              // load dynamic error page provider
 	dynamicRouter := router.NewManager(db, hybridTransport)        // load dynamic router manager
+	dynamicRouter.SetDomainSettings(allowedDomains)                // wildcard depth of each domain
+	allowedDomains.SetRoutePurger(dynamicRouter)                   // remove routes of purged expired domains
+
+	// routes flagged for maintenance use the same page as domains
+	dynamicRouter.SetMaintenance(servers.MaintenanceHandler(allowedDomains, dynamicErrorPages))
+
+	// backend failures use separate pages for each failure
+	dynamicRouter.SetUpstreamErrors(servers.UpstreamErrorHandler(dynamicErrorPages))
+
+	// other responses generated by the router also use the error pages
+	dynamicRouter.SetErrorPages(servers.ErrorPageHandler(dynamicErrorPages))
+
+	// error pages managed using the api are stored in the database
+	if startUp.ErrorPageDb {
+		if err := dynamicErrorPages.SetDatabase(db); err != nil {
+			log.Fatalf("[Violet] Failed to setup error page database: %s", err)
+		}
+	}
+
+	// the built-in error pages use the theme instead of plain text responses
+	if t := startUp.ErrorTheme; t != nil {
+		err := dynamicErrorPages.SetTheme(errorPages.Theme{
+			SiteName:   t.SiteName,
+			LogoUrl:    t.LogoUrl,
+			Background: t.Background,
+			Text:       t.Text,
+			Accent:     t.Accent,
+		})
+		if err != nil {
+			log.Fatalf("[Violet] Invalid error page theme: %s", err)
+		}
+	}
+
+	// load dynamic favicon provider with the extra webp or avif formats
+	dynamicFavicons := favicons.New(db, loadSvgConverter(startUp), loadIconFormats(startUp)...)
+	// icons on hosts served by violet are downloaded using the router so
+	// internal only services work
+	dynamicFavicons.SetRouter(dynamicRouter, allowedDomains.IsValid)
+	dynamicFavicons.SetDomainSettings(allowedDomains)
+	dynamicFavicons.SetConcurrency(startUp.IconWorkers)
+	dynamicFavicons.SetDiscovery(time.Duration(startUp.IconDiscover)*time.Minute, allowedDomains.IsValid)
+	if startUp.IconDefault != nil {
+		if err := dynamicFavicons.SetDefault(loadDefaultFavicon(*startUp.IconDefault)); err != nil {
+			log.Fatalf("[Violet] Failed to setup default favicon: %s", err)
+		}
+	}
+
+	// favicons with a refresh interval are checked for changes every minute
+	go dynamicFavicons.WatchRefresh(time.Minute)
+
+	// keep deleted routes and redirects in the recycle bin for longer or shorter
+	if startUp.RecycleDays > 0 {
+		dynamicRouter.SetRecycleRetention(time.Duration(startUp.RecycleDays) * 24 * time.Hour)
+	}
+	dynamicRouter.SetGenerationHeader(startUp.GenHeader)
+
+	// new domains stay inactive until the dns challenge is verified
+	if startUp.DomainVerify != nil {
+		allowedDomains.RequireVerification(startUp.DomainVerify.CnameTarget)
+	}
+
+	// load certificates from the database instead of the filesystem
+	if startUp.CertDatabase != nil && !startUp.SelfSigned {
+		secret := startUp.CertDatabase.Secret
+		if secret == "" {
+			secret = os.Getenv("VIOLET_CERT_DB_SECRET")
+		}
+		dbStore, err := certs.NewDBStore(db, secret)
+		if err != nil {
+			log.Fatalf("[Violet] Failed to load certificate database: %s", err)
+		}
+		allowedCerts = certs.NewFromStore(dbStore)
+	}
+
+	// load certificates from vault instead of the filesystem
+	if v := startUp.Vault; v != nil && !startUp.SelfSigned {
+		token := v.Token
+		if token == "" {
+			token = os.Getenv("VAULT_TOKEN")
+		}
+		allowedCerts = certs.NewFromStore(certs.NewVaultStore(v.Address, token, v.Mount, v.Path))
+		if v.Refresh > 0 {
+			go allowedCerts.CompileEvery(time.Duration(v.Refresh) * time.Minute)
+		}
+	}
+
+	// passphrase for encrypted private keys
+	keyPass := startUp.KeyPass
+	if keyPass == "" {
+		keyPass = os.Getenv("VIOLET_KEY_PASSPHRASE")
+	}
+	if keyPass == "" && startUp.KeyPassFile != "" {
+		keyPassRaw, err := os.ReadFile(startUp.KeyPassFile)
+		if err != nil {
+			log.Fatalf("[Violet] Failed to read key passphrase file '%s': %s", startUp.KeyPassFile, err)
+		}
+		keyPass = strings.TrimRight(string(keyPassRaw), "\r\n")
+	}
+	if keyPass != "" {
+		allowedCerts.SetKeyPassphrase([]byte(keyPass))
+	}
+	allowedCerts.SetPkcs12Passwords(startUp.Pkcs12Pass)
+
+	// sign handshakes remotely for certificates without a private key
+	if r := startUp.RemoteSigner; r != nil {
+		token := r.Token
+		if token == "" {
+			token = os.Getenv("VIOLET_REMOTE_SIGNER_TOKEN")
+		}
+		allowedCerts.SetRemoteSigner(certs.NewRemoteSigner(r.Address, token))
+	}
+
+	// load certificates from the certbot live directory
+	if startUp.CertbotLive != "" {
+		allowedCerts.SetCertbotLive(os.DirFS(startUp.CertbotLive))
+	}
+
+	// certificate used when no other certificate is available
+	if startUp.DefaultCert != nil {
+		defCert, err := os.ReadFile(startUp.DefaultCert.Cert)
+		if err != nil {
+			log.Fatalf("[Violet] Failed to read default certificate '%s': %s", startUp.DefaultCert.Cert, err)
+		}
+		defKey, err := os.ReadFile(startUp.DefaultCert.Key)
+		if err != nil {
+			log.Fatalf("[Violet] Failed to read default key '%s': %s", startUp.DefaultCert.Key, err)
+		}
+		if err := allowedCerts.SetDefaultCert(defCert, defKey); err != nil {
+			log.Fatalf("[Violet] Failed to load default certificate: %s", err)
+		}
+	}
+
+	// key type used for generating self-signed certificates
+	if err := allowedCerts.SetSelfSignedKeyType(certs.KeyType(startUp.SelfKeyType)); err != nil {
+		log.Fatalf("[Violet] Invalid self-signed key type '%s'", startUp.SelfKeyType)
+	}
+
+	// generate self-signed certificates for domains without a certificate
+	if startUp.SelfFallback && !startUp.SelfSigned {
+		allowedCerts.EnableSelfSignedFallback()
+	}
+
+	// search certificate transparency logs for unexpected certificates
+	if ct := startUp.CtMonitor; ct != nil && !startUp.SelfSigned {
+		interval := time.Duration(ct.Interval) * time.Hour
+		if interval <= 0 {
+			interval = 6 * time.Hour
+		}
+		go allowedCerts.WatchCertTransparency(certs.NewCtMonitor(ct.Webhook), interval)
+	}
+
+	// sign self-signed certificates with a persistent CA
+	if startUp.SelfSigned || startUp.SelfFallback {
+		err := os.MkdirAll(filepath.Join(wd, "ca"), os.ModePerm)
+		if err != nil {
+			log.Fatal("[Violet] Failed to create self-signed CA path")
+		}
+		if err := allowedCerts.LoadSelfSignedCa(certs.DirFS(filepath.Join(wd, "ca"))); err != nil {
+			log.Fatalf("[Violet] Failed to load self-signed CA: %s", err)
+		}
+	}
 
 	// struct containing config for the http servers
 	srvConf := &conf.Conf{
-		ApiListen:   startUp.Listen.Api,
-		HttpListen:  startUp.Listen.Http,
-		HttpsListen: startUp.Listen.Https,
-		RateLimit:   startUp.RateLimit,
-		DB:          db,
-		Domains:     allowedDomains,
-		Acme:        acmeChallenges,
-		Certs:       allowedCerts,
-		Favicons:    dynamicFavicons,
-		Signer:      mJwtVerify,
-		ErrorPages:  dynamicErrorPages,
-		Router:      dynamicRouter,
+		ApiListen:         startUp.Listen.Api,
+		HttpListen:        startUp.Listen.Http,
+		HttpsListen:       startUp.Listen.Https,
+		GrpcListen:        startUp.Listen.Grpc,
+		RateLimit:         startUp.RateLimit,
+		FaviconCache:      startUp.IconCache,
+		MaxBodyBytes:      startUp.MaxBodyBytes,
+		MaxHeaderBytes:    startUp.MaxHeaderSize,
+		RejectSni:         startUp.RejectSni,
+		AutoRegister:      startUp.AutoRegister,
+		ApiRateLimit:      startUp.ApiRateLimit,
+		ApiAuthFailures:   startUp.ApiAuthFails,
+		ApiRequireIfMatch: startUp.ApiIfMatch,
+		DB:                db,
+		Domains:           allowedDomains,
+		Acme:              acmeChallenges,
+		Certs:             allowedCerts,
+		Favicons:          dynamicFavicons,
+		Signer:            mJwtVerify,
+		ErrorPages:        dynamicErrorPages,
+		Router:            dynamicRouter,
+		Audit:             audit.New(db),
+		ApiKeys:           apikeys.New(db),
+		Listeners:         utils.NewListenerStates(),
+		Stats:             utils.NewHostStats(),
+	}
+
+	// record domain actions in the api audit log
+	allowedDomains.SetAudit(srvConf.Audit)
+
+	// notify external systems about configuration, domain and certificate events
+	for _, i := range startUp.DomainHooks {
+		startUp.Webhooks = append(startUp.Webhooks, webhooks.Hook{Url: i, Events: []string{webhooks.DomainChange}})
+	}
+	srvConf.Webhooks, err = webhooks.New(startUp.Webhooks)
+	if err != nil {
+		log.Fatalf("[Violet] Failed to setup webhooks: %s", err)
+	}
+	if srvConf.Webhooks != nil {
+		go sendCertRenewals(allowedCerts, srvConf.Webhooks)
+	}
+
+	if l := startUp.ApiLimits; l != nil {
+		srvConf.ApiLimits = conf.ServerLimits{
+			ReadTimeout:       time.Duration(l.ReadTimeout) * time.Second,
+			ReadHeaderTimeout: time.Duration(l.ReadHeaderTimeout) * time.Second,
+			WriteTimeout:      time.Duration(l.WriteTimeout) * time.Second,
+			IdleTimeout:       time.Duration(l.IdleTimeout) * time.Second,
+			MaxHeaderBytes:    l.MaxHeaderBytes,
+		}
+	}
+	if startUp.ApiCors != nil {
+		srvConf.ApiCorsOrigins = startUp.ApiCors.Origins
+		srvConf.ApiCorsMethods = startUp.ApiCors.Methods
+	}
+
+	// write api requests to a separate access log, `-` uses stdout
+	switch startUp.ApiAccessLog {
+	case "":
+	case "-":
+		srvConf.ApiAccessLog = os.Stdout
+	default:
+		accessLog, err := os.OpenFile(startUp.ApiAccessLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalf("[Violet] Failed to open API access log '%s': %s", startUp.ApiAccessLog, err)
+		}
+		srvConf.ApiAccessLog = accessLog
+	}
+
+	// terminate tls on the api server
+	if startUp.ApiTls != nil {
+		var apiCert, apiKey, apiClientCa []byte
+		for _, i := range []struct {
+			name, path string
+			out        *[]byte
+		}{
+			{"certificate", startUp.ApiTls.Cert, &apiCert},
+			{"key", startUp.ApiTls.Key, &apiKey},
+			{"client CA", startUp.ApiTls.ClientCa, &apiClientCa},
+		} {
+			if i.path == "" {
+				continue
+			}
+			*i.out, err = os.ReadFile(i.path)
+			if err != nil {
+				log.Fatalf("[Violet] Failed to read API %s '%s': %s", i.name, i.path, err)
+			}
+		}
+		srvConf.ApiTls, err = api.NewApiTlsConfig(allowedCerts, apiCert, apiKey, apiClientCa)
+		if err != nil {
+			log.Fatalf("[Violet] Failed to setup API TLS: %s", err)
+		}
 	}
 
 	// create the compilable list and run a first time compile
 	allCompilables := utils.MultiCompilable{allowedDomains, allowedCerts, dynamicFavicons, dynamicErrorPages, dynamicRouter}
 	allCompilables.Compile()
 
-	var srvApi, srvHttp, srvHttps *http.Server
+	// warn about certificates close to expiry
+	if startUp.CertExpiry > 0 {
+		go allowedCerts.WatchExpiry(time.Duration(startUp.CertExpiry)*24*time.Hour, 12*time.Hour, func(leaf *x509.Certificate) {
+			srvConf.Webhooks.Send(webhooks.CertExpiry, certExpiryEvent{leaf.Subject.CommonName, leaf.DNSNames, leaf.NotAfter})
+		})
+	}
+
+	var srvApi, srvApiSocket, srvHttp, srvHttps *http.Server
+	var srvGrpc *grpc.Server
+	if srvConf.ApiListen != "" || startUp.ApiSocket != nil || srvConf.GrpcListen != "" {
+		srvApi, srvGrpc = api.NewApiServers(srvConf, allCompilables)
+	} else if srvConf.Webhooks != nil {
+		// without the api the domain list only changes when domains expire
+		allowedDomains.SetEventHook(func(e domains.Event) {
+			srvConf.Webhooks.Send(webhooks.DomainChange, e)
+		})
+	}
 	if srvConf.ApiListen != "" {
-		srvApi = api.NewApiServer(srvConf, allCompilables)
 		log.Printf("[API] Starting API server on: '%s'\n", srvApi.Addr)
-		go utils.RunBackgroundHttp("API", srvApi)
+		if srvApi.TLSConfig != nil {
+			go utils.RunBackgroundHttps("API", srvApi, srvConf.Listeners)
+		} else {
+			go utils.RunBackgroundHttp("API", srvApi, srvConf.Listeners)
+		}
+	}
+	if startUp.ApiSocket != nil {
+		srvApiSocket = api.NewApiSocketServer(srvApi.Handler, startUp.ApiSocket.TrustedUids, srvConf.ApiLimits)
+		log.Printf("[API] Starting API server on socket: '%s'\n", startUp.ApiSocket.Path)
+		go utils.RunBackgroundUnix("API", srvApiSocket, startUp.ApiSocket.Path, srvConf.Listeners)
+	}
+	if srvGrpc != nil {
+		log.Printf("[gRPC] Starting gRPC server on: '%s'\n", srvConf.GrpcListen)
+		go utils.RunBackgroundServe("gRPC", srvConf.GrpcListen, srvGrpc.Serve, srvConf.Listeners)
 	}
 	if srvConf.HttpListen != "" {
 		srvHttp = servers.NewHttpServer(srvConf)
 		log.Printf("[HTTP] Starting HTTP server on: '%s'\n", srvHttp.Addr)
-		go utils.RunBackgroundHttp("HTTP", srvHttp)
+		go utils.RunBackgroundHttp("HTTP", srvHttp, srvConf.Listeners)
 	}
 	if srvConf.HttpsListen != "" {
 		srvHttps = servers.NewHttpsServer(srvConf)
 		log.Printf("[HTTPS] Starting HTTPS server on: '%s'\n", srvHttps.Addr)
-		go utils.RunBackgroundHttps("HTTPS", srvHttps)
+		go utils.RunBackgroundHttps("HTTPS", srvHttps, srvConf.Listeners)
 	}
 
 	// Wait for exit signal
@@ -171,6 +450,12 @@ func normalLoad(startUp startUpConfig, wd string) {
 	if srvApi != nil {
 		srvApi.Close()
 	}
+	if srvApiSocket != nil {
+		srvApiSocket.Close()
+	}
+	if srvGrpc != nil {
+		srvGrpc.Stop()
+	}
 	if srvHttp != nil {
 		srvHttp.Close()
 	}
@@ -181,3 +466,91 @@ func normalLoad(startUp startUpConfig, wd string) {
 	log.Printf("[Violet] Took '%s' to shutdown\n", time.Now().Sub(n))
 	log.Println("[Violet] Goodbye")
 }
+
+// certExpiryEvent is the data of the certificate expiry webhook
+type certExpiryEvent struct {
+	CommonName string    `json:"common_name"`
+	DnsNames   []string  `json:"dns_names"`
+	NotAfter   time.Time `json:"not_after"`
+}
+
+// sendCertRenewals sends a webhook for each certificate compile or reload which
+// replaced the certificates of existing domains
+func sendCertRenewals(c *certs.Certs, hooks *webhooks.Webhooks) {
+	ch, _ := c.SubscribeCompileDiff()
+	for d := range ch {
+		if len(d.Changed) > 0 {
+			hooks.Send(webhooks.CertRenew, d)
+		}
+	}
+}
+
+// loadSvgConverter creates the converter for svg favicons, the inkscape option
+// is used when no converter is configured
+func loadSvgConverter(startUp startUpConfig) favicons.Converter {
+	c := startUp.SvgConverter
+	if c == nil {
+		if startUp.InkscapeCmd == "" {
+			return favicons.Builtin
+		}
+		c = &svgConverterConfig{Type: "inkscape", Path: startUp.InkscapeCmd}
+	}
+	conv, err := favicons.NewConverter(c.Type, c.Path, c.Args, c.Size, time.Duration(c.Timeout)*time.Second)
+	if err != nil {
+		log.Fatalf("[Violet] Failed to setup svg converter: %s", err)
+	}
+	if cmd, ok := conv.(*favicons.CommandConverter); ok {
+		if c.MaxInput > 0 {
+			cmd.Limits.MaxInput = int(c.MaxInput) << 10
+		}
+		c.applyLimits(&cmd.Limits)
+	}
+	return conv
+}
+
+// applyLimits sets the memory and cpu limits of the converter programs
+func (c *svgConverterConfig) applyLimits(l *favicons.Limits) {
+	l.Memory = c.Memory << 20
+	l.CPU = time.Duration(c.CPU) * time.Second
+}
+
+// loadDefaultFavicon reads the file paths of the default favicon into data
+// urls, http and https urls are downloaded when compiling
+func loadDefaultFavicon(c defaultIconConfig) favicons.FaviconRecord {
+	load := func(src, contentType string) string {
+		if src == "" || strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+			return src
+		}
+		raw, err := os.ReadFile(src)
+		if err != nil {
+			log.Fatalf("[Violet] Failed to read default favicon: %s", err)
+		}
+		return favicons.DataUrl(contentType, raw)
+	}
+	return favicons.FaviconRecord{
+		Svg: load(c.Svg, "image/svg+xml"),
+		Png: load(c.Png, "image/png"),
+		Ico: load(c.Ico, "image/x-icon"),
+
+		DarkSvg:    load(c.DarkSvg, "image/svg+xml"),
+		Name:       c.Name,
+		ThemeColor: c.ThemeColor,
+	}
+}
+
+// loadIconFormats creates the extra formats for png favicons, the encoders use
+// the memory and cpu limits of the svg converter
+func loadIconFormats(startUp startUpConfig) []favicons.Format {
+	formats := make([]favicons.Format, 0, len(startUp.IconFormats))
+	for _, c := range startUp.IconFormats {
+		f, err := favicons.NewFormat(c.Type, c.Path, c.Args, time.Duration(c.Timeout)*time.Second)
+		if err != nil {
+			log.Fatalf("[Violet] Failed to setup favicon format: %s", err)
+		}
+		if cmd, ok := f.Enc.(*favicons.CommandConverter); ok && startUp.SvgConverter != nil {
+			startUp.SvgConverter.applyLimits(&cmd.Limits)
+		}
+		formats = append(formats, f)
+	}
+	return formats
+}
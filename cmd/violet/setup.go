@@ -139,6 +139,7 @@ func (s *setupCmd) Execute(_ context.Context, _ *flag.FlagSet, _ ...interface{})
 		},
 		InkscapeCmd: "inkscape",
 		RateLimit:   answers.RateLimit,
+		CertExpiry:  14,
 	})
 	if err != nil {
 		fmt.Println("[Violet] Failed to write config file: ", err)
@@ -0,0 +1,101 @@
+package domains
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/MrMelon54/violet/audit"
+	"github.com/MrMelon54/violet/utils"
+	"io/fs"
+)
+
+var ErrDomainActive = errors.New("domain must be disabled before it is purged")
+
+// auditMethod is the method of the audit log entries for domain actions, the
+// path is the domain endpoint and the new value is the action
+const auditMethod = "DOMAIN"
+
+// execer is implemented by both sql.DB and sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// SetAudit sets the log which records each action on a domain in the same
+// transaction as the change, nil disables the audit trail
+func (d *Domains) SetAudit(l *audit.Log) {
+	d.audit = l
+}
+
+// addAudit records an action on the domain in the audit trail
+func (d *Domains) addAudit(tx *sql.Tx, domain, action, actor string) error {
+	if d.audit == nil {
+		return nil
+	}
+	return d.audit.RecordTx(tx, audit.Entry{Actor: actor, Method: auditMethod, Path: "/domain/" + domain, New: action})
+}
+
+// Purge removes a disabled domain and its settings, ErrDomainActive is
+// returned if the domain is still active and fs.ErrNotExist is returned if the
+// domain is not registered.
+func (d *Domains) Purge(domain, actor string) error {
+	domain, ok := utils.NormaliseDomain(domain)
+	if !ok {
+		return fs.ErrNotExist
+	}
+	d.s.Lock()
+	defer d.s.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var active bool
+	err = tx.QueryRow(`SELECT active FROM domains WHERE domain = ?`, domain).Scan(&active)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return fs.ErrNotExist
+	case err != nil:
+		return err
+	case active:
+		return ErrDomainActive
+	}
+
+	if _, err := tx.Exec(`DELETE FROM domains WHERE domain = ?`, domain); err != nil {
+		return err
+	}
+	if err := d.addAudit(tx, domain, "purge", actor); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	d.publishChange(domain, domainState{exists: true}, domainState{})
+	return nil
+}
+
+// Audit returns the audit trail for the domain ordered from oldest to newest.
+func (d *Domains) Audit(domain string) ([]utils.DomainAudit, error) {
+	domain, ok := utils.NormaliseDomain(domain)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	list := make([]utils.DomainAudit, 0)
+	if d.audit == nil {
+		return list, nil
+	}
+
+	// the path filter is a prefix so entries of longer domains are skipped
+	p := "/domain/" + domain
+	entries, _, err := d.audit.List(audit.Filter{Method: auditMethod, Path: p}, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Path != p {
+			continue
+		}
+		list = append(list, utils.DomainAudit{Domain: domain, Action: e.New, Actor: e.Actor, Time: e.Time})
+	}
+	return list, nil
+}
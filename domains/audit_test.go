@@ -0,0 +1,52 @@
+package domains
+
+import (
+	"database/sql"
+	"github.com/MrMelon54/violet/audit"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"testing"
+)
+
+func TestDomains_Purge(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestDomains_Purge?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	domains := New(db)
+	log := audit.New(db)
+	domains.SetAudit(log)
+
+	assert.ErrorIs(t, domains.Purge("example.com", "abc"), fs.ErrNotExist)
+
+	// active domains can't be purged
+	domains.Put("example.com", true)
+	assert.ErrorIs(t, domains.Purge("example.com", "abc"), ErrDomainActive)
+
+	// deleting only disables the domain
+	domains.Delete("example.com", "abc")
+	_, err = domains.LoadSettings("example.com")
+	assert.NoError(t, err)
+
+	assert.NoError(t, domains.Purge("example.com", "def"))
+	_, err = domains.LoadSettings("example.com")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	trail, err := domains.Audit("example.com")
+	assert.NoError(t, err)
+	assert.Len(t, trail, 2)
+	assert.Equal(t, "disable", trail[0].Action)
+	assert.Equal(t, "abc", trail[0].Actor)
+	assert.Equal(t, "purge", trail[1].Action)
+	assert.Equal(t, "def", trail[1].Actor)
+	assert.False(t, trail[1].Time.IsZero())
+
+	// the actions are stored in the api audit log and longer domains with the
+	// same prefix are not included
+	domains.Delete("example.com.au", "abc")
+	trail, err = domains.Audit("example.com")
+	assert.NoError(t, err)
+	assert.Len(t, trail, 2)
+	entries, total, err := log.List(audit.Filter{Method: "DOMAIN"}, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, audit.Entry{Id: entries[0].Id, Actor: "abc", Method: "DOMAIN", Path: "/domain/example.com.au", New: "disable", Time: entries[0].Time}, entries[0])
+}
@@ -0,0 +1,367 @@
+package domains
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MrMelon54/violet/utils"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidRecord = errors.New("invalid domain record")
+
+// csvHeader is the list of columns used for CSV import and export
+var csvHeader = []string{"domain", "active", "owner", "force_https", "hsts", "default_backend", "wildcard_depth", "rate_limit", "expires", "maintenance", "maintenance_page", "maintenance_retry_after", "maintenance_allow"}
+
+// defaultRecord returns a record using the default settings of the domains
+// table for fields missing from imported data
+func defaultRecord() utils.DomainRecord {
+	var r utils.DomainRecord
+	r.Active = true
+	r.ForceHttps = true
+	r.WildcardDepth = 1
+	return r
+}
+
+// Export returns every domain with its settings ordered by name.
+func (d *Domains) Export() ([]utils.DomainRecord, error) {
+	rows, err := d.db.Query(`SELECT domain, active, owner, ` + settingsColumns + ` FROM domains ORDER BY domain`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]utils.DomainRecord, 0)
+	for rows.Next() {
+		var r utils.DomainRecord
+		err := rows.Scan(append([]any{&r.Domain, &r.Active, &r.Owner}, settingsFields(&r.DomainSettings)...)...)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// Import adds or replaces the domains and their settings in a single
+// transaction, no changes are made if any record is invalid.
+//
+// Imported domains skip DNS verification as they are expected to come from
+// another instance or a trusted seed list.
+func (d *Domains) Import(records []utils.DomainRecord) error {
+	if err := normaliseRecords(records); err != nil {
+		return err
+	}
+
+	d.s.Lock()
+	defer d.s.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	publish, err := d.importRecords(tx, records)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	publish()
+	return nil
+}
+
+// ImportTx adds or replaces the domains and their settings using a transaction
+// shared with other tables, no changes are made if any record is invalid. The
+// returned function publishes the changes and must only be called after the
+// transaction is committed.
+func (d *Domains) ImportTx(tx *sql.Tx, records []utils.DomainRecord) (func(), error) {
+	if err := normaliseRecords(records); err != nil {
+		return nil, err
+	}
+	return d.importRecords(tx, records)
+}
+
+// normaliseRecords validates each record and normalises the domain,
+// ErrInvalidRecord is returned if any record is invalid
+func normaliseRecords(records []utils.DomainRecord) error {
+	for i := range records {
+		domain, ok := utils.NormaliseDomain(records[i].Domain)
+		if !ok || domain == "" {
+			return fmt.Errorf("%w: line %d: invalid domain '%s'", ErrInvalidRecord, i+1, records[i].Domain)
+		}
+		if records[i].WildcardDepth < 1 {
+			return fmt.Errorf("%w: line %d: wildcard depth must be at least 1", ErrInvalidRecord, i+1)
+		}
+		if records[i].Expires < 0 {
+			return fmt.Errorf("%w: line %d: expiry must not be negative", ErrInvalidRecord, i+1)
+		}
+		if strings.ContainsAny(records[i].Hsts, "\r\n") {
+			return fmt.Errorf("%w: line %d: invalid HSTS policy", ErrInvalidRecord, i+1)
+		}
+		if !utils.ValidAddrList(records[i].MaintenanceAllow) {
+			return fmt.Errorf("%w: line %d: invalid maintenance allow list", ErrInvalidRecord, i+1)
+		}
+		records[i].Domain = domain
+	}
+	return nil
+}
+
+// importRecords writes the records using the transaction and returns the
+// function which publishes the changes
+func (d *Domains) importRecords(tx *sql.Tx, records []utils.DomainRecord) (func(), error) {
+	stmt, err := tx.Prepare(`INSERT INTO domains (domain, active, owner, force_https, hsts, default_backend, wildcard_depth, rate_limit, expires, maintenance, maintenance_page, maintenance_retry_after, maintenance_allow, verify_token) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, '')
+ON CONFLICT(domain) DO UPDATE SET active = excluded.active, owner = excluded.owner, force_https = excluded.force_https, hsts = excluded.hsts, default_backend = excluded.default_backend, wildcard_depth = excluded.wildcard_depth, rate_limit = excluded.rate_limit, expires = excluded.expires, maintenance = excluded.maintenance, maintenance_page = excluded.maintenance_page, maintenance_retry_after = excluded.maintenance_retry_after, maintenance_allow = excluded.maintenance_allow, verify_token = ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	before := make([]domainState, len(records))
+	for i, r := range records {
+		before[i], err = getState(tx, r.Domain)
+		if err != nil {
+			return nil, err
+		}
+		_, err := stmt.Exec(r.Domain, r.Active, r.Owner, r.ForceHttps, r.Hsts, r.DefaultBackend, r.WildcardDepth, r.RateLimit, r.Expires, r.Maintenance, r.MaintenancePage, r.MaintenanceRetry, r.MaintenanceAllow)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return func() {
+		for i, r := range records {
+			d.publishChange(r.Domain, before[i], domainState{exists: true, active: r.Active})
+		}
+	}, nil
+}
+
+// Batch adds the domains in put and disables the domains in del in a single
+// transaction, no changes are made if any domain is invalid. Added domains
+// without an owner are owned by the actor and require verification if it is
+// enabled.
+func (d *Domains) Batch(put, del []string, actor string) error {
+	put, err := normaliseList(put)
+	if err != nil {
+		return err
+	}
+	del, err = normaliseList(del)
+	if err != nil {
+		return err
+	}
+
+	d.s.Lock()
+	defer d.s.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type change struct {
+		domain        string
+		before, after domainState
+	}
+	changes := make([]change, 0, len(put)+len(del))
+	for _, domain := range put {
+		before, err := getState(tx, domain)
+		if err != nil {
+			return err
+		}
+		if d.verify {
+			err = d.putPending(tx, domain, true)
+		} else {
+			_, err = tx.Exec("INSERT INTO domains (domain, active) VALUES (?, ?) ON CONFLICT(domain) DO UPDATE SET active = excluded.active", domain, true)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE domains SET owner = ? WHERE domain = ? AND owner = ''`, actor, domain); err != nil {
+			return err
+		}
+		after, err := getState(tx, domain)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change{domain, before, after})
+	}
+	for _, domain := range del {
+		before, err := getState(tx, domain)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO domains (domain, active) VALUES (?, ?) ON CONFLICT(domain) DO UPDATE SET active = excluded.active", domain, false)
+		if err != nil {
+			return err
+		}
+		if err := d.addAudit(tx, domain, "disable", actor); err != nil {
+			return err
+		}
+		changes = append(changes, change{domain, before, domainState{exists: true}})
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		d.publishChange(c.domain, c.before, c.after)
+	}
+	return nil
+}
+
+// normaliseList returns the normalised form of each domain, ErrInvalidRecord
+// is returned if any domain is invalid
+func normaliseList(list []string) ([]string, error) {
+	out := make([]string, len(list))
+	for i, name := range list {
+		domain, ok := utils.NormaliseDomain(name)
+		if !ok || domain == "" {
+			return nil, fmt.Errorf("%w: invalid domain '%s'", ErrInvalidRecord, name)
+		}
+		out[i] = domain
+	}
+	return out, nil
+}
+
+// ReadJson decodes a JSON array of domain records, missing fields use the
+// default settings.
+func ReadJson(r io.Reader) ([]utils.DomainRecord, error) {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return nil, fmt.Errorf("%w: expected JSON array", ErrInvalidRecord)
+	}
+	list := make([]utils.DomainRecord, 0)
+	for dec.More() {
+		a := defaultRecord()
+		if err := dec.Decode(&a); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidRecord, len(list)+1, err)
+		}
+		list = append(list, a)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+	}
+	return list, nil
+}
+
+// WriteCsv encodes the domain records as CSV with a header row.
+func WriteCsv(w io.Writer, records []utils.DomainRecord) error {
+	c := csv.NewWriter(w)
+	if err := c.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		err := c.Write([]string{
+			r.Domain,
+			strconv.FormatBool(r.Active),
+			r.Owner,
+			strconv.FormatBool(r.ForceHttps),
+			r.Hsts,
+			r.DefaultBackend,
+			strconv.Itoa(r.WildcardDepth),
+			strconv.FormatUint(r.RateLimit, 10),
+			strconv.FormatInt(r.Expires, 10),
+			strconv.FormatBool(r.Maintenance),
+			r.MaintenancePage,
+			strconv.FormatUint(r.MaintenanceRetry, 10),
+			r.MaintenanceAllow,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// ReadCsv decodes CSV domain records, the first row is a header naming the
+// columns. Only the domain column is required, missing columns use the
+// default settings.
+func ReadCsv(r io.Reader) ([]utils.DomainRecord, error) {
+	c := csv.NewReader(r)
+	header, err := c.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing header: %s", ErrInvalidRecord, err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := cols["domain"]; !ok {
+		return nil, fmt.Errorf("%w: missing domain column", ErrInvalidRecord)
+	}
+
+	list := make([]utils.DomainRecord, 0)
+	for {
+		row, err := c.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+		}
+		a := defaultRecord()
+		if err := parseCsvRow(&a, cols, row); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidRecord, len(list)+1, err)
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+// parseCsvRow fills the record using the columns present in the row
+func parseCsvRow(a *utils.DomainRecord, cols map[string]int, row []string) error {
+	get := func(name string) (string, bool) {
+		if i, ok := cols[name]; ok && i < len(row) && row[i] != "" {
+			return row[i], true
+		}
+		return "", false
+	}
+
+	var err error
+	a.Domain, _ = get("domain")
+	if v, ok := get("active"); ok {
+		if a.Active, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid active value '%s'", v)
+		}
+	}
+	a.Owner, _ = get("owner")
+	if v, ok := get("force_https"); ok {
+		if a.ForceHttps, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid force_https value '%s'", v)
+		}
+	}
+	a.Hsts, _ = get("hsts")
+	a.DefaultBackend, _ = get("default_backend")
+	if v, ok := get("wildcard_depth"); ok {
+		if a.WildcardDepth, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid wildcard_depth value '%s'", v)
+		}
+	}
+	if v, ok := get("rate_limit"); ok {
+		if a.RateLimit, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return fmt.Errorf("invalid rate_limit value '%s'", v)
+		}
+	}
+	if v, ok := get("expires"); ok {
+		if a.Expires, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("invalid expires value '%s'", v)
+		}
+	}
+	if v, ok := get("maintenance"); ok {
+		if a.Maintenance, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid maintenance value '%s'", v)
+		}
+	}
+	a.MaintenancePage, _ = get("maintenance_page")
+	if v, ok := get("maintenance_retry_after"); ok {
+		if a.MaintenanceRetry, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return fmt.Errorf("invalid maintenance_retry_after value '%s'", v)
+		}
+	}
+	a.MaintenanceAllow, _ = get("maintenance_allow")
+	return nil
+}
@@ -0,0 +1,98 @@
+package domains
+
+import (
+	"bytes"
+	"database/sql"
+	"github.com/MrMelon54/violet/audit"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestDomains_ImportExport(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestDomains_ImportExport?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	domains := New(db)
+	domains.Put("example.com", false)
+
+	list, err := ReadCsv(strings.NewReader("domain,active,hsts,rate_limit\nexample.com,true,max-age=300,10\nBücher.example,false,,\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, domains.Import(list))
+
+	out, err := domains.Export()
+	assert.NoError(t, err)
+	a := utils.DomainRecord{
+		DomainEntry:    utils.DomainEntry{Domain: "example.com", Active: true},
+		DomainSettings: utils.DomainSettings{ForceHttps: true, Hsts: "max-age=300", WildcardDepth: 1, RateLimit: 10},
+	}
+	b := utils.DomainRecord{
+		DomainEntry:    utils.DomainEntry{Domain: "xn--bcher-kva.example"},
+		DomainSettings: utils.DomainSettings{ForceHttps: true, WildcardDepth: 1},
+	}
+	assert.Equal(t, []utils.DomainRecord{a, b}, out)
+
+	// an invalid record stops the whole import
+	err = domains.Import([]utils.DomainRecord{{DomainEntry: utils.DomainEntry{Domain: "example.org"}, DomainSettings: utils.DomainSettings{WildcardDepth: 1}}, {}})
+	assert.ErrorIs(t, err, ErrInvalidRecord)
+	out, err = domains.Export()
+	assert.NoError(t, err)
+	assert.Len(t, out, 2)
+}
+
+func TestDomains_Batch(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestDomains_Batch?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	domains := New(db)
+	domains.SetAudit(audit.New(db))
+	domains.Put("example.org", true)
+	assert.NoError(t, domains.SetOwner("example.org", "other"))
+
+	assert.NoError(t, domains.Batch([]string{"example.com", "Bücher.example"}, []string{"example.org"}, "abc"))
+	list, _, err := domains.List("", 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []utils.DomainEntry{
+		{Domain: "example.com", Active: true, Owner: "abc"},
+		{Domain: "example.org", Owner: "other"},
+		{Domain: "xn--bcher-kva.example", Active: true, Owner: "abc"},
+	}, list)
+	trail, err := domains.Audit("example.org")
+	assert.NoError(t, err)
+	assert.Len(t, trail, 1)
+
+	// an invalid domain stops the whole batch
+	assert.ErrorIs(t, domains.Batch([]string{"example.net", ""}, nil, "abc"), ErrInvalidRecord)
+	_, err = domains.LoadSettings("example.net")
+	assert.Error(t, err)
+}
+
+func TestCsv(t *testing.T) {
+	list := []utils.DomainRecord{{
+		DomainEntry:    utils.DomainEntry{Domain: "example.com", Active: true, Owner: "abc"},
+		DomainSettings: utils.DomainSettings{Hsts: "max-age=300, preload", DefaultBackend: "127.0.0.1:8080", WildcardDepth: 2, MaintenanceRetry: 120, MaintenanceAllow: "10.0.0.0/8"},
+	}}
+	buf := new(bytes.Buffer)
+	assert.NoError(t, WriteCsv(buf, list))
+	assert.Equal(t, "domain,active,owner,force_https,hsts,default_backend,wildcard_depth,rate_limit,expires,maintenance,maintenance_page,maintenance_retry_after,maintenance_allow\nexample.com,true,abc,false,\"max-age=300, preload\",127.0.0.1:8080,2,0,0,false,,120,10.0.0.0/8\n", buf.String())
+
+	out, err := ReadCsv(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, list, out)
+
+	_, err = ReadCsv(strings.NewReader("active\ntrue\n"))
+	assert.ErrorIs(t, err, ErrInvalidRecord)
+	_, err = ReadCsv(strings.NewReader("domain,active\nexample.com,maybe\n"))
+	assert.ErrorIs(t, err, ErrInvalidRecord)
+}
+
+func TestReadJson(t *testing.T) {
+	out, err := ReadJson(strings.NewReader(`[{"domain":"example.com","hsts":"max-age=300"},{"domain":"example.org","active":false,"force_https":false}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []utils.DomainRecord{
+		{DomainEntry: utils.DomainEntry{Domain: "example.com", Active: true}, DomainSettings: utils.DomainSettings{ForceHttps: true, Hsts: "max-age=300", WildcardDepth: 1}},
+		{DomainEntry: utils.DomainEntry{Domain: "example.org"}, DomainSettings: utils.DomainSettings{WildcardDepth: 1}},
+	}, out)
+
+	_, err = ReadJson(strings.NewReader(`{"domain":"example.com"}`))
+	assert.ErrorIs(t, err, ErrInvalidRecord)
+}
@@ -1,6 +1,16 @@
 CREATE TABLE IF NOT EXISTS domains
 (
-    id     INTEGER PRIMARY KEY AUTOINCREMENT,
-    domain TEXT UNIQUE,
-    active INTEGER DEFAULT 1
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain          TEXT UNIQUE,
+    active          INTEGER DEFAULT 1,
+    force_https     INTEGER DEFAULT 1,
+    hsts            TEXT    DEFAULT '',
+    default_backend TEXT    DEFAULT '',
+    wildcard_depth  INTEGER DEFAULT 1,
+    rate_limit      INTEGER DEFAULT 0,
+    verify_token    TEXT    DEFAULT '',
+    owner           TEXT    DEFAULT '',
+    expires          INTEGER DEFAULT 0,
+    maintenance      INTEGER DEFAULT 0,
+    maintenance_page TEXT    DEFAULT ''
 );
@@ -4,21 +4,41 @@ import (
 	"database/sql"
 	_ "embed"
 	"github.com/MrMelon54/rescheduler"
+	"github.com/MrMelon54/violet/audit"
 	"github.com/MrMelon54/violet/utils"
 	"log"
 	"strings"
 	"sync"
+	"time"
 )
 
 //go:embed create-table-domains.sql
 var createTableDomains string
 
+// likeEscaper escapes the wildcard characters in LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 // Domains is the domain list and management system.
 type Domains struct {
 	db *sql.DB
 	s  *sync.RWMutex
-	m  map[string]struct{}
+	m  map[string]utils.DomainSettings
 	r  *rescheduler.Rescheduler
+
+	// dns verification of new domains
+	verify      bool
+	cnameTarget string
+	resolver    dnsResolver
+
+	// records each action on a domain
+	audit *audit.Log
+
+	// receives each change to the domain list
+	hookLock *sync.RWMutex
+	hook     func(Event)
+
+	// removes the routes of expired domains when they are purged
+	routes RoutePurger
 }
 
 // New creates a new domain list
@@ -26,7 +46,9 @@ func New(db *sql.DB) *Domains {
 	a := &Domains{
 		db: db,
 		s:  &sync.RWMutex{},
-		m:  make(map[string]struct{}),
+		m:  make(map[string]utils.DomainSettings),
+
+		hookLock: &sync.RWMutex{},
 	}
 	a.r = rescheduler.NewRescheduler(a.threadCompile)
 
@@ -36,12 +58,28 @@ func New(db *sql.DB) *Domains {
 		log.Printf("[WARN] Failed to generate 'domains' table\n")
 		return nil
 	}
+	err = addMissingColumns(a.db, "domains", domainColumns)
+	if err != nil {
+		log.Printf("[WARN] Failed to update 'domains' table: %s\n", err)
+		return nil
+	}
 	return a
 }
 
 // IsValid returns true if a domain is valid.
 func (d *Domains) IsValid(host string) bool {
+	_, ok := d.GetSettings(host)
+	return ok
+}
+
+// GetSettings returns the settings of the registered domain which the host is
+// part of, false is returned if the host is not valid.
+func (d *Domains) GetSettings(host string) (utils.DomainSettings, bool) {
 	domain, _, _ := utils.SplitDomainPort(host, 0)
+	domain, ok := utils.NormaliseDomain(domain)
+	if !ok {
+		return utils.DomainSettings{}, false
+	}
 
 	// read lock for safety
 	d.s.RLock()
@@ -49,8 +87,12 @@ func (d *Domains) IsValid(host string) bool {
 
 	// check root domains `www.example.com`, `example.com`, `com`
 	for len(domain) > 0 {
-		if _, ok := d.m[domain]; ok {
-			return true
+		if settings, ok := d.m[domain]; ok {
+			// expired domains are removed from the map during the next compile
+			if isExpired(settings.Expires, time.Now()) {
+				return utils.DomainSettings{}, false
+			}
+			return settings, true
 		}
 		n := strings.IndexByte(domain, '.')
 		if n == -1 {
@@ -58,7 +100,7 @@ func (d *Domains) IsValid(host string) bool {
 		}
 		domain = domain[n+1:]
 	}
-	return false
+	return utils.DomainSettings{}, false
 }
 
 // Compile downloads the list of domains from the database and loads them into
@@ -71,62 +113,154 @@ func (d *Domains) Compile() {
 }
 
 func (d *Domains) threadCompile() {
+	if err := d.CompileSync(); err != nil {
+		log.Printf("[Domains] Compile failed: %s\n", err)
+	}
+}
+
+// CompileSync downloads the list of domains from the database and waits for
+// the compile to finish.
+func (d *Domains) CompileSync() error {
 	// new map
-	domainMap := make(map[string]struct{})
+	domainMap := make(map[string]utils.DomainSettings)
 
 	// compile map and check errors
 	err := d.internalCompile(domainMap)
 	if err != nil {
-		log.Printf("[Domains] Compile failed: %s\n", err)
-		return
+		return err
 	}
 
 	// lock while replacing the map
 	d.s.Lock()
 	d.m = domainMap
 	d.s.Unlock()
+	return nil
 }
 
 // internalCompile is a hidden internal method for querying the database during
 // the Compile() method.
-func (d *Domains) internalCompile(m map[string]struct{}) error {
+func (d *Domains) internalCompile(m map[string]utils.DomainSettings) error {
 	log.Println("[Domains] Updating domains from database")
 
+	// deactivate and clean up temporary domains
+	if err := d.expireDomains(time.Now()); err != nil {
+		return err
+	}
+
 	// sql or something?
-	rows, err := d.db.Query(`select domain from domains where active = 1`)
+	rows, err := d.db.Query(`select domain, ` + settingsColumns + ` from domains where active = 1`)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
-	// loop through rows and scan the allowed domain names
+	// loop through rows and scan the allowed domain names and settings
 	for rows.Next() {
 		var name string
-		err = rows.Scan(&name)
+		var settings utils.DomainSettings
+		err = rows.Scan(append([]any{&name}, settingsFields(&settings)...)...)
 		if err != nil {
 			return err
 		}
-		m[name] = struct{}{}
+		m[name] = settings
 	}
 
 	// check for errors
 	return rows.Err()
 }
 
-func (d *Domains) Put(domain string, active bool) {
+func (d *Domains) Put(name string, active bool) {
+	domain, ok := utils.NormaliseDomain(name)
+	if !ok {
+		log.Printf("[Violet] Invalid domain: '%s'\n", name)
+		return
+	}
 	d.s.Lock()
 	defer d.s.Unlock()
-	_, err := d.db.Exec("INSERT OR REPLACE INTO domains (domain, active) VALUES (?, ?)", domain, active)
+	before, err := getState(d.db, domain)
+	if err != nil {
+		log.Printf("[Violet] Database error: %s\n", err)
+		return
+	}
+	if d.verify {
+		err = d.putPending(d.db, domain, active)
+	} else {
+		_, err = d.db.Exec("INSERT INTO domains (domain, active) VALUES (?, ?) ON CONFLICT(domain) DO UPDATE SET active = excluded.active", domain, active)
+	}
 	if err != nil {
 		log.Printf("[Violet] Database error: %s\n", err)
+		return
+	}
+	if after, err := getState(d.db, domain); err == nil {
+		d.publishChange(domain, before, after)
 	}
 }
 
-func (d *Domains) Delete(domain string) {
+// Delete disables the domain keeping the settings and dependent routes, the
+// actor and time are recorded in the audit trail. Use Purge to remove the
+// domain completely.
+func (d *Domains) Delete(domain, actor string) {
+	domain, ok := utils.NormaliseDomain(domain)
+	if !ok {
+		return
+	}
 	d.s.Lock()
 	defer d.s.Unlock()
-	_, err := d.db.Exec("INSERT OR REPLACE INTO domains (domain, active) VALUES (?, ?)", domain, false)
+	before, err := d.disable(domain, actor)
 	if err != nil {
 		log.Printf("[Violet] Database error: %s\n", err)
+		return
+	}
+	d.publishChange(domain, before, domainState{exists: true})
+}
+
+// disable marks the domain as inactive and records the action in the audit
+// trail using a single transaction, the state before the change is returned
+func (d *Domains) disable(domain, actor string) (domainState, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return domainState{}, err
+	}
+	defer tx.Rollback()
+	before, err := getState(tx, domain)
+	if err != nil {
+		return domainState{}, err
+	}
+	_, err = tx.Exec("INSERT INTO domains (domain, active) VALUES (?, ?) ON CONFLICT(domain) DO UPDATE SET active = excluded.active", domain, false)
+	if err != nil {
+		return domainState{}, err
+	}
+	if err := d.addAudit(tx, domain, "disable", actor); err != nil {
+		return domainState{}, err
+	}
+	return before, tx.Commit()
+}
+
+// List returns the domains containing the search string ordered by name and
+// the total number of matching domains for pagination.
+func (d *Domains) List(search string, offset, limit int) ([]utils.DomainEntry, int, error) {
+	// escape the wildcard characters in the search string
+	search = "%" + likeEscaper.Replace(search) + "%"
+
+	var total int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM domains WHERE domain LIKE ? ESCAPE '\'`, search).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := d.db.Query(`SELECT domain, active, owner FROM domains WHERE domain LIKE ? ESCAPE '\' ORDER BY domain LIMIT ? OFFSET ?`, search, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	list := make([]utils.DomainEntry, 0)
+	for rows.Next() {
+		var e utils.DomainEntry
+		if err := rows.Scan(&e.Domain, &e.Active, &e.Owner); err != nil {
+			return nil, 0, err
+		}
+		list = append(list, e)
 	}
+	return list, total, rows.Err()
 }
@@ -2,8 +2,10 @@ package domains
 
 import (
 	"database/sql"
+	"github.com/MrMelon54/violet/utils"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
+	"io/fs"
 	"testing"
 )
 
@@ -43,3 +45,85 @@ func TestDomains_IsValid(t *testing.T) {
 	assert.False(t, domains.IsValid("notexample.com"))
 	assert.False(t, domains.IsValid("www.notexample.com"))
 }
+
+func TestDomains_List(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestDomains_List?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	domains := New(db)
+	domains.Put("example.com", true)
+	domains.Put("www.example.com", false)
+	domains.Put("example_org.test", true)
+	domains.Put("exampleAorg.test", true)
+
+	list, total, err := domains.List("", 0, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, total)
+	assert.Len(t, list, 4)
+
+	// pagination
+	list, total, err = domains.List("example.com", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []utils.DomainEntry{{Domain: "www.example.com", Active: false}}, list)
+
+	// wildcard characters are escaped
+	list, total, err = domains.List("_", 0, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []utils.DomainEntry{{Domain: "example_org.test", Active: true}}, list)
+}
+
+func TestDomains_Settings(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestDomains_Settings?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	// table created before the settings columns existed
+	_, err = db.Exec(`CREATE TABLE domains (id INTEGER PRIMARY KEY AUTOINCREMENT, domain TEXT UNIQUE, active INTEGER DEFAULT 1)`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO domains (domain, active) VALUES (?, ?)`, "example.com", 1)
+	assert.NoError(t, err)
+
+	domains := New(db)
+	assert.NotNil(t, domains)
+	settings, err := domains.LoadSettings("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.DomainSettings{ForceHttps: true, WildcardDepth: 1}, settings)
+
+	settings.Hsts = "max-age=63072000"
+	settings.RateLimit = 10
+	assert.NoError(t, domains.PutSettings("example.com", settings))
+	assert.ErrorIs(t, domains.PutSettings("notexample.com", settings), fs.ErrNotExist)
+	_, err = domains.LoadSettings("notexample.com")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// toggling the domain keeps the settings
+	domains.Put("example.com", false)
+	domains.Put("example.com", true)
+	assert.NoError(t, domains.internalCompile(domains.m))
+	got, ok := domains.GetSettings("www.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, settings, got)
+}
+
+func TestDomains_Punycode(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestDomains_Punycode?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	domains := New(db)
+	domains.Put("Bücher.example", true)
+	domains.Put("invalid domain.example", true)
+	assert.NoError(t, domains.internalCompile(domains.m))
+
+	assert.True(t, domains.IsValid("xn--bcher-kva.example"))
+	assert.True(t, domains.IsValid("www.bücher.example"))
+	assert.False(t, domains.IsValid("invalid domain.example"))
+
+	list, total, err := domains.List("", 0, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []utils.DomainEntry{{Domain: "xn--bcher-kva.example", Active: true}}, list)
+
+	_, err = domains.LoadSettings("bücher.example")
+	assert.NoError(t, err)
+}
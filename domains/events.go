@@ -0,0 +1,71 @@
+package domains
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Event describes a change to the domain list
+type Event struct {
+	Domain string    `json:"domain"`
+	Action string    `json:"action"` // add, remove, enable or disable
+	Time   time.Time `json:"time"`
+}
+
+// domainState is the registered and active state of a domain
+type domainState struct {
+	exists bool
+	active bool
+}
+
+// queryRower is implemented by both sql.DB and sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// SetEventHook sets the function called with each change to the domain list,
+// the hook is called while making the change so it must not block
+func (d *Domains) SetEventHook(hook func(Event)) {
+	d.hookLock.Lock()
+	d.hook = hook
+	d.hookLock.Unlock()
+}
+
+// getState reads the current state of the domain
+func getState(db queryRower, domain string) (domainState, error) {
+	var s domainState
+	err := db.QueryRow(`SELECT active FROM domains WHERE domain = ?`, domain).Scan(&s.active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, nil
+	}
+	s.exists = err == nil
+	return s, err
+}
+
+// publishChange sends an event if the state of the domain has changed
+func (d *Domains) publishChange(domain string, before, after domainState) {
+	var action string
+	switch {
+	case !before.exists && after.exists:
+		action = "add"
+	case before.exists && !after.exists:
+		action = "remove"
+	case before.active == after.active:
+		return
+	case after.active:
+		action = "enable"
+	default:
+		action = "disable"
+	}
+	d.publishEvent(Event{Domain: domain, Action: action, Time: time.Now()})
+}
+
+// publishEvent sends the event to the hook
+func (d *Domains) publishEvent(e Event) {
+	d.hookLock.RLock()
+	defer d.hookLock.RUnlock()
+	if d.hook != nil {
+		d.hook(e)
+	}
+}
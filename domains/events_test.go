@@ -0,0 +1,32 @@
+package domains
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDomains_SetEventHook(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestDomains_SetEventHook?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	domains := New(db)
+
+	var got []string
+	domains.SetEventHook(func(e Event) {
+		assert.Equal(t, "example.com", e.Domain)
+		assert.False(t, e.Time.IsZero())
+		got = append(got, e.Action)
+	})
+
+	domains.Put("example.com", true)
+	domains.Put("example.com", true) // no change
+	domains.Delete("example.com", "abc")
+	domains.Put("example.com", true)
+	domains.Delete("example.com", "abc")
+	assert.NoError(t, domains.Purge("example.com", "abc"))
+	assert.Equal(t, []string{"add", "disable", "enable", "disable", "remove"}, got)
+
+	domains.SetEventHook(nil)
+	domains.Put("example.com", true)
+	assert.Len(t, got, 5)
+}
@@ -0,0 +1,121 @@
+package domains
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// expiryGracePeriod is how long expired domains are kept before being removed
+const expiryGracePeriod = 7 * 24 * time.Hour
+
+// expiryActor is recorded in the audit trail for expiry actions
+const expiryActor = "violet"
+
+// RoutePurger removes the routes and redirects under a host, sources under
+// hosts where keep returns true are not removed
+type RoutePurger interface {
+	PurgeHost(host string, keep func(host string) bool) error
+}
+
+// SetRoutePurger sets the route store used to remove the routes and redirects
+// of expired domains once they are purged
+func (d *Domains) SetRoutePurger(p RoutePurger) {
+	d.routes = p
+}
+
+// isExpired returns true if the expiry time is set and has passed
+func isExpired(expires int64, now time.Time) bool {
+	return expires > 0 && expires <= now.Unix()
+}
+
+// expireDomains deactivates expired domains and removes domains which expired
+// longer than the grace period ago. The changes are made in a single
+// transaction so the mutex is not required.
+func (d *Domains) expireDomains(now time.Time) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	events := make([]Event, 0)
+	purged := make([]string, 0)
+	for _, i := range []struct {
+		action string
+		query  string
+		before time.Time
+	}{
+		{"expire", `SELECT domain FROM domains WHERE active = 1 AND expires > 0 AND expires <= ?`, now},
+		{"purge", `SELECT domain FROM domains WHERE active = 0 AND expires > 0 AND expires <= ?`, now.Add(-expiryGracePeriod)},
+	} {
+		rows, err := tx.Query(i.query, i.before.Unix())
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0)
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			names = append(names, name)
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			if i.action == "expire" {
+				_, err = tx.Exec(`UPDATE domains SET active = 0 WHERE domain = ?`, name)
+			} else {
+				_, err = tx.Exec(`DELETE FROM domains WHERE domain = ?`, name)
+			}
+			if err != nil {
+				return err
+			}
+			if err := d.addAudit(tx, name, i.action, expiryActor); err != nil {
+				return err
+			}
+			action := "disable"
+			if i.action == "purge" {
+				action = "remove"
+			}
+			events = append(events, Event{Domain: name, Action: action, Time: now})
+			if i.action == "purge" {
+				purged = append(purged, name)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, e := range events {
+		d.publishEvent(e)
+	}
+
+	// routes of purged domains would otherwise stay in the router
+	if d.routes != nil {
+		for _, name := range purged {
+			if err := d.routes.PurgeHost(name, d.registeredBelow(name)); err != nil {
+				log.Printf("[Domains] Failed to purge routes of '%s': %s\n", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// registeredBelow returns a function which reports if a host under the domain
+// is registered separately, the routes of these hosts are kept
+func (d *Domains) registeredBelow(domain string) func(host string) bool {
+	return func(host string) bool {
+		for ; len(host) > len(domain); host = host[strings.IndexByte(host, '.')+1:] {
+			if _, err := d.LoadSettings(host); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,76 @@
+package domains
+
+import (
+	"database/sql"
+	"github.com/MrMelon54/violet/audit"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+// fakePurger records the hosts passed to PurgeHost
+type fakePurger struct {
+	hosts []string
+	keep  func(host string) bool
+}
+
+func (f *fakePurger) PurgeHost(host string, keep func(host string) bool) error {
+	f.hosts = append(f.hosts, host)
+	f.keep = keep
+	return nil
+}
+
+func TestDomains_Expiry(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestDomains_Expiry?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	domains := New(db)
+	domains.SetAudit(audit.New(db))
+	purger := &fakePurger{}
+	domains.SetRoutePurger(purger)
+	now := time.Now()
+
+	domains.Put("example.com", true)
+	domains.Put("preview.example.org", true)
+	domains.Put("old.example.org", true)
+	settings, err := domains.LoadSettings("preview.example.org")
+	assert.NoError(t, err)
+	settings.Expires = now.Add(time.Hour).Unix()
+	assert.NoError(t, domains.PutSettings("preview.example.org", settings))
+	settings.Expires = now.Add(-8 * 24 * time.Hour).Unix()
+	assert.NoError(t, domains.PutSettings("old.example.org", settings))
+
+	assert.NoError(t, domains.internalCompile(domains.m))
+	assert.True(t, domains.IsValid("example.com"))
+	assert.True(t, domains.IsValid("preview.example.org"))
+	assert.False(t, domains.IsValid("old.example.org"))
+
+	// expired domains are invalid before the next compile
+	domains.m["preview.example.org"] = settings
+	assert.False(t, domains.IsValid("preview.example.org"))
+
+	// domains are removed after the grace period
+	_, err = domains.LoadSettings("old.example.org")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	trail, err := domains.Audit("old.example.org")
+	assert.NoError(t, err)
+	assert.Len(t, trail, 2)
+	assert.Equal(t, "expire", trail[0].Action)
+	assert.Equal(t, "purge", trail[1].Action)
+
+	// the routes of removed domains are purged, except under other domains
+	assert.Equal(t, []string{"old.example.org"}, purger.hosts)
+	domains.Put("api.old.example.org", false)
+	assert.True(t, purger.keep("api.old.example.org"))
+	assert.True(t, purger.keep("v1.api.old.example.org"))
+	assert.False(t, purger.keep("www.old.example.org"))
+
+	// recently expired domains are only deactivated
+	assert.NoError(t, domains.expireDomains(now.Add(2*time.Hour)))
+	active := false
+	assert.NoError(t, db.QueryRow(`SELECT active FROM domains WHERE domain = ?`, "preview.example.org").Scan(&active))
+	assert.False(t, active)
+	_, err = domains.LoadSettings("preview.example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"old.example.org"}, purger.hosts)
+}
@@ -0,0 +1,63 @@
+package domains
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// tableColumn is a column which may be missing from tables created by older
+// versions
+type tableColumn struct {
+	name string
+	def  string
+}
+
+// domainColumns are the columns added to the domains table after it was first
+// created
+var domainColumns = []tableColumn{
+	{"force_https", "INTEGER DEFAULT 1"},
+	{"hsts", "TEXT DEFAULT ''"},
+	{"default_backend", "TEXT DEFAULT ''"},
+	{"wildcard_depth", "INTEGER DEFAULT 1"},
+	{"rate_limit", "INTEGER DEFAULT 0"},
+	{"verify_token", "TEXT DEFAULT ''"},
+	{"owner", "TEXT DEFAULT ''"},
+	{"expires", "INTEGER DEFAULT 0"},
+	{"maintenance", "INTEGER DEFAULT 0"},
+	{"maintenance_page", "TEXT DEFAULT ''"},
+	{"maintenance_retry_after", "INTEGER DEFAULT 0"},
+	{"maintenance_allow", "TEXT DEFAULT ''"},
+}
+
+// addMissingColumns adds the columns which don't exist in the table yet
+func addMissingColumns(db *sql.DB, table string, columns []tableColumn) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]struct{})
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var def sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &def, &pk); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		existing[name] = struct{}{}
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, i := range columns {
+		if _, ok := existing[i.name]; ok {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, i.name, i.def)); err != nil {
+			return fmt.Errorf("failed to add column '%s': %w", i.name, err)
+		}
+	}
+	return nil
+}
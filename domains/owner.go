@@ -0,0 +1,63 @@
+package domains
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/MrMelon54/violet/utils"
+	"io/fs"
+	"strings"
+)
+
+// SetOwner records the subject which owns the domain, fs.ErrNotExist is
+// returned if the domain is not registered.
+func (d *Domains) SetOwner(domain, owner string) error {
+	domain, ok := utils.NormaliseDomain(domain)
+	if !ok {
+		return fs.ErrNotExist
+	}
+	d.s.Lock()
+	defer d.s.Unlock()
+	res, err := d.db.Exec(`UPDATE domains SET owner = ? WHERE domain = ?`, owner, domain)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// GetOwner returns the owner of the closest registered domain which the host
+// is part of, an empty string is returned if the domain has no owner.
+//
+// Inactive domains are included so ownership is kept while a domain is
+// disabled.
+func (d *Domains) GetOwner(host string) (string, error) {
+	domain, _, _ := utils.SplitDomainPort(host, 0)
+	domain, ok := utils.NormaliseDomain(domain)
+	if !ok {
+		return "", nil
+	}
+
+	// check root domains `www.example.com`, `example.com`, `com`
+	for len(domain) > 0 {
+		var owner string
+		err := d.db.QueryRow(`SELECT owner FROM domains WHERE domain = ?`, domain).Scan(&owner)
+		switch {
+		case err == nil:
+			return owner, nil
+		case !errors.Is(err, sql.ErrNoRows):
+			return "", err
+		}
+		n := strings.IndexByte(domain, '.')
+		if n == -1 {
+			break
+		}
+		domain = domain[n+1:]
+	}
+	return "", nil
+}
@@ -0,0 +1,34 @@
+package domains
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"testing"
+)
+
+func TestDomains_Owner(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestDomains_Owner?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	domains := New(db)
+
+	// unregistered domains have no owner
+	assert.ErrorIs(t, domains.SetOwner("example.com", "abc"), fs.ErrNotExist)
+	owner, err := domains.GetOwner("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "", owner)
+
+	domains.Put("example.com", true)
+	assert.NoError(t, domains.SetOwner("example.com", "abc"))
+
+	// subdomains use the owner of the parent domain
+	owner, err = domains.GetOwner("www.example.com:443")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", owner)
+
+	// ownership is kept while the domain is inactive
+	domains.Put("example.com", false)
+	owner, err = domains.GetOwner("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", owner)
+}
@@ -0,0 +1,54 @@
+package domains
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/MrMelon54/violet/utils"
+	"io/fs"
+)
+
+// settingsColumns are the columns scanned by settingsFields
+const settingsColumns = `force_https, hsts, default_backend, wildcard_depth, rate_limit, expires, maintenance, maintenance_page, maintenance_retry_after, maintenance_allow`
+
+// settingsFields returns the pointers to scan the settings columns into
+func settingsFields(s *utils.DomainSettings) []any {
+	return []any{&s.ForceHttps, &s.Hsts, &s.DefaultBackend, &s.WildcardDepth, &s.RateLimit, &s.Expires, &s.Maintenance, &s.MaintenancePage, &s.MaintenanceRetry, &s.MaintenanceAllow}
+}
+
+// LoadSettings reads the settings for the domain from the database,
+// fs.ErrNotExist is returned if the domain is not registered.
+func (d *Domains) LoadSettings(domain string) (utils.DomainSettings, error) {
+	var settings utils.DomainSettings
+	domain, ok := utils.NormaliseDomain(domain)
+	if !ok {
+		return settings, fs.ErrNotExist
+	}
+	err := d.db.QueryRow(`SELECT `+settingsColumns+` FROM domains WHERE domain = ?`, domain).Scan(settingsFields(&settings)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return settings, fs.ErrNotExist
+	}
+	return settings, err
+}
+
+// PutSettings saves the settings for the domain, fs.ErrNotExist is returned
+// if the domain is not registered.
+func (d *Domains) PutSettings(domain string, settings utils.DomainSettings) error {
+	domain, ok := utils.NormaliseDomain(domain)
+	if !ok {
+		return fs.ErrNotExist
+	}
+	d.s.Lock()
+	defer d.s.Unlock()
+	res, err := d.db.Exec(`UPDATE domains SET force_https = ?, hsts = ?, default_backend = ?, wildcard_depth = ?, rate_limit = ?, expires = ?, maintenance = ?, maintenance_page = ?, maintenance_retry_after = ?, maintenance_allow = ? WHERE domain = ?`, settings.ForceHttps, settings.Hsts, settings.DefaultBackend, settings.WildcardDepth, settings.RateLimit, settings.Expires, settings.Maintenance, settings.MaintenancePage, settings.MaintenanceRetry, settings.MaintenanceAllow, domain)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
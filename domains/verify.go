@@ -0,0 +1,135 @@
+package domains
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/MrMelon54/violet/utils"
+	"io/fs"
+	"net"
+	"strings"
+	"time"
+)
+
+var ErrVerifyFailed = errors.New("domain verification record not found")
+
+// dnsResolver is the subset of net.Resolver used to check challenge records
+type dnsResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+}
+
+// RequireVerification makes newly added domains inactive until the ownership
+// challenge is confirmed using DNS. The challenge is a TXT record containing
+// the token or, if the CNAME target is set, a CNAME record pointing to
+// `<token>.<target>`.
+func (d *Domains) RequireVerification(cnameTarget string) {
+	d.verify = true
+	d.cnameTarget = strings.TrimSuffix(cnameTarget, ".")
+	if d.resolver == nil {
+		d.resolver = net.DefaultResolver
+	}
+}
+
+// challengeName returns the name of the challenge record for the domain
+func challengeName(domain string) string {
+	return "_violet-challenge." + domain
+}
+
+// putPending adds a new domain as inactive with a verification token, existing
+// domains are updated as normal.
+func (d *Domains) putPending(db execer, domain string, active bool) error {
+	token, err := genVerifyToken()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO domains (domain, active, verify_token) VALUES (?, 0, ?) ON CONFLICT(domain) DO UPDATE SET active = CASE WHEN verify_token = '' THEN ? ELSE 0 END`, domain, token, active)
+	return err
+}
+
+// GetChallenge returns the challenge for a domain waiting for verification,
+// the challenge is nil if the domain is already verified. fs.ErrNotExist is
+// returned if the domain is not registered.
+func (d *Domains) GetChallenge(domain string) (*utils.DomainChallenge, error) {
+	domain, ok := utils.NormaliseDomain(domain)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	var token string
+	err := d.db.QueryRow(`SELECT verify_token FROM domains WHERE domain = ?`, domain).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fs.ErrNotExist
+	}
+	if err != nil || token == "" {
+		return nil, err
+	}
+	c := &utils.DomainChallenge{Name: challengeName(domain), Type: "TXT", Value: token}
+	if d.cnameTarget != "" {
+		c.Type = "CNAME"
+		c.Value = token + "." + d.cnameTarget
+	}
+	return c, nil
+}
+
+// Verify checks the challenge record for the domain and activates the domain
+// if the record matches. ErrVerifyFailed is returned if the record is missing.
+func (d *Domains) Verify(domain string) error {
+	domain, ok := utils.NormaliseDomain(domain)
+	if !ok {
+		return fs.ErrNotExist
+	}
+	c, err := d.GetChallenge(domain)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		// already verified
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var found bool
+	switch c.Type {
+	case "TXT":
+		records, err := d.resolver.LookupTXT(ctx, c.Name)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrVerifyFailed, err)
+		}
+		for _, i := range records {
+			if i == c.Value {
+				found = true
+			}
+		}
+	case "CNAME":
+		cname, err := d.resolver.LookupCNAME(ctx, c.Name)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrVerifyFailed, err)
+		}
+		found = strings.TrimSuffix(cname, ".") == c.Value
+	}
+	if !found {
+		return ErrVerifyFailed
+	}
+
+	d.s.Lock()
+	defer d.s.Unlock()
+	_, err = d.db.Exec(`UPDATE domains SET active = 1, verify_token = '' WHERE domain = ?`, domain)
+	if err != nil {
+		return err
+	}
+	d.publishChange(domain, domainState{exists: true}, domainState{exists: true, active: true})
+	return nil
+}
+
+// genVerifyToken generates a random token for the challenge record
+func genVerifyToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
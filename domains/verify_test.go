@@ -0,0 +1,77 @@
+package domains
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"testing"
+)
+
+type fakeResolver struct {
+	txt   map[string][]string
+	cname map[string]string
+}
+
+func (f *fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if v, ok := f.txt[name]; ok {
+		return v, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (f *fakeResolver) LookupCNAME(_ context.Context, host string) (string, error) {
+	if v, ok := f.cname[host]; ok {
+		return v, nil
+	}
+	return "", errors.New("no such host")
+}
+
+func TestDomains_Verify(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestDomains_Verify?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	domains := New(db)
+	domains.Put("old.example.com", true)
+	resolver := &fakeResolver{txt: map[string][]string{}, cname: map[string]string{}}
+	domains.RequireVerification("")
+	domains.resolver = resolver
+
+	// existing domains don't need verification
+	c, err := domains.GetChallenge("old.example.com")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	// new domains are inactive until verified
+	domains.Put("example.com", true)
+	assert.NoError(t, domains.internalCompile(domains.m))
+	assert.False(t, domains.IsValid("example.com"))
+	c, err = domains.GetChallenge("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "_violet-challenge.example.com", c.Name)
+	assert.Equal(t, "TXT", c.Type)
+
+	// adding again keeps the same challenge
+	domains.Put("example.com", true)
+	c2, err := domains.GetChallenge("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, c, c2)
+
+	assert.ErrorIs(t, domains.Verify("example.com"), ErrVerifyFailed)
+	resolver.txt[c.Name] = []string{"other", c.Value}
+	assert.NoError(t, domains.Verify("example.com"))
+	assert.NoError(t, domains.internalCompile(domains.m))
+	assert.True(t, domains.IsValid("example.com"))
+
+	assert.ErrorIs(t, domains.Verify("missing.example.com"), fs.ErrNotExist)
+
+	// cname challenges point to the token under the target
+	domains.RequireVerification("verify.violet.test.")
+	domains.Put("cname.example.com", true)
+	c, err = domains.GetChallenge("cname.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "CNAME", c.Type)
+	resolver.cname[c.Name] = c.Value + "."
+	assert.NoError(t, domains.Verify("cname.example.com"))
+}
@@ -0,0 +1,79 @@
+package error_pages
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// assetsDir is the subdirectory of the error pages directory and each host
+// directory containing the stylesheets and images used by the pages
+const assetsDir = "assets"
+
+// maxAssetSize limits the size of each asset inlined into the pages
+const maxAssetSize = 1 << 20
+
+// assetMap stores the data URI of each asset by path relative to the assets
+// directory, the assets are read when the pages are compiled and inlined using
+// `{{asset "style.css"}}` so the pages don't need another request
+type assetMap map[string]template.URL
+
+// funcs returns the template functions for the pages using the assets
+func (a assetMap) funcs() template.FuncMap {
+	return template.FuncMap{
+		"asset": func(name string) (template.URL, error) {
+			if u, ok := a[name]; ok {
+				return u, nil
+			}
+			return "", fmt.Errorf("unknown asset '%s'", name)
+		},
+	}
+}
+
+// readAssets reads the assets directory of the error pages directory, the
+// assets of the parent are used if the directory doesn't contain the asset
+func readAssets(dir fs.FS, parent assetMap) (assetMap, error) {
+	a := make(assetMap, len(parent))
+	for k, v := range parent {
+		a[k] = v
+	}
+	if _, err := fs.Stat(dir, assetsDir); errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	}
+	err := fs.WalkDir(dir, assetsDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxAssetSize {
+			log.Printf("[ErrorPages] WARNING: ignoring asset larger than 1MiB in error pages directory: '%s'\n", p)
+			return nil
+		}
+		raw, err := fs.ReadFile(dir, p)
+		if err != nil {
+			return fmt.Errorf("failed to read asset '%s': %w", p, err)
+		}
+		contentType := mime.TypeByExtension(path.Ext(p))
+		if contentType == "" {
+			contentType = http.DetectContentType(raw)
+		}
+		// parameters such as the charset are not needed for the data uri
+		contentType, _, _ = strings.Cut(contentType, ";")
+		a[strings.TrimPrefix(p, assetsDir+"/")] = template.URL("data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(raw))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error page assets: %w", err)
+	}
+	return a, nil
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS error_pages (
+    host VARCHAR DEFAULT '',
+    code INTEGER,
+    html VARCHAR,
+    PRIMARY KEY (host, code)
+);
+
+CREATE TABLE IF NOT EXISTS error_page_sorry (
+    host VARCHAR PRIMARY KEY
+);
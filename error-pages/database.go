@@ -0,0 +1,180 @@
+package error_pages
+
+import (
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/MrMelon54/violet/utils"
+	"html/template"
+	"io/fs"
+	"strings"
+)
+
+//go:embed create-table-error-pages.sql
+var createTableErrorPages string
+
+var ErrInvalidPage = errors.New("invalid error page")
+
+// PageRecord is an error page stored in the database, the host is empty for
+// global pages, code 0 is the catch-all page used for codes without a page,
+// codes 1-5 are the class pages such as `4xx`, code -1 is the maintenance page,
+// code -2 is the backend tls failure page and code -3 is the sorry page
+type PageRecord struct {
+	Host string `json:"host,omitempty"`
+	Code int    `json:"code"`
+	Html string `json:"html"`
+}
+
+// SetDatabase stores error pages in the database so they can be managed using
+// the API, the pages in the database replace the pages with the same host and
+// code in the error page directory. The hosts with the sorry page enabled are
+// also stored in the database.
+func (e *ErrorPages) SetDatabase(db *sql.DB) error {
+	if _, err := db.Exec(createTableErrorPages); err != nil {
+		return fmt.Errorf("failed to create error pages table: %w", err)
+	}
+	e.db = db
+	return e.loadSorry()
+}
+
+// HasDatabase returns true if the error pages are stored in the database
+func (e *ErrorPages) HasDatabase() bool {
+	return e.db != nil
+}
+
+// ValidCode returns true for the status codes which can have a page, code 0 is
+// the catch-all page, codes 1-5 are the class pages, code -1 is the maintenance
+// page, code -2 is the backend tls failure page and code -3 is the sorry page
+func ValidCode(code int) bool {
+	_, named := namedPages[code]
+	return named || (code >= 100 && code < 600)
+}
+
+// Validate returns ErrInvalidPage if the host or code is invalid or the html is
+// not a valid template
+func (r PageRecord) Validate() error {
+	if r.Host != "" && !validHost(r.Host) {
+		return fmt.Errorf("%w: invalid host '%s'", ErrInvalidPage, r.Host)
+	}
+	if !ValidCode(r.Code) {
+		return fmt.Errorf("%w: code must be -3 to 5 or 100-599", ErrInvalidPage)
+	}
+	if strings.Contains(r.Html, "{{") {
+		if _, err := template.New("").Funcs(assetMap(nil).funcs()).Parse(r.Html); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidPage, err)
+		}
+	}
+	return nil
+}
+
+// validHost returns true if the host is already normalised
+func validHost(host string) bool {
+	h, ok := utils.NormaliseDomain(host)
+	return ok && h == host
+}
+
+// Put replaces the error page of the host and code, ErrInvalidPage is returned
+// if the host or code is invalid or the html is not a valid template.
+func (e *ErrorPages) Put(r PageRecord) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	_, err := e.db.Exec(`INSERT OR REPLACE INTO error_pages (host, code, html) VALUES (?, ?, ?)`, r.Host, r.Code, r.Html)
+	return err
+}
+
+// ImportTx adds or replaces the error pages and enables the sorry page of the
+// hosts using a transaction shared with other tables, no changes are made if
+// any page or host is invalid. The returned function enables the sorry pages
+// and must only be called after the transaction is committed.
+func (e *ErrorPages) ImportTx(tx *sql.Tx, records []PageRecord, sorry []string) (func(), error) {
+	for i, r := range records {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("page %d: %w", i+1, err)
+		}
+	}
+	for _, host := range sorry {
+		if !validHost(host) || host == "" {
+			return nil, fmt.Errorf("%w: invalid sorry host '%s'", ErrInvalidPage, host)
+		}
+	}
+	for _, r := range records {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO error_pages (host, code, html) VALUES (?, ?, ?)`, r.Host, r.Code, r.Html); err != nil {
+			return nil, err
+		}
+	}
+	for _, host := range sorry {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO error_page_sorry (host) VALUES (?)`, host); err != nil {
+			return nil, err
+		}
+	}
+	return func() {
+		e.s.Lock()
+		for _, host := range sorry {
+			e.sorry[host] = struct{}{}
+		}
+		e.s.Unlock()
+	}, nil
+}
+
+// Delete removes the error page of the host and code, fs.ErrNotExist is
+// returned if there is no page.
+func (e *ErrorPages) Delete(host string, code int) error {
+	res, err := e.db.Exec(`DELETE FROM error_pages WHERE host = ? AND code = ?`, host, code)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// List returns the error pages stored in the database ordered by host and code.
+func (e *ErrorPages) List() ([]PageRecord, error) {
+	rows, err := e.db.Query(`SELECT host, code, html FROM error_pages ORDER BY host, code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]PageRecord, 0)
+	for rows.Next() {
+		var r PageRecord
+		if err := rows.Scan(&r.Host, &r.Code, &r.Html); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// internalCompileDatabase adds the pages stored in the database to the global
+// and host page maps, the pages use the assets of the host directory or the
+// global assets
+func (e *ErrorPages) internalCompileDatabase(m map[int]*page, h map[string]map[int]*page, a map[string]assetMap) error {
+	list, err := e.List()
+	if err != nil {
+		return fmt.Errorf("failed to read error pages from database: %w", err)
+	}
+	for _, r := range list {
+		if r.Host == "" {
+			m[r.Code] = parsePage(pageName(r.Code), []byte(r.Html), a[""])
+			continue
+		}
+		if h[r.Host] == nil {
+			h[r.Host] = make(map[int]*page)
+		}
+		assets, ok := a[r.Host]
+		if !ok {
+			assets = a[""]
+		}
+		h[r.Host][r.Code] = parsePage(r.Host+"/"+pageName(r.Code), []byte(r.Html), assets)
+	}
+	return nil
+}
@@ -1,8 +1,10 @@
 package error_pages
 
 import (
+	"database/sql"
 	"fmt"
 	"github.com/MrMelon54/rescheduler"
+	"github.com/MrMelon54/violet/utils"
 	"io/fs"
 	"log"
 	"net/http"
@@ -13,20 +15,27 @@ import (
 )
 
 // ErrorPages stores the custom error pages and is called by the servers to
-// output meaningful pages for HTTP error codes
+// output meaningful pages for HTTP error codes, subdirectories named after a
+// host contain the pages used for that host
 type ErrorPages struct {
 	s       *sync.RWMutex
-	m       map[int]func(rw http.ResponseWriter)
+	m       map[int]*page
+	hosts   map[string]map[int]*page
+	sorry   map[string]struct{}
+	theme   *Theme
 	generic func(rw http.ResponseWriter, code int)
 	dir     fs.FS
+	db      *sql.DB
 	r       *rescheduler.Rescheduler
 }
 
 // New creates a new error pages generator
 func New(dir fs.FS) *ErrorPages {
 	e := &ErrorPages{
-		s: &sync.RWMutex{},
-		m: make(map[int]func(rw http.ResponseWriter)),
+		s:     &sync.RWMutex{},
+		m:     make(map[int]*page),
+		hosts: make(map[string]map[int]*page),
+		sorry: make(map[string]struct{}),
 		// generic error page writer
 		generic: func(rw http.ResponseWriter, code int) {
 			// if status text is empty then the code is unknown
@@ -48,18 +57,98 @@ func New(dir fs.FS) *ErrorPages {
 
 // ServeError writes the error page for the given code to the response writer
 func (e *ErrorPages) ServeError(rw http.ResponseWriter, code int) {
-	// read lock for safety
-	e.s.RLock()
-	defer e.s.RUnlock()
+	e.serve(rw, "", nil, e.newPageData(code, "", ""))
+}
+
+// ServeHostError writes the error page of the host for the given code to the
+// response writer, the pages of the wildcard covering the host are used next
+// and the global error page is used if the host has no custom page
+func (e *ErrorPages) ServeHostError(rw http.ResponseWriter, host string, code int) {
+	e.serve(rw, host, nil, e.newPageData(code, host, ""))
+}
+
+// ServeRequestError writes the error page of the request host for the given
+// code to the response writer, the request ID is read from the X-Request-Id
+// header or generated and is sent back in the response header. The page for
+// the language preferred by the Accept-Language header is used if it exists.
+func (e *ErrorPages) ServeRequestError(rw http.ResponseWriter, req *http.Request, code int) {
+	id := requestID(req)
+	rw.Header().Set("X-Request-Id", id)
+	e.serve(rw, req.Host, acceptedLanguages(req), e.newPageData(code, req.Host, id))
+}
+
+// ServeLimitError writes the error page for a request rejected for exceeding
+// the body or header size limit, the limit in bytes is available to templates
+func (e *ErrorPages) ServeLimitError(rw http.ResponseWriter, req *http.Request, code int, limit int64) {
+	id := requestID(req)
+	rw.Header().Set("X-Request-Id", id)
+	data := e.newPageData(code, req.Host, id)
+	data.Limit = limit
+	e.serve(rw, req.Host, acceptedLanguages(req), data)
+}
+
+// ServeMaintenance writes the maintenance page of the request host with the 503
+// status code, the 503 error page is used if there is no maintenance page
+func (e *ErrorPages) ServeMaintenance(rw http.ResponseWriter, req *http.Request) {
+	id := requestID(req)
+	rw.Header().Set("X-Request-Id", id)
+	langs := acceptedLanguages(req)
+	data := e.newPageData(http.StatusServiceUnavailable, req.Host, id)
+	if p := e.findPage(req.Host, langs, MaintenanceStatus); p != nil && p.write(rw, data) {
+		return
+	}
+	e.serve(rw, req.Host, langs, data)
+}
 
+func (e *ErrorPages) serve(rw http.ResponseWriter, host string, langs []string, data PageData) {
 	// use the custom error page if it exists
-	if p, ok := e.m[code]; ok {
-		p(rw)
+	if p := e.findPage(host, langs, data.Status, ClassStatus(data.Status), AnyStatus); p != nil && p.write(rw, data) {
+		return
+	}
+
+	// the built-in page is used once a theme is set
+	e.s.RLock()
+	themed := e.theme != nil
+	e.s.RUnlock()
+	if themed && themeDefault.write(rw, data) {
 		return
 	}
 
 	// otherwise use the generic error page
-	e.generic(rw, code)
+	e.generic(rw, data.Status)
+}
+
+// findPage returns the first page of the host for the codes, the pages of the
+// host are used before the global pages so the catch-all page of the host is
+// used before the global page for the code. The variant for the first accepted
+// language is used and pages with only variants for other languages are
+// skipped.
+func (e *ErrorPages) findPage(host string, langs []string, codes ...int) *page {
+	e.s.RLock()
+	defer e.s.RUnlock()
+	if host, ok := utils.NormaliseDomain(utils.GetDomainWithoutPort(host)); ok {
+		hostPages := []map[int]*page{e.hosts[host]}
+		if wildcard, ok := utils.ReplaceSubdomainWithWildcard(host); ok {
+			hostPages = append(hostPages, e.hosts[wildcard])
+		}
+		for _, c := range codes {
+			for _, m := range hostPages {
+				if p, ok := m[c]; ok {
+					if p = p.localize(langs); p != nil {
+						return p
+					}
+				}
+			}
+		}
+	}
+	for _, c := range codes {
+		if p, ok := e.m[c]; ok {
+			if p = p.localize(langs); p != nil {
+				return p
+			}
+		}
+	}
+	return nil
 }
 
 // Compile loads the error pages  the certificates and keys from the directories.
@@ -71,34 +160,167 @@ func (e *ErrorPages) Compile() {
 }
 
 func (e *ErrorPages) threadCompile() {
-	// new map
-	errorPageMap := make(map[int]func(rw http.ResponseWriter))
+	if err := e.CompileSync(); err != nil {
+		log.Printf("[ErrorPages] Compile failed: %s\n", err)
+	}
+}
 
-	// compile map and check errors
+// CompileSync loads the error pages and waits for the compile to finish.
+func (e *ErrorPages) CompileSync() error {
+	// new maps
+	errorPageMap := make(map[int]*page)
+	hostPageMap := make(map[string]map[int]*page)
+	assetsMap := make(map[string]assetMap)
+
+	// compile maps and check errors
 	if e.dir != nil {
-		err := e.internalCompile(errorPageMap)
+		err := e.internalCompile(errorPageMap, assetsMap)
 		if err != nil {
-			log.Printf("[ErrorPages] Compile failed: %s\n", err)
-			return
+			return err
+		}
+		if err := e.internalCompileHosts(hostPageMap, assetsMap); err != nil {
+			return err
+		}
+	}
+	if e.db != nil {
+		if err := e.internalCompileDatabase(errorPageMap, hostPageMap, assetsMap); err != nil {
+			return err
 		}
 	}
 
-	// lock while replacing the map
+	// lock while replacing the maps
 	e.s.Lock()
 	e.m = errorPageMap
+	e.hosts = hostPageMap
 	e.s.Unlock()
+	return nil
 }
 
-func (e *ErrorPages) internalCompile(m map[int]func(rw http.ResponseWriter)) error {
-	// try to read dir
+// Export returns the html of each global custom error page by status code, the
+// pages are read from the error page directory and database on each call. The
+// catch-all, class, maintenance, backend tls failure and sorry pages and the
+// pages for other languages are not exported.
+func (e *ErrorPages) Export() (map[int]string, error) {
+	out := make(map[int]string)
+	if e.dir != nil {
+		pages, err := readPages(e.dir, false)
+		if err != nil {
+			return nil, err
+		}
+		for code, files := range pages {
+			if htmlData, ok := files[""]; ok {
+				out[code] = string(htmlData)
+			}
+		}
+	}
+	if e.db != nil {
+		list, err := e.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range list {
+			if r.Host == "" {
+				out[r.Code] = r.Html
+			}
+		}
+	}
+	for code := range namedPages {
+		delete(out, code)
+	}
+	return out, nil
+}
+
+// internalCompile loads the global error pages and the global assets which are
+// stored in the asset map with an empty host
+func (e *ErrorPages) internalCompile(m map[int]*page, a map[string]assetMap) error {
+	pages, err := readPages(e.dir, true)
+	if err != nil {
+		return err
+	}
+	assets, err := readAssets(e.dir, nil)
+	if err != nil {
+		return err
+	}
+	a[""] = assets
+	log.Printf("[ErrorPages] Compiling lookup table for %d error pages\n", len(pages))
+	for code, files := range pages {
+		m[code] = parseLocalizedPage(pageName(code), files, assets)
+	}
+
+	// well no errors happened
+	return nil
+}
+
+// internalCompileHosts loads the error pages in the subdirectory of each host,
+// the directory name is the host or a wildcard such as `*.example.com`. The
+// assets of each host are added to the global assets.
+func (e *ErrorPages) internalCompileHosts(h map[string]map[int]*page, a map[string]assetMap) error {
 	files, err := fs.ReadDir(e.dir, ".")
 	if err != nil {
 		return fmt.Errorf("failed to read error pages dir: %w", err)
 	}
+	for _, i := range files {
+		if !i.IsDir() || i.Name() == assetsDir {
+			continue
+		}
+		host, ok := utils.NormaliseDomain(i.Name())
+		if !ok || host != i.Name() {
+			log.Printf("[ErrorPages] WARNING: ignoring directory which is not a lowercase host in error pages directory: '%s'\n", i.Name())
+			continue
+		}
+		sub, err := fs.Sub(e.dir, i.Name())
+		if err != nil {
+			return fmt.Errorf("failed to open error pages dir '%s': %w", i.Name(), err)
+		}
+		pages, err := readPages(sub, true)
+		if err != nil {
+			return fmt.Errorf("host '%s': %w", host, err)
+		}
+		assets, err := readAssets(sub, a[""])
+		if err != nil {
+			return fmt.Errorf("host '%s': %w", host, err)
+		}
+		a[host] = assets
+		m := make(map[int]*page, len(pages))
+		for code, files := range pages {
+			m[code] = parseLocalizedPage(host+"/"+pageName(code), files, assets)
+		}
+		h[host] = m
+	}
+	return nil
+}
+
+// pageName returns the file name of the page for the code
+func pageName(code int) string {
+	if name, ok := namedPages[code]; ok {
+		return name
+	}
+	return strconv.Itoa(code) + ".html"
+}
+
+// namedPageCode returns the code of the page which is not named after a code
+func namedPageCode(name string) (int, bool) {
+	for code, n := range namedPages {
+		if n == name {
+			return code, true
+		}
+	}
+	return 0, false
+}
 
-	log.Printf("[ErrorPages] Compiling lookup table for %d error pages\n", len(files))
+// readPages reads the html error pages in the directory by status code and
+// language, the default language is stored as an empty string. The named pages
+// are stored with their special codes and the invalid files are skipped and
+// logged if warn is set.
+func readPages(dir fs.FS, warn bool) (map[int]map[string][]byte, error) {
+	// try to read dir
+	files, err := fs.ReadDir(dir, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error pages dir: %w", err)
+	}
 
 	// find and load error pages
+	pages := make(map[int]map[string][]byte)
 	for _, i := range files {
 		// skip dirs
 		if i.IsDir() {
@@ -111,37 +333,52 @@ func (e *ErrorPages) internalCompile(m map[int]func(rw http.ResponseWriter)) err
 
 		// if the extension is not 'html' then ignore the file
 		if ext != ".html" {
-			log.Printf("[ErrorPages] WARNING: ignoring non '.html' file in error pages directory: '%s'\n", name)
+			if warn {
+				log.Printf("[ErrorPages] WARNING: ignoring non '.html' file in error pages directory: '%s'\n", name)
+			}
 			continue
 		}
 
-		// if the name can't be
-		nameInt, err := strconv.Atoi(strings.TrimSuffix(name, ".html"))
-		if err != nil {
-			log.Printf("[ErrorPages] WARNING: ignoring invalid error page in error pages directory: '%s'\n", name)
+		// localized pages have the language before the extension
+		base, lang, ok := splitPageName(name)
+		if !ok {
+			if warn {
+				log.Printf("[ErrorPages] WARNING: ignoring error page with invalid language in error pages directory: '%s'\n", name)
+			}
 			continue
 		}
 
-		// check if code is in range 100-599
-		if nameInt < 100 || nameInt >= 600 {
-			log.Printf("[ErrorPages] WARNING: ignoring invalid error page in error pages directory must be 100-599: '%s'\n", name)
-			continue
+		// the catch-all, maintenance and backend tls failure pages are stored
+		// with special codes
+		code, ok := namedPageCode(base)
+		if !ok {
+			// if the name can't be
+			code, err = strconv.Atoi(strings.TrimSuffix(base, ".html"))
+			if err != nil {
+				if warn {
+					log.Printf("[ErrorPages] WARNING: ignoring invalid error page in error pages directory: '%s'\n", name)
+				}
+				continue
+			}
+
+			// check if code is in range 100-599
+			if code < 100 || code >= 600 {
+				if warn {
+					log.Printf("[ErrorPages] WARNING: ignoring invalid error page in error pages directory must be 100-599: '%s'\n", name)
+				}
+				continue
+			}
 		}
 
 		// try to read html file
-		htmlData, err := fs.ReadFile(e.dir, name)
+		htmlData, err := fs.ReadFile(dir, name)
 		if err != nil {
-			return fmt.Errorf("failed to read html file '%s': %w", name, err)
+			return nil, fmt.Errorf("failed to read html file '%s': %w", name, err)
 		}
-
-		// create a callback function to write the page
-		m[nameInt] = func(rw http.ResponseWriter) {
-			rw.Header().Set("Content-Type", "text/html; encoding=utf-8")
-			rw.WriteHeader(nameInt)
-			_, _ = rw.Write(htmlData)
+		if pages[code] == nil {
+			pages[code] = make(map[string][]byte)
 		}
+		pages[code][lang] = htmlData
 	}
-
-	// well no errors happened
-	return nil
+	return pages, nil
 }
@@ -1,12 +1,18 @@
 package error_pages
 
 import (
+	"database/sql"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"testing/fstest"
+	"time"
 )
 
 func TestErrorPages_ServeError(t *testing.T) {
@@ -42,7 +48,7 @@ func TestErrorPagesWithCustom(t *testing.T) {
 	}
 
 	errorPages := New(fs)
-	assert.NoError(t, errorPages.internalCompile(errorPages.m))
+	assert.NoError(t, errorPages.internalCompile(errorPages.m, make(map[string]assetMap)))
 
 	rec := httptest.NewRecorder()
 	errorPages.ServeError(rec, http.StatusTeapot)
@@ -62,3 +68,421 @@ func TestErrorPagesWithCustom(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "469 Custom Error Page\n", string(a))
 }
+
+func TestErrorPages_ServeHostError(t *testing.T) {
+	fs := fstest.MapFS{
+		"404.html":                  {Data: []byte("global 404\n")},
+		"503.html":                  {Data: []byte("global 503\n")},
+		"example.com/404.html":      {Data: []byte("example 404\n")},
+		"*.example.org/503.html":    {Data: []byte("wildcard 503\n")},
+		"Example.net/404.html":      {Data: []byte("ignored\n")},
+		"example.com/nested/a.html": {Data: []byte("ignored\n")},
+	}
+
+	errorPages := New(fs)
+	assert.NoError(t, errorPages.CompileSync())
+	assert.Len(t, errorPages.hosts, 2)
+
+	body := func(host string, code int) string {
+		rec := httptest.NewRecorder()
+		errorPages.ServeHostError(rec, host, code)
+		assert.Equal(t, code, rec.Code)
+		return rec.Body.String()
+	}
+	assert.Equal(t, "example 404\n", body("example.com:443", http.StatusNotFound))
+	assert.Equal(t, "example 404\n", body("EXAMPLE.com", http.StatusNotFound))
+
+	// missing pages fall back to the global pages
+	assert.Equal(t, "global 503\n", body("example.com", http.StatusServiceUnavailable))
+	assert.Equal(t, "global 404\n", body("www.example.com", http.StatusNotFound))
+	assert.Equal(t, "global 404\n", body("example.net", http.StatusNotFound))
+	assert.Equal(t, "418 I'm a teapot\n\n", body("example.com", http.StatusTeapot))
+
+	// wildcards cover a single subdomain level
+	assert.Equal(t, "wildcard 503\n", body("www.example.org", http.StatusServiceUnavailable))
+	assert.Equal(t, "global 503\n", body("example.org", http.StatusServiceUnavailable))
+
+	// only the global pages are exported
+	pages, err := errorPages.Export()
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{404: "global 404\n", 503: "global 503\n"}, pages)
+}
+
+func TestErrorPages_ServeRequestError(t *testing.T) {
+	fs := fstest.MapFS{
+		"error.html":             {Data: []byte("{{.Status}} {{.StatusText}} on {{.Host}} ({{.RequestID}})\n")},
+		"404.html":               {Data: []byte("global 404 {{.Time.Year}}\n")},
+		"500.html":               {Data: []byte("broken {{.Missing}}\n")},
+		"502.html":               {Data: []byte("not a template {{\n")},
+		"example.com/error.html": {Data: []byte("example {{.Status}}\n")},
+	}
+
+	errorPages := New(fs)
+	assert.NoError(t, errorPages.CompileSync())
+
+	serve := func(host, id string, code int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+		if id != "" {
+			req.Header.Set("X-Request-Id", id)
+		}
+		rec := httptest.NewRecorder()
+		errorPages.ServeRequestError(rec, req, code)
+		assert.Equal(t, code, rec.Code)
+		return rec
+	}
+
+	// the catch-all page covers codes without a page
+	rec := serve("example.org", "abc-123", http.StatusServiceUnavailable)
+	assert.Equal(t, "503 Service Unavailable on example.org (abc-123)\n", rec.Body.String())
+	assert.Equal(t, "abc-123", rec.Header().Get("X-Request-Id"))
+	assert.Equal(t, "text/html; encoding=utf-8", rec.Header().Get("Content-Type"))
+
+	// values are escaped
+	rec = serve("example.org", "<b>", http.StatusTeapot)
+	assert.Equal(t, "418 I&#39;m a teapot on example.org (&lt;b&gt;)\n", rec.Body.String())
+
+	// long request IDs are replaced
+	rec = serve("example.org", strings.Repeat("a", 129), http.StatusTeapot)
+	id := rec.Header().Get("X-Request-Id")
+	assert.Len(t, id, 32)
+	assert.Equal(t, "418 I&#39;m a teapot on example.org ("+id+")\n", rec.Body.String())
+
+	// pages for the code are used before the catch-all page
+	rec = serve("example.org", "", http.StatusNotFound)
+	assert.Equal(t, fmt.Sprintf("global 404 %d\n", time.Now().UTC().Year()), rec.Body.String())
+
+	// the host catch-all page is used before the global page for the code
+	assert.Equal(t, "example 404\n", serve("example.com", "", http.StatusNotFound).Body.String())
+
+	// failing templates use the generic page and invalid templates are output as-is
+	assert.Equal(t, "500 Internal Server Error\n\n", serve("example.org", "", http.StatusInternalServerError).Body.String())
+	assert.Equal(t, "not a template {{\n", serve("example.org", "", http.StatusBadGateway).Body.String())
+
+	// the catch-all page isn't exported
+	pages, err := errorPages.Export()
+	assert.NoError(t, err)
+	assert.Len(t, pages, 3)
+}
+
+func TestErrorPages_SetDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestErrorPages_SetDatabase?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	errorPages := New(fstest.MapFS{
+		"404.html": {Data: []byte("file 404\n")},
+		"503.html": {Data: []byte("file 503\n")},
+	})
+	assert.False(t, errorPages.HasDatabase())
+	assert.NoError(t, errorPages.SetDatabase(db))
+	assert.True(t, errorPages.HasDatabase())
+
+	assert.ErrorIs(t, errorPages.Put(PageRecord{Code: 99, Html: "bad code"}), ErrInvalidPage)
+	assert.ErrorIs(t, errorPages.Put(PageRecord{Host: "Example.com", Code: 404, Html: "bad host"}), ErrInvalidPage)
+	assert.ErrorIs(t, errorPages.Put(PageRecord{Code: 404, Html: "{{.Status"}), ErrInvalidPage)
+
+	assert.NoError(t, errorPages.Put(PageRecord{Code: 503, Html: "db 503\n"}))
+	assert.NoError(t, errorPages.Put(PageRecord{Host: "example.com", Code: 0, Html: "example {{.Status}}\n"}))
+	assert.NoError(t, errorPages.CompileSync())
+
+	body := func(host string, code int) string {
+		rec := httptest.NewRecorder()
+		errorPages.ServeHostError(rec, host, code)
+		return rec.Body.String()
+	}
+	assert.Equal(t, "file 404\n", body("example.org", http.StatusNotFound))
+	assert.Equal(t, "db 503\n", body("example.org", http.StatusServiceUnavailable))
+	assert.Equal(t, "example 404\n", body("example.com", http.StatusNotFound))
+
+	pages, err := errorPages.Export()
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{404: "file 404\n", 503: "db 503\n"}, pages)
+
+	list, err := errorPages.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []PageRecord{{Code: 503, Html: "db 503\n"}, {Host: "example.com", Code: 0, Html: "example {{.Status}}\n"}}, list)
+
+	assert.NoError(t, errorPages.Delete("", 503))
+	assert.ErrorIs(t, errorPages.Delete("", 503), fs.ErrNotExist)
+	assert.NoError(t, errorPages.CompileSync())
+	assert.Equal(t, "file 503\n", body("example.org", http.StatusServiceUnavailable))
+}
+
+func TestErrorPages_ServeMaintenance(t *testing.T) {
+	errorPages := New(fstest.MapFS{
+		"error.html":                   {Data: []byte("error {{.Status}}\n")},
+		"example.com/maintenance.html": {Data: []byte("maintenance {{.Status}}\n")},
+	})
+	assert.NoError(t, errorPages.CompileSync())
+
+	serve := func(host string) string {
+		rec := httptest.NewRecorder()
+		errorPages.ServeMaintenance(rec, httptest.NewRequest(http.MethodGet, "https://"+host+"/", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		return rec.Body.String()
+	}
+	assert.Equal(t, "maintenance 503\n", serve("example.com"))
+
+	// the 503 error page is used without a maintenance page
+	assert.Equal(t, "error 503\n", serve("example.org"))
+
+	// the maintenance page isn't used for 503 errors
+	rec := httptest.NewRecorder()
+	errorPages.ServeHostError(rec, "example.com", http.StatusServiceUnavailable)
+	assert.Equal(t, "error 503\n", rec.Body.String())
+}
+
+func TestErrorPages_ServeUpstreamError(t *testing.T) {
+	errorPages := New(fstest.MapFS{
+		"504.html":                      {Data: []byte("custom 504\n")},
+		"example.com/upstream-tls.html": {Data: []byte("example tls {{.Status}}\n")},
+	})
+	assert.NoError(t, errorPages.CompileSync())
+
+	serve := func(host string, code, status int) string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+		req.Header.Set("X-Request-Id", "abc")
+		errorPages.ServeUpstreamError(rec, req, code)
+		assert.Equal(t, status, rec.Code)
+		assert.Equal(t, "text/html; encoding=utf-8", rec.Header().Get("Content-Type"))
+		return rec.Body.String()
+	}
+
+	// custom pages are used first
+	assert.Equal(t, "custom 504\n", serve("example.org", http.StatusGatewayTimeout, http.StatusGatewayTimeout))
+	assert.Equal(t, "example tls 502\n", serve("example.com", UpstreamTlsStatus, http.StatusBadGateway))
+
+	// the built-in pages are different for each failure
+	down := serve("example.org", http.StatusBadGateway, http.StatusBadGateway)
+	tls := serve("example.org", UpstreamTlsStatus, http.StatusBadGateway)
+	assert.Contains(t, down, "<h1>502 Bad Gateway</h1>")
+	assert.Contains(t, down, "The server behind example.org is not accepting connections")
+	assert.Contains(t, down, "Request ID: abc")
+	assert.Contains(t, tls, "A secure connection to the server behind example.org could not be established")
+	assert.NotContains(t, serve("example.com", http.StatusBadGateway, http.StatusBadGateway), "example tls")
+
+	// the built-in pages are not exported
+	pages, err := errorPages.Export()
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{504: "custom 504\n"}, pages)
+}
+
+func TestErrorPages_Localized(t *testing.T) {
+	errorPages := New(fstest.MapFS{
+		"404.html":                  {Data: []byte("not found\n")},
+		"404.de.html":               {Data: []byte("nicht gefunden {{.Lang}}\n")},
+		"404.pt-br.html":            {Data: []byte("não encontrado\n")},
+		"410.fr.html":               {Data: []byte("disparu\n")},
+		"404.bad_lang.html":         {Data: []byte("ignored\n")},
+		"example.com/404.fr.html":   {Data: []byte("example.com introuvable\n")},
+		"example.com/error.de.html": {Data: []byte("example.com fehler\n")},
+	})
+	assert.NoError(t, errorPages.CompileSync())
+
+	serve := func(host, accept string, code int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+		if accept != "" {
+			req.Header.Set("Accept-Language", accept)
+		}
+		rec := httptest.NewRecorder()
+		errorPages.ServeRequestError(rec, req, code)
+		assert.Equal(t, code, rec.Code)
+		return rec
+	}
+
+	// the default page is used without a matching language
+	rec := serve("example.org", "", http.StatusNotFound)
+	assert.Equal(t, "not found\n", rec.Body.String())
+	assert.Equal(t, "Accept-Language", rec.Header().Get("Vary"))
+	assert.Equal(t, "not found\n", serve("example.org", "es, *;q=0.5", http.StatusNotFound).Body.String())
+
+	// languages are chosen by quality and regions fall back to the base language
+	assert.Equal(t, "nicht gefunden de\n", serve("example.org", "fr;q=0.2, de-AT;q=0.9", http.StatusNotFound).Body.String())
+	assert.Equal(t, "não encontrado\n", serve("example.org", "pt-BR", http.StatusNotFound).Body.String())
+	assert.Equal(t, "not found\n", serve("example.org", "de;q=0", http.StatusNotFound).Body.String())
+
+	// pages with only other languages are skipped
+	assert.Equal(t, "disparu\n", serve("example.org", "fr", http.StatusGone).Body.String())
+	assert.Equal(t, "410 Gone\n\n", serve("example.org", "en", http.StatusGone).Body.String())
+
+	// host pages are used before global pages
+	assert.Equal(t, "example.com introuvable\n", serve("example.com", "fr", http.StatusNotFound).Body.String())
+	assert.Equal(t, "example.com fehler\n", serve("example.com", "de", http.StatusNotFound).Body.String())
+	assert.Equal(t, "not found\n", serve("example.com", "en", http.StatusNotFound).Body.String())
+}
+
+func TestErrorPages_CompileCache(t *testing.T) {
+	dir := fstest.MapFS{"404.html": {Data: []byte("missing {{.Host}}\n")}}
+	errorPages := New(dir)
+	assert.NoError(t, errorPages.CompileSync())
+
+	serve := func() string {
+		rec := httptest.NewRecorder()
+		errorPages.ServeHostError(rec, "example.com", http.StatusNotFound)
+		return rec.Body.String()
+	}
+
+	// pages are not read again until they are compiled
+	dir["404.html"] = &fstest.MapFile{Data: []byte("gone {{.Host}}\n")}
+	assert.Equal(t, "missing example.com\n", serve())
+	assert.NoError(t, errorPages.CompileSync())
+	assert.Equal(t, "gone example.com\n", serve())
+}
+
+type fakeResponseWriter struct{ h http.Header }
+
+func (f fakeResponseWriter) Header() http.Header             { return f.h }
+func (f fakeResponseWriter) Write(bytes []byte) (int, error) { return len(bytes), nil }
+func (f fakeResponseWriter) WriteHeader(statusCode int)      {}
+
+func benchmarkServeRequestError(b *testing.B, dir fs.FS) {
+	errorPages := New(dir)
+	assert.NoError(b, errorPages.CompileSync())
+	req := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+	req.Header.Set("X-Request-Id", "abc123")
+	req.Header.Set("Accept-Language", "de-AT, en;q=0.8")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		errorPages.ServeRequestError(&fakeResponseWriter{h: make(http.Header, 4)}, req, http.StatusBadGateway)
+	}
+}
+
+func BenchmarkErrorPages_Generic(b *testing.B) {
+	benchmarkServeRequestError(b, nil)
+}
+
+func BenchmarkErrorPages_Static(b *testing.B) {
+	benchmarkServeRequestError(b, fstest.MapFS{"502.html": {Data: []byte("<h1>Bad Gateway</h1>\n")}})
+}
+
+func BenchmarkErrorPages_Template(b *testing.B) {
+	benchmarkServeRequestError(b, fstest.MapFS{"502.html": {Data: []byte("<h1>{{.Status}} {{.StatusText}}</h1><p>{{.Host}} {{.RequestID}} {{.Time}}</p>\n")}})
+}
+
+func BenchmarkErrorPages_Localized(b *testing.B) {
+	benchmarkServeRequestError(b, fstest.MapFS{
+		"502.html":    {Data: []byte("<h1>{{.Status}} {{.StatusText}}</h1>\n")},
+		"502.de.html": {Data: []byte("<h1 lang=\"{{.Lang}}\">{{.Status}} Fehlerhaftes Gateway</h1>\n")},
+	})
+}
+
+func TestErrorPages_ClassPages(t *testing.T) {
+	errorPages := New(fstest.MapFS{
+		"404.html":             {Data: []byte("not found\n")},
+		"4xx.html":             {Data: []byte("client error {{.Status}}\n")},
+		"5xx.html":             {Data: []byte("server error {{.Status}}\n")},
+		"error.html":           {Data: []byte("error {{.Status}}\n")},
+		"6xx.html":             {Data: []byte("ignored\n")},
+		"example.com/5xx.html": {Data: []byte("example.com server error\n")},
+	})
+	assert.NoError(t, errorPages.CompileSync())
+
+	serve := func(host string, code int) string {
+		rec := httptest.NewRecorder()
+		errorPages.ServeHostError(rec, host, code)
+		assert.Equal(t, code, rec.Code)
+		return rec.Body.String()
+	}
+
+	// the exact page is used before the class page and the catch-all page
+	assert.Equal(t, "not found\n", serve("example.org", http.StatusNotFound))
+	assert.Equal(t, "client error 410\n", serve("example.org", http.StatusGone))
+	assert.Equal(t, "server error 500\n", serve("example.org", http.StatusInternalServerError))
+	assert.Equal(t, "error 302\n", serve("example.org", http.StatusFound))
+	assert.Equal(t, "example.com server error\n", serve("example.com", http.StatusServiceUnavailable))
+
+	// class pages are used for backend failures before the built-in pages
+	rec := httptest.NewRecorder()
+	errorPages.ServeUpstreamError(rec, httptest.NewRequest(http.MethodGet, "https://example.org/", nil), UpstreamTlsStatus)
+	assert.Equal(t, "server error 502\n", rec.Body.String())
+
+	// class pages are not exported
+	out, err := errorPages.Export()
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{404: "not found\n"}, out)
+}
+
+func TestErrorPages_ServeSorry(t *testing.T) {
+	errorPages := New(fstest.MapFS{
+		"503.html":               {Data: []byte("unavailable\n")},
+		"example.com/sorry.html": {Data: []byte("sorry {{.Host}}\n")},
+	})
+	assert.NoError(t, errorPages.CompileSync())
+	assert.NoError(t, errorPages.SetSorry("example.com", true))
+	assert.True(t, errorPages.IsSorry("example.com:8443"))
+	assert.False(t, errorPages.IsSorry("example.org"))
+	assert.Equal(t, []string{"example.com"}, errorPages.SorryHosts())
+
+	// the 503 page is used without a sorry page
+	for host, body := range map[string]string{"example.com": "sorry example.com\n", "example.org": "unavailable\n"} {
+		rec := httptest.NewRecorder()
+		errorPages.ServeSorry(rec, httptest.NewRequest(http.MethodGet, "https://"+host+"/", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, body, rec.Body.String())
+	}
+
+	assert.NoError(t, errorPages.SetSorry("example.com", false))
+	assert.False(t, errorPages.IsSorry("example.com"))
+}
+
+func TestErrorPages_Assets(t *testing.T) {
+	errorPages := New(fstest.MapFS{
+		"404.html":                    {Data: []byte(`<link rel="stylesheet" href="{{asset "css/site.css"}}"><img src="{{asset "logo.png"}}">`)},
+		"500.html":                    {Data: []byte(`<img src="{{asset "missing.png"}}">`)},
+		"assets/css/site.css":         {Data: []byte("body{}")},
+		"assets/logo.png":             {Data: []byte("global")},
+		"example.com/404.html":        {Data: []byte(`<img src="{{asset "logo.png"}}"><link href="{{asset "css/site.css"}}">`)},
+		"example.com/assets/logo.png": {Data: []byte("host")},
+	})
+	assert.NoError(t, errorPages.CompileSync())
+
+	serve := func(host string, code int) string {
+		rec := httptest.NewRecorder()
+		errorPages.ServeHostError(rec, host, code)
+		assert.Equal(t, code, rec.Code)
+		return rec.Body.String()
+	}
+
+	// assets are inlined as data uris and host assets replace global assets
+	assert.Equal(t, `<link rel="stylesheet" href="data:text/css;base64,Ym9keXt9"><img src="data:image/png;base64,Z2xvYmFs">`, serve("example.org", http.StatusNotFound))
+	assert.Equal(t, `<img src="data:image/png;base64,aG9zdA=="><link href="data:text/css;base64,Ym9keXt9">`, serve("example.com", http.StatusNotFound))
+
+	// the assets directory is not a host and unknown assets use the generic page
+	assert.NotContains(t, errorPages.hosts, "assets")
+	assert.Equal(t, "500 Internal Server Error\n\n", serve("example.org", http.StatusInternalServerError))
+}
+
+func TestErrorPages_SetTheme(t *testing.T) {
+	errorPages := New(fstest.MapFS{"404.html": {Data: []byte("{{.Theme.SiteName}} not found\n")}})
+	assert.NoError(t, errorPages.CompileSync())
+
+	serve := func(code int) string {
+		rec := httptest.NewRecorder()
+		errorPages.ServeRequestError(rec, httptest.NewRequest(http.MethodGet, "https://example.com/", nil), code)
+		assert.Equal(t, code, rec.Code)
+		return rec.Body.String()
+	}
+
+	// plain text is used without a theme
+	assert.Equal(t, "418 I'm a teapot\n\n", serve(http.StatusTeapot))
+
+	assert.Error(t, errorPages.SetTheme(Theme{Accent: "red;}body{display:none"}))
+	assert.Error(t, errorPages.SetTheme(Theme{LogoUrl: "javascript:alert(1)"}))
+	assert.Error(t, errorPages.SetTheme(Theme{LogoUrl: "logo.png"}))
+	assert.Equal(t, "418 I'm a teapot\n\n", serve(http.StatusTeapot))
+
+	assert.NoError(t, errorPages.SetTheme(Theme{SiteName: "Example", LogoUrl: "/logo.png", Background: "#000", Text: "#eeeeee", Accent: "#f0a"}))
+	body := serve(http.StatusTeapot)
+	assert.Contains(t, body, "<title>418 I&#39;m a teapot - Example</title>")
+	assert.Contains(t, body, `<img src="/logo.png" alt="Example">`)
+	assert.Contains(t, body, "color:#eeeeee;background:#000}h1{color:#f0a}")
+	assert.Contains(t, body, "The request to example.com could not be completed.")
+
+	// custom pages can use the theme and the backend failure pages are themed
+	assert.Equal(t, "Example not found\n", serve(http.StatusNotFound))
+	rec := httptest.NewRecorder()
+	errorPages.ServeUpstreamError(rec, httptest.NewRequest(http.MethodGet, "https://example.com/", nil), http.StatusGatewayTimeout)
+	assert.Contains(t, rec.Body.String(), `<img src="/logo.png" alt="Example">`)
+	assert.Contains(t, rec.Body.String(), "took too long to respond")
+}
@@ -0,0 +1,83 @@
+package error_pages
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// languagePattern matches the lowercase language tags used to name localized
+// pages such as `404.de.html` or `404.pt-br.html`
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{1,8})*$`)
+
+// acceptedLanguages returns the lowercase language tags of the Accept-Language
+// header in order of preference, the base language of each tag is added after
+// the tag so `de-AT` also matches pages for `de`
+func acceptedLanguages(req *http.Request) []string {
+	if req == nil {
+		return nil
+	}
+	header := req.Header.Get("Accept-Language")
+	if header == "" {
+		return nil
+	}
+
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !languagePattern.MatchString(tag) {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	langs := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	add := func(lang string) {
+		if !seen[lang] {
+			seen[lang] = true
+			langs = append(langs, lang)
+		}
+	}
+	for _, t := range tags {
+		add(t.tag)
+		if base, _, ok := strings.Cut(t.tag, "-"); ok {
+			add(base)
+		}
+	}
+	return langs
+}
+
+// splitPageName returns the name of the page without the language suffix and
+// the language, `404.de.html` returns `404.html` and `de`
+func splitPageName(name string) (string, string, bool) {
+	stem := strings.TrimSuffix(name, ".html")
+	n := strings.IndexByte(stem, '.')
+	if n == -1 {
+		return name, "", true
+	}
+	lang := stem[n+1:]
+	if !languagePattern.MatchString(lang) {
+		return "", "", false
+	}
+	return stem[:n] + ".html", lang, true
+}
@@ -0,0 +1,102 @@
+package error_pages
+
+import (
+	"fmt"
+	"github.com/MrMelon54/violet/utils"
+	"net/http"
+	"sort"
+)
+
+// SorryStatus is the code used to store the page loaded from `sorry.html`, it
+// is served with 503 for every request to hosts with the sorry page enabled
+const SorryStatus = -3
+
+// sorryPage is the file name of the sorry page
+const sorryPage = "sorry.html"
+
+// SetSorry enables or disables the sorry page of the host, the change applies
+// to the next request and is stored in the database if enabled
+func (e *ErrorPages) SetSorry(host string, enabled bool) error {
+	if e.db != nil {
+		var err error
+		if enabled {
+			_, err = e.db.Exec(`INSERT OR IGNORE INTO error_page_sorry (host) VALUES (?)`, host)
+		} else {
+			_, err = e.db.Exec(`DELETE FROM error_page_sorry WHERE host = ?`, host)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	e.s.Lock()
+	if enabled {
+		e.sorry[host] = struct{}{}
+	} else {
+		delete(e.sorry, host)
+	}
+	e.s.Unlock()
+	return nil
+}
+
+// IsSorry returns true if the sorry page of the host is enabled
+func (e *ErrorPages) IsSorry(host string) bool {
+	host, ok := utils.NormaliseDomain(utils.GetDomainWithoutPort(host))
+	if !ok {
+		return false
+	}
+	e.s.RLock()
+	defer e.s.RUnlock()
+	_, ok = e.sorry[host]
+	return ok
+}
+
+// SorryHosts returns the sorted hosts with the sorry page enabled
+func (e *ErrorPages) SorryHosts() []string {
+	e.s.RLock()
+	hosts := make([]string, 0, len(e.sorry))
+	for host := range e.sorry {
+		hosts = append(hosts, host)
+	}
+	e.s.RUnlock()
+	sort.Strings(hosts)
+	return hosts
+}
+
+// ServeSorry writes the sorry page of the request host with the 503 status
+// code, the 503 error page is used if there is no sorry page
+func (e *ErrorPages) ServeSorry(rw http.ResponseWriter, req *http.Request) {
+	id := requestID(req)
+	rw.Header().Set("X-Request-Id", id)
+	langs := acceptedLanguages(req)
+	data := e.newPageData(http.StatusServiceUnavailable, req.Host, id)
+	if p := e.findPage(req.Host, langs, SorryStatus); p != nil && p.write(rw, data) {
+		return
+	}
+	e.serve(rw, req.Host, langs, data)
+}
+
+// loadSorry reads the hosts with the sorry page enabled from the database, the
+// hosts are only read once as changes are made using SetSorry
+func (e *ErrorPages) loadSorry() error {
+	rows, err := e.db.Query(`SELECT host FROM error_page_sorry`)
+	if err != nil {
+		return fmt.Errorf("failed to read sorry hosts from database: %w", err)
+	}
+	defer rows.Close()
+
+	sorry := make(map[string]struct{})
+	for rows.Next() {
+		var host string
+		if err := rows.Scan(&host); err != nil {
+			return err
+		}
+		sorry[host] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	e.s.Lock()
+	e.sorry = sorry
+	e.s.Unlock()
+	return nil
+}
@@ -0,0 +1,202 @@
+package error_pages
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnyStatus is the code used to store the catch-all page loaded from
+// `error.html`, it is used for codes without a custom page
+const AnyStatus = 0
+
+// catchAllPage is the file name of the page used for codes without a page
+const catchAllPage = "error.html"
+
+// MaintenanceStatus is the code used to store the page loaded from
+// `maintenance.html`, it is served with 503 to domains and routes in
+// maintenance mode
+const MaintenanceStatus = -1
+
+// maintenancePage is the file name of the maintenance page
+const maintenancePage = "maintenance.html"
+
+// namedPages are the file names of the pages which are not named after a code,
+// the class pages such as `4xx.html` are stored with the first digit of the
+// class as the code
+var namedPages = map[int]string{
+	AnyStatus:         catchAllPage,
+	MaintenanceStatus: maintenancePage,
+	UpstreamTlsStatus: upstreamTlsPage,
+	SorryStatus:       sorryPage,
+	1:                 "1xx.html",
+	2:                 "2xx.html",
+	3:                 "3xx.html",
+	4:                 "4xx.html",
+	5:                 "5xx.html",
+}
+
+// ClassStatus returns the code used to store the class page for the status
+// code, the class page is used when the status code has no page
+func ClassStatus(code int) int {
+	return code / 100
+}
+
+// PageData is the data available to error page templates
+type PageData struct {
+	Status     int
+	StatusText string
+	Host       string
+	RequestID  string
+	Time       time.Time
+	Lang       string // language of the page, empty for the default page
+	Limit      int64  // size limit in bytes exceeded by the request for 413 and 431 pages
+	Theme      Theme
+}
+
+func (e *ErrorPages) newPageData(code int, host, id string) PageData {
+	data := PageData{
+		Status:     code,
+		StatusText: http.StatusText(code),
+		Host:       host,
+		RequestID:  id,
+		Time:       time.Now().UTC(),
+	}
+	e.s.RLock()
+	if e.theme != nil {
+		data.Theme = *e.theme
+	}
+	e.s.RUnlock()
+	return data
+}
+
+// page is a custom error page, pages without template actions are written
+// as-is. The page for the default language stores the variants for other
+// languages.
+type page struct {
+	name      string
+	raw       []byte
+	tmpl      *template.Template
+	lang      string
+	langs     map[string]*page
+	localized bool // the page has variants for other languages
+	noDefault bool // there is no page for the default language
+}
+
+// parseLocalizedPage creates the page for the default language with the
+// variants for the other languages, the files are stored by language with the
+// default language as an empty string
+func parseLocalizedPage(name string, files map[string][]byte, assets assetMap) *page {
+	p := &page{name: name, noDefault: true}
+	if htmlData, ok := files[""]; ok {
+		p = parsePage(name, htmlData, assets)
+	}
+	for lang, htmlData := range files {
+		if lang == "" {
+			continue
+		}
+		if p.langs == nil {
+			p.langs = make(map[string]*page)
+			p.localized = true
+		}
+		v := parsePage(strings.TrimSuffix(name, ".html")+"."+lang+".html", htmlData, assets)
+		v.lang = lang
+		v.localized = true
+		p.langs[lang] = v
+	}
+	return p
+}
+
+// localize returns the variant of the page for the first accepted language
+// with a variant, the default page is used if no language matches and nil is
+// returned if there is no default page
+func (p *page) localize(langs []string) *page {
+	for _, l := range langs {
+		if v, ok := p.langs[l]; ok {
+			return v
+		}
+	}
+	if p.noDefault {
+		return nil
+	}
+	return p
+}
+
+// parsePage creates the page from the html, pages which are not valid
+// templates are logged and written as-is so existing pages keep working. The
+// assets are inlined by the `asset` template function.
+func parsePage(name string, htmlData []byte, assets assetMap) *page {
+	p := &page{name: name, raw: htmlData}
+	if !bytes.Contains(htmlData, []byte("{{")) {
+		return p
+	}
+	t, err := template.New(name).Option("missingkey=error").Funcs(assets.funcs()).Parse(string(htmlData))
+	if err != nil {
+		log.Printf("[ErrorPages] WARNING: serving error page '%s' without templating: %s\n", name, err)
+		return p
+	}
+	p.tmpl = t
+	return p
+}
+
+// bufferPool reuses the buffers templates are executed into so error storms
+// during an outage don't allocate a buffer for each response
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// maxPooledBuffer stops buffers grown by large pages staying in the pool
+const maxPooledBuffer = 64 << 10
+
+// write outputs the page with the status code, false is returned without
+// writing anything if the template fails to execute. Templates are parsed when
+// the pages are compiled so nothing is read from disk for each response.
+func (p *page) write(rw http.ResponseWriter, data PageData) bool {
+	out := p.raw
+	data.Lang = p.lang
+	if p.tmpl != nil {
+		b := bufferPool.Get().(*bytes.Buffer)
+		b.Reset()
+		defer func() {
+			if b.Cap() <= maxPooledBuffer {
+				bufferPool.Put(b)
+			}
+		}()
+		if err := p.tmpl.Execute(b, data); err != nil {
+			log.Printf("[ErrorPages] Failed to execute error page '%s': %s\n", p.name, err)
+			return false
+		}
+		out = b.Bytes()
+	}
+	rw.Header().Set("Content-Type", "text/html; encoding=utf-8")
+	if p.localized {
+		rw.Header().Add("Vary", "Accept-Language")
+	}
+	rw.WriteHeader(data.Status)
+	_, _ = rw.Write(out)
+	return true
+}
+
+// requestID returns the X-Request-Id header of the request if it is safe to
+// output or a new random ID
+func requestID(req *http.Request) string {
+	if id := req.Header.Get("X-Request-Id"); id != "" && len(id) <= 128 && validRequestID(id) {
+		return id
+	}
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func validRequestID(id string) bool {
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package error_pages
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// Theme is rendered by the built-in error pages and is available to custom
+// pages as `.Theme`, empty fields use the default style
+type Theme struct {
+	SiteName   string
+	LogoUrl    string
+	Background string
+	Text       string
+	Accent     string
+}
+
+// themeColorPattern matches the hex colors allowed in the theme
+var themeColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Validate returns an error if a color is not a hex color or the logo is not a
+// http or https url or an absolute path
+func (t Theme) Validate() error {
+	for _, c := range []string{t.Background, t.Text, t.Accent} {
+		if c != "" && !themeColorPattern.MatchString(c) {
+			return fmt.Errorf("invalid theme color '%s'", c)
+		}
+	}
+	if t.LogoUrl != "" {
+		u, err := url.Parse(t.LogoUrl)
+		if err != nil || !(u.Scheme == "https" || u.Scheme == "http" || (u.Scheme == "" && u.Host == "" && len(u.Path) > 0 && u.Path[0] == '/')) {
+			return fmt.Errorf("invalid theme logo url '%s'", t.LogoUrl)
+		}
+	}
+	return nil
+}
+
+// SetTheme sets the theme of the built-in error pages, the built-in pages are
+// used instead of plain text responses for codes without a custom page once a
+// theme is set
+func (e *ErrorPages) SetTheme(t Theme) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	e.s.Lock()
+	e.theme = &t
+	e.s.Unlock()
+	return nil
+}
+
+// themeDefault is the built-in page used for codes without a custom page when
+// a theme is set
+var themeDefault = builtinPages[AnyStatus]
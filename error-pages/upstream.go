@@ -0,0 +1,68 @@
+package error_pages
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+)
+
+// UpstreamTlsStatus is the code used to store the page loaded from
+// `upstream-tls.html`, it is served with 502 when the tls connection to the
+// backend of a route fails
+const UpstreamTlsStatus = -2
+
+// upstreamTlsPage is the file name of the backend tls failure page
+const upstreamTlsPage = "upstream-tls.html"
+
+//go:embed defaults/*.html
+var defaultsDir embed.FS
+
+// defaultLayout is the document shared by the built-in pages, each page
+// defines the "message" template and executes the "layout" template
+//
+//go:embed defaults/layout.tmpl
+var defaultLayout string
+
+// builtinPages are the built-in pages for backend failures used when there is
+// no custom page and the catch-all page used when a theme is set
+var builtinPages = func() map[int]*page {
+	sub, err := fs.Sub(defaultsDir, "defaults")
+	if err != nil {
+		panic(err)
+	}
+	pages, err := readPages(sub, false)
+	if err != nil {
+		panic(err)
+	}
+	layout := template.Must(template.New("layout").Option("missingkey=error").Parse(defaultLayout))
+	m := make(map[int]*page, len(pages))
+	for code, files := range pages {
+		name := "defaults/" + pageName(code)
+		t := template.Must(template.Must(layout.Clone()).New(name).Parse(string(files[""])))
+		m[code] = &page{name: name, raw: files[""], tmpl: t}
+	}
+	return m
+}()
+
+// ServeUpstreamError writes the error page for a backend failure, the code is
+// 502 when the backend is down, 504 when it timed out or UpstreamTlsStatus for
+// tls failures. The custom pages are used first followed by the built-in pages
+// for each failure.
+func (e *ErrorPages) ServeUpstreamError(rw http.ResponseWriter, req *http.Request, code int) {
+	id := requestID(req)
+	rw.Header().Set("X-Request-Id", id)
+	status, codes := code, []int{code, ClassStatus(code), AnyStatus}
+	if code == UpstreamTlsStatus {
+		status, codes = http.StatusBadGateway, []int{UpstreamTlsStatus, http.StatusBadGateway, ClassStatus(http.StatusBadGateway), AnyStatus}
+	}
+	langs := acceptedLanguages(req)
+	data := e.newPageData(status, req.Host, id)
+	if p := e.findPage(req.Host, langs, codes...); p != nil && p.write(rw, data) {
+		return
+	}
+	if p, ok := builtinPages[code]; ok && p.localize(langs).write(rw, data) {
+		return
+	}
+	e.generic(rw, status)
+}
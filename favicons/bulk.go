@@ -0,0 +1,80 @@
+package favicons
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidRecord = errors.New("invalid favicon record")
+
+// FaviconRecord is the favicon sources of a host used for bulk import and
+// export
+type FaviconRecord struct {
+	Host string `json:"host"`
+	Path string `json:"path,omitempty"` // path prefix of the override, empty for the whole host
+	Svg  string `json:"svg"`
+	Png  string `json:"png"`
+	Ico  string `json:"ico"`
+
+	// svg shown instead of the svg when the browser prefers a dark color
+	// scheme
+	DarkSvg string `json:"dark_svg,omitempty"`
+
+	// seconds between checking the sources for changes, zero only fetches
+	// them when compiling
+	Refresh uint64 `json:"refresh_seconds,omitempty"`
+
+	// app name and hex theme color used in site.webmanifest and
+	// browserconfig.xml
+	Name       string `json:"name,omitempty"`
+	ThemeColor string `json:"theme_color,omitempty"`
+}
+
+// Export returns the favicon sources of every host ordered by host.
+func (f *Favicons) Export() ([]FaviconRecord, error) {
+	rows, err := f.db.Query(`SELECT host, path, svg, png, ico, refresh, name, theme_color, dark_svg FROM favicons ORDER BY host, path`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]FaviconRecord, 0)
+	for rows.Next() {
+		var r FaviconRecord
+		if err := rows.Scan(&r.Host, &r.Path, &r.Svg, &r.Png, &r.Ico, &r.Refresh, &r.Name, &r.ThemeColor, &r.DarkSvg); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// ImportTx replaces the favicon sources of each host and path prefix using a
+// transaction shared with other tables, ErrInvalidRecord is returned if any
+// record is missing the host or has an invalid path prefix or theme color.
+func (f *Favicons) ImportTx(tx *sql.Tx, records []FaviconRecord) error {
+	paths := make([]string, len(records))
+	for i, r := range records {
+		if r.Host == "" {
+			return fmt.Errorf("%w: line %d: missing host", ErrInvalidRecord, i+1)
+		}
+		p, ok := CleanIconPath(r.Path)
+		if !ok {
+			return fmt.Errorf("%w: line %d: invalid path '%s'", ErrInvalidRecord, i+1, r.Path)
+		}
+		if !validThemeColor(r.ThemeColor) {
+			return fmt.Errorf("%w: line %d: invalid theme color '%s'", ErrInvalidRecord, i+1, r.ThemeColor)
+		}
+		paths[i] = p
+	}
+	for i, r := range records {
+		if _, err := tx.Exec(`DELETE FROM favicons WHERE host = ? AND path = ?`, r.Host, paths[i]); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO favicons (host, path, svg, png, ico, refresh, name, theme_color, dark_svg) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, r.Host, paths[i], r.Svg, r.Png, r.Ico, r.Refresh, r.Name, r.ThemeColor, r.DarkSvg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
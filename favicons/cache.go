@@ -0,0 +1,117 @@
+package favicons
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed create-table-favicon-artifacts.sql
+var createTableFaviconArtifacts string
+
+// artifactRetention is how long unused artifacts are kept in the database
+const artifactRetention = 30 * 24 * time.Hour
+
+// artifactCache stores converted images in the database keyed by the hash of
+// the source image and the converter, so unchanged sources aren't converted
+// again after restarts and recompiles
+type artifactCache struct {
+	db *sql.DB
+}
+
+// get returns the stored artifact and marks it as used
+func (c *artifactCache) get(key string) ([]byte, bool) {
+	var raw []byte
+	err := c.db.QueryRow(`SELECT raw FROM favicon_artifacts WHERE hash = ?`, key).Scan(&raw)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("[Favicons] Failed to read cached artifact: %s\n", err)
+		}
+		return nil, false
+	}
+	_, _ = c.db.Exec(`UPDATE favicon_artifacts SET used = ? WHERE hash = ?`, time.Now().Unix(), key)
+	return raw, true
+}
+
+func (c *artifactCache) put(key string, raw []byte) {
+	_, err := c.db.Exec(`INSERT OR REPLACE INTO favicon_artifacts (hash, raw, used) VALUES (?, ?, ?)`, key, raw, time.Now().Unix())
+	if err != nil {
+		log.Printf("[Favicons] Failed to store cached artifact: %s\n", err)
+	}
+}
+
+// prune removes artifacts which haven't been used within the retention
+func (c *artifactCache) prune() error {
+	_, err := c.db.Exec(`DELETE FROM favicon_artifacts WHERE used < ?`, time.Now().Add(-artifactRetention).Unix())
+	return err
+}
+
+// artifactKey hashes the operation, converter and source image
+func artifactKey(op, conv string, in []byte) string {
+	h := sha256.New()
+	h.Write([]byte(op + "\x00" + conv + "\x00"))
+	h.Write(in)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// converterId describes the converter so changing the program or arguments
+// doesn't reuse artifacts from the old converter
+func converterId(conv Converter) string {
+	if c, ok := conv.(*CommandConverter); ok {
+		return strings.Join(append([]string{c.Name, c.Path, strconv.Itoa(c.Size)}, append(c.Args, c.SizeArgs...)...), " ")
+	}
+	return fmt.Sprintf("%T", conv)
+}
+
+// cachedConverter looks up the output of the converter in the artifact cache
+// before running it
+type cachedConverter struct {
+	conv  Converter
+	id    string
+	cache *artifactCache
+}
+
+// cachedSizedConverter also caches the output of each size
+type cachedSizedConverter struct {
+	*cachedConverter
+}
+
+// newCachedConverter wraps the converter, the result only implements
+// SizedConverter if the converter does
+func newCachedConverter(cache *artifactCache, conv Converter) Converter {
+	c := &cachedConverter{conv: conv, id: converterId(conv), cache: cache}
+	if _, ok := conv.(SizedConverter); ok {
+		return cachedSizedConverter{c}
+	}
+	return c
+}
+
+func (c *cachedConverter) Convert(in []byte) ([]byte, error) {
+	return c.cached("convert", in, c.conv.Convert)
+}
+
+func (c cachedSizedConverter) ConvertSize(in []byte, size int) ([]byte, error) {
+	return c.cached("size="+strconv.Itoa(size), in, func(in []byte) ([]byte, error) {
+		return c.conv.(SizedConverter).ConvertSize(in, size)
+	})
+}
+
+func (c *cachedConverter) cached(op string, in []byte, convert func([]byte) ([]byte, error)) ([]byte, error) {
+	key := artifactKey(op, c.id, in)
+	if raw, ok := c.cache.get(key); ok {
+		return raw, nil
+	}
+	raw, err := convert(in)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.put(key, raw)
+	return raw, nil
+}
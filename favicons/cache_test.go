@@ -0,0 +1,80 @@
+package favicons
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// countingConverter counts the conversions and returns the built-in output
+type countingConverter struct {
+	calls int
+}
+
+func (c *countingConverter) Convert(in []byte) ([]byte, error) {
+	c.calls++
+	return Builtin.Convert(in)
+}
+
+func (c *countingConverter) ConvertSize(in []byte, size int) ([]byte, error) {
+	c.calls++
+	return Builtin.(SizedConverter).ConvertSize(in, size)
+}
+
+func TestCachedConverter(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestCachedConverter?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	_, err = db.Exec(createTableFaviconArtifacts)
+	assert.NoError(t, err)
+	cache := &artifactCache{db: db}
+
+	inner := &countingConverter{}
+	conv := newCachedConverter(cache, inner)
+	sized, ok := conv.(SizedConverter)
+	assert.True(t, ok)
+
+	a, err := conv.Convert(exampleSvg)
+	assert.NoError(t, err)
+	b, err := conv.Convert(exampleSvg)
+	assert.NoError(t, err)
+	assert.Equal(t, a, b)
+	assert.Equal(t, 1, inner.calls)
+
+	// each size is stored separately
+	_, err = sized.ConvertSize(exampleSvg, 32)
+	assert.NoError(t, err)
+	_, err = sized.ConvertSize(exampleSvg, 32)
+	assert.NoError(t, err)
+	_, err = sized.ConvertSize(exampleSvg, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, inner.calls)
+
+	// the cache survives a new converter using the same database
+	inner2 := &countingConverter{}
+	c, err := newCachedConverter(cache, inner2).Convert(exampleSvg)
+	assert.NoError(t, err)
+	assert.Equal(t, a, c)
+	assert.Equal(t, 0, inner2.calls)
+
+	// wrapping keeps converters without sizes unsized
+	_, ok = newCachedConverter(cache, prefixEncoder("webp")).(SizedConverter)
+	assert.False(t, ok)
+
+	// unused artifacts are pruned
+	_, err = db.Exec(`UPDATE favicon_artifacts SET used = ?`, time.Now().Add(-2*artifactRetention).Unix())
+	assert.NoError(t, err)
+	assert.NoError(t, cache.prune())
+	var n int
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM favicon_artifacts`).Scan(&n))
+	assert.Equal(t, 0, n)
+}
+
+func TestConverterId(t *testing.T) {
+	a, err := NewConverter("inkscape", "", nil, 0, 0)
+	assert.NoError(t, err)
+	b, err := NewConverter("inkscape", "/opt/inkscape", nil, 0, 0)
+	assert.NoError(t, err)
+	assert.NotEqual(t, converterId(a), converterId(b))
+	assert.Equal(t, "favicons.builtinConverter", converterId(Builtin))
+}
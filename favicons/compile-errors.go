@@ -0,0 +1,72 @@
+package favicons
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SourceError is returned by PreProcess when an icon fails to download or
+// generate, Icon is svg, png or ico and Url is empty for generated icons.
+type SourceError struct {
+	Icon string
+	Url  string
+	Err  error
+}
+
+func (e *SourceError) Error() string { return e.Err.Error() }
+func (e *SourceError) Unwrap() error { return e.Err }
+
+// EntryError is a favicon override which failed to compile, the previous
+// icons of the host are served until the source is fixed.
+type EntryError struct {
+	Host   string `json:"host"`
+	Path   string `json:"path,omitempty"`
+	Icon   string `json:"icon,omitempty"`
+	Source string `json:"source,omitempty"`
+	Error  string `json:"error"`
+}
+
+// newEntryError creates the entry error for the host and path using the
+// broken source from the error if known
+func newEntryError(host, prefix string, err error) EntryError {
+	e := EntryError{Host: host, Path: prefix, Error: err.Error()}
+	var s *SourceError
+	if errors.As(err, &s) {
+		e.Icon, e.Source = s.Icon, displaySource(s.Url)
+	}
+	return e
+}
+
+// displaySource removes the data from data urls so uploaded icons are not
+// repeated in error reports
+func displaySource(u string) string {
+	if strings.HasPrefix(u, "data:") {
+		u, _, _ = strings.Cut(u, ",")
+	}
+	return u
+}
+
+// CompileErrors is returned by CompileSync when any favicon override fails to
+// compile, the other overrides are still replaced.
+type CompileErrors []EntryError
+
+func (c CompileErrors) Error() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "%d favicons failed to compile", len(c))
+	for _, i := range c {
+		name := i.Host + i.Path
+		if name == defaultKey {
+			name = "default"
+		}
+		_, _ = fmt.Fprintf(&b, "; %s: %s", name, i.Error)
+	}
+	return b.String()
+}
+
+// Errors returns the favicon overrides which failed during the last compile
+func (f *Favicons) Errors() []EntryError {
+	f.cLock.RLock()
+	defer f.cLock.RUnlock()
+	return append([]EntryError{}, f.errors...)
+}
@@ -0,0 +1,60 @@
+package favicons
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestFavicons_Errors(t *testing.T) {
+	broken := map[string]bool{}
+	getFaviconViaRequest = func(_ *http.Client, u string) ([]byte, error) {
+		if broken[u] {
+			return nil, errors.New("404 Not Found")
+		}
+		return exampleSvg, nil
+	}
+
+	db, err := sql.Open("sqlite3", "file:TestFavicons_Errors?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	f := New(db, nil)
+	f.r.Wait()
+	assert.NoError(t, f.Put(FaviconRecord{Host: "example.com", Svg: "https://example.com/logo.svg"}))
+	assert.NoError(t, f.Put(FaviconRecord{Host: "example.org", Path: "/docs", Svg: "https://example.org/logo.svg"}))
+	assert.NoError(t, f.CompileSync())
+	assert.Empty(t, f.Errors())
+	good := f.GetIcons("example.com")
+	assert.NotNil(t, good)
+
+	// a broken source doesn't stop other hosts compiling
+	broken["https://example.com/logo.svg"] = true
+	assert.NoError(t, f.Put(FaviconRecord{Host: "example.net", Svg: "https://example.net/logo.svg"}))
+	err = f.CompileSync()
+	var failed CompileErrors
+	assert.ErrorAs(t, err, &failed)
+	assert.Equal(t, "1 favicons failed to compile; example.com: [Favicons] Failed to fetch SVG icon: 404 Not Found", err.Error())
+	assert.Equal(t, []EntryError{{
+		Host:   "example.com",
+		Icon:   "svg",
+		Source: "https://example.com/logo.svg",
+		Error:  "[Favicons] Failed to fetch SVG icon: 404 Not Found",
+	}}, f.Errors())
+	assert.NotNil(t, f.GetIcons("example.net"))
+	assert.NotNil(t, f.FindIconsAt("example.org", "/docs"))
+
+	// the previous icons are kept for the broken host
+	assert.Same(t, good, f.GetIcons("example.com"))
+
+	// fixing the source clears the error
+	delete(broken, "https://example.com/logo.svg")
+	assert.NoError(t, f.CompileSync())
+	assert.Empty(t, f.Errors())
+	assert.NotSame(t, good, f.GetIcons("example.com"))
+}
+
+func TestDisplaySource(t *testing.T) {
+	assert.Equal(t, "https://example.com/logo.svg", displaySource("https://example.com/logo.svg"))
+	assert.Equal(t, "data:image/png;base64", displaySource(DataUrl("image/png", []byte("png data"))))
+}
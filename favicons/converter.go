@@ -0,0 +1,226 @@
+package favicons
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Converter generates png image bytes from svg image bytes
+type Converter interface {
+	Convert(in []byte) ([]byte, error)
+}
+
+// SizedConverter is implemented by converters which can render the svg with
+// the longest side matching the size
+type SizedConverter interface {
+	Converter
+	ConvertSize(in []byte, size int) ([]byte, error)
+}
+
+// Builtin is the pure Go converter used when no other converter is configured
+var Builtin Converter = builtinConverter{}
+
+type builtinConverter struct{}
+
+func (builtinConverter) Convert(in []byte) ([]byte, error) {
+	return builtinConverter{}.ConvertSize(in, 0)
+}
+
+func (builtinConverter) ConvertSize(in []byte, size int) ([]byte, error) {
+	if len(in) > defaultMaxInput {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInputTooLarge, len(in))
+	}
+	return rasterizeSvg(in, size)
+}
+
+var (
+	ErrUnknownConverter = errors.New("unknown svg converter")
+	ErrInputTooLarge    = errors.New("converter input too large")
+	ErrOutputTooLarge   = errors.New("converter output too large")
+)
+
+const (
+	// defaultConvertTimeout stops converters which never finish
+	defaultConvertTimeout = 30 * time.Second
+	// defaultConvertSize replaces `{size}` in arguments if no size is set
+	defaultConvertSize = 256
+	// defaultMaxInput and defaultMaxOutput limit the svg documents passed to
+	// converters and the png images read from them
+	defaultMaxInput  = 4 << 20
+	defaultMaxOutput = 32 << 20
+	// maxStderr is the amount of stderr kept for error messages
+	maxStderr = 16 << 10
+)
+
+// Limits restricts the resources used by a converter program, zero values are
+// unlimited. Memory and CPU limits are only applied on Linux.
+type Limits struct {
+	MaxInput  int           // bytes of input passed to the program
+	MaxOutput int           // bytes of output read from the program
+	Memory    uint64        // bytes of address space
+	CPU       time.Duration // processor time
+}
+
+// converterBackends contains the default program and argument templates of
+// each supported converter, all of them read stdin and write to stdout. The
+// size arguments are added when rendering an icon with a fixed size.
+var converterBackends = map[string]struct {
+	path     string
+	args     []string
+	sizeArgs []string
+}{
+	"inkscape":     {"inkscape", []string{"--export-type", "png", "--export-filename", "-", "--export-background-opacity", "0", "--pipe"}, []string{"--export-width", "{size}"}},
+	"rsvg-convert": {"rsvg-convert", []string{"--format", "png"}, []string{"--width", "{size}", "--keep-aspect-ratio"}},
+	"resvg":        {"resvg", []string{"-", "-c"}, []string{"--width", "{size}"}},
+}
+
+// NewConverter creates the converter of the named type, an empty path or nil
+// args use the defaults for the type. The `{size}` placeholder in args is
+// replaced with the size unless a fixed size is requested, a zero timeout uses
+// the default. The input and output sizes use the default limits.
+//
+// The `builtin` type ignores the other options.
+func NewConverter(kind, path string, args []string, size int, timeout time.Duration) (Converter, error) {
+	if kind == "builtin" {
+		return Builtin, nil
+	}
+	backend, ok := converterBackends[kind]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownConverter, kind)
+	}
+	if path == "" {
+		path = backend.path
+	}
+	if args == nil {
+		args = backend.args
+	}
+	if size <= 0 {
+		size = defaultConvertSize
+	}
+	if timeout <= 0 {
+		timeout = defaultConvertTimeout
+	}
+	return &CommandConverter{
+		Name:     kind,
+		Path:     path,
+		Args:     args,
+		SizeArgs: backend.sizeArgs,
+		Size:     size,
+		Timeout:  timeout,
+		Limits:   Limits{MaxInput: defaultMaxInput, MaxOutput: defaultMaxOutput},
+	}, nil
+}
+
+// CommandConverter runs a program which reads the svg from stdin and writes
+// the png to stdout, `{size}` in the arguments is replaced with the size. The
+// program runs in its own process group where supported so the timeout also
+// stops any programs it starts.
+type CommandConverter struct {
+	Name     string // shown in errors
+	Path     string
+	Args     []string
+	SizeArgs []string // added to the arguments by ConvertSize
+	Size     int
+	Timeout  time.Duration
+	Limits   Limits
+}
+
+// Convert runs the program and returns the png image bytes or an error which
+// includes the output of stderr
+func (c *CommandConverter) Convert(in []byte) ([]byte, error) {
+	return c.run(in, c.Args, c.Size)
+}
+
+// ConvertSize runs the program with the size arguments
+func (c *CommandConverter) ConvertSize(in []byte, size int) ([]byte, error) {
+	return c.run(in, append(append([]string{}, c.Args...), c.SizeArgs...), size)
+}
+
+func (c *CommandConverter) run(in []byte, args []string, size int) ([]byte, error) {
+	if c.Limits.MaxInput > 0 && len(in) > c.Limits.MaxInput {
+		return nil, fmt.Errorf("%s: %w: %d bytes", c.Name, ErrInputTooLarge, len(in))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	expanded := make([]string, len(args))
+	for i, a := range args {
+		expanded[i] = strings.ReplaceAll(a, "{size}", strconv.Itoa(size))
+	}
+
+	// prepare command and attach buffers, the program is stopped if the
+	// output is too large
+	stdout := &limitWriter{max: c.Limits.MaxOutput, cancel: cancel}
+	stderr := &limitWriter{max: maxStderr, truncate: true}
+	cmd := exec.CommandContext(ctx, c.Path, expanded...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+	sandboxCommand(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Name, err)
+	}
+
+	// run the command and return errors
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Name, err)
+	}
+	if err := applyLimits(cmd.Process.Pid, c.Limits); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("%s: failed to apply limits: %w", c.Name, err)
+	}
+
+	// the svg is only sent once the limits apply
+	go func() {
+		_, _ = stdin.Write(in)
+		_ = stdin.Close()
+	}()
+	if err := cmd.Wait(); err != nil {
+		switch {
+		case stdout.exceeded:
+			return nil, fmt.Errorf("%s: %w", c.Name, ErrOutputTooLarge)
+		case ctx.Err() != nil:
+			return nil, fmt.Errorf("%s timed out after %s", c.Name, c.Timeout)
+		}
+		return nil, fmt.Errorf("%s: %w\nSTDERR:\n%s", c.Name, err, stderr.buf.String())
+	}
+
+	// error if there is no output
+	if stdout.buf.Len() == 0 {
+		return nil, fmt.Errorf("got no data from %s", c.Name)
+	}
+	return stdout.buf.Bytes(), nil
+}
+
+// limitWriter buffers up to max bytes, extra bytes are dropped if truncate is
+// set otherwise the write fails and cancel stops the program
+type limitWriter struct {
+	buf      bytes.Buffer
+	max      int
+	truncate bool
+	cancel   func()
+	exceeded bool
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.max <= 0 || w.buf.Len()+len(p) <= w.max {
+		return w.buf.Write(p)
+	}
+	if w.truncate {
+		w.buf.Write(p[:w.max-w.buf.Len()])
+		return len(p), nil
+	}
+	w.exceeded = true
+	if w.cancel != nil {
+		w.cancel()
+	}
+	return 0, ErrOutputTooLarge
+}
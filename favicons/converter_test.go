@@ -0,0 +1,67 @@
+package favicons
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewConverter(t *testing.T) {
+	c, err := NewConverter("builtin", "", nil, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Builtin, c)
+
+	c, err = NewConverter("rsvg-convert", "", []string{"-w", "{size}", "-h", "{size}"}, 64, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, &CommandConverter{Name: "rsvg-convert", Path: "rsvg-convert", Args: []string{"-w", "{size}", "-h", "{size}"}, SizeArgs: []string{"--width", "{size}", "--keep-aspect-ratio"}, Size: 64, Timeout: defaultConvertTimeout, Limits: Limits{MaxInput: defaultMaxInput, MaxOutput: defaultMaxOutput}}, c)
+
+	c, err = NewConverter("resvg", "/opt/resvg", nil, 0, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, &CommandConverter{Name: "resvg", Path: "/opt/resvg", Args: []string{"-", "-c"}, SizeArgs: []string{"--width", "{size}"}, Size: defaultConvertSize, Timeout: time.Second, Limits: Limits{MaxInput: defaultMaxInput, MaxOutput: defaultMaxOutput}}, c)
+
+	_, err = NewConverter("imagemagick", "", nil, 0, 0)
+	assert.ErrorIs(t, err, ErrUnknownConverter)
+}
+
+func TestCommandConverter_Convert(t *testing.T) {
+	// cat copies the input to the output
+	out, err := (&CommandConverter{Name: "cat", Path: "cat", Timeout: time.Second}).Convert([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), out)
+
+	// echo outputs the expanded arguments
+	out, err = (&CommandConverter{Name: "echo", Path: "echo", Args: []string{"{size}"}, SizeArgs: []string{"-w", "{size}"}, Size: 32, Timeout: time.Second}).ConvertSize(nil, 180)
+	assert.NoError(t, err)
+	assert.Equal(t, "180 -w 180\n", string(out))
+
+	_, err = (&CommandConverter{Name: "true", Path: "true", Timeout: time.Second}).Convert([]byte("hello"))
+	assert.EqualError(t, err, "got no data from true")
+
+	_, err = (&CommandConverter{Name: "sleep", Path: "sleep", Args: []string{"5"}, Timeout: 50 * time.Millisecond}).Convert(nil)
+	assert.EqualError(t, err, "sleep timed out after 50ms")
+}
+
+func TestCommandConverter_Limits(t *testing.T) {
+	_, err := (&CommandConverter{Name: "cat", Path: "cat", Timeout: time.Second, Limits: Limits{MaxInput: 4}}).Convert([]byte("hello"))
+	assert.ErrorIs(t, err, ErrInputTooLarge)
+
+	// the program is stopped once the output is too large
+	_, err = (&CommandConverter{Name: "yes", Path: "yes", Timeout: 5 * time.Second, Limits: Limits{MaxOutput: 1024}}).Convert(nil)
+	assert.ErrorIs(t, err, ErrOutputTooLarge)
+
+	_, err = Builtin.Convert(make([]byte, defaultMaxInput+1))
+	assert.ErrorIs(t, err, ErrInputTooLarge)
+}
+
+func TestLimitWriter(t *testing.T) {
+	w := &limitWriter{max: 4, truncate: true}
+	n, err := w.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "hell", w.buf.String())
+
+	w = &limitWriter{max: 4}
+	_, err = w.Write([]byte("hello"))
+	assert.ErrorIs(t, err, ErrOutputTooLarge)
+	assert.True(t, w.exceeded)
+}
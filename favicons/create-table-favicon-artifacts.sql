@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS favicon_artifacts (
+    hash VARCHAR PRIMARY KEY,
+    raw BLOB,
+    used INTEGER
+);
@@ -3,5 +3,10 @@ CREATE TABLE IF NOT EXISTS favicons (
     host VARCHAR,
     svg VARCHAR,
     png VARCHAR,
-    ico VARCHAR
+    ico VARCHAR,
+    refresh INTEGER DEFAULT 0,
+    path VARCHAR DEFAULT '',
+    name VARCHAR DEFAULT '',
+    theme_color VARCHAR DEFAULT '',
+    dark_svg VARCHAR DEFAULT ''
 );
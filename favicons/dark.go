@@ -0,0 +1,35 @@
+package favicons
+
+import (
+	"bytes"
+	"encoding/base64"
+)
+
+// adaptiveStyle shows the dark icon instead of the light icon when the browser
+// prefers a dark color scheme
+const adaptiveStyle = `#dark{display:none}@media (prefers-color-scheme: dark){#light{display:none}#dark{display:inline}}`
+
+// genAdaptive combines the svg and dark svg into a single svg which switches
+// between them using a media query, browsers also support the dark svg being
+// linked directly from `/favicon-dark.svg` using the media attribute
+func (l *FaviconList) genAdaptive() {
+	l.Adaptive = nil
+	if l.Svg == nil || l.DarkSvg == nil {
+		return
+	}
+
+	// the icons are embedded as data urls as svg images can't load other files
+	var b bytes.Buffer
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">`)
+	b.WriteString(`<style>` + adaptiveStyle + `</style>`)
+	for _, i := range []struct {
+		id  string
+		img *FaviconImage
+	}{{"light", l.Svg}, {"dark", l.DarkSvg}} {
+		b.WriteString(`<image id="` + i.id + `" width="100" height="100" href="data:image/svg+xml;base64,`)
+		b.WriteString(base64.StdEncoding.EncodeToString(i.img.Raw))
+		b.WriteString(`"/>`)
+	}
+	b.WriteString("</svg>\n")
+	l.Adaptive = &FaviconImage{Raw: b.Bytes(), Hash: genSha256(b.Bytes())}
+}
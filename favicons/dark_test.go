@@ -0,0 +1,60 @@
+package favicons
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestFaviconList_GenAdaptive(t *testing.T) {
+	dark := []byte(`<svg width="10" height="10" xmlns="http://www.w3.org/2000/svg"><rect width="10" height="10" fill="white"/></svg>`)
+	icons := &FaviconList{
+		Svg:     CreateFaviconImage(DataUrl("image/svg+xml", exampleSvg)),
+		DarkSvg: CreateFaviconImage(DataUrl("image/svg+xml", dark)),
+	}
+	assert.NoError(t, icons.PreProcess(Builtin))
+
+	img, contentType, err := icons.ProduceForRequest("/favicon.svg", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/svg+xml", contentType)
+	assert.Same(t, icons.Adaptive, img)
+	raw := string(img.Raw)
+	assert.True(t, strings.HasPrefix(raw, "<svg"))
+	assert.Contains(t, raw, "@media (prefers-color-scheme: dark)")
+	assert.Contains(t, raw, base64.StdEncoding.EncodeToString(exampleSvg))
+	assert.Contains(t, raw, base64.StdEncoding.EncodeToString(dark))
+	assert.NotEmpty(t, img.Hash)
+
+	img, _, err = icons.ProduceForRequest("/favicon-dark.svg", "")
+	assert.NoError(t, err)
+	assert.Equal(t, dark, img.Raw)
+
+	// the png icons are generated from the light svg
+	light := &FaviconList{Svg: CreateFaviconImage(DataUrl("image/svg+xml", exampleSvg))}
+	assert.NoError(t, light.PreProcess(Builtin))
+	assert.Equal(t, light.Png.Hash, icons.Png.Hash)
+	assert.Nil(t, light.Adaptive)
+	_, _, err = light.ProduceForRequest("/favicon-dark.svg", "")
+	assert.ErrorIs(t, err, ErrFaviconNotFound)
+}
+
+func TestFavicons_DarkSvg(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestFavicons_DarkSvg?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	f := New(db, nil)
+	f.r.Wait()
+
+	assert.ErrorIs(t, f.Put(FaviconRecord{Host: "example.com", Svg: DataUrl("image/svg+xml", exampleSvg), DarkSvg: DataUrl("image/png", examplePng)}), ErrInvalidRecord)
+
+	want := FaviconRecord{Host: "example.com", Svg: DataUrl("image/svg+xml", exampleSvg), DarkSvg: DataUrl("image/svg+xml", exampleSvg)}
+	assert.NoError(t, f.Put(want))
+	r, err := f.Get("example.com", "")
+	assert.NoError(t, err)
+	assert.Equal(t, want, r)
+
+	assert.NoError(t, f.CompileSync())
+	assert.NotNil(t, f.GetIcons("example.com").Adaptive)
+	assert.True(t, IsIconPath("/favicon-dark.svg"))
+}
@@ -0,0 +1,35 @@
+package favicons
+
+import "fmt"
+
+// defaultKey is the key of the default favicon list, hosts are never empty
+const defaultKey = ""
+
+// SetDefault sets the favicon served for hosts without a favicon row, wildcard
+// row or discovered icons and compiles the favicons. The host and path of the
+// record are ignored.
+func (f *Favicons) SetDefault(r FaviconRecord) error {
+	if r.Svg == "" && r.Png == "" && r.Ico == "" {
+		return fmt.Errorf("%w: missing default favicon source", ErrInvalidRecord)
+	}
+	if err := checkSources(r); err != nil {
+		return err
+	}
+	if !validThemeColor(r.ThemeColor) {
+		return fmt.Errorf("%w: invalid theme color '%s'", ErrInvalidRecord, r.ThemeColor)
+	}
+	f.fallback.Store(&FaviconRecord{Svg: r.Svg, Png: r.Png, Ico: r.Ico, DarkSvg: r.DarkSvg, Name: r.Name, ThemeColor: r.ThemeColor})
+	f.Compile()
+	return nil
+}
+
+// FindIcons returns the favicon list for the host, the discovered icons or
+// the default favicon list. Nil is returned if none are available.
+func (f *Favicons) FindIcons(host string) *FaviconList {
+	if l := f.findIcons(host); l != nil {
+		return l
+	}
+	f.cLock.RLock()
+	defer f.cLock.RUnlock()
+	return f.faviconMap[defaultKey]
+}
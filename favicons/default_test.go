@@ -0,0 +1,32 @@
+package favicons
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFavicons_SetDefault(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestFavicons_SetDefault?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	f := New(db, nil)
+	f.r.Wait()
+	assert.Nil(t, f.FindIcons("example.com"))
+
+	assert.ErrorIs(t, f.SetDefault(FaviconRecord{}), ErrInvalidRecord)
+	assert.ErrorIs(t, f.SetDefault(FaviconRecord{Png: DataUrl("image/png", exampleSvg)}), ErrInvalidRecord)
+	assert.NoError(t, f.SetDefault(FaviconRecord{Host: "ignored", Svg: DataUrl("image/svg+xml", exampleSvg)}))
+	assert.NoError(t, f.CompileSync())
+
+	def := f.FindIcons("example.com")
+	assert.NotNil(t, def)
+	assert.NotNil(t, def.Png)
+	assert.Nil(t, f.GetIcons("example.com"))
+	assert.Nil(t, f.GetIcons("ignored"), "the host of the default is ignored")
+
+	// hosts with icons don't use the default
+	assert.NoError(t, f.Put(FaviconRecord{Host: "example.com", Ico: DataUrl("image/x-icon", exampleIco)}))
+	assert.NoError(t, f.CompileSync())
+	assert.NotSame(t, def, f.FindIcons("example.com"))
+	assert.Same(t, f.FindIcons("example.org"), f.FindIcons("example.net"))
+}
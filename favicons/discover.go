@@ -0,0 +1,243 @@
+package favicons
+
+import (
+	"fmt"
+	"golang.org/x/net/html"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxDiscoverPage limits the bytes of html read when discovering favicons
+	maxDiscoverPage = 1 << 20
+	// maxDiscovered limits the hosts cached by discovery
+	maxDiscovered = 1024
+)
+
+// discovery caches the icons found in the html of hosts without a favicon row,
+// hosts without icons are cached as nil so the page isn't fetched again until
+// the entry expires
+type discovery struct {
+	ttl     time.Duration
+	valid   func(host string) bool
+	lock    sync.Mutex
+	entries map[string]*discovered
+}
+
+type discovered struct {
+	icons   *FaviconList
+	expires time.Time
+}
+
+// SetDiscovery enables fetching the `/` page of hosts without a favicon row to
+// find the `<link rel="icon">` tags, the icons are kept for the ttl and a zero
+// ttl disables discovery. Only hosts where valid returns true are discovered.
+func (f *Favicons) SetDiscovery(ttl time.Duration, valid func(host string) bool) {
+	f.cLock.Lock()
+	defer f.cLock.Unlock()
+	if ttl <= 0 || valid == nil {
+		f.discover = nil
+		return
+	}
+	f.discover = &discovery{ttl: ttl, valid: valid, entries: make(map[string]*discovered)}
+}
+
+// findIcons returns the favicon list for the host, if the host has no favicon
+// row or matching wildcard row and discovery is enabled then the discovered
+// icons are returned. The first request for a host starts discovery in the
+// background and returns nil.
+func (f *Favicons) findIcons(host string) *FaviconList {
+	f.cLock.RLock()
+	icons, d := f.lookup(host, ""), f.discover
+	f.cLock.RUnlock()
+	if icons != nil || d == nil || !d.valid(host) {
+		return icons
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Now()
+	if e, ok := d.entries[host]; ok && (e.expires.IsZero() || now.Before(e.expires)) {
+		return e.icons
+	}
+	if !d.evict(now) {
+		// every cached host is still being discovered
+		return nil
+	}
+
+	// a zero expiry marks the discovery as running
+	d.entries[host] = &discovered{}
+	go func() {
+		icons, err := f.discoverIcons(host)
+		if err != nil {
+			log.Printf("[Favicons] Discovery failed for '%s': %s\n", host, err)
+		}
+		d.lock.Lock()
+		d.entries[host] = &discovered{icons: icons, expires: time.Now().Add(d.ttl)}
+		d.lock.Unlock()
+	}()
+	return nil
+}
+
+// evict removes expired entries once the cache is full, the entry expiring
+// first is removed if none have expired. False is returned if the cache is
+// still full. The lock must be held.
+func (d *discovery) evict(now time.Time) bool {
+	if len(d.entries) < maxDiscovered {
+		return true
+	}
+	var oldest string
+	var oldestExpires time.Time
+	for k, e := range d.entries {
+		if e.expires.IsZero() {
+			continue
+		}
+		if !now.Before(e.expires) {
+			delete(d.entries, k)
+			continue
+		}
+		if oldest == "" || e.expires.Before(oldestExpires) {
+			oldest, oldestExpires = k, e.expires
+		}
+	}
+	if len(d.entries) >= maxDiscovered && oldest != "" {
+		delete(d.entries, oldest)
+	}
+	return len(d.entries) < maxDiscovered
+}
+
+// discoverIcons fetches the page of the host and generates the icons from the
+// links, nil is returned if the page has no icon links
+func (f *Favicons) discoverIcons(host string) (*FaviconList, error) {
+	base := &url.URL{Scheme: "https", Host: host, Path: "/"}
+	client := f.discoverClient()
+	page, err := getPageViaRequest(client, base.String())
+	if err != nil {
+		return nil, err
+	}
+	l, err := parseIconLinks(page, base)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	if err := l.preProcess(client, f.conv, f.formats...); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// parseIconLinks finds the svg, png and ico icons in the `<link rel="icon">`
+// tags of the html, relative urls are resolved against the base url or the
+// `<base>` tag. The first link of each type is used.
+func parseIconLinks(page []byte, base *url.URL) (*FaviconList, error) {
+	doc, err := html.Parse(strings.NewReader(string(page)))
+	if err != nil {
+		return nil, fmt.Errorf("[Favicons] Failed to parse html: %w", err)
+	}
+
+	l := &FaviconList{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "base":
+				if href := htmlAttr(n, "href"); href != "" {
+					if u, err := base.Parse(href); err == nil {
+						base = u
+					}
+				}
+			case "link":
+				if hasRelIcon(htmlAttr(n, "rel")) {
+					l.addLink(base, htmlAttr(n, "href"), htmlAttr(n, "type"))
+				}
+			case "body":
+				// icon links are only found in the head
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if l.Svg == nil && l.Png == nil && l.Ico == nil {
+		return nil, nil
+	}
+	return l, nil
+}
+
+// addLink sets the icon matching the content type or the extension of the url
+// if it isn't already set
+func (l *FaviconList) addLink(base *url.URL, href, contentType string) {
+	if href == "" {
+		return
+	}
+	u, err := base.Parse(href)
+	if err != nil || !validSourceUrl(u.String()) {
+		return
+	}
+	t, _, _ := mime.ParseMediaType(contentType)
+	var img **FaviconImage
+	switch {
+	case t == "image/svg+xml" || (t == "" && path.Ext(u.Path) == ".svg"):
+		img = &l.Svg
+	case t == "image/png" || (t == "" && path.Ext(u.Path) == ".png"):
+		img = &l.Png
+	case t == "image/x-icon" || t == "image/vnd.microsoft.icon" || (t == "" && path.Ext(u.Path) == ".ico"):
+		img = &l.Ico
+	default:
+		return
+	}
+	if *img == nil {
+		*img = &FaviconImage{Url: u.String()}
+	}
+}
+
+// hasRelIcon returns true if the space separated rel attribute contains icon
+func hasRelIcon(rel string) bool {
+	for _, i := range strings.Fields(rel) {
+		if strings.EqualFold(i, "icon") {
+			return true
+		}
+	}
+	return false
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+// getPageViaRequest downloads the html page used for discovery, the response
+// must be a successful html response.
+var getPageViaRequest = func(client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("[Favicons] Failed to send request '%s': %w", url, err)
+	}
+	req.Header.Set("X-Violet-Raw-Favicon", "1")
+	req.Header.Set("Accept", "text/html")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("[Favicons] Failed to do request '%s': %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("[Favicons] Unexpected status for '%s': %s", url, resp.Status)
+	}
+	if t, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); t != "text/html" {
+		return nil, fmt.Errorf("[Favicons] Unexpected content type for '%s': %s", url, t)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxDiscoverPage))
+}
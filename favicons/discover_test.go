@@ -0,0 +1,100 @@
+package favicons
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseIconLinks(t *testing.T) {
+	base := &url.URL{Scheme: "https", Host: "example.com", Path: "/"}
+	l, err := parseIconLinks([]byte(`<!DOCTYPE html><html><head>
+<link rel="stylesheet" href="/style.css">
+<link rel="shortcut icon" href="/favicon.ico">
+<link rel="icon" type="image/png" href="icons/a.png" sizes="32x32">
+<link rel="icon" type="image/png" href="icons/b.png" sizes="16x16">
+<link rel="ICON" href="https://cdn.example.com/logo.svg">
+</head><body><link rel="icon" href="/body.svg"></body></html>`), base)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/logo.svg", l.Svg.Url)
+	assert.Equal(t, "https://example.com/icons/a.png", l.Png.Url)
+	assert.Equal(t, "https://example.com/favicon.ico", l.Ico.Url)
+
+	// relative to the base tag
+	l, err = parseIconLinks([]byte(`<html><head><base href="/app/"><link rel="icon" href="logo.png"></head></html>`), base)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/app/logo.png", l.Png.Url)
+
+	// unknown types and invalid urls are ignored
+	l, err = parseIconLinks([]byte(`<link rel="icon" type="image/gif" href="/a.gif"><link rel="icon" href="ftp://example.com/a.png"><link rel="apple-touch-icon" href="/b.png">`), base)
+	assert.NoError(t, err)
+	assert.Nil(t, l)
+}
+
+func TestFavicons_FindIcons(t *testing.T) {
+	getFaviconViaRequest = func(_ *http.Client, _ string) ([]byte, error) { return exampleSvg, nil }
+	pages := make(chan string, 4)
+	getPageViaRequest = func(_ *http.Client, u string) ([]byte, error) {
+		pages <- u
+		if u == "https://missing.example.com/" {
+			return nil, errors.New("not found")
+		}
+		return []byte(`<link rel="icon" href="/logo.svg">`), nil
+	}
+
+	db, err := sql.Open("sqlite3", "file:TestFavicons_FindIcons?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	favicons := New(db, Builtin)
+
+	// discovery is disabled by default
+	assert.Nil(t, favicons.FindIcons("example.com"))
+	assert.Len(t, pages, 0)
+
+	// unknown hosts are never discovered
+	favicons.SetDiscovery(time.Hour, func(host string) bool { return host != "unknown.example.com" })
+	assert.Never(t, func() bool { return favicons.FindIcons("unknown.example.com") != nil }, 50*time.Millisecond, 10*time.Millisecond)
+	assert.Len(t, pages, 0)
+
+	assert.Nil(t, favicons.FindIcons("example.com"))
+	assert.Eventually(t, func() bool { return favicons.FindIcons("example.com") != nil }, 5*time.Second, 10*time.Millisecond)
+	icons := favicons.FindIcons("example.com")
+	assert.Equal(t, "https://example.com/logo.svg", icons.Svg.Url)
+	assert.NotEmpty(t, icons.Png.Hash)
+	assert.Equal(t, "https://example.com/", <-pages)
+
+	// failed discoveries are cached
+	assert.Nil(t, favicons.FindIcons("missing.example.com"))
+	assert.Equal(t, "https://missing.example.com/", <-pages)
+	assert.Never(t, func() bool { return favicons.FindIcons("missing.example.com") != nil }, 50*time.Millisecond, 10*time.Millisecond)
+	assert.Len(t, pages, 0)
+}
+
+func TestDiscovery_Evict(t *testing.T) {
+	now := time.Now()
+	d := &discovery{entries: make(map[string]*discovered)}
+	for i := 0; i < maxDiscovered; i++ {
+		d.entries[fmt.Sprint(i)] = &discovered{expires: now.Add(time.Duration(i+1) * time.Minute)}
+	}
+	assert.True(t, d.evict(now))
+	assert.Len(t, d.entries, maxDiscovered-1)
+	assert.NotContains(t, d.entries, "0")
+
+	// expired entries are all removed
+	d.entries["a"] = &discovered{expires: now.Add(-time.Minute)}
+	d.entries["b"] = &discovered{expires: now.Add(-time.Minute)}
+	assert.True(t, d.evict(now))
+	assert.Len(t, d.entries, maxDiscovered-1)
+
+	// running discoveries are kept
+	for k := range d.entries {
+		d.entries[k] = &discovered{}
+	}
+	d.entries["c"] = &discovered{}
+	assert.False(t, d.evict(now))
+	assert.Len(t, d.entries, maxDiscovered)
+}
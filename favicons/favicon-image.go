@@ -1,10 +1,14 @@
 package favicons
 
-// FaviconImage stores the url, hash and raw bytes of an image
+// FaviconImage stores the url, hash and raw bytes of an image, the ETag and
+// Last-Modified headers of the download are used to check for changes
 type FaviconImage struct {
 	Url  string
 	Hash string
 	Raw  []byte
+
+	ETag         string
+	LastModified string
 }
 
 // CreateFaviconImage outputs a FaviconImage with the specified URL or nil if
@@ -1,22 +1,47 @@
 package favicons
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/MrMelon54/png2ico"
-	"image/png"
 	"io"
 	"net/http"
+	"path"
+	"time"
 )
 
 // FaviconList contains the ico, png and svg icons for separate favicons
 type FaviconList struct {
-	Ico *FaviconImage // can be generated from png with wrapper
-	Png *FaviconImage // can be generated from svg with inkscape
+	Ico *FaviconImage // can be generated from the sized png icons
+	Png *FaviconImage // can be generated from svg with inkscape or the built-in rasterizer
 	Svg *FaviconImage
+
+	// DarkSvg is shown when the browser prefers a dark color scheme, Adaptive
+	// is served as the svg and contains both svg icons switched by a media
+	// query
+	DarkSvg  *FaviconImage
+	Adaptive *FaviconImage
+
+	// square png icons for each size in IconSizes
+	Sized map[int]*FaviconImage
+
+	// png icons encoded with other formats, keyed by content type then by
+	// size, the png icon uses size zero
+	Encoded map[string]map[int]*FaviconImage
+	formats []string // content types in Encoded in order of preference
+
+	// Refresh is the interval between checking the sources for changes, zero
+	// disables refreshing
+	Refresh time.Duration
+	checked time.Time
+
+	// Name and ThemeColor are used in the generated site.webmanifest and
+	// browserconfig.xml
+	Name          string
+	ThemeColor    string
+	Manifest      *FaviconImage
+	BrowserConfig *FaviconImage
 }
 
 var ErrInvalidFaviconExtension = errors.New("invalid favicon extension")
@@ -40,6 +65,29 @@ func (l *FaviconList) ProduceForExt(ext string) (raw []byte, contentType string,
 	return
 }
 
+// ProduceForPath outputs the bytes and the HTTP Content-Type header for the
+// favicon or generated icon size served on the path.
+func (l *FaviconList) ProduceForPath(p string) (raw []byte, contentType string, err error) {
+	if size, ok := SizeForPath(p); ok {
+		raw, err = l.ProduceSized(size)
+		return raw, "image/png", err
+	}
+	switch p {
+	case "/favicon.ico", "/favicon.png", "/favicon.svg":
+		return l.ProduceForExt(path.Ext(p))
+	}
+	return nil, "", ErrInvalidFaviconExtension
+}
+
+// ProduceSized outputs the bytes of the square png icon with the size or an
+// error
+func (l *FaviconList) ProduceSized(size int) ([]byte, error) {
+	if l.Sized[size] == nil {
+		return nil, ErrFaviconNotFound
+	}
+	return l.Sized[size].Raw, nil
+}
+
 // ProduceIco outputs the bytes of the ico icon or an error
 func (l *FaviconList) ProduceIco() ([]byte, error) {
 	if l.Ico == nil {
@@ -64,60 +112,84 @@ func (l *FaviconList) ProduceSvg() ([]byte, error) {
 	return l.Svg.Raw, nil
 }
 
-// PreProcess takes an input of the svg2png conversion function and outputs
-// an error if the SVG, PNG or ICO fails to download or generate
-func (l *FaviconList) PreProcess(convert func(in []byte) ([]byte, error)) error {
+// PreProcess takes an input of the svg to png converter and the extra formats
+// and outputs an error if the SVG, PNG, ICO or sized icons fail to download or
+// generate
+func (l *FaviconList) PreProcess(conv Converter, formats ...Format) error {
+	return l.preProcess(http.DefaultClient, conv, formats...)
+}
+
+// preProcess downloads the icons using the client
+func (l *FaviconList) preProcess(client *http.Client, conv Converter, formats ...Format) error {
 	var err error
 
 	// SVG
-	if l.Svg != nil {
+	if l.Svg != nil && l.Svg.Raw == nil {
 		// download SVG
-		l.Svg.Raw, err = getFaviconViaRequest(l.Svg.Url)
+		l.Svg.Raw, err = fetchFavicon(client, l.Svg.Url)
 		if err != nil {
-			return fmt.Errorf("[Favicons] Failed to fetch SVG icon: %w", err)
+			return &SourceError{Icon: "svg", Url: l.Svg.Url, Err: fmt.Errorf("[Favicons] Failed to fetch SVG icon: %w", err)}
 		}
 		l.Svg.Hash = hex.EncodeToString(sha256.New().Sum(l.Svg.Raw))
 	}
 
+	// dark SVG
+	if l.DarkSvg != nil && l.DarkSvg.Raw == nil {
+		l.DarkSvg.Raw, err = fetchFavicon(client, l.DarkSvg.Url)
+		if err != nil {
+			return &SourceError{Icon: "dark_svg", Url: l.DarkSvg.Url, Err: fmt.Errorf("[Favicons] Failed to fetch dark SVG icon: %w", err)}
+		}
+	}
+
 	// PNG
-	if l.Png != nil {
+	if l.Png != nil && l.Png.Raw == nil {
 		// download PNG
-		l.Png.Raw, err = getFaviconViaRequest(l.Png.Url)
+		l.Png.Raw, err = fetchFavicon(client, l.Png.Url)
 		if err != nil {
-			return fmt.Errorf("[Favicons] Failed to fetch PNG icon: %w", err)
+			return &SourceError{Icon: "png", Url: l.Png.Url, Err: fmt.Errorf("[Favicons] Failed to fetch PNG icon: %w", err)}
 		}
-	} else if l.Svg != nil {
+	} else if l.Png == nil && l.Svg != nil {
 		// generate PNG from SVG
 		l.Png = &FaviconImage{}
-		l.Png.Raw, err = convert(l.Svg.Raw)
+		l.Png.Raw, err = conv.Convert(l.Svg.Raw)
 		if err != nil {
-			return fmt.Errorf("[Favicons] Failed to generate PNG icon: %w", err)
+			return &SourceError{Icon: "png", Err: fmt.Errorf("[Favicons] Failed to generate PNG icon: %w", err)}
 		}
 	}
 
+	// png icons at fixed sizes for browsers, apple touch and web app manifests
+	if err := l.generateSized(conv); err != nil {
+		return err
+	}
+
 	// ICO
-	if l.Ico != nil {
+	if l.Ico != nil && l.Ico.Raw == nil {
 		// download ICO
-		l.Ico.Raw, err = getFaviconViaRequest(l.Ico.Url)
+		l.Ico.Raw, err = fetchFavicon(client, l.Ico.Url)
 		if err != nil {
-			return fmt.Errorf("[Favicons] Failed to fetch ICO icon: %w", err)
+			return &SourceError{Icon: "ico", Url: l.Ico.Url, Err: fmt.Errorf("[Favicons] Failed to fetch ICO icon: %w", err)}
 		}
-	} else if l.Png != nil {
-		// generate ICO from PNG
+	} else if l.Ico == nil && len(l.Sized) > 0 {
+		// generate a multi-resolution ICO from the sized icons
 		l.Ico = &FaviconImage{}
-		decode, err := png.Decode(bytes.NewReader(l.Png.Raw))
-		if err != nil {
-			return fmt.Errorf("[Favicons] Failed to decode PNG icon: %w", err)
-		}
-		b := decode.Bounds()
-		l.Ico.Raw, err = png2ico.ConvertPngToIco(l.Png.Raw, b.Dx(), b.Dy())
+		l.Ico.Raw, err = buildIco(l.Sized, IcoSizes)
 		if err != nil {
-			return fmt.Errorf("[Favicons] Failed to generate ICO icon: %w", err)
+			return &SourceError{Icon: "ico", Err: fmt.Errorf("[Favicons] Failed to generate ICO icon: %w", err)}
 		}
 	}
 
-	// generate sha256 hashes for svg, png and ico
+	// optional renditions of the png icons
+	l.encodeFormats(formats)
+
+	// generate sha256 hashes for svg, png, ico, the sized and encoded icons
 	l.genSha256()
+	l.genAdaptive()
+
+	// companion files referencing the sized icons using their hashes
+	if err := l.genCompanions(); err != nil {
+		return err
+	}
+	l.checked = time.Now()
 	return nil
 }
 
@@ -132,17 +204,28 @@ func (l *FaviconList) genSha256() {
 	if l.Ico != nil {
 		l.Ico.Hash = genSha256(l.Ico.Raw)
 	}
+	if l.DarkSvg != nil {
+		l.DarkSvg.Hash = genSha256(l.DarkSvg.Raw)
+	}
+	for _, i := range l.Sized {
+		i.Hash = genSha256(i.Raw)
+	}
+	for _, m := range l.Encoded {
+		for _, i := range m {
+			i.Hash = genSha256(i.Raw)
+		}
+	}
 }
 
 // getFaviconViaRequest uses the standard http request library to download
 // icons, outputs the raw bytes from the download or an error.
-var getFaviconViaRequest = func(url string) ([]byte, error) {
+var getFaviconViaRequest = func(client *http.Client, url string) ([]byte, error) {
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("[Favicons] Failed to send request '%s': %w", url, err)
 	}
 	req.Header.Set("X-Violet-Raw-Favicon", "1")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("[Favicons] Failed to do request '%s': %w", url, err)
 	}
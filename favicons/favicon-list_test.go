@@ -4,17 +4,18 @@ import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
 	"image/png"
+	"net/http"
 	"testing"
 )
 
 func TestFaviconList_PreProcess(t *testing.T) {
-	getFaviconViaRequest = func(_ string) ([]byte, error) {
+	getFaviconViaRequest = func(_ *http.Client, _ string) ([]byte, error) {
 		return exampleSvg, nil
 	}
 	icons := &FaviconList{Svg: &FaviconImage{Url: "https://example.com/assets/logo.svg"}}
-	assert.NoError(t, icons.PreProcess(func(in []byte) ([]byte, error) {
-		return svg2png("inkscape", in)
-	}))
+	inkscape, err := NewConverter("inkscape", "", nil, 0, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, icons.PreProcess(inkscape))
 	assert.Equal(t, "https://example.com/assets/logo.svg", icons.Svg.Url)
 
 	assert.Equal(t, "74cdc17d0502a690941799c327d9ca1ed042e76c784def43a42937f2eed270b4", icons.Svg.Hash)
@@ -23,6 +24,6 @@ func TestFaviconList_PreProcess(t *testing.T) {
 
 	// verify png bytes are a valid png image
 	pngRaw := bytes.NewBuffer(icons.Png.Raw)
-	_, err := png.Decode(pngRaw)
+	_, err = png.Decode(pngRaw)
 	assert.NoError(t, err)
 }
@@ -8,7 +8,12 @@ import (
 	"github.com/MrMelon54/rescheduler"
 	"golang.org/x/sync/errgroup"
 	"log"
+	"net/http"
+	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var ErrFaviconNotFound = errors.New("favicon not found")
@@ -19,20 +24,36 @@ var createTableFavicons string
 // Favicons is a dynamic favicon generator which supports overwriting favicons
 type Favicons struct {
 	db         *sql.DB
-	cmd        string
+	conv       Converter
+	formats    []Format
 	cLock      *sync.RWMutex
 	faviconMap map[string]*FaviconList
 	r          *rescheduler.Rescheduler
+	discover   *discovery
+	cache      *artifactCache
+	settings   SettingsProvider
+	client     *http.Client // downloads the configured favicon sources
+	discClient *http.Client // downloads the discovered pages and icons
+	fallback   atomic.Pointer[FaviconRecord]
+	errors     CompileErrors
+	workers    atomic.Int32
 }
 
-// New creates a new dynamic favicon generator
-func New(db *sql.DB, inkscapeCmd string) *Favicons {
+// New creates a new dynamic favicon generator, the converter generates png
+// icons from svg icons and nil uses the built-in rasterizer. The png icons are
+// also encoded with each format.
+func New(db *sql.DB, conv Converter, formats ...Format) *Favicons {
+	if conv == nil {
+		conv = Builtin
+	}
 	f := &Favicons{
 		db:         db,
-		cmd:        inkscapeCmd,
+		conv:       conv,
+		formats:    formats,
 		cLock:      &sync.RWMutex{},
 		faviconMap: make(map[string]*FaviconList),
 	}
+	f.SetConcurrency(0)
 	f.r = rescheduler.NewRescheduler(f.threadCompile)
 
 	// init favicons table
@@ -41,21 +62,38 @@ func New(db *sql.DB, inkscapeCmd string) *Favicons {
 		log.Printf("[WARN] Failed to generate 'favicons' table\n")
 		return nil
 	}
+	if err := addMissingColumns(db); err != nil {
+		log.Printf("[WARN] Failed to migrate 'favicons' table: %s\n", err)
+		return nil
+	}
+
+	// init favicon artifacts table and cache the converted images
+	_, err = f.db.Exec(createTableFaviconArtifacts)
+	if err != nil {
+		log.Printf("[WARN] Failed to generate 'favicon_artifacts' table\n")
+		return nil
+	}
+	f.cache = &artifactCache{db: db}
+	f.conv = newCachedConverter(f.cache, conv)
+	f.formats = make([]Format, len(formats))
+	for i, format := range formats {
+		f.formats[i] = Format{ContentType: format.ContentType, Enc: newCachedConverter(f.cache, format.Enc)}
+	}
 
 	// run compile to get the initial data
 	f.Compile()
 	return f
 }
 
-// GetIcons returns the favicon list for the provided host or nil if no
-// icon is found or generated
+// GetIcons returns the favicon list for the provided host or a wildcard row
+// matching the host, nil is returned if no icon is found or generated
 func (f *Favicons) GetIcons(host string) *FaviconList {
 	// read lock for safety
 	f.cLock.RLock()
 	defer f.cLock.RUnlock()
 
 	// return value from map
-	return f.faviconMap[host]
+	return f.lookup(host, "")
 }
 
 // Compile downloads the list of favicon mappings from the database and loads
@@ -67,40 +105,88 @@ func (f *Favicons) Compile() {
 	f.r.Run()
 }
 
+// SetConcurrency limits the number of favicons generated at the same time
+// while compiling, zero or less uses the number of CPUs.
+func (f *Favicons) SetConcurrency(n int) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	f.workers.Store(int32(n))
+}
+
 func (f *Favicons) threadCompile() {
+	if err := f.CompileSync(); err != nil {
+		// log compile errors
+		log.Printf("[Favicons] Compile failed: %s\n", err)
+	}
+}
+
+// CompileSync loads the favicons and waits for the compile to finish. The
+// overrides which fail to compile keep their previous icons and are returned
+// as CompileErrors.
+func (f *Favicons) CompileSync() error {
 	// new map
 	favicons := make(map[string]*FaviconList)
 
 	// compile map and check errors
-	err := f.internalCompile(favicons)
+	failed, err := f.internalCompile(favicons)
 	if err != nil {
-		// log compile errors
-		log.Printf("[Favicons] Compile failed: %s\n", err)
-		return
+		return err
 	}
 
 	// lock while replacing the map
 	f.cLock.Lock()
+	for _, i := range failed {
+		if l, ok := f.faviconMap[iconKey(i.Host, i.Path)]; ok {
+			favicons[iconKey(i.Host, i.Path)] = l
+		}
+	}
 	f.faviconMap = favicons
+	f.errors = failed
 	f.cLock.Unlock()
+
+	// remove artifacts of sources which are no longer used
+	if err := f.cache.prune(); err != nil {
+		log.Printf("[Favicons] Failed to prune cached artifacts: %s\n", err)
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
 }
 
 // internalCompile is a hidden internal method for loading and generating all
-// favicons.
-func (f *Favicons) internalCompile(m map[string]*FaviconList) error {
+// favicons. The overrides which fail to generate are left out of the map and
+// returned, the error is only used for database failures.
+func (f *Favicons) internalCompile(m map[string]*FaviconList) (CompileErrors, error) {
 	// query all rows in database
-	query, err := f.db.Query(`select host, svg, png, ico from favicons`)
+	query, err := f.db.Query(`select host, path, svg, png, ico, refresh, name, theme_color, dark_svg from favicons`)
 	if err != nil {
-		return fmt.Errorf("failed to prepare query: %w", err)
+		return nil, fmt.Errorf("failed to prepare query: %w", err)
+	}
+
+	// record the overrides which fail to generate
+	var failLock sync.Mutex
+	failed := make(CompileErrors, 0)
+	fail := func(host, prefix string, err error) {
+		failLock.Lock()
+		failed = append(failed, newEntryError(host, prefix, err))
+		failLock.Unlock()
 	}
 
-	// loop over rows and scan in data using error group to catch errors
+	// loop over rows and scan in data using error group to wait for the
+	// pre-process of each row, starting a pre-process waits while the limit
+	// is reached
+	client := f.httpClient()
 	var g errgroup.Group
+	g.SetLimit(int(f.workers.Load()))
 	for query.Next() {
-		var host, rawSvg, rawPng, rawIco string
-		err := query.Scan(&host, &rawSvg, &rawPng, &rawIco)
+		var host, prefix, rawSvg, rawPng, rawIco, name, themeColor, rawDarkSvg string
+		var refresh int64
+		err := query.Scan(&host, &prefix, &rawSvg, &rawPng, &rawIco, &refresh, &name, &themeColor, &rawDarkSvg)
 		if err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
+			_ = g.Wait()
+			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 
 		// create favicon list for this row
@@ -108,20 +194,57 @@ func (f *Favicons) internalCompile(m map[string]*FaviconList) error {
 			Ico: CreateFaviconImage(rawIco),
 			Png: CreateFaviconImage(rawPng),
 			Svg: CreateFaviconImage(rawSvg),
+
+			DarkSvg: CreateFaviconImage(rawDarkSvg),
+
+			Refresh: time.Duration(refresh) * time.Second,
+
+			Name:       name,
+			ThemeColor: themeColor,
 		}
 
 		// save the favicon list to the map
-		m[host] = l
+		m[iconKey(host, prefix)] = l
 
 		// run the pre-process in a separate goroutine
 		g.Go(func() error {
-			return l.PreProcess(f.convertSvgToPng)
+			if err := l.preProcess(client, f.conv, f.formats...); err != nil {
+				fail(host, prefix, err)
+			}
+			return nil
 		})
 	}
-	return g.Wait()
-}
 
-// convertSvgToPng calls svg2png which runs inkscape in a subprocess
-func (f *Favicons) convertSvgToPng(in []byte) ([]byte, error) {
-	return svg2png(f.cmd, in)
+	// the default favicon uses an empty host which never matches a request
+	if r := f.fallback.Load(); r != nil {
+		l := &FaviconList{
+			Ico: CreateFaviconImage(r.Ico),
+			Png: CreateFaviconImage(r.Png),
+			Svg: CreateFaviconImage(r.Svg),
+
+			DarkSvg:    CreateFaviconImage(r.DarkSvg),
+			Name:       r.Name,
+			ThemeColor: r.ThemeColor,
+		}
+		m[defaultKey] = l
+		g.Go(func() error {
+			if err := l.preProcess(client, f.conv, f.formats...); err != nil {
+				fail("", "", fmt.Errorf("default favicon: %w", err))
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	// remove the failed overrides and sort them for reporting
+	for _, i := range failed {
+		delete(m, iconKey(i.Host, i.Path))
+	}
+	sort.Slice(failed, func(i, j int) bool {
+		if failed[i].Host != failed[j].Host {
+			return failed[i].Host < failed[j].Host
+		}
+		return failed[i].Path < failed[j].Path
+	})
+	return failed, nil
 }
@@ -4,10 +4,15 @@ import (
 	"bytes"
 	"database/sql"
 	_ "embed"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 	"image/png"
+	"net/http"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 )
 
 var (
@@ -20,16 +25,20 @@ var (
 )
 
 func TestFaviconsNew(t *testing.T) {
-	getFaviconViaRequest = func(_ string) ([]byte, error) { return exampleSvg, nil }
+	getFaviconViaRequest = func(_ *http.Client, _ string) ([]byte, error) { return exampleSvg, nil }
 
 	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
 	assert.NoError(t, err)
 
-	favicons := New(db, "inkscape")
+	inkscape, err := NewConverter("inkscape", "", nil, 0, 0)
+	assert.NoError(t, err)
+	favicons := New(db, inkscape)
 	_, err = db.Exec("insert into favicons (host, svg, png, ico) values (?, ?, ?, ?)", "example.com", "https://example.com/assets/logo.svg", "", "")
 	assert.NoError(t, err)
 	favicons.cLock.Lock()
-	assert.NoError(t, favicons.internalCompile(favicons.faviconMap))
+	failed, err := favicons.internalCompile(favicons.faviconMap)
+	assert.NoError(t, err)
+	assert.Empty(t, failed)
 	favicons.cLock.Unlock()
 
 	icons := favicons.GetIcons("example.com")
@@ -44,3 +53,43 @@ func TestFaviconsNew(t *testing.T) {
 	_, err = png.Decode(pngRaw)
 	assert.NoError(t, err)
 }
+
+// busyConverter records the most conversions running at the same time
+type busyConverter struct {
+	lock         sync.Mutex
+	running, max int
+}
+
+func (b *busyConverter) Convert(in []byte) ([]byte, error) {
+	b.lock.Lock()
+	b.running++
+	if b.running > b.max {
+		b.max = b.running
+	}
+	b.lock.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	b.lock.Lock()
+	b.running--
+	b.lock.Unlock()
+	return Builtin.Convert(in)
+}
+
+func TestFavicons_SetConcurrency(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestFavicons_SetConcurrency?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	conv := &busyConverter{}
+	f := New(db, conv)
+	f.r.Wait()
+	f.SetConcurrency(2)
+	for i := 0; i < 8; i++ {
+		// unique sources so the artifact cache isn't used
+		svg := fmt.Sprintf(`<svg width="%d" height="10" xmlns="http://www.w3.org/2000/svg"><!-- %d --></svg>`, i+1, time.Now().UnixNano())
+		assert.NoError(t, f.Put(FaviconRecord{Host: fmt.Sprintf("%d.example.com", i), Svg: DataUrl("image/svg+xml", []byte(svg))}))
+	}
+	assert.NoError(t, f.CompileSync())
+	assert.Equal(t, 2, conv.max)
+	assert.NotNil(t, f.GetIcons("7.example.com"))
+
+	f.SetConcurrency(0)
+	assert.Equal(t, int32(runtime.NumCPU()), f.workers.Load())
+}
@@ -0,0 +1,163 @@
+package favicons
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is an extra encoding of the png icons which is served to browsers
+// accepting the content type, Enc converts png image bytes
+type Format struct {
+	ContentType string
+	Enc         Converter
+}
+
+var ErrUnknownFormat = errors.New("unknown favicon format")
+
+// formatBackends contains the content type and the default program and
+// arguments of each supported format, the programs read the png from stdin and
+// write to stdout
+var formatBackends = map[string]struct {
+	contentType string
+	path        string
+	args        []string
+}{
+	"webp": {"image/webp", "cwebp", []string{"-quiet", "-o", "-", "--", "-"}},
+	"avif": {"image/avif", "magick", []string{"png:-", "avif:-"}},
+}
+
+// NewFormat creates the encoder for the named format, an empty path or nil args
+// use the defaults for the format and a zero timeout uses the default. The
+// encoder reads png icons up to the output limit of the svg converters.
+func NewFormat(kind, path string, args []string, timeout time.Duration) (Format, error) {
+	backend, ok := formatBackends[kind]
+	if !ok {
+		return Format{}, fmt.Errorf("%w: %s", ErrUnknownFormat, kind)
+	}
+	if path == "" {
+		path = backend.path
+	}
+	if args == nil {
+		args = backend.args
+	}
+	if timeout <= 0 {
+		timeout = defaultConvertTimeout
+	}
+	return Format{
+		ContentType: backend.contentType,
+		Enc: &CommandConverter{
+			Name:    kind,
+			Path:    path,
+			Args:    args,
+			Timeout: timeout,
+			Limits:  Limits{MaxInput: defaultMaxOutput, MaxOutput: defaultMaxOutput},
+		},
+	}, nil
+}
+
+// encodeFormats encodes the png icon and the sized icons with each format, a
+// format is skipped if any icon fails to encode so the png icons are used
+func (l *FaviconList) encodeFormats(formats []Format) {
+	if l.Png == nil || len(formats) == 0 {
+		return
+	}
+	l.Encoded = make(map[string]map[int]*FaviconImage)
+	l.formats = nil
+	for _, f := range formats {
+		m, err := l.encodeFormat(f.Enc)
+		if err != nil {
+			log.Printf("[Favicons] Failed to encode '%s' icons: %s\n", f.ContentType, err)
+			continue
+		}
+		l.Encoded[f.ContentType] = m
+		l.formats = append(l.formats, f.ContentType)
+	}
+}
+
+func (l *FaviconList) encodeFormat(enc Converter) (map[int]*FaviconImage, error) {
+	raw, err := enc.Convert(l.Png.Raw)
+	if err != nil {
+		return nil, err
+	}
+	m := map[int]*FaviconImage{0: {Raw: raw}}
+	for size, img := range l.Sized {
+		raw, err := enc.Convert(img.Raw)
+		if err != nil {
+			return nil, err
+		}
+		m[size] = &FaviconImage{Raw: raw}
+	}
+	return m, nil
+}
+
+// ProduceForRequest outputs the image and the HTTP Content-Type header for the
+// icon served on the path, png icons are replaced with the first encoded format
+// listed in the Accept header.
+func (l *FaviconList) ProduceForRequest(p, accept string) (img *FaviconImage, contentType string, err error) {
+	size, ok := SizeForPath(p)
+	if ok || p == "/favicon.png" {
+		for _, t := range l.formats {
+			if img := l.Encoded[t][size]; img != nil && acceptsType(accept, t) {
+				return img, t, nil
+			}
+		}
+	}
+	return l.imageForPath(p)
+}
+
+// imageForPath returns the image and content type of the favicon or generated
+// icon size served on the path
+func (l *FaviconList) imageForPath(p string) (img *FaviconImage, contentType string, err error) {
+	if size, ok := SizeForPath(p); ok {
+		img, contentType = l.Sized[size], "image/png"
+	} else {
+		switch p {
+		case "/favicon.ico":
+			img, contentType = l.Ico, "image/x-icon"
+		case "/favicon.png":
+			img, contentType = l.Png, "image/png"
+		case "/favicon.svg":
+			img, contentType = l.Svg, "image/svg+xml"
+			if l.Adaptive != nil {
+				img = l.Adaptive
+			}
+		case "/favicon-dark.svg":
+			img, contentType = l.DarkSvg, "image/svg+xml"
+		case "/site.webmanifest":
+			img, contentType = l.Manifest, "application/manifest+json"
+		case "/browserconfig.xml":
+			img, contentType = l.BrowserConfig, "application/xml"
+		default:
+			return nil, "", ErrInvalidFaviconExtension
+		}
+	}
+	if img == nil {
+		return nil, "", ErrFaviconNotFound
+	}
+	return img, contentType, nil
+}
+
+// acceptsType returns true if the Accept header lists the content type with a
+// non-zero quality, wildcards are ignored as every browser accepts png
+func acceptsType(accept, contentType string) bool {
+	for _, i := range strings.Split(accept, ",") {
+		params := strings.Split(i, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), contentType) {
+			continue
+		}
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if k == "q" {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package favicons
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+// prefixEncoder adds the prefix to the png bytes instead of encoding them
+type prefixEncoder string
+
+func (p prefixEncoder) Convert(in []byte) ([]byte, error) {
+	if p == "" {
+		return nil, errors.New("failed")
+	}
+	return append([]byte(p), in...), nil
+}
+
+func TestNewFormat(t *testing.T) {
+	f, err := NewFormat("webp", "", nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/webp", f.ContentType)
+	assert.Equal(t, &CommandConverter{Name: "webp", Path: "cwebp", Args: []string{"-quiet", "-o", "-", "--", "-"}, Timeout: defaultConvertTimeout, Limits: Limits{MaxInput: defaultMaxOutput, MaxOutput: defaultMaxOutput}}, f.Enc)
+
+	f, err = NewFormat("avif", "/usr/local/bin/magick", nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/avif", f.ContentType)
+	assert.Equal(t, "/usr/local/bin/magick", f.Enc.(*CommandConverter).Path)
+
+	_, err = NewFormat("jxl", "", nil, 0)
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestFaviconList_ProduceForRequest(t *testing.T) {
+	getFaviconViaRequest = func(_ *http.Client, _ string) ([]byte, error) {
+		return exampleSvg, nil
+	}
+	icons := &FaviconList{Svg: &FaviconImage{Url: "https://example.com/assets/logo.svg"}}
+	assert.NoError(t, icons.PreProcess(Builtin,
+		Format{ContentType: "image/avif", Enc: prefixEncoder("")},
+		Format{ContentType: "image/webp", Enc: prefixEncoder("webp")},
+	))
+
+	// the failed format is skipped
+	assert.Equal(t, []string{"image/webp"}, icons.formats)
+	assert.NotEmpty(t, icons.Encoded["image/webp"][32].Hash)
+
+	chrome := "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"
+	img, contentType, err := icons.ProduceForRequest("/favicon.png", chrome)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/webp", contentType)
+	assert.Equal(t, append([]byte("webp"), icons.Png.Raw...), img.Raw)
+
+	img, contentType, err = icons.ProduceForRequest("/favicon-32x32.png", chrome)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/webp", contentType)
+	assert.Equal(t, append([]byte("webp"), icons.Sized[32].Raw...), img.Raw)
+
+	// fallback to png
+	img, contentType, err = icons.ProduceForRequest("/favicon.png", "image/webp;q=0, image/*")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+	assert.Same(t, icons.Png, img)
+
+	// other icons are unchanged
+	_, contentType, err = icons.ProduceForRequest("/favicon.ico", chrome)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/x-icon", contentType)
+	_, _, err = icons.ProduceForRequest("/favicon.gif", chrome)
+	assert.ErrorIs(t, err, ErrInvalidFaviconExtension)
+}
+
+func TestAcceptsType(t *testing.T) {
+	assert.True(t, acceptsType("image/webp", "image/webp"))
+	assert.True(t, acceptsType("text/html, IMAGE/WEBP;q=0.5", "image/webp"))
+	assert.False(t, acceptsType("image/webp;q=0", "image/webp"))
+	assert.False(t, acceptsType("image/*,*/*", "image/webp"))
+	assert.False(t, acceptsType("", "image/avif"))
+}
@@ -0,0 +1,42 @@
+package favicons
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// IcoSizes are the images included in generated ICO files
+var IcoSizes = []int{16, 32, 48}
+
+// buildIco creates an ICO container holding the png image of each size, png
+// images are supported in ICO files since Windows Vista
+func buildIco(images map[int]*FaviconImage, sizes []int) ([]byte, error) {
+	const headerLen, entryLen = 6, 16
+
+	var out bytes.Buffer
+	_ = binary.Write(&out, binary.LittleEndian, [3]uint16{0, 1, uint16(len(sizes))})
+	offset := uint32(headerLen + entryLen*len(sizes))
+	for _, size := range sizes {
+		img := images[size]
+		if img == nil {
+			return nil, fmt.Errorf("missing %dx%d image", size, size)
+		}
+		if size < 1 || size > 256 {
+			return nil, fmt.Errorf("invalid ICO image size: %d", size)
+		}
+
+		// a width and height of 0 means 256 pixels
+		dim := uint8(size % 256)
+		_ = binary.Write(&out, binary.LittleEndian, struct {
+			Width, Height, Colors, Reserved uint8
+			Planes, BitCount                uint16
+			Size, Offset                    uint32
+		}{dim, dim, 0, 0, 1, 32, uint32(len(img.Raw)), offset})
+		offset += uint32(len(img.Raw))
+	}
+	for _, size := range sizes {
+		out.Write(images[size].Raw)
+	}
+	return out.Bytes(), nil
+}
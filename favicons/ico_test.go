@@ -0,0 +1,30 @@
+package favicons
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBuildIco(t *testing.T) {
+	images := map[int]*FaviconImage{16: {Raw: []byte("sixteen")}, 256: {Raw: []byte("big")}}
+	raw, err := buildIco(images, []int{16, 256})
+	assert.NoError(t, err)
+
+	// header
+	assert.Equal(t, []byte{0, 0, 1, 0, 2, 0}, raw[:6])
+
+	// entries
+	assert.Equal(t, []byte{16, 16, 0, 0, 1, 0, 32, 0}, raw[6:14])
+	assert.Equal(t, uint32(7), binary.LittleEndian.Uint32(raw[14:]))
+	assert.Equal(t, uint32(38), binary.LittleEndian.Uint32(raw[18:]))
+	assert.Equal(t, []byte{0, 0, 0, 0, 1, 0, 32, 0}, raw[22:30])
+	assert.Equal(t, uint32(3), binary.LittleEndian.Uint32(raw[30:]))
+	assert.Equal(t, uint32(45), binary.LittleEndian.Uint32(raw[34:]))
+
+	// images
+	assert.Equal(t, "sixteenbig", string(raw[38:]))
+
+	_, err = buildIco(images, []int{32})
+	assert.EqualError(t, err, "missing 32x32 image")
+}
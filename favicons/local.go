@@ -0,0 +1,184 @@
+package favicons
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	// localFetchTimeout stops downloads from hosts served by violet which
+	// never finish
+	localFetchTimeout = 30 * time.Second
+	// maxLocalFetch limits the response buffered from hosts served by violet
+	maxLocalFetch = 16 << 20
+)
+
+// publicClient only connects to public addresses, the discovered pages and the
+// icon links found in them come from remote html so they must not reach
+// services on the private network
+var publicClient = &http.Client{Transport: publicTransport}
+
+var publicTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkPublicAddr,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+// checkPublicAddr runs after the host is resolved so the check can't be
+// skipped by a dns record pointing at a private address
+func checkPublicAddr(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(ip) {
+		return fmt.Errorf("address '%s' is not public", ip)
+	}
+	return nil
+}
+
+// nonPublicRanges are the ranges missed by the netip checks, "this network"
+// reaches the local host on linux and the carrier grade nat range is private
+var nonPublicRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// isPublicAddr returns false for loopback, private, link-local, multicast and
+// unspecified addresses
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, i := range nonPublicRanges {
+		if i.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetRouter makes favicon downloads from hosts served by violet use the
+// handler instead of connecting to the public address, so icons of internal
+// only services and unix socket backends can be used. The served function
+// returns true for hosts which violet serves, other hosts found by discovery
+// must have a public address.
+func (f *Favicons) SetRouter(handler http.Handler, served func(host string) bool) {
+	f.cLock.Lock()
+	defer f.cLock.Unlock()
+	f.client = &http.Client{Transport: &localTransport{handler: handler, served: served, next: http.DefaultTransport}}
+	f.discClient = &http.Client{Transport: &localTransport{handler: handler, served: served, next: publicTransport}}
+}
+
+// httpClient returns the client which downloads the configured favicon
+// sources, the default client is used until the router is set
+func (f *Favicons) httpClient() *http.Client {
+	f.cLock.RLock()
+	defer f.cLock.RUnlock()
+	if f.client != nil {
+		return f.client
+	}
+	return http.DefaultClient
+}
+
+// discoverClient returns the client which downloads discovery pages and the
+// icons found in them, the public client is used until the router is set
+func (f *Favicons) discoverClient() *http.Client {
+	f.cLock.RLock()
+	defer f.cLock.RUnlock()
+	if f.discClient != nil {
+		return f.discClient
+	}
+	return publicClient
+}
+
+// localTransport serves requests for hosts violet serves using the handler and
+// sends other requests using the next transport
+type localTransport struct {
+	handler http.Handler
+	served  func(host string) bool
+	next    http.RoundTripper
+}
+
+func (t *localTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.served(req.URL.Hostname()) {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), localFetchTimeout)
+	defer cancel()
+
+	// the handler expects a request received by the server
+	in := req.Clone(ctx)
+	in.Host = req.URL.Host
+	in.URL = &url.URL{Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}
+	in.RequestURI = req.URL.RequestURI()
+	in.RemoteAddr = "127.0.0.1:0"
+	if in.Body == nil {
+		in.Body = http.NoBody
+	}
+	if req.URL.Scheme == "https" {
+		in.TLS = &tls.ConnectionState{HandshakeComplete: true, ServerName: req.URL.Hostname()}
+	}
+
+	rw := &responseBuffer{header: make(http.Header), body: limitWriter{max: maxLocalFetch, cancel: cancel}}
+	t.handler.ServeHTTP(rw, in)
+	if rw.body.exceeded {
+		return nil, fmt.Errorf("response from '%s' is too large", req.URL.Host)
+	}
+	if rw.code == 0 {
+		rw.code = http.StatusOK
+	}
+	raw := rw.body.buf.Bytes()
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rw.code, http.StatusText(rw.code)),
+		StatusCode:    rw.code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rw.header,
+		Body:          io.NopCloser(bytes.NewReader(raw)),
+		ContentLength: int64(len(raw)),
+		Request:       req,
+	}, nil
+}
+
+// responseBuffer stores the response written by the handler
+type responseBuffer struct {
+	header http.Header
+	code   int
+	body   limitWriter
+}
+
+func (r *responseBuffer) Header() http.Header { return r.header }
+
+func (r *responseBuffer) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *responseBuffer) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
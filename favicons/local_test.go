@@ -0,0 +1,90 @@
+package favicons
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestLocalTransport(t *testing.T) {
+	var got *http.Request
+	client := &http.Client{Transport: &localTransport{
+		handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			got = req
+			if req.URL.Path == "/big" {
+				_, _ = rw.Write(make([]byte, maxLocalFetch+1))
+				return
+			}
+			rw.Header().Set("Content-Type", "image/svg+xml")
+			_, _ = rw.Write(exampleSvg)
+		}),
+		served: func(host string) bool { return host == "internal.example.com" },
+		next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("public request")
+		}),
+	}}
+
+	resp, err := client.Get("https://internal.example.com:8443/assets/logo.svg?v=1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/svg+xml", resp.Header.Get("Content-Type"))
+	assert.Equal(t, int64(len(exampleSvg)), resp.ContentLength)
+	assert.Equal(t, "internal.example.com:8443", got.Host)
+	assert.Equal(t, "/assets/logo.svg?v=1", got.RequestURI)
+	assert.Equal(t, "/assets/logo.svg", got.URL.Path)
+	assert.Equal(t, "", got.URL.Host)
+	assert.NotNil(t, got.TLS)
+
+	_, err = client.Get("https://internal.example.com/big")
+	assert.ErrorContains(t, err, "too large")
+
+	_, err = client.Get("https://example.com/logo.svg")
+	assert.ErrorContains(t, err, "public request")
+}
+
+func TestFavicons_SetRouter(t *testing.T) {
+	f := &Favicons{cLock: &sync.RWMutex{}}
+	assert.Same(t, http.DefaultClient, f.httpClient())
+	assert.Same(t, publicClient, f.discoverClient())
+	f.SetRouter(http.NotFoundHandler(), func(host string) bool { return host == "internal.example.com" })
+
+	resp, err := f.httpClient().Get("http://internal.example.com/favicon.svg")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, err = f.discoverClient().Get("http://internal.example.com/favicon.svg")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// configured favicons can use private addresses but discovered icons can't
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	resp, err = f.httpClient().Get(srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_, err = f.discoverClient().Get(srv.URL)
+	assert.ErrorContains(t, err, "is not public")
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	for _, i := range []string{"1.1.1.1", "93.184.216.34", "2606:4700:4700::1111"} {
+		assert.True(t, isPublicAddr(netip.MustParseAddr(i)), i)
+	}
+	for _, i := range []string{"127.0.0.1", "::1", "10.0.0.1", "172.16.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "fd00::1", "0.0.0.0", "0.1.2.3", "100.64.0.1", "::ffff:127.0.0.1", "224.0.0.1", "::"} {
+		assert.False(t, isPublicAddr(netip.MustParseAddr(i)), i)
+	}
+}
+
+func TestPublicClient(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	_, err := publicClient.Get(srv.URL)
+	assert.ErrorContains(t, err, "is not public")
+}
@@ -0,0 +1,134 @@
+package favicons
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Put replaces the favicon sources of the host, ErrInvalidRecord is returned
+// if the host is missing, a source isn't a http, https or data url or a data
+// url doesn't contain an image of the matching format.
+func (f *Favicons) Put(r FaviconRecord) error {
+	if r.Host == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidRecord)
+	}
+	if err := checkSources(r); err != nil {
+		return err
+	}
+
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := f.ImportTx(tx, []FaviconRecord{r}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get returns the favicon sources of the host and path prefix, fs.ErrNotExist
+// is returned if there are no favicons.
+func (f *Favicons) Get(host, prefix string) (FaviconRecord, error) {
+	r := FaviconRecord{Host: host, Path: prefix}
+	err := f.db.QueryRow(`SELECT svg, png, ico, refresh, name, theme_color, dark_svg FROM favicons WHERE host = ? AND path = ? ORDER BY id DESC LIMIT 1`, host, prefix).Scan(&r.Svg, &r.Png, &r.Ico, &r.Refresh, &r.Name, &r.ThemeColor, &r.DarkSvg)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, fs.ErrNotExist
+	}
+	return r, err
+}
+
+// Delete removes the favicon sources of the host and path prefix,
+// fs.ErrNotExist is returned if there are no favicons.
+func (f *Favicons) Delete(host, prefix string) error {
+	res, err := f.db.Exec(`DELETE FROM favicons WHERE host = ? AND path = ?`, host, prefix)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// checkSources returns ErrInvalidRecord if a source isn't a http, https or data
+// url or a data url doesn't contain an image of the matching format
+func checkSources(r FaviconRecord) error {
+	for _, i := range []struct{ src, format string }{{r.Svg, "svg"}, {r.Png, "png"}, {r.Ico, "ico"}, {r.DarkSvg, "svg"}} {
+		if i.src == "" {
+			continue
+		}
+		if !validSourceUrl(i.src) {
+			return fmt.Errorf("%w: invalid url '%s'", ErrInvalidRecord, i.src)
+		}
+		if strings.HasPrefix(i.src, "data:") {
+			raw, _ := decodeDataUrl(i.src)
+			if err := CheckImage(i.format, raw); err != nil {
+				return fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckImage returns an error if the raw bytes don't look like an image of the
+// format, which is svg, png or ico
+func CheckImage(format string, raw []byte) error {
+	ok := false
+	switch format {
+	case "svg":
+		ok = isSvgDocument(raw)
+	case "png":
+		ok = bytes.HasPrefix(raw, []byte("\x89PNG\r\n\x1a\n"))
+	case "ico":
+		ok = bytes.HasPrefix(raw, []byte{0, 0, 1, 0})
+	}
+	if !ok {
+		return fmt.Errorf("uploaded %s icon is not a valid %s image", format, format)
+	}
+	return nil
+}
+
+// DataUrl encodes an uploaded icon as a data url which is stored in place of a
+// download url
+func DataUrl(contentType string, raw []byte) string {
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(raw)
+}
+
+// validSourceUrl returns true for http, https and base64 data urls
+func validSourceUrl(s string) bool {
+	if strings.HasPrefix(s, "data:") {
+		_, err := decodeDataUrl(s)
+		return err == nil
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// decodeDataUrl returns the bytes of a base64 data url
+func decodeDataUrl(s string) ([]byte, error) {
+	meta, data, ok := strings.Cut(strings.TrimPrefix(s, "data:"), ",")
+	if !ok || !strings.HasSuffix(meta, ";base64") {
+		return nil, fmt.Errorf("[Favicons] Invalid data url")
+	}
+	return base64.StdEncoding.DecodeString(data)
+}
+
+// fetchFavicon returns the bytes of an uploaded icon or downloads the icon
+func fetchFavicon(client *http.Client, s string) ([]byte, error) {
+	if strings.HasPrefix(s, "data:") {
+		return decodeDataUrl(s)
+	}
+	return getFaviconViaRequest(client, s)
+}
@@ -0,0 +1,50 @@
+package favicons
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"testing"
+)
+
+func TestFavicons_Put(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestFavicons_Put?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	f := New(db, nil)
+
+	assert.ErrorIs(t, f.Put(FaviconRecord{Svg: "https://example.com/logo.svg"}), ErrInvalidRecord)
+	assert.ErrorIs(t, f.Put(FaviconRecord{Host: "example.com", Svg: "ftp://example.com/logo.svg"}), ErrInvalidRecord)
+	assert.ErrorIs(t, f.Put(FaviconRecord{Host: "example.com", Png: "data:image/png;base64,!!"}), ErrInvalidRecord)
+	assert.ErrorIs(t, f.Put(FaviconRecord{Host: "example.com", Png: DataUrl("image/png", exampleSvg)}), ErrInvalidRecord)
+	assert.ErrorIs(t, f.Put(FaviconRecord{Host: "example.com", Ico: DataUrl("image/x-icon", examplePng)}), ErrInvalidRecord)
+
+	_, err = f.Get("example.com", "")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	assert.NoError(t, f.Put(FaviconRecord{Host: "example.com", Svg: "https://example.com/logo.svg"}))
+	data := DataUrl("image/png", examplePng)
+	assert.NoError(t, f.Put(FaviconRecord{Host: "example.com", Png: data}))
+	r, err := f.Get("example.com", "")
+	assert.NoError(t, err)
+	assert.Equal(t, FaviconRecord{Host: "example.com", Png: data}, r)
+
+	assert.NoError(t, f.Delete("example.com", ""))
+	assert.ErrorIs(t, f.Delete("example.com", ""), fs.ErrNotExist)
+}
+
+func TestCheckImage(t *testing.T) {
+	assert.NoError(t, CheckImage("svg", exampleSvg))
+	assert.NoError(t, CheckImage("png", examplePng))
+	assert.NoError(t, CheckImage("ico", exampleIco))
+	assert.EqualError(t, CheckImage("png", exampleIco), "uploaded png icon is not a valid png image")
+	assert.Error(t, CheckImage("svg", examplePng))
+	assert.Error(t, CheckImage("svg", []byte(`<html><svg/></html>`)))
+	assert.Error(t, CheckImage("gif", exampleSvg))
+}
+
+func TestFetchFavicon(t *testing.T) {
+	raw, err := fetchFavicon(nil, DataUrl("image/x-icon", exampleIco))
+	assert.NoError(t, err)
+	assert.Equal(t, exampleIco, raw)
+}
@@ -0,0 +1,83 @@
+package favicons
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// themeColorPattern matches the hex colors allowed in browserconfig.xml
+var themeColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// validThemeColor returns true for empty or hex theme colors
+func validThemeColor(c string) bool {
+	return c == "" || themeColorPattern.MatchString(c)
+}
+
+type webManifest struct {
+	Name       string         `json:"name,omitempty"`
+	ShortName  string         `json:"short_name,omitempty"`
+	Icons      []manifestIcon `json:"icons"`
+	ThemeColor string         `json:"theme_color,omitempty"`
+	Display    string         `json:"display"`
+}
+
+type manifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+// manifestSizes are the sized icons listed in site.webmanifest
+var manifestSizes = []string{"/android-chrome-192x192.png", "/android-chrome-512x512.png"}
+
+// genCompanions generates site.webmanifest and browserconfig.xml referencing
+// the sized icons, the sources are relative so the files work for path
+// prefixes and the hash is added so the icons are cached forever. The files
+// are only generated if a name or theme color is set so the files of the
+// backend are served otherwise.
+func (l *FaviconList) genCompanions() error {
+	l.Manifest, l.BrowserConfig = nil, nil
+	if len(l.Sized) == 0 || (l.Name == "" && l.ThemeColor == "") {
+		return nil
+	}
+
+	m := webManifest{Name: l.Name, ShortName: l.Name, Icons: make([]manifestIcon, 0, len(manifestSizes)), ThemeColor: l.ThemeColor, Display: "standalone"}
+	for _, p := range manifestSizes {
+		size, _ := SizeForPath(p)
+		m.Icons = append(m.Icons, manifestIcon{
+			Src:   l.versionedSrc(p, size),
+			Sizes: fmt.Sprintf("%dx%d", size, size),
+			Type:  "image/png",
+		})
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("[Favicons] Failed to generate site.webmanifest: %w", err)
+	}
+	l.Manifest = &FaviconImage{Raw: raw, Hash: genSha256(raw)}
+
+	// the theme color is validated as a hex color so it is safe in xml
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString("<browserconfig><msapplication><tile>")
+	size, _ := SizeForPath("/mstile-150x150.png")
+	_, _ = fmt.Fprintf(&b, `<square150x150logo src="%s"/>`, l.versionedSrc("/mstile-150x150.png", size))
+	if l.ThemeColor != "" {
+		_, _ = fmt.Fprintf(&b, "<TileColor>%s</TileColor>", l.ThemeColor)
+	}
+	b.WriteString("</tile></msapplication></browserconfig>\n")
+	l.BrowserConfig = &FaviconImage{Raw: b.Bytes(), Hash: genSha256(b.Bytes())}
+	return nil
+}
+
+// versionedSrc returns the relative source of the sized icon with the start of
+// the hash as the version
+func (l *FaviconList) versionedSrc(p string, size int) string {
+	src := p[1:]
+	if img := l.Sized[size]; img != nil && len(img.Hash) >= 8 {
+		src += "?v=" + img.Hash[:8]
+	}
+	return src
+}
@@ -0,0 +1,77 @@
+package favicons
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestFaviconList_GenCompanions(t *testing.T) {
+	getFaviconViaRequest = func(_ *http.Client, _ string) ([]byte, error) {
+		return exampleSvg, nil
+	}
+	icons := &FaviconList{
+		Svg:        &FaviconImage{Url: "https://example.com/assets/logo.svg"},
+		Name:       "Example",
+		ThemeColor: "#336699",
+	}
+	assert.NoError(t, icons.PreProcess(Builtin))
+
+	img, contentType, err := icons.ProduceForRequest("/site.webmanifest", "")
+	assert.NoError(t, err)
+	raw := img.Raw
+	assert.Equal(t, "application/manifest+json", contentType)
+	var m webManifest
+	assert.NoError(t, json.Unmarshal(raw, &m))
+	assert.Equal(t, "Example", m.Name)
+	assert.Equal(t, "#336699", m.ThemeColor)
+	assert.Equal(t, []manifestIcon{
+		{Src: "android-chrome-192x192.png?v=" + icons.Sized[192].Hash[:8], Sizes: "192x192", Type: "image/png"},
+		{Src: "android-chrome-512x512.png?v=" + icons.Sized[512].Hash[:8], Sizes: "512x512", Type: "image/png"},
+	}, m.Icons)
+	assert.NotEmpty(t, icons.Manifest.Hash)
+
+	img, contentType, err = icons.ProduceForRequest("/browserconfig.xml", "")
+	assert.NoError(t, err)
+	raw = img.Raw
+	assert.Equal(t, "application/xml", contentType)
+	assert.Contains(t, string(raw), `<square150x150logo src="mstile-150x150.png?v=`+icons.Sized[150].Hash[:8]+`"/>`)
+	assert.Contains(t, string(raw), "<TileColor>#336699</TileColor>")
+
+	// lists without sized icons have no companion files
+	icons = &FaviconList{Ico: &FaviconImage{Url: "data:image/x-icon;base64,AAABAA=="}, Name: "Example"}
+	assert.NoError(t, icons.PreProcess(Builtin))
+	_, _, err = icons.ProduceForRequest("/site.webmanifest", "")
+	assert.ErrorIs(t, err, ErrFaviconNotFound)
+
+	// the companion files of the backend are used without a name or theme color
+	icons = &FaviconList{Svg: &FaviconImage{Url: "https://example.com/assets/logo.svg"}}
+	assert.NoError(t, icons.PreProcess(Builtin))
+	assert.Nil(t, icons.Manifest)
+	assert.Nil(t, icons.BrowserConfig)
+}
+
+func TestFavicons_ThemeColor(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestFavicons_ThemeColor?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	f := New(db, nil)
+	f.r.Wait()
+
+	assert.ErrorIs(t, f.Put(FaviconRecord{Host: "example.com", Svg: DataUrl("image/svg+xml", exampleSvg), ThemeColor: "red"}), ErrInvalidRecord)
+	assert.ErrorIs(t, f.Put(FaviconRecord{Host: "example.com", Svg: DataUrl("image/svg+xml", exampleSvg), ThemeColor: "#12</TileColor>"}), ErrInvalidRecord)
+
+	want := FaviconRecord{Host: "example.com", Svg: DataUrl("image/svg+xml", exampleSvg), Name: "Example", ThemeColor: "#fff"}
+	assert.NoError(t, f.Put(want))
+	r, err := f.Get("example.com", "")
+	assert.NoError(t, err)
+	assert.Equal(t, want, r)
+
+	assert.NoError(t, f.CompileSync())
+	icons := f.GetIcons("example.com")
+	assert.Equal(t, "Example", icons.Name)
+	assert.NotNil(t, icons.Manifest)
+	assert.True(t, IsIconPath("/site.webmanifest"))
+	assert.True(t, IsIconPath("/browserconfig.xml"))
+}
@@ -0,0 +1,56 @@
+package favicons
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// tableColumn is a column which may be missing from tables created by older
+// versions
+type tableColumn struct {
+	name string
+	def  string
+}
+
+// faviconColumns are the columns added to the favicons table after it was
+// first created
+var faviconColumns = []tableColumn{
+	{"refresh", "INTEGER DEFAULT 0"},
+	{"path", "VARCHAR DEFAULT ''"},
+	{"name", "VARCHAR DEFAULT ''"},
+	{"theme_color", "VARCHAR DEFAULT ''"},
+	{"dark_svg", "VARCHAR DEFAULT ''"},
+}
+
+// addMissingColumns adds the columns which don't exist in the favicons table
+func addMissingColumns(db *sql.DB) error {
+	rows, err := db.Query("PRAGMA table_info(favicons)")
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]struct{})
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var def sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &def, &pk); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		existing[name] = struct{}{}
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, i := range faviconColumns {
+		if _, ok := existing[i.name]; ok {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE favicons ADD COLUMN %s %s", i.name, i.def)); err != nil {
+			return fmt.Errorf("failed to add column '%s': %w", i.name, err)
+		}
+	}
+	return nil
+}
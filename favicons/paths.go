@@ -0,0 +1,71 @@
+package favicons
+
+import (
+	"path"
+	"strings"
+)
+
+// CleanIconPath normalises the path prefix of a favicon override, the root is
+// an empty string and false is returned for invalid prefixes
+func CleanIconPath(p string) (string, bool) {
+	if p == "" || p == "/" {
+		return "", true
+	}
+	if !strings.HasPrefix(p, "/") || strings.ContainsAny(p, "?#") {
+		return "", false
+	}
+	p = path.Clean(p)
+	if p == "/" {
+		return "", true
+	}
+	return p, true
+}
+
+// iconKey is the key of the favicon list of the host and path prefix, hosts
+// never contain a slash so the key is unique
+func iconKey(host, prefix string) string {
+	return host + prefix
+}
+
+// SplitIconPath splits the request path into the path prefix and the icon
+// path served by the favicon list, for example `/app1/favicon.ico` becomes
+// `/app1` and `/favicon.ico`. False is returned if the path isn't an icon.
+func SplitIconPath(p string) (prefix, name string, ok bool) {
+	i := strings.LastIndexByte(p, '/')
+	if i < 0 {
+		return "", "", false
+	}
+	prefix, name = p[:i], p[i:]
+	return prefix, name, IsIconPath(name)
+}
+
+// IsIconPath returns true for the favicon paths, the generated icon sizes and
+// the companion files
+func IsIconPath(p string) bool {
+	switch p {
+	case "/favicon.svg", "/favicon-dark.svg", "/favicon.png", "/favicon.ico":
+		return true
+	}
+	if IsCompanionPath(p) {
+		return true
+	}
+	_, ok := SizeForPath(p)
+	return ok
+}
+
+// IsCompanionPath returns true for site.webmanifest and browserconfig.xml
+func IsCompanionPath(p string) bool {
+	return p == "/site.webmanifest" || p == "/browserconfig.xml"
+}
+
+// FindIconsAt returns the favicon list with the longest path prefix matching
+// the prefix, the root favicon list is only used for an empty prefix so apps
+// mounted under a path without an override keep their own icons
+func (f *Favicons) FindIconsAt(host, prefix string) *FaviconList {
+	if prefix == "" {
+		return f.FindIcons(host)
+	}
+	f.cLock.RLock()
+	defer f.cLock.RUnlock()
+	return f.lookup(host, prefix)
+}
@@ -0,0 +1,73 @@
+package favicons
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCleanIconPath(t *testing.T) {
+	for _, i := range []struct {
+		in, out string
+		ok      bool
+	}{
+		{"", "", true},
+		{"/", "", true},
+		{"/app1", "/app1", true},
+		{"/app1/", "/app1", true},
+		{"/app1/../app2", "/app2", true},
+		{"app1", "", false},
+		{"/app1?a=b", "", false},
+	} {
+		out, ok := CleanIconPath(i.in)
+		assert.Equal(t, i.out, out, i.in)
+		assert.Equal(t, i.ok, ok, i.in)
+	}
+}
+
+func TestSplitIconPath(t *testing.T) {
+	prefix, name, ok := SplitIconPath("/app1/favicon.ico")
+	assert.True(t, ok)
+	assert.Equal(t, "/app1", prefix)
+	assert.Equal(t, "/favicon.ico", name)
+
+	prefix, name, ok = SplitIconPath("/apple-touch-icon.png")
+	assert.True(t, ok)
+	assert.Equal(t, "", prefix)
+	assert.Equal(t, "/apple-touch-icon.png", name)
+
+	_, _, ok = SplitIconPath("/app1/logo.png")
+	assert.False(t, ok)
+}
+
+func TestFavicons_FindIconsAt(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestFavicons_FindIconsAt?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	f := New(db, nil)
+	f.r.Wait()
+
+	svg := DataUrl("image/svg+xml", exampleSvg)
+	assert.NoError(t, f.Put(FaviconRecord{Host: "example.com", Svg: svg}))
+	assert.NoError(t, f.Put(FaviconRecord{Host: "example.com", Path: "/app1/", Svg: svg}))
+	assert.ErrorIs(t, f.Put(FaviconRecord{Host: "example.com", Path: "app2", Svg: svg}), ErrInvalidRecord)
+	assert.NoError(t, f.CompileSync())
+
+	root, app1 := f.FindIconsAt("example.com", ""), f.FindIconsAt("example.com", "/app1")
+	assert.NotNil(t, root)
+	assert.NotNil(t, app1)
+	assert.NotSame(t, root, app1)
+	assert.Same(t, app1, f.FindIconsAt("example.com", "/app1/sub"))
+	assert.Nil(t, f.FindIconsAt("example.com", "/app2"))
+	assert.Nil(t, f.FindIconsAt("example.com", "/app10"))
+
+	r, err := f.Get("example.com", "/app1")
+	assert.NoError(t, err)
+	assert.Equal(t, "/app1", r.Path)
+	list, err := f.Export()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "/app1"}, []string{list[0].Path, list[1].Path})
+
+	assert.NoError(t, f.Delete("example.com", "/app1"))
+	_, err = f.Get("example.com", "")
+	assert.NoError(t, err)
+}
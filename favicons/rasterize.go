@@ -0,0 +1,79 @@
+package favicons
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"image"
+	"image/png"
+	"math"
+)
+
+const (
+	// rasterDefaultSize is used when the svg has no size or view box
+	rasterDefaultSize = 256
+	// rasterMaxSize limits the memory used for huge svg documents
+	rasterMaxSize = 1024
+)
+
+// rasterizeSvg converts svg image bytes to png image bytes without an external
+// program, this supports fewer svg features than inkscape. The longest side of
+// the png is the size or the size of the svg view box if the size is zero, both
+// are limited to rasterMaxSize pixels.
+func rasterizeSvg(in []byte, size int) ([]byte, error) {
+	// the parser accepts documents without any svg element
+	if !isSvgDocument(in) {
+		return nil, fmt.Errorf("failed to parse svg: root element is not svg")
+	}
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(in), oksvg.WarnErrorMode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse svg: %w", err)
+	}
+
+	w, h := icon.ViewBox.W, icon.ViewBox.H
+	if w <= 0 || h <= 0 {
+		w, h = rasterDefaultSize, rasterDefaultSize
+	}
+	target := float64(size)
+	if size <= 0 {
+		target = math.Max(w, h)
+	}
+	target = math.Min(target, rasterMaxSize)
+	s := math.Max(w, h)
+	w, h = w*target/s, h*target/s
+	width, height := int(math.Max(1, math.Round(w))), int(math.Max(1, math.Round(h)))
+
+	// draw onto a transparent image
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// isSvgDocument returns true if the root element of the xml document is an svg
+// element, the declaration, comments and doctype before the root are skipped
+func isSvgDocument(in []byte) bool {
+	d := xml.NewDecoder(bytes.NewReader(in))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return false
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return t.Name.Local == "svg"
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) != 0 {
+				return false
+			}
+		}
+	}
+}
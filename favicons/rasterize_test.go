@@ -0,0 +1,63 @@
+package favicons
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"image/png"
+	"net/http"
+	"testing"
+)
+
+func TestRasterizeSvg(t *testing.T) {
+	raw, err := rasterizeSvg(exampleSvg, 0)
+	assert.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, img.Bounds().Dx())
+	assert.Equal(t, 100, img.Bounds().Dy())
+
+	// the corner is transparent and the centre of the circle is red
+	_, _, _, a := img.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0), a)
+	r, g, b, a := img.At(50, 50).RGBA()
+	assert.Equal(t, []uint32{0xffff, 0, 0, 0xffff}, []uint32{r, g, b, a})
+
+	// large documents are scaled down
+	raw, err = rasterizeSvg([]byte(`<svg width="4096" height="2048" xmlns="http://www.w3.org/2000/svg"><rect width="4096" height="2048" fill="blue"/></svg>`), 0)
+	assert.NoError(t, err)
+	img, err = png.Decode(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, 1024, img.Bounds().Dx())
+	assert.Equal(t, 512, img.Bounds().Dy())
+
+	// the longest side matches the requested size
+	raw, err = rasterizeSvg([]byte(`<svg width="20" height="10" xmlns="http://www.w3.org/2000/svg"/>`), 180)
+	assert.NoError(t, err)
+	img, err = png.Decode(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, 180, img.Bounds().Dx())
+	assert.Equal(t, 90, img.Bounds().Dy())
+
+	_, err = rasterizeSvg([]byte("not an svg"), 0)
+	assert.Error(t, err)
+	_, err = rasterizeSvg([]byte(`<html><!-- <svg> --><svg/></html>`), 0)
+	assert.Error(t, err)
+}
+
+func TestIsSvgDocument(t *testing.T) {
+	assert.True(t, isSvgDocument([]byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`)))
+	assert.True(t, isSvgDocument([]byte("<?xml version=\"1.0\"?>\n<!DOCTYPE svg>\n<!-- icon -->\n<svg/>")))
+	assert.False(t, isSvgDocument([]byte(`<html><svg/></html>`)))
+	assert.False(t, isSvgDocument([]byte(`text <svg/>`)))
+	assert.False(t, isSvgDocument([]byte("")))
+}
+
+func TestFaviconList_PreProcess_Rasterize(t *testing.T) {
+	getFaviconViaRequest = func(_ *http.Client, _ string) ([]byte, error) {
+		return exampleSvg, nil
+	}
+	icons := &FaviconList{Svg: &FaviconImage{Url: "https://example.com/assets/logo.svg"}}
+	assert.NoError(t, icons.PreProcess(Builtin))
+	assert.NotEqual(t, "", icons.Png.Hash)
+	assert.NotEqual(t, "", icons.Ico.Hash)
+}
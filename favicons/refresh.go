@@ -0,0 +1,145 @@
+package favicons
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxRefreshDownload limits the bytes read from each source when refreshing
+const maxRefreshDownload = 16 << 20
+
+// WatchRefresh checks the favicons which have a refresh interval at each tick
+// and replaces the icons if a source has changed.
+func (f *Favicons) WatchRefresh(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		f.RefreshDue()
+	}
+}
+
+// RefreshDue downloads the sources of the favicons which are due a refresh
+// using conditional requests and regenerates the icons which have changed.
+func (f *Favicons) RefreshDue() {
+	f.cLock.RLock()
+	due := make(map[string]*FaviconList)
+	for host, l := range f.faviconMap {
+		if l.Refresh > 0 && time.Since(l.checked) >= l.Refresh {
+			due[host] = l
+		}
+	}
+	f.cLock.RUnlock()
+
+	for host, l := range due {
+		n, err := l.refresh(f.httpClient(), f.conv, f.formats)
+		if err != nil {
+			log.Printf("[Favicons] Failed to refresh '%s': %s\n", host, err)
+			continue
+		}
+
+		// replace the icons unless a compile replaced them first
+		f.cLock.Lock()
+		if f.faviconMap[host] == l {
+			f.faviconMap[host] = n
+		}
+		f.cLock.Unlock()
+	}
+}
+
+// refresh returns a new favicon list with the icons generated again if any
+// downloaded source has changed, otherwise a copy of the current list is
+// marked as checked and returned. The current list is never modified.
+func (l *FaviconList) refresh(client *http.Client, conv Converter, formats []Format) (*FaviconList, error) {
+	n := &FaviconList{Refresh: l.Refresh, Name: l.Name, ThemeColor: l.ThemeColor}
+	changed := false
+	for _, i := range []struct {
+		cur *FaviconImage
+		dst **FaviconImage
+	}{{l.Svg, &n.Svg}, {l.Png, &n.Png}, {l.Ico, &n.Ico}, {l.DarkSvg, &n.DarkSvg}} {
+		// generated icons are generated again by the pre-process
+		if i.cur == nil || i.cur.Url == "" {
+			continue
+		}
+		*i.dst = CreateFaviconImage(i.cur.Url)
+		if strings.HasPrefix(i.cur.Url, "data:") {
+			continue
+		}
+
+		img := *i.dst
+		img.Raw, img.ETag, img.LastModified = i.cur.Raw, i.cur.ETag, i.cur.LastModified
+		modified, err := getFaviconConditional(client, img)
+		if err != nil {
+			return nil, err
+		}
+		if modified && !bytes.Equal(img.Raw, i.cur.Raw) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		// readers hold the current list without a lock, so a copy keeps the
+		// headers of unchanged downloads for the next refresh
+		c := *l
+		for _, i := range []struct {
+			dst **FaviconImage
+			got *FaviconImage
+		}{{&c.Svg, n.Svg}, {&c.Png, n.Png}, {&c.Ico, n.Ico}, {&c.DarkSvg, n.DarkSvg}} {
+			if i.got != nil && i.got.Raw != nil {
+				img := **i.dst
+				img.ETag, img.LastModified = i.got.ETag, i.got.LastModified
+				*i.dst = &img
+			}
+		}
+		c.checked = time.Now()
+		return &c, nil
+	}
+	if err := n.preProcess(client, conv, formats...); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// getFaviconConditional downloads the icon using the ETag and Last-Modified
+// headers from the previous download, false is returned if the server
+// responds with 304 Not Modified.
+var getFaviconConditional = func(client *http.Client, img *FaviconImage) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, img.Url, nil)
+	if err != nil {
+		return false, fmt.Errorf("[Favicons] Failed to send request '%s': %w", img.Url, err)
+	}
+	req.Header.Set("X-Violet-Raw-Favicon", "1")
+	if img.ETag != "" {
+		req.Header.Set("If-None-Match", img.ETag)
+	}
+	if img.LastModified != "" {
+		req.Header.Set("If-Modified-Since", img.LastModified)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("[Favicons] Failed to do request '%s': %w", img.Url, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, fmt.Errorf("[Favicons] Unexpected status for '%s': %s", img.Url, resp.Status)
+	}
+	rawBody, err := io.ReadAll(io.LimitReader(resp.Body, maxRefreshDownload+1))
+	if err != nil {
+		return false, fmt.Errorf("[Favicons] Failed to read response '%s': %w", img.Url, err)
+	}
+	if len(rawBody) > maxRefreshDownload {
+		return false, fmt.Errorf("[Favicons] Response from '%s' is too large", img.Url)
+	}
+	img.Raw = rawBody
+	img.ETag = resp.Header.Get("ETag")
+	img.LastModified = resp.Header.Get("Last-Modified")
+	return true, nil
+}
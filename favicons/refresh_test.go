@@ -0,0 +1,89 @@
+package favicons
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFavicons_RefreshDue(t *testing.T) {
+	var lock sync.Mutex
+	body, etag := exampleSvg, `"v1"`
+	var conditional []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		conditional = append(conditional, req.Header.Get("If-None-Match"))
+		if req.Header.Get("If-None-Match") == etag {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		rw.Header().Set("ETag", etag)
+		_, _ = rw.Write(body)
+	}))
+	defer srv.Close()
+	getFaviconViaRequest = func(_ *http.Client, _ string) ([]byte, error) { return exampleSvg, nil }
+
+	db, err := sql.Open("sqlite3", "file:TestFavicons_RefreshDue?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	favicons := New(db, Builtin)
+	favicons.r.Wait() // initial compile
+	// the test server is on a loopback address
+	favicons.client = srv.Client()
+	assert.NoError(t, favicons.Put(FaviconRecord{Host: "example.com", Svg: srv.URL + "/logo.svg", Refresh: 60}))
+	r, err := favicons.Get("example.com", "")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(60), r.Refresh)
+	assert.NoError(t, favicons.CompileSync())
+
+	icons := favicons.GetIcons("example.com")
+	assert.Equal(t, time.Minute, icons.Refresh)
+
+	// not due yet
+	favicons.RefreshDue()
+	assert.Len(t, conditional, 0)
+
+	// the same icon keeps the generated icons and stores the etag in a copy
+	icons.checked = time.Time{}
+	favicons.RefreshDue()
+	same := favicons.GetIcons("example.com")
+	assert.NotSame(t, icons, same)
+	assert.Same(t, icons.Png, same.Png)
+	assert.Equal(t, `"v1"`, same.Svg.ETag)
+	assert.Equal(t, "", icons.Svg.ETag)
+
+	same.checked = time.Time{}
+	favicons.RefreshDue()
+	assert.Same(t, icons.Png, favicons.GetIcons("example.com").Png)
+	assert.Equal(t, []string{"", `"v1"`}, conditional)
+
+	// changed icons are generated again
+	lock.Lock()
+	body, etag = []byte(`<svg width="10" height="10" xmlns="http://www.w3.org/2000/svg"><rect width="10" height="10" fill="blue"/></svg>`), `"v2"`
+	lock.Unlock()
+	cur := favicons.GetIcons("example.com")
+	cur.checked = time.Time{}
+	favicons.RefreshDue()
+	n := favicons.GetIcons("example.com")
+	assert.NotSame(t, cur, n)
+	assert.Equal(t, `"v2"`, n.Svg.ETag)
+	assert.Equal(t, time.Minute, n.Refresh)
+	assert.NotEqual(t, icons.Png.Hash, n.Png.Hash)
+	assert.NotNil(t, n.Ico)
+}
+
+func TestGetFaviconConditional_TooLarge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write(make([]byte, maxRefreshDownload+1))
+	}))
+	defer srv.Close()
+
+	img := CreateFaviconImage(srv.URL + "/logo.svg")
+	_, err := getFaviconConditional(srv.Client(), img)
+	assert.ErrorContains(t, err, "too large")
+	assert.Nil(t, img.Raw)
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package favicons
+
+import (
+	"golang.org/x/sys/unix"
+	"math"
+	"os/exec"
+	"syscall"
+)
+
+// sandboxCommand runs the program in a new process group and kills the whole
+// group when the timeout is reached
+func sandboxCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// applyLimits sets the address space and processor time limits of the started
+// program, programs it starts inherit the limits
+func applyLimits(pid int, l Limits) error {
+	if l.Memory > 0 {
+		if err := unix.Prlimit(pid, unix.RLIMIT_AS, &unix.Rlimit{Cur: l.Memory, Max: l.Memory}, nil); err != nil {
+			return err
+		}
+	}
+	if l.CPU > 0 {
+		// round up to whole seconds as a zero limit is unlimited
+		secs := uint64(math.Ceil(l.CPU.Seconds()))
+		if err := unix.Prlimit(pid, unix.RLIMIT_CPU, &unix.Rlimit{Cur: secs, Max: secs}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
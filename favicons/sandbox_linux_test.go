@@ -0,0 +1,24 @@
+package favicons
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCommandConverter_Sandbox(t *testing.T) {
+	// the timeout stops programs started by the converter
+	start := time.Now()
+	_, err := (&CommandConverter{Name: "sh", Path: "sh", Args: []string{"-c", "sleep 5 & wait"}, Timeout: 50 * time.Millisecond}).Convert(nil)
+	assert.EqualError(t, err, "sh timed out after 50ms")
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// the cpu limit kills busy programs
+	_, err = (&CommandConverter{Name: "sh", Path: "sh", Args: []string{"-c", "while :; do :; done"}, Timeout: 10 * time.Second, Limits: Limits{CPU: time.Second}}).Convert(nil)
+	assert.ErrorContains(t, err, "signal: killed")
+
+	// the memory limit applies before the input is sent
+	out, err := (&CommandConverter{Name: "sh", Path: "sh", Args: []string{"-c", "cat >/dev/null; ulimit -v"}, Timeout: time.Second, Limits: Limits{Memory: 512 << 20}}).Convert([]byte("<svg/>"))
+	assert.NoError(t, err)
+	assert.Equal(t, "524288\n", string(out))
+}
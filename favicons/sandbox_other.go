@@ -0,0 +1,11 @@
+//go:build !linux
+
+package favicons
+
+import "os/exec"
+
+// sandboxCommand leaves the program unchanged on this platform
+func sandboxCommand(*exec.Cmd) {}
+
+// applyLimits ignores the memory and processor time limits on this platform
+func applyLimits(int, Limits) error { return nil }
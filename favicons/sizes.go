@@ -0,0 +1,103 @@
+package favicons
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/png"
+)
+
+// IconSize is a square png icon generated from the svg or png favicon
+type IconSize struct {
+	Path string
+	Size int
+}
+
+// IconSizes lists the browser favicon sizes, the apple touch icons and the
+// icons referenced by site.webmanifest and browserconfig.xml
+var IconSizes = []IconSize{
+	{"/favicon-16x16.png", 16},
+	{"/favicon-32x32.png", 32},
+	{"/favicon-48x48.png", 48},
+	{"/apple-touch-icon.png", 180},
+	{"/apple-touch-icon-precomposed.png", 180},
+	{"/android-chrome-192x192.png", 192},
+	{"/android-chrome-512x512.png", 512},
+	{"/mstile-150x150.png", 150},
+}
+
+// SizeForPath returns the size of the generated icon served on the path
+func SizeForPath(p string) (int, bool) {
+	for _, i := range IconSizes {
+		if i.Path == p {
+			return i.Size, true
+		}
+	}
+	return 0, false
+}
+
+// generateSized renders each icon size from the svg when the converter
+// supports sizes, otherwise the png is scaled
+func (l *FaviconList) generateSized(conv Converter) error {
+	if l.Svg == nil && l.Png == nil {
+		return nil
+	}
+	l.Sized = make(map[int]*FaviconImage)
+	sized, canSize := conv.(SizedConverter)
+	for _, i := range IconSizes {
+		if l.Sized[i.Size] != nil {
+			continue
+		}
+		var raw []byte
+		var err error
+		if l.Svg != nil && canSize {
+			raw, err = sized.ConvertSize(l.Svg.Raw, i.Size)
+		} else {
+			raw, err = l.ProducePng()
+		}
+		if err == nil {
+			raw, err = fitSquare(raw, i.Size)
+		}
+		if err != nil {
+			return fmt.Errorf("[Favicons] Failed to generate %dx%d icon: %w", i.Size, i.Size, err)
+		}
+		l.Sized[i.Size] = &FaviconImage{Raw: raw}
+	}
+	return nil
+}
+
+// fitSquare scales the png to fit inside a transparent square of the size
+// keeping the aspect ratio, the png is unchanged if it already has the size
+func fitSquare(raw []byte, size int) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if b.Dx() == size && b.Dy() == size {
+		return raw, nil
+	}
+
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = b.Dy() * size / b.Dx()
+	} else if b.Dy() > b.Dx() {
+		w = b.Dx() * size / b.Dy()
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	x, y := (size-w)/2, (size-h)/2
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, image.Rect(x, y, x+w, y+h), img, b, draw.Over, nil)
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, dst); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
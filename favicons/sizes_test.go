@@ -0,0 +1,55 @@
+package favicons
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/png"
+	"net/http"
+	"testing"
+)
+
+func TestFaviconList_GenerateSized(t *testing.T) {
+	getFaviconViaRequest = func(_ *http.Client, _ string) ([]byte, error) {
+		return exampleSvg, nil
+	}
+	icons := &FaviconList{Svg: &FaviconImage{Url: "https://example.com/assets/logo.svg"}}
+	assert.NoError(t, icons.PreProcess(Builtin))
+
+	for _, i := range IconSizes {
+		raw, contentType, err := icons.ProduceForPath(i.Path)
+		assert.NoError(t, err)
+		assert.Equal(t, "image/png", contentType)
+		img, err := png.Decode(bytes.NewReader(raw))
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, i.Size, i.Size), img.Bounds())
+		assert.NotEmpty(t, icons.Sized[i.Size].Hash)
+	}
+
+	_, _, err := icons.ProduceForPath("/favicon-64x64.png")
+	assert.ErrorIs(t, err, ErrInvalidFaviconExtension)
+	_, contentType, err := icons.ProduceForPath("/favicon.svg")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/svg+xml", contentType)
+}
+
+func TestFitSquare(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20))))
+
+	raw, err := fitSquare(buf.Bytes(), 40)
+	assert.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 40, 40), img.Bounds())
+
+	// matching sizes are unchanged
+	square := new(bytes.Buffer)
+	assert.NoError(t, png.Encode(square, image.NewRGBA(image.Rect(0, 0, 16, 16))))
+	raw, err = fitSquare(square.Bytes(), 16)
+	assert.NoError(t, err)
+	assert.Equal(t, square.Bytes(), raw)
+
+	_, err = fitSquare([]byte("abc"), 16)
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package favicons
+
+import (
+	"github.com/MrMelon54/violet/utils"
+	"path"
+	"strings"
+)
+
+// SettingsProvider finds the wildcard depth of each domain
+type SettingsProvider interface {
+	GetSettings(host string) (utils.DomainSettings, bool)
+}
+
+// SetDomainSettings sets the provider used to find the wildcard depth of each
+// domain, wildcard rows only match a single subdomain level without a provider
+func (f *Favicons) SetDomainSettings(settings SettingsProvider) {
+	f.cLock.Lock()
+	f.settings = settings
+	f.cLock.Unlock()
+}
+
+// lookup finds the favicon list of the host and the longest matching path
+// prefix, the wildcard of each parent is tried if the host has no favicons
+// using the same depth as the router. The read lock must be held.
+func (f *Favicons) lookup(host, prefix string) *FaviconList {
+	for _, h := range f.hostCandidates(host) {
+		if prefix == "" {
+			if l := f.faviconMap[iconKey(h, "")]; l != nil {
+				return l
+			}
+			continue
+		}
+		for p := prefix; p != "" && p != "/"; p = path.Dir(p) {
+			if l := f.faviconMap[iconKey(h, p)]; l != nil {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+// hostCandidates returns the host followed by the wildcard of each parent
+// starting with the closest
+func (f *Favicons) hostCandidates(host string) []string {
+	hosts := []string{host}
+	if domain, _, ok := utils.SplitDomainPort(host, 0); ok && domain != host {
+		host = domain
+		hosts = append(hosts, host)
+	}
+
+	depth := 1
+	if f.settings != nil {
+		if settings, ok := f.settings.GetSettings(host); ok && settings.WildcardDepth > 1 {
+			depth = settings.WildcardDepth
+		}
+	}
+	parent := host
+	for ; depth > 0; depth-- {
+		n := strings.IndexByte(parent, '.')
+		if n == -1 {
+			break
+		}
+		parent = parent[n+1:]
+		hosts = append(hosts, "*."+parent)
+	}
+	return hosts
+}
@@ -0,0 +1,41 @@
+package favicons
+
+import (
+	"database/sql"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type depthSettings int
+
+func (d depthSettings) GetSettings(_ string) (utils.DomainSettings, bool) {
+	return utils.DomainSettings{WildcardDepth: int(d)}, true
+}
+
+func TestFavicons_Wildcard(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestFavicons_Wildcard?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	f := New(db, nil)
+	f.r.Wait()
+
+	svg := DataUrl("image/svg+xml", exampleSvg)
+	assert.NoError(t, f.Put(FaviconRecord{Host: "*.example.com", Svg: svg}))
+	assert.NoError(t, f.Put(FaviconRecord{Host: "www.example.com", Svg: svg}))
+	assert.NoError(t, f.Put(FaviconRecord{Host: "*.example.com", Path: "/app1", Svg: svg}))
+	assert.NoError(t, f.CompileSync())
+
+	wildcard, www := f.GetIcons("*.example.com"), f.GetIcons("www.example.com")
+	assert.NotNil(t, wildcard)
+	assert.NotSame(t, wildcard, www)
+	assert.Same(t, wildcard, f.GetIcons("api.example.com"))
+	assert.Same(t, wildcard, f.FindIcons("api.example.com:8443"))
+	assert.Same(t, f.FindIconsAt("*.example.com", "/app1"), f.FindIconsAt("api.example.com", "/app1"))
+	assert.Nil(t, f.GetIcons("example.com"))
+	assert.Nil(t, f.GetIcons("example.org"))
+
+	// wildcards match a single level unless the domain settings allow more
+	assert.Nil(t, f.GetIcons("a.b.example.com"))
+	f.SetDomainSettings(depthSettings(2))
+	assert.Same(t, wildcard, f.GetIcons("a.b.example.com"))
+}
@@ -4,7 +4,8 @@ CREATE TABLE IF NOT EXISTS routes
     source      TEXT UNIQUE,
     destination TEXT,
     flags       INTEGER DEFAULT 0,
-    active      INTEGER DEFAULT 1
+    active      INTEGER DEFAULT 1,
+    deleted     INTEGER DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS redirects
@@ -14,5 +15,93 @@ CREATE TABLE IF NOT EXISTS redirects
     destination TEXT,
     flags       INTEGER DEFAULT 0,
     code        INTEGER DEFAULT 0,
-    active      INTEGER DEFAULT 1
+    active      INTEGER DEFAULT 1,
+    deleted     INTEGER DEFAULT 0
 );
+
+CREATE TABLE IF NOT EXISTS route_history
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    route_id    INTEGER,
+    version     INTEGER,
+    source      TEXT,
+    destination TEXT,
+    flags       INTEGER,
+    active      INTEGER,
+    time        INTEGER,
+    UNIQUE (route_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS redirect_history
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    redirect_id INTEGER,
+    version     INTEGER,
+    source      TEXT,
+    destination TEXT,
+    flags       INTEGER,
+    code        INTEGER,
+    active      INTEGER,
+    time        INTEGER,
+    UNIQUE (redirect_id, version)
+);
+
+-- entries created before the history tables start at version 1
+INSERT INTO route_history (route_id, version, source, destination, flags, active, time)
+SELECT id, 1, source, destination, flags, active, CAST(strftime('%s', 'now') AS INTEGER)
+FROM routes
+WHERE id NOT IN (SELECT route_id FROM route_history);
+
+INSERT INTO redirect_history (redirect_id, version, source, destination, flags, code, active, time)
+SELECT id, 1, source, destination, flags, code, active, CAST(strftime('%s', 'now') AS INTEGER)
+FROM redirects
+WHERE id NOT IN (SELECT redirect_id FROM redirect_history);
+
+CREATE TRIGGER IF NOT EXISTS route_history_insert
+    AFTER INSERT
+    ON routes
+BEGIN
+    INSERT INTO route_history (route_id, version, source, destination, flags, active, time)
+    VALUES (NEW.id, (SELECT COALESCE(MAX(version), 0) + 1 FROM route_history WHERE route_id = NEW.id), NEW.source,
+            NEW.destination, NEW.flags, NEW.active, CAST(strftime('%s', 'now') AS INTEGER));
+END;
+
+CREATE TRIGGER IF NOT EXISTS route_history_update
+    AFTER UPDATE
+    ON routes
+    WHEN OLD.source IS NOT NEW.source OR OLD.destination IS NOT NEW.destination OR OLD.flags IS NOT NEW.flags OR
+         OLD.active IS NOT NEW.active
+BEGIN
+    INSERT INTO route_history (route_id, version, source, destination, flags, active, time)
+    VALUES (NEW.id, (SELECT COALESCE(MAX(version), 0) + 1 FROM route_history WHERE route_id = NEW.id), NEW.source,
+            NEW.destination, NEW.flags, NEW.active, CAST(strftime('%s', 'now') AS INTEGER));
+END;
+
+CREATE TRIGGER IF NOT EXISTS redirect_history_insert
+    AFTER INSERT
+    ON redirects
+BEGIN
+    INSERT INTO redirect_history (redirect_id, version, source, destination, flags, code, active, time)
+    VALUES (NEW.id, (SELECT COALESCE(MAX(version), 0) + 1 FROM redirect_history WHERE redirect_id = NEW.id),
+            NEW.source, NEW.destination, NEW.flags, NEW.code, NEW.active, CAST(strftime('%s', 'now') AS INTEGER));
+END;
+
+CREATE TRIGGER IF NOT EXISTS redirect_history_update
+    AFTER UPDATE
+    ON redirects
+    WHEN OLD.source IS NOT NEW.source OR OLD.destination IS NOT NEW.destination OR OLD.flags IS NOT NEW.flags OR
+         OLD.code IS NOT NEW.code OR OLD.active IS NOT NEW.active
+BEGIN
+    INSERT INTO redirect_history (redirect_id, version, source, destination, flags, code, active, time)
+    VALUES (NEW.id, (SELECT COALESCE(MAX(version), 0) + 1 FROM redirect_history WHERE redirect_id = NEW.id),
+            NEW.source, NEW.destination, NEW.flags, NEW.code, NEW.active, CAST(strftime('%s', 'now') AS INTEGER));
+END;
+
+CREATE TABLE IF NOT EXISTS generation
+(
+    id    INTEGER PRIMARY KEY CHECK (id = 1),
+    value INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT OR IGNORE INTO generation (id, value)
+VALUES (1, 0);
@@ -0,0 +1,51 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// GenerationHeader is the response header containing the generation of the
+// router which served the request
+const GenerationHeader = "X-Violet-Generation"
+
+// Generation returns the configuration generation of the current router, the
+// generation is 0 until the first successful compile
+func (m *Manager) Generation() uint64 {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	return m.gen
+}
+
+// SetGenerationHeader enables adding GenerationHeader to proxied responses
+func (m *Manager) SetGenerationHeader(enabled bool) {
+	m.s.Lock()
+	m.genHeader = enabled
+	m.s.Unlock()
+}
+
+// nextGeneration increments the generation stored in the database so the
+// number keeps increasing across restarts
+func (m *Manager) nextGeneration() (uint64, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE generation SET value = value + 1 WHERE id = 1`); err != nil {
+		return 0, err
+	}
+	var gen uint64
+	if err := tx.QueryRow(`SELECT value FROM generation WHERE id = 1`).Scan(&gen); err != nil {
+		return 0, err
+	}
+	return gen, tx.Commit()
+}
+
+// writeGenerationHeader adds the generation header if enabled, the caller
+// must hold the read lock
+func (m *Manager) writeGenerationHeader(rw http.ResponseWriter) {
+	if m.genHeader {
+		rw.Header().Set(GenerationHeader, strconv.FormatUint(m.gen, 10))
+	}
+}
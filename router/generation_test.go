@@ -0,0 +1,37 @@
+package router
+
+import (
+	"database/sql"
+	"github.com/MrMelon54/violet/proxy"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestManager_Generation(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestManager_Generation?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, proxy.NewHybridTransport())
+	assert.Equal(t, uint64(0), m.Generation())
+
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com", nil))
+		return rec
+	}
+	assert.Equal(t, "", serve().Header().Get(GenerationHeader))
+
+	assert.NoError(t, m.CompileSync())
+	assert.NoError(t, m.CompileSync())
+	assert.Equal(t, uint64(2), m.Generation())
+
+	m.SetGenerationHeader(true)
+	assert.Equal(t, "2", serve().Header().Get(GenerationHeader))
+
+	// the generation continues from the database after a restart
+	m = NewManager(db, proxy.NewHybridTransport())
+	assert.NoError(t, m.CompileSync())
+	assert.Equal(t, uint64(3), m.Generation())
+}
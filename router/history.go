@@ -0,0 +1,91 @@
+package router
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/MrMelon54/violet/target"
+	"io/fs"
+	"time"
+)
+
+// RouteVersion is a route as it was after a change, versions are recorded by
+// triggers on the routes table so every change is included
+type RouteVersion struct {
+	Version int64     `json:"version"`
+	Time    time.Time `json:"time"`
+	target.RouteWithActive
+}
+
+// RedirectVersion is a redirect as it was after a change, versions are
+// recorded by triggers on the redirects table so every change is included
+type RedirectVersion struct {
+	Version int64     `json:"version"`
+	Time    time.Time `json:"time"`
+	target.RedirectWithActive
+}
+
+// RouteHistory returns every version of the route ordered from oldest to
+// newest.
+func (m *Manager) RouteHistory(id int64) ([]RouteVersion, error) {
+	rows, err := m.db.Query(`SELECT version, time, source, destination, flags, active FROM route_history WHERE route_id = ? ORDER BY version`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]RouteVersion, 0)
+	for rows.Next() {
+		a := RouteVersion{RouteWithActive: target.RouteWithActive{Id: id}}
+		var t int64
+		if err := rows.Scan(&a.Version, &t, &a.Src, &a.Dst, &a.Flags, &a.Active); err != nil {
+			return nil, err
+		}
+		a.Time = time.Unix(t, 0).UTC()
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// RedirectHistory returns every version of the redirect ordered from oldest to
+// newest.
+func (m *Manager) RedirectHistory(id int64) ([]RedirectVersion, error) {
+	rows, err := m.db.Query(`SELECT version, time, source, destination, flags, code, active FROM redirect_history WHERE redirect_id = ? ORDER BY version`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]RedirectVersion, 0)
+	for rows.Next() {
+		a := RedirectVersion{RedirectWithActive: target.RedirectWithActive{Id: id}}
+		var t int64
+		if err := rows.Scan(&a.Version, &t, &a.Src, &a.Dst, &a.Flags, &a.Code, &a.Active); err != nil {
+			return nil, err
+		}
+		a.Time = time.Unix(t, 0).UTC()
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// GetRouteVersion returns a single version of the route, fs.ErrNotExist is
+// returned if the version doesn't exist.
+func (m *Manager) GetRouteVersion(id, version int64) (target.RouteWithActive, error) {
+	a := target.RouteWithActive{Id: id}
+	err := m.db.QueryRow(`SELECT source, destination, flags, active FROM route_history WHERE route_id = ? AND version = ?`, id, version).Scan(&a.Src, &a.Dst, &a.Flags, &a.Active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, fs.ErrNotExist
+	}
+	return a, err
+}
+
+// GetRedirectVersion returns a single version of the redirect, fs.ErrNotExist
+// is returned if the version doesn't exist.
+func (m *Manager) GetRedirectVersion(id, version int64) (target.RedirectWithActive, error) {
+	a := target.RedirectWithActive{Id: id}
+	err := m.db.QueryRow(`SELECT source, destination, flags, code, active FROM redirect_history WHERE redirect_id = ? AND version = ?`, id, version).Scan(&a.Src, &a.Dst, &a.Flags, &a.Code, &a.Active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, fs.ErrNotExist
+	}
+	return a, err
+}
@@ -0,0 +1,61 @@
+package router
+
+import (
+	"database/sql"
+	"github.com/MrMelon54/violet/proxy"
+	"github.com/MrMelon54/violet/target"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"net/http"
+	"testing"
+)
+
+func TestManager_RouteHistory(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestManager_RouteHistory?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, proxy.NewHybridTransport())
+
+	assert.NoError(t, m.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}))
+	assert.NoError(t, m.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8081"}))
+	// unchanged entries don't add a version
+	assert.NoError(t, m.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8081"}))
+	assert.NoError(t, m.UpdateRoute(target.RouteWithActive{Id: 1, Route: target.Route{Src: "example.com/", Dst: "127.0.0.1:8081"}, Active: false}))
+	// moving to the recycle bin doesn't add a version
+	assert.NoError(t, m.DeleteRoute("example.com/"))
+
+	list, err := m.RouteHistory(1)
+	assert.NoError(t, err)
+	assert.Len(t, list, 3)
+	for i, dst := range []string{"127.0.0.1:8080", "127.0.0.1:8081", "127.0.0.1:8081"} {
+		assert.Equal(t, int64(i+1), list[i].Version)
+		assert.Equal(t, int64(1), list[i].Id)
+		assert.Equal(t, dst, list[i].Dst)
+	}
+	assert.False(t, list[2].Active)
+
+	a, err := m.GetRouteVersion(1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, target.RouteWithActive{Id: 1, Route: target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}, Active: true}, a)
+	_, err = m.GetRouteVersion(1, 4)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestManager_RedirectHistory(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestManager_RedirectHistory?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, proxy.NewHybridTransport())
+
+	assert.NoError(t, m.InsertRedirect(target.Redirect{Src: "www.example.com/", Dst: "example.com", Code: http.StatusFound}))
+	assert.NoError(t, m.UpdateRedirect(target.RedirectWithActive{Id: 1, Redirect: target.Redirect{Src: "www.example.com/", Dst: "example.org", Code: http.StatusMovedPermanently}, Active: true}))
+
+	list, err := m.RedirectHistory(1)
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "example.com", list[0].Dst)
+	assert.Equal(t, http.StatusMovedPermanently, list[1].Code)
+
+	a, err := m.GetRedirectVersion(1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, target.RedirectWithActive{Id: 1, Redirect: target.Redirect{Src: "www.example.com/", Dst: "example.com", Code: http.StatusFound}, Active: true}, a)
+}
@@ -3,12 +3,17 @@ package router
 import (
 	"database/sql"
 	_ "embed"
+	"errors"
 	"github.com/MrMelon54/rescheduler"
 	"github.com/MrMelon54/violet/proxy"
 	"github.com/MrMelon54/violet/target"
+	"github.com/MrMelon54/violet/utils"
+	"io/fs"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Manager is a database and mutex wrap around router allowing it to be
@@ -18,9 +23,24 @@ type Manager struct {
 	s  *sync.RWMutex
 	r  *Router
 	p  *proxy.HybridTransport
+	d  SettingsProvider
+	mh MaintenanceHandler
+	eh target.UpstreamErrorHandler
+	ep ErrorHandler
 	z  *rescheduler.Rescheduler
+
+	// deleted entries are purged after the retention
+	retention time.Duration
+
+	// generation of the current router
+	gen       uint64
+	genHeader bool
 }
 
+// defaultRecycleRetention is how long deleted routes and redirects are kept
+// before being purged
+const defaultRecycleRetention = 30 * 24 * time.Hour
+
 var (
 	//go:embed create-tables.sql
 	createTables string
@@ -34,6 +54,8 @@ func NewManager(db *sql.DB, proxy *proxy.HybridTransport) *Manager {
 		s:  &sync.RWMutex{},
 		r:  New(proxy),
 		p:  proxy,
+
+		retention: defaultRecycleRetention,
 	}
 	m.z = rescheduler.NewRescheduler(m.threadCompile)
 
@@ -43,11 +65,62 @@ func NewManager(db *sql.DB, proxy *proxy.HybridTransport) *Manager {
 		log.Printf("[WARN] Failed to generate tables\n")
 		return nil
 	}
+	for _, table := range []string{"routes", "redirects"} {
+		if err := addDeletedColumn(m.db, table); err != nil {
+			log.Printf("[WARN] Failed to update '%s' table: %s\n", table, err)
+			return nil
+		}
+	}
 	return m
 }
 
+// SetRecycleRetention sets how long deleted routes and redirects are kept
+// before being purged during the next compile
+func (m *Manager) SetRecycleRetention(retention time.Duration) {
+	m.s.Lock()
+	m.retention = retention
+	m.s.Unlock()
+}
+
+// SetMaintenance sets the handler for routes flagged for maintenance for the
+// current and future routers
+func (m *Manager) SetMaintenance(h MaintenanceHandler) {
+	m.s.Lock()
+	m.mh = h
+	m.r.SetMaintenance(h)
+	m.s.Unlock()
+}
+
+// SetUpstreamErrors sets the handler which writes the response when the
+// backend of a route fails for the current and future routers
+func (m *Manager) SetUpstreamErrors(h target.UpstreamErrorHandler) {
+	m.s.Lock()
+	m.eh = h
+	m.r.SetUpstreamErrors(h)
+	m.s.Unlock()
+}
+
+// SetErrorPages sets the handler which writes the error responses generated by
+// the current and future routers
+func (m *Manager) SetErrorPages(h ErrorHandler) {
+	m.s.Lock()
+	m.ep = h
+	m.r.SetErrorPages(h)
+	m.s.Unlock()
+}
+
+// SetDomainSettings sets the provider used to find the wildcard depth of each
+// domain for the current and future routers
+func (m *Manager) SetDomainSettings(settings SettingsProvider) {
+	m.s.Lock()
+	m.d = settings
+	m.r.SetDomainSettings(settings)
+	m.s.Unlock()
+}
+
 func (m *Manager) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	m.s.RLock()
+	m.writeGenerationHeader(rw)
 	m.r.ServeHTTP(rw, req)
 	m.s.RUnlock()
 }
@@ -57,20 +130,45 @@ func (m *Manager) Compile() {
 }
 
 func (m *Manager) threadCompile() {
+	if err := m.CompileSync(); err != nil {
+		log.Printf("[Manager] Compile failed: %s\n", err)
+	}
+}
+
+// CompileSync regenerates the router from the database and waits for the
+// compile to finish.
+func (m *Manager) CompileSync() error {
 	// new router
 	router := New(m.p)
+	m.s.RLock()
+	router.SetDomainSettings(m.d)
+	router.SetMaintenance(m.mh)
+	router.SetUpstreamErrors(m.eh)
+	router.SetErrorPages(m.ep)
+	retention := m.retention
+	m.s.RUnlock()
+
+	// remove deleted entries older than the retention
+	if err := m.purgeDeleted(time.Now().Add(-retention)); err != nil {
+		return err
+	}
 
 	// compile router and check errors
 	err := m.internalCompile(router)
 	if err != nil {
-		log.Printf("[Manager] Compile failed: %s\n", err)
-		return
+		return err
+	}
+	gen, err := m.nextGeneration()
+	if err != nil {
+		return err
 	}
 
 	// lock while replacing router
 	m.s.Lock()
 	m.r = router
+	m.gen = gen
 	m.s.Unlock()
+	return nil
 }
 
 // internalCompile is a hidden internal method for querying the database during
@@ -79,7 +177,7 @@ func (m *Manager) internalCompile(router *Router) error {
 	log.Println("[Manager] Updating routes from database")
 
 	// sql or something?
-	rows, err := m.db.Query(`SELECT source, destination, flags FROM routes WHERE active = 1`)
+	rows, err := m.db.Query(`SELECT source, destination, flags FROM routes WHERE active = 1 AND deleted = 0`)
 	if err != nil {
 		return err
 	}
@@ -109,7 +207,7 @@ func (m *Manager) internalCompile(router *Router) error {
 	}
 
 	// sql or something?
-	rows, err = m.db.Query(`SELECT source,destination,flags,code FROM redirects WHERE active = 1`)
+	rows, err = m.db.Query(`SELECT source,destination,flags,code FROM redirects WHERE active = 1 AND deleted = 0`)
 	if err != nil {
 		return err
 	}
@@ -139,60 +237,424 @@ func (m *Manager) internalCompile(router *Router) error {
 	return rows.Err()
 }
 
+// likeEscaper escapes the wildcard characters in LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// TargetFilter limits the routes or redirects returned by a list query, empty
+// fields match every entry
+type TargetFilter struct {
+	Host    string       // host of the source
+	Dst     string       // substring of the destination
+	Flags   target.Flags // flags which must all be set
+	Domains []string     // source must be on one of these domains or a subdomain, nil matches every source
+	Deleted bool         // only match entries in the recycle bin instead of excluding them
+}
+
+// sourceHost is the source without the path
+const sourceHost = `substr(source, 1, instr(source || '/', '/') - 1)`
+
+// where returns the WHERE clause and arguments for the filter
+func (f TargetFilter) where() (string, []any) {
+	clauses := make([]string, 0, 5)
+	args := make([]any, 0, 5)
+	if f.Deleted {
+		clauses = append(clauses, `deleted != 0`)
+	} else {
+		clauses = append(clauses, `deleted = 0`)
+	}
+	if f.Host != "" {
+		host := likeEscaper.Replace(normaliseHost(f.Host))
+		clauses = append(clauses, `(source LIKE ? ESCAPE '\' OR source LIKE ? ESCAPE '\')`)
+		args = append(args, host+"/%", host+":%")
+	}
+	if f.Dst != "" {
+		clauses = append(clauses, `destination LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(f.Dst)+"%")
+	}
+	if f.Flags != 0 {
+		clauses = append(clauses, `flags & ? = ?`)
+		args = append(args, f.Flags, f.Flags)
+	}
+	if f.Domains != nil {
+		// an empty list matches nothing
+		domains := []string{`0`}
+		for _, i := range f.Domains {
+			d := likeEscaper.Replace(normaliseHost(i))
+			domains = append(domains, `(`+sourceHost+` LIKE ? ESCAPE '\' OR `+sourceHost+` LIKE ? ESCAPE '\' OR `+sourceHost+` LIKE ? ESCAPE '\' OR `+sourceHost+` LIKE ? ESCAPE '\')`)
+			args = append(args, d, d+":%", "%."+d, "%."+d+":%")
+		}
+		clauses = append(clauses, `(`+strings.Join(domains, " OR ")+`)`)
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args
+}
+
 func (m *Manager) GetAllRoutes() ([]target.RouteWithActive, error) {
-	s := make([]target.RouteWithActive, 0)
+	s, _, err := m.ListRoutes(TargetFilter{}, 0, -1)
+	return s, err
+}
 
-	query, err := m.db.Query(`SELECT source, destination, flags, active FROM routes`)
+// ListRoutes returns the routes matching the filter ordered by id and the total
+// number of matching routes for pagination, a negative limit returns every
+// route after the offset.
+func (m *Manager) ListRoutes(f TargetFilter, offset, limit int) ([]target.RouteWithActive, int, error) {
+	where, args := f.where()
+	var total int
+	if err := m.db.QueryRow(`SELECT COUNT(*) FROM routes`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query, err := m.db.Query(`SELECT id, source, destination, flags, active, deleted FROM routes`+where+` ORDER BY id LIMIT ? OFFSET ?`, append(args, limit, offset)...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	defer query.Close()
 
+	s := make([]target.RouteWithActive, 0)
 	for query.Next() {
 		var a target.RouteWithActive
-		if query.Scan(&a.Src, &a.Dst, &a.Flags, &a.Active) != nil {
-			return nil, err
+		if err := query.Scan(&a.Id, &a.Src, &a.Dst, &a.Flags, &a.Active, &a.Deleted); err != nil {
+			return nil, 0, err
 		}
 		s = append(s, a)
 	}
+	return s, total, query.Err()
+}
 
-	return s, nil
+// GetRoute returns the route with the id, fs.ErrNotExist is returned if the
+// route doesn't exist.
+func (m *Manager) GetRoute(id int64) (target.RouteWithActive, error) {
+	var a target.RouteWithActive
+	err := m.db.QueryRow(`SELECT id, source, destination, flags, active, deleted FROM routes WHERE id = ?`, id).Scan(&a.Id, &a.Src, &a.Dst, &a.Flags, &a.Active, &a.Deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, fs.ErrNotExist
+	}
+	return a, err
 }
 
 func (m *Manager) InsertRoute(route target.Route) error {
-	_, err := m.db.Exec(`INSERT INTO routes (source, destination, flags) VALUES (?, ?, ?) ON CONFLICT(source) DO UPDATE SET destination = excluded.destination, flags = excluded.flags, active = 1`, route.Src, route.Dst, route.Flags)
+	_, err := m.db.Exec(`INSERT INTO routes (source, destination, flags) VALUES (?, ?, ?) ON CONFLICT(source) DO UPDATE SET destination = excluded.destination, flags = excluded.flags, active = 1, deleted = 0`, route.Src, route.Dst, route.Flags)
 	return err
 }
 
+// DeleteRoute moves the route to the recycle bin, it is excluded from the
+// router until restored and purged after the retention.
 func (m *Manager) DeleteRoute(source string) error {
-	_, err := m.db.Exec(`UPDATE routes SET active = 0 WHERE source = ?`, source)
+	_, err := m.db.Exec(`UPDATE routes SET deleted = ? WHERE source = ? AND deleted = 0`, time.Now().Unix(), source)
 	return err
 }
 
 func (m *Manager) GetAllRedirects() ([]target.RedirectWithActive, error) {
-	s := make([]target.RedirectWithActive, 0)
+	s, _, err := m.ListRedirects(TargetFilter{}, 0, -1)
+	return s, err
+}
+
+// ListRedirects returns the redirects matching the filter ordered by id and the
+// total number of matching redirects for pagination, a negative limit returns
+// every redirect after the offset.
+func (m *Manager) ListRedirects(f TargetFilter, offset, limit int) ([]target.RedirectWithActive, int, error) {
+	where, args := f.where()
+	var total int
+	if err := m.db.QueryRow(`SELECT COUNT(*) FROM redirects`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
 
-	query, err := m.db.Query(`SELECT source, destination, flags, code, active FROM redirects`)
+	query, err := m.db.Query(`SELECT id, source, destination, flags, code, active, deleted FROM redirects`+where+` ORDER BY id LIMIT ? OFFSET ?`, append(args, limit, offset)...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	defer query.Close()
 
+	s := make([]target.RedirectWithActive, 0)
 	for query.Next() {
 		var a target.RedirectWithActive
-		if query.Scan(&a.Src, &a.Dst, &a.Flags, &a.Code, &a.Active) != nil {
-			return nil, err
+		if err := query.Scan(&a.Id, &a.Src, &a.Dst, &a.Flags, &a.Code, &a.Active, &a.Deleted); err != nil {
+			return nil, 0, err
 		}
 		s = append(s, a)
 	}
+	return s, total, query.Err()
+}
 
-	return s, nil
+// GetRedirect returns the redirect with the id, fs.ErrNotExist is returned if
+// the redirect doesn't exist.
+func (m *Manager) GetRedirect(id int64) (target.RedirectWithActive, error) {
+	var a target.RedirectWithActive
+	err := m.db.QueryRow(`SELECT id, source, destination, flags, code, active, deleted FROM redirects WHERE id = ?`, id).Scan(&a.Id, &a.Src, &a.Dst, &a.Flags, &a.Code, &a.Active, &a.Deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, fs.ErrNotExist
+	}
+	return a, err
 }
 
 func (m *Manager) InsertRedirect(redirect target.Redirect) error {
-	_, err := m.db.Exec(`INSERT INTO redirects (source, destination, flags, code) VALUES (?, ?, ?, ?) ON CONFLICT(source) DO UPDATE SET destination = excluded.destination, flags = excluded.flags, code = excluded.code, active = 1`, redirect.Src, redirect.Dst, redirect.Flags, redirect.Code)
+	_, err := m.db.Exec(`INSERT INTO redirects (source, destination, flags, code) VALUES (?, ?, ?, ?) ON CONFLICT(source) DO UPDATE SET destination = excluded.destination, flags = excluded.flags, code = excluded.code, active = 1, deleted = 0`, redirect.Src, redirect.Dst, redirect.Flags, redirect.Code)
 	return err
 }
 
+// DeleteRedirect moves the redirect to the recycle bin, it is excluded from
+// the router until restored and purged after the retention.
 func (m *Manager) DeleteRedirect(source string) error {
-	_, err := m.db.Exec(`UPDATE redirects SET active = 0 WHERE source = ?`, source)
+	_, err := m.db.Exec(`UPDATE redirects SET deleted = ? WHERE source = ? AND deleted = 0`, time.Now().Unix(), source)
 	return err
 }
+
+// ApplyRoutes adds or updates the routes in put and moves the sources in del to
+// the recycle bin in a single transaction.
+func (m *Manager) ApplyRoutes(put []target.Route, del []string) error {
+	args := make([][]any, len(put))
+	for i, route := range put {
+		args[i] = []any{route.Src, route.Dst, route.Flags}
+	}
+	return m.applyTargets("routes", `INSERT INTO routes (source, destination, flags) VALUES (?, ?, ?) ON CONFLICT(source) DO UPDATE SET destination = excluded.destination, flags = excluded.flags, active = 1, deleted = 0`, args, del)
+}
+
+// ApplyRedirects adds or updates the redirects in put and moves the sources in
+// del to the recycle bin in a single transaction.
+func (m *Manager) ApplyRedirects(put []target.Redirect, del []string) error {
+	args := make([][]any, len(put))
+	for i, redirect := range put {
+		args[i] = []any{redirect.Src, redirect.Dst, redirect.Flags, redirect.Code}
+	}
+	return m.applyTargets("redirects", `INSERT INTO redirects (source, destination, flags, code) VALUES (?, ?, ?, ?) ON CONFLICT(source) DO UPDATE SET destination = excluded.destination, flags = excluded.flags, code = excluded.code, active = 1, deleted = 0`, args, del)
+}
+
+// applyTargets runs the insert query for each set of arguments then moves the
+// sources in del to the recycle bin
+func (m *Manager) applyTargets(table, insert string, put [][]any, del []string) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, args := range put {
+		if _, err := tx.Exec(insert, args...); err != nil {
+			return err
+		}
+	}
+	now := time.Now().Unix()
+	for _, src := range del {
+		if _, err := tx.Exec(`UPDATE `+table+` SET deleted = ? WHERE source = ? AND deleted = 0`, now, src); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ImportTx adds or replaces the routes and redirects by source using a
+// transaction shared with other tables, the id of each entry is ignored.
+func (m *Manager) ImportTx(tx *sql.Tx, routes []target.RouteWithActive, redirects []target.RedirectWithActive) error {
+	for _, a := range routes {
+		_, err := tx.Exec(`INSERT INTO routes (source, destination, flags, active) VALUES (?, ?, ?, ?) ON CONFLICT(source) DO UPDATE SET destination = excluded.destination, flags = excluded.flags, active = excluded.active, deleted = 0`, a.Src, a.Dst, a.Flags, a.Active)
+		if err != nil {
+			return err
+		}
+	}
+	for _, a := range redirects {
+		_, err := tx.Exec(`INSERT INTO redirects (source, destination, flags, code, active) VALUES (?, ?, ?, ?, ?) ON CONFLICT(source) DO UPDATE SET destination = excluded.destination, flags = excluded.flags, code = excluded.code, active = excluded.active, deleted = 0`, a.Src, a.Dst, a.Flags, a.Code, a.Active)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeHost removes the routes and redirects for the host and its subdomains
+// along with their history, sources where keep returns true for the source
+// host are not removed.
+func (m *Manager) PurgeHost(host string, keep func(host string) bool) error {
+	host = normaliseHost(host)
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, i := range []struct{ table, history, column string }{
+		{"routes", "route_history", "route_id"},
+		{"redirects", "redirect_history", "redirect_id"},
+	} {
+		rows, err := tx.Query(`SELECT id, source FROM ` + i.table)
+		if err != nil {
+			return err
+		}
+		ids := make([]int64, 0)
+		for rows.Next() {
+			var id int64
+			var src string
+			if err := rows.Scan(&id, &src); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			h, _ := utils.SplitHostPath(src)
+			h, _, _ = utils.SplitDomainPort(h, 0)
+			h = normaliseHost(h)
+			if h != host && !strings.HasSuffix(h, "."+host) {
+				continue
+			}
+			if keep != nil && keep(h) {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if _, err := tx.Exec(`DELETE FROM `+i.table+` WHERE id = ?`, id); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`DELETE FROM `+i.history+` WHERE `+i.column+` = ?`, id); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// ErrSourceExists is returned when an update uses the source of another entry
+var ErrSourceExists = errors.New("source is used by another entry")
+
+// UpdateRoute replaces the route with the same id, fs.ErrNotExist is returned
+// if the route doesn't exist.
+func (m *Manager) UpdateRoute(a target.RouteWithActive) error {
+	return m.updateTarget("routes", a.Id, a.Src, `UPDATE routes SET source = ?, destination = ?, flags = ?, active = ? WHERE id = ?`, a.Src, a.Dst, a.Flags, a.Active, a.Id)
+}
+
+// UpdateRedirect replaces the redirect with the same id, fs.ErrNotExist is
+// returned if the redirect doesn't exist.
+func (m *Manager) UpdateRedirect(a target.RedirectWithActive) error {
+	return m.updateTarget("redirects", a.Id, a.Src, `UPDATE redirects SET source = ?, destination = ?, flags = ?, code = ?, active = ? WHERE id = ?`, a.Src, a.Dst, a.Flags, a.Code, a.Active, a.Id)
+}
+
+// PutRoute creates or replaces the route with the client supplied id and
+// restores it from the recycle bin, created is true if the id was unused.
+func (m *Manager) PutRoute(a target.RouteWithActive) (created bool, err error) {
+	return m.putTarget("routes", a.Id, a.Src,
+		`INSERT INTO routes (id, source, destination, flags, active) VALUES (?, ?, ?, ?, ?)`, []any{a.Id, a.Src, a.Dst, a.Flags, a.Active},
+		`UPDATE routes SET source = ?, destination = ?, flags = ?, active = ?, deleted = 0 WHERE id = ?`, []any{a.Src, a.Dst, a.Flags, a.Active, a.Id})
+}
+
+// PutRedirect creates or replaces the redirect with the client supplied id and
+// restores it from the recycle bin, created is true if the id was unused.
+func (m *Manager) PutRedirect(a target.RedirectWithActive) (created bool, err error) {
+	return m.putTarget("redirects", a.Id, a.Src,
+		`INSERT INTO redirects (id, source, destination, flags, code, active) VALUES (?, ?, ?, ?, ?, ?)`, []any{a.Id, a.Src, a.Dst, a.Flags, a.Code, a.Active},
+		`UPDATE redirects SET source = ?, destination = ?, flags = ?, code = ?, active = ?, deleted = 0 WHERE id = ?`, []any{a.Src, a.Dst, a.Flags, a.Code, a.Active, a.Id})
+}
+
+// putTarget runs the insert query if the id is unused otherwise the update
+// query, both after checking the source isn't used by another entry
+func (m *Manager) putTarget(table string, id int64, src, insert string, insertArgs []any, update string, updateArgs []any) (bool, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE source = ? AND id != ?`, src, id).Scan(&n); err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, ErrSourceExists
+	}
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE id = ?`, id).Scan(&n); err != nil {
+		return false, err
+	}
+	created := n == 0
+	if created {
+		_, err = tx.Exec(insert, insertArgs...)
+	} else {
+		_, err = tx.Exec(update, updateArgs...)
+	}
+	if err != nil {
+		return false, err
+	}
+	return created, tx.Commit()
+}
+
+// updateTarget runs the update query after checking the source isn't used by
+// another entry in the table
+func (m *Manager) updateTarget(table string, id int64, src, query string, args ...any) error {
+	var n int
+	err := m.db.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE source = ? AND id != ?`, src, id).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrSourceExists
+	}
+	res, err := m.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// RestoreRoute moves the route out of the recycle bin, fs.ErrNotExist is
+// returned if the route doesn't exist or isn't deleted.
+func (m *Manager) RestoreRoute(id int64) error {
+	return m.restoreTarget("routes", id)
+}
+
+// RestoreRedirect moves the redirect out of the recycle bin, fs.ErrNotExist is
+// returned if the redirect doesn't exist or isn't deleted.
+func (m *Manager) RestoreRedirect(id int64) error {
+	return m.restoreTarget("redirects", id)
+}
+
+func (m *Manager) restoreTarget(table string, id int64) error {
+	res, err := m.db.Exec(`UPDATE `+table+` SET deleted = 0 WHERE id = ? AND deleted != 0`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// purgeDeleted removes the routes and redirects deleted before the time along
+// with their history
+func (m *Manager) purgeDeleted(before time.Time) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, i := range []struct{ table, history, column string }{
+		{"routes", "route_history", "route_id"},
+		{"redirects", "redirect_history", "redirect_id"},
+	} {
+		res, err := tx.Exec(`DELETE FROM `+i.table+` WHERE deleted != 0 AND deleted <= ?`, before.Unix())
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM ` + i.history + ` WHERE ` + i.column + ` NOT IN (SELECT id FROM ` + i.table + `)`); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -6,9 +6,11 @@ import (
 	"github.com/MrMelon54/violet/target"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 type fakeTransport struct{ req *http.Request }
@@ -49,3 +51,163 @@ func TestNewManager(t *testing.T) {
 	assert.Equal(t, http.StatusOK, res.StatusCode)
 	assert.NotNil(t, ft.req)
 }
+
+func TestManager_PurgeHost(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestManager_PurgeHost?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, proxy.NewHybridTransport())
+
+	for _, i := range []string{"example.com/", "*.example.com/", "www.example.com:8443/hello", "keep.example.com/", "example.org/"} {
+		assert.NoError(t, m.InsertRoute(target.Route{Src: i, Dst: "127.0.0.1:8080"}))
+	}
+	assert.NoError(t, m.InsertRedirect(target.Redirect{Src: "www.example.com/", Dst: "example.com"}))
+
+	assert.NoError(t, m.PurgeHost("example.com", func(host string) bool { return host == "keep.example.com" }))
+
+	routes, err := m.GetAllRoutes()
+	assert.NoError(t, err)
+	src := make([]string, 0, len(routes))
+	for _, i := range routes {
+		src = append(src, i.Src)
+	}
+	assert.Equal(t, []string{"keep.example.com/", "example.org/"}, src)
+
+	redirects, err := m.GetAllRedirects()
+	assert.NoError(t, err)
+	assert.Len(t, redirects, 0)
+
+	// the history of purged entries is removed too
+	history, err := m.RouteHistory(1)
+	assert.NoError(t, err)
+	assert.Len(t, history, 0)
+	redirectHistory, err := m.RedirectHistory(1)
+	assert.NoError(t, err)
+	assert.Len(t, redirectHistory, 0)
+	history, err = m.RouteHistory(4)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, history)
+}
+
+func TestManager_ListRoutes(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestManager_ListRoutes?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, proxy.NewHybridTransport())
+
+	assert.NoError(t, m.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8080", Flags: target.FlagPre}))
+	assert.NoError(t, m.InsertRoute(target.Route{Src: "example.com:8443/a", Dst: "127.0.0.1:8081", Flags: target.FlagPre | target.FlagAbs}))
+	assert.NoError(t, m.InsertRoute(target.Route{Src: "www.example.com/", Dst: "10.0.0.1:8080"}))
+	assert.NoError(t, m.InsertRoute(target.Route{Src: "example_com/", Dst: "127.0.0.1:8080"}))
+
+	src := func(f TargetFilter, offset, limit int) ([]string, int) {
+		routes, total, err := m.ListRoutes(f, offset, limit)
+		assert.NoError(t, err)
+		s := make([]string, 0, len(routes))
+		for _, i := range routes {
+			s = append(s, i.Src)
+		}
+		return s, total
+	}
+
+	s, total := src(TargetFilter{}, 1, 2)
+	assert.Equal(t, []string{"example.com:8443/a", "www.example.com/"}, s)
+	assert.Equal(t, 4, total)
+
+	s, total = src(TargetFilter{Host: "example.com"}, 0, -1)
+	assert.Equal(t, []string{"example.com/", "example.com:8443/a"}, s)
+	assert.Equal(t, 2, total)
+
+	s, _ = src(TargetFilter{Dst: "127.0.0.1"}, 0, -1)
+	assert.Equal(t, []string{"example.com/", "example.com:8443/a", "example_com/"}, s)
+
+	s, _ = src(TargetFilter{Host: "example.com", Flags: target.FlagAbs}, 0, -1)
+	assert.Equal(t, []string{"example.com:8443/a"}, s)
+
+	s, total = src(TargetFilter{Domains: []string{"example.com"}}, 0, -1)
+	assert.Equal(t, []string{"example.com/", "example.com:8443/a", "www.example.com/"}, s)
+	assert.Equal(t, 3, total)
+
+	s, total = src(TargetFilter{Domains: []string{}}, 0, -1)
+	assert.Equal(t, []string{}, s)
+	assert.Equal(t, 0, total)
+}
+
+func TestManager_RecycleBin(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestManager_RecycleBin?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, proxy.NewHybridTransport())
+
+	assert.NoError(t, m.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}))
+	assert.NoError(t, m.InsertRedirect(target.Redirect{Src: "www.example.com/", Dst: "example.com", Code: http.StatusFound}))
+	assert.NoError(t, m.DeleteRoute("example.com/"))
+	assert.NoError(t, m.DeleteRedirect("www.example.com/"))
+
+	// deleted entries are hidden from the list and the router
+	routes, err := m.GetAllRoutes()
+	assert.NoError(t, err)
+	assert.Len(t, routes, 0)
+	r := New(nil)
+	assert.NoError(t, m.internalCompile(r))
+	assert.Len(t, r.route, 0)
+	assert.Len(t, r.redirect, 0)
+
+	deleted, total, err := m.ListRoutes(TargetFilter{Deleted: true}, 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.NotZero(t, deleted[0].Deleted)
+
+	// restoring only works for deleted entries
+	assert.NoError(t, m.RestoreRoute(1))
+	assert.ErrorIs(t, m.RestoreRoute(1), fs.ErrNotExist)
+	routes, err = m.GetAllRoutes()
+	assert.NoError(t, err)
+	assert.Len(t, routes, 1)
+
+	// entries deleted before the retention are purged with their history
+	assert.NoError(t, m.purgeDeleted(time.Now().Add(time.Minute)))
+	_, err = m.GetRedirect(1)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	history, err := m.RedirectHistory(1)
+	assert.NoError(t, err)
+	assert.Len(t, history, 0)
+	_, err = m.GetRoute(1)
+	assert.NoError(t, err)
+}
+
+func TestManager_PutRoute(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestManager_PutRoute?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, proxy.NewHybridTransport())
+
+	route := target.RouteWithActive{Id: 5, Route: target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}, Active: true}
+	created, err := m.PutRoute(route)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	created, err = m.PutRoute(route)
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	// repeating the same put doesn't add a version
+	history, err := m.RouteHistory(5)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	// the source of another route can't be used
+	_, err = m.PutRoute(target.RouteWithActive{Id: 6, Route: target.Route{Src: "example.com/", Dst: "127.0.0.1:9090"}, Active: true})
+	assert.ErrorIs(t, err, ErrSourceExists)
+
+	// deleted routes are restored
+	assert.NoError(t, m.DeleteRoute("example.com/"))
+	route.Dst = "127.0.0.1:9090"
+	created, err = m.PutRoute(route)
+	assert.NoError(t, err)
+	assert.False(t, created)
+	got, err := m.GetRoute(5)
+	assert.NoError(t, err)
+	assert.Equal(t, route, got)
+
+	// new routes without an id continue after the client supplied id
+	assert.NoError(t, m.InsertRoute(target.Route{Src: "www.example.com/", Dst: "127.0.0.1:8080"}))
+	routes, err := m.GetAllRoutes()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), routes[1].Id)
+}
@@ -0,0 +1,42 @@
+package router
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// deletedColumn marks entries in the recycle bin, it is missing from tables
+// created by older versions
+const deletedColumn = "deleted"
+
+// addDeletedColumn adds the deleted column to the table if it doesn't exist
+func addDeletedColumn(db *sql.DB, table string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	found := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var def sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &def, &pk); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if name == deletedColumn {
+			found = true
+		}
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if found {
+		return nil
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s INTEGER DEFAULT 0", table, deletedColumn)); err != nil {
+		return fmt.Errorf("failed to add column '%s': %w", deletedColumn, err)
+	}
+	return nil
+}
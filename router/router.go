@@ -15,8 +15,25 @@ type Router struct {
 	redirect map[string]*trie.Trie[target.Redirect]
 	notFound http.Handler
 	proxy    *proxy.HybridTransport
+	settings SettingsProvider
+	maintain MaintenanceHandler
+	onError  target.UpstreamErrorHandler
+	errors   ErrorHandler
 }
 
+// SettingsProvider returns the per-domain settings used while routing
+type SettingsProvider interface {
+	GetSettings(host string) (utils.DomainSettings, bool)
+}
+
+// MaintenanceHandler serves requests to routes flagged for maintenance, next
+// serves the route for requests which bypass maintenance
+type MaintenanceHandler func(rw http.ResponseWriter, req *http.Request, next http.Handler)
+
+// ErrorHandler writes the error responses generated by the router, the message
+// describes the error for operators
+type ErrorHandler func(rw http.ResponseWriter, req *http.Request, code int, msg string)
+
 func New(proxy *proxy.HybridTransport) *Router {
 	return &Router{
 		route:    make(map[string]*trie.Trie[target.Route]),
@@ -46,14 +63,40 @@ func (r *Router) hostRedirect(host string) *trie.Trie[target.Redirect] {
 	return h
 }
 
+// SetDomainSettings sets the provider used to find the wildcard depth of each
+// domain, wildcards only match a single subdomain level without a provider
+func (r *Router) SetDomainSettings(settings SettingsProvider) {
+	r.settings = settings
+}
+
+// SetMaintenance sets the handler for routes flagged for maintenance, a plain
+// 503 response is used without a handler
+func (r *Router) SetMaintenance(h MaintenanceHandler) {
+	r.maintain = h
+}
+
+// SetUpstreamErrors sets the handler which writes the response when the backend
+// of a route fails, a plain response is used without a handler
+func (r *Router) SetUpstreamErrors(h target.UpstreamErrorHandler) {
+	r.onError = h
+}
+
+// SetErrorPages sets the handler which writes the error responses generated by
+// the router, plain text responses are used without a handler
+func (r *Router) SetErrorPages(h ErrorHandler) {
+	r.errors = h
+}
+
 func (r *Router) AddRoute(t target.Route) {
 	t.Proxy = r.proxy
 	host, path := utils.SplitHostPath(t.Src)
+	host = normaliseHost(host)
 	r.hostRoute(host).PutString(path, t)
 }
 
 func (r *Router) AddRedirect(t target.Redirect) {
 	host, path := utils.SplitHostPath(t.Src)
+	host = normaliseHost(host)
 	r.hostRedirect(host).PutString(path, t)
 }
 
@@ -63,6 +106,7 @@ func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	host, _, _ := utils.SplitDomainPort(req.Host, 0)
+	host = normaliseHost(host)
 	if r.serveRedirectHTTP(rw, req, host) {
 		return
 	}
@@ -70,22 +114,48 @@ func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	parentHostDot := strings.IndexByte(host, '.')
-	if parentHostDot == -1 {
+	if strings.IndexByte(host, '.') == -1 {
+		if r.errors != nil {
+			r.errors(rw, req, http.StatusNotFound, "Unknown host")
+			return
+		}
 		r.notFound.ServeHTTP(rw, req)
 		return
 	}
 
-	wildcardHost := "*" + host[parentHostDot:]
+	// try the wildcard of each parent starting with the closest, the domain
+	// settings control how many subdomain levels a wildcard can match
+	parent := host
+	for depth := r.wildcardDepth(host); depth > 0; depth-- {
+		n := strings.IndexByte(parent, '.')
+		if n == -1 {
+			break
+		}
+		parent = parent[n+1:]
+		wildcardHost := "*." + parent
 
-	if r.serveRedirectHTTP(rw, req, wildcardHost) {
-		return
+		if r.serveRedirectHTTP(rw, req, wildcardHost) {
+			return
+		}
+		if r.serveRouteHTTP(rw, req, wildcardHost) {
+			return
+		}
 	}
-	if r.serveRouteHTTP(rw, req, wildcardHost) {
+
+	if r.serveDefaultBackend(rw, req, host) {
 		return
 	}
 
-	utils.RespondVioletError(rw, http.StatusTeapot, "No route")
+	r.respondError(rw, req, http.StatusTeapot, "No route")
+}
+
+// respondError writes the error response using the error handler if set
+func (r *Router) respondError(rw http.ResponseWriter, req *http.Request, code int, msg string) {
+	if r.errors != nil {
+		r.errors(rw, req, code, msg)
+		return
+	}
+	utils.RespondVioletError(rw, code, msg)
 }
 
 func (r *Router) serveRouteHTTP(rw http.ResponseWriter, req *http.Request, host string) bool {
@@ -95,7 +165,7 @@ func (r *Router) serveRouteHTTP(rw http.ResponseWriter, req *http.Request, host
 		for i := len(pairs) - 1; i >= 0; i-- {
 			if pairs[i].Value.HasFlag(target.FlagPre) || pairs[i].Key == req.URL.Path {
 				req.URL.Path = strings.TrimPrefix(req.URL.Path, pairs[i].Key)
-				pairs[i].Value.ServeHTTP(rw, req)
+				r.serveRoute(rw, req, pairs[i].Value)
 				return true
 			}
 		}
@@ -103,6 +173,21 @@ func (r *Router) serveRouteHTTP(rw http.ResponseWriter, req *http.Request, host
 	return false
 }
 
+// serveRoute proxies the request using the route, routes flagged for
+// maintenance use the maintenance handler
+func (r *Router) serveRoute(rw http.ResponseWriter, req *http.Request, route target.Route) {
+	route.OnError = r.onError
+	if !route.HasFlag(target.FlagMaintenance) {
+		route.ServeHTTP(rw, req)
+		return
+	}
+	if r.maintain == nil {
+		r.respondError(rw, req, http.StatusServiceUnavailable, "Route is under maintenance")
+		return
+	}
+	r.maintain(rw, req, route)
+}
+
 func (r *Router) serveRedirectHTTP(rw http.ResponseWriter, req *http.Request, host string) bool {
 	h := r.redirect[host]
 	if h != nil {
@@ -117,3 +202,50 @@ func (r *Router) serveRedirectHTTP(rw http.ResponseWriter, req *http.Request, ho
 	}
 	return false
 }
+
+// serveDefaultBackend proxies the request to the default backend of the domain
+// when no route or redirect matches
+func (r *Router) serveDefaultBackend(rw http.ResponseWriter, req *http.Request, host string) bool {
+	if r.settings == nil {
+		return false
+	}
+	settings, ok := r.settings.GetSettings(host)
+	if !ok || settings.DefaultBackend == "" {
+		return false
+	}
+	r.serveRoute(rw, req, defaultBackendRoute(host, settings.DefaultBackend, r.proxy))
+	return true
+}
+
+// defaultBackendRoute returns the catch-all route for the default backend of a
+// domain, the backend can start with `https://` to use a secure connection.
+func defaultBackendRoute(host, backend string, proxy *proxy.HybridTransport) target.Route {
+	flags := target.FlagPre | target.FlagForwardHost | target.FlagForwardAddr
+	if a, ok := strings.CutPrefix(backend, "https://"); ok {
+		backend = a
+		flags |= target.FlagSecureMode
+	} else {
+		backend = strings.TrimPrefix(backend, "http://")
+	}
+	return target.Route{Src: host, Dst: backend, Flags: flags, Proxy: proxy}
+}
+
+// wildcardDepth returns the number of subdomain levels a wildcard can match
+// for the host
+func (r *Router) wildcardDepth(host string) int {
+	if r.settings != nil {
+		if settings, ok := r.settings.GetSettings(host); ok && settings.WildcardDepth > 1 {
+			return settings.WildcardDepth
+		}
+	}
+	return 1
+}
+
+// normaliseHost converts the host to punycode so unicode and punycode hosts
+// are matched consistently, invalid hosts are left unchanged
+func normaliseHost(host string) string {
+	if a, ok := utils.NormaliseDomain(host); ok {
+		return a
+	}
+	return host
+}
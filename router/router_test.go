@@ -3,6 +3,7 @@ package router
 import (
 	"github.com/MrMelon54/violet/proxy"
 	"github.com/MrMelon54/violet/target"
+	"github.com/MrMelon54/violet/utils"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -299,3 +300,144 @@ func TestRouter_AddWildcardRoute(t *testing.T) {
 		}
 	}
 }
+
+func TestRouter_AddRoute_Punycode(t *testing.T) {
+	transSecure := &fakeTransport{}
+	transInsecure := &fakeTransport{}
+
+	r := New(proxy.NewHybridTransportWithCalls(transSecure, transInsecure))
+	r.AddRoute(target.Route{Src: "bücher.example/", Dst: "127.0.0.1:8080", Flags: target.FlagPre})
+
+	req := httptest.NewRequest(http.MethodGet, "https://XN--BCHER-KVA.example/hello", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if transSecure.req == nil {
+		t.Fatal("expected the punycode host to match the unicode route")
+	}
+}
+
+type fakeWildcardDepth int
+
+func (f fakeWildcardDepth) GetSettings(string) (utils.DomainSettings, bool) {
+	return utils.DomainSettings{WildcardDepth: int(f)}, true
+}
+
+func TestRouter_WildcardDepth(t *testing.T) {
+	transSecure := &fakeTransport{}
+	transInsecure := &fakeTransport{}
+
+	r := New(proxy.NewHybridTransportWithCalls(transSecure, transInsecure))
+	r.AddRoute(target.Route{Src: "*.example.com/", Dst: "127.0.0.1:8080", Flags: target.FlagPre})
+
+	// wildcards match a single level by default
+	req := httptest.NewRequest(http.MethodGet, "https://a.b.example.com/hello", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if transSecure.req != nil {
+		t.Fatal("expected the wildcard to only match a single level")
+	}
+	req = httptest.NewRequest(http.MethodGet, "https://b.example.com/hello", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if transSecure.req == nil {
+		t.Fatal("expected the wildcard to match a single level")
+	}
+	transSecure.req = nil
+
+	r.SetDomainSettings(fakeWildcardDepth(2))
+	req = httptest.NewRequest(http.MethodGet, "https://a.b.example.com/hello", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if transSecure.req == nil {
+		t.Fatal("expected the wildcard to match two levels")
+	}
+	transSecure.req = nil
+
+	req = httptest.NewRequest(http.MethodGet, "https://x.a.b.example.com/hello", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if transSecure.req != nil {
+		t.Fatal("expected the wildcard to not match three levels")
+	}
+}
+
+type fakeDefaultBackend string
+
+func (f fakeDefaultBackend) GetSettings(host string) (utils.DomainSettings, bool) {
+	return utils.DomainSettings{DefaultBackend: string(f), WildcardDepth: 1}, host == "example.com"
+}
+
+func TestRouter_DefaultBackend(t *testing.T) {
+	transSecure := &fakeTransport{}
+	transInsecure := &fakeTransport{}
+
+	r := New(proxy.NewHybridTransportWithCalls(transSecure, transInsecure))
+	r.AddRoute(target.Route{Src: "example.com/api", Dst: "127.0.0.1:8081", Flags: target.FlagPre})
+	r.SetDomainSettings(fakeDefaultBackend("https://127.0.0.1:8443/app"))
+
+	// routes are used before the default backend
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/api/hello", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if transSecure.req == nil || transSecure.req.URL.Host != "127.0.0.1:8081" {
+		t.Fatal("expected the route to be used")
+	}
+	transSecure.req = nil
+
+	req = httptest.NewRequest(http.MethodGet, "https://example.com/hello?a=b", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if transSecure.req == nil {
+		t.Fatal("expected the default backend to be used")
+	}
+	if u := transSecure.req.URL.String(); u != "https://127.0.0.1:8443/app/hello?a=b" {
+		t.Fatalf("unexpected default backend url: %s", u)
+	}
+
+	// domains without a default backend still respond with no route
+	rec := httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "https://example.org/hello", nil)
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTeapot {
+		t.Fatalf("expected no route, got %d", rec.Code)
+	}
+}
+
+func TestRouter_Maintenance(t *testing.T) {
+	transSecure := &fakeTransport{}
+	transInsecure := &fakeTransport{}
+
+	r := New(proxy.NewHybridTransportWithCalls(transSecure, transInsecure))
+	r.AddRoute(target.Route{Src: "example.com/admin", Dst: "127.0.0.1:8081", Flags: target.FlagPre | target.FlagMaintenance})
+
+	// a plain 503 is used without a handler
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/admin/hello", nil))
+	if rec.Code != http.StatusServiceUnavailable || transSecure.req != nil {
+		t.Fatalf("expected maintenance response, got %d", rec.Code)
+	}
+
+	// the handler can pass the request to the route
+	r.SetMaintenance(func(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+		if req.RemoteAddr == "192.0.2.1:1234" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/admin/hello", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if transSecure.req == nil || transSecure.req.URL.Path != "/hello" {
+		t.Fatal("expected the route to be used")
+	}
+}
+
+func TestRouter_SetErrorPages(t *testing.T) {
+	r := New(proxy.NewHybridTransportWithCalls(&fakeTransport{}, &fakeTransport{}))
+	var codes []int
+	r.SetErrorPages(func(rw http.ResponseWriter, req *http.Request, code int, msg string) {
+		codes = append(codes, code)
+		rw.WriteHeader(code)
+	})
+
+	// unknown hosts and missing routes use the handler
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://localhost/", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+	if len(codes) != 2 || codes[0] != http.StatusNotFound || codes[1] != http.StatusTeapot {
+		t.Fatalf("expected error handler to be used, got %v", codes)
+	}
+}
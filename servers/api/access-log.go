@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type accessKey struct{}
+
+// accessEntry is a single line of the API access log
+type accessEntry struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"` // empty if the request wasn't authenticated
+	Method   string    `json:"method"`
+	Endpoint string    `json:"endpoint"` // registered path of the endpoint
+	Path     string    `json:"path"`
+	Status   int       `json:"status"`
+	Outcome  string    `json:"outcome"` // success, denied, rejected or error
+	Ip       string    `json:"ip"`
+	Duration int64     `json:"duration_ms"`
+}
+
+// accessLog writes a JSON line for every API request, this is separate from
+// the audit log which only contains successful changes
+type accessLog struct {
+	s *sync.Mutex
+	w io.Writer
+}
+
+// newAccessLog returns nil if w is nil so the access log is disabled
+func newAccessLog(w io.Writer) *accessLog {
+	if w == nil {
+		return nil
+	}
+	return &accessLog{s: &sync.Mutex{}, w: w}
+}
+
+// Wrap records the actor, endpoint, outcome and source address of each
+// request to the endpoint
+func (a *accessLog) Wrap(method, p string, h httprouter.Handle) httprouter.Handle {
+	if a == nil {
+		return h
+	}
+	return func(rw http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		start := time.Now()
+		actor := new(string)
+		sw := &statusWriter{ResponseWriter: rw}
+		h(sw, req.WithContext(context.WithValue(req.Context(), accessKey{}, actor)), params)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		a.write(accessEntry{
+			Time:     start.UTC(),
+			Actor:    *actor,
+			Method:   method,
+			Endpoint: p,
+			Path:     strings.TrimPrefix(req.URL.Path, apiVersion),
+			Status:   sw.status,
+			Outcome:  accessOutcome(sw.status),
+			Ip:       remoteIp(req),
+			Duration: time.Since(start).Milliseconds(),
+		})
+	}
+}
+
+func (a *accessLog) write(e accessEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	a.s.Lock()
+	defer a.s.Unlock()
+	if _, err := a.w.Write(append(b, '\n')); err != nil {
+		log.Printf("[Violet] Failed to write API access log: %s\n", err)
+	}
+}
+
+// accessOutcome groups the status codes for filtering the access log
+func accessOutcome(status int) string {
+	switch {
+	case status < 400:
+		return "success"
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return "denied"
+	case status < 500:
+		return "rejected"
+	default:
+		return "error"
+	}
+}
+
+// setAccessActor sets the actor recorded in the access log for the request
+func setAccessActor(req *http.Request, actor string) {
+	if r, ok := req.Context().Value(accessKey{}).(*string); ok {
+		*r = actor
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewApiServer_AccessLog(t *testing.T) {
+	out := new(bytes.Buffer)
+	api := newTestApi(t, &conf.Conf{ApiAccessLog: out})
+	do := func(method, p, key string) {
+		req := newTestRequest(method, p, key, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		api.serve(req)
+	}
+	do(http.MethodGet, "/v1/domain", fake.GenSnakeOilKeyFor("alice", "violet:domains"))
+	do(http.MethodGet, "/domain", "")
+	do(http.MethodPut, "/v1/domain/example.com", fake.GenSnakeOilKeyFor("bob", "violet:domains", "owns=example.com"))
+	do(http.MethodGet, "/v1/compile/abc", fake.GenSnakeOilKeyFor("alice", "violet:compile"))
+
+	var entries []accessEntry
+	s := bufio.NewScanner(out)
+	for s.Scan() {
+		var e accessEntry
+		assert.NoError(t, json.Unmarshal(s.Bytes(), &e))
+		assert.False(t, e.Time.IsZero())
+		e.Time = time.Time{}
+		e.Duration = 0
+		entries = append(entries, e)
+	}
+	assert.Equal(t, []accessEntry{
+		{Actor: "alice", Method: http.MethodGet, Endpoint: "/domain", Path: "/domain", Status: http.StatusOK, Outcome: "success", Ip: "192.0.2.1"},
+		{Method: http.MethodGet, Endpoint: "/domain", Path: "/domain", Status: http.StatusForbidden, Outcome: "denied", Ip: "192.0.2.1"},
+		{Actor: "bob", Method: http.MethodPut, Endpoint: "/domain/:domain", Path: "/domain/example.com", Status: http.StatusOK, Outcome: "success", Ip: "192.0.2.1"},
+		{Actor: "alice", Method: http.MethodGet, Endpoint: "/compile/:id", Path: "/compile/abc", Status: http.StatusNotFound, Outcome: "rejected", Ip: "192.0.2.1"},
+	}, entries)
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/MrMelon54/mjwt"
+	"github.com/MrMelon54/violet/apikeys"
+	"github.com/julienschmidt/httprouter"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// apiKeyList outputs every api key without the key itself
+func apiKeyList(verify mjwt.Verifier, keys *apikeys.Keys) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:api-keys", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		list, err := keys.List()
+		if err != nil {
+			log.Printf("[Violet] Failed to list api keys: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get api keys from database")
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(list)
+	})
+}
+
+// apiKeyCreate generates a new api key, tokens can only grant permissions they
+// already have and the key uses the subject and ownership claims of the token.
+// Only local users can choose the subject.
+func apiKeyCreate(verify mjwt.Verifier, keys *apikeys.Keys) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:api-keys", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		var j struct {
+			Name    string   `json:"name"`
+			Subject string   `json:"subject"`
+			Perms   []string `json:"perms"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&j); err != nil {
+			apiBodyError(rw, err)
+			return
+		}
+		if j.Name == "" || strings.ContainsAny(j.Name, " \t\r\n/") {
+			apiError(rw, http.StatusBadRequest, "Invalid api key name")
+			return
+		}
+		if !b.Local {
+			for _, perm := range j.Perms {
+				if !hasPerm(b.Claims.Perms, perm) {
+					apiError(rw, http.StatusForbidden, "Cannot grant permission: "+perm)
+					return
+				}
+			}
+			// the subject owns domains so keys act as their creator
+			j.Subject = b.Subject
+
+			// keys without ownership claims are unrestricted so tenants pass
+			// their claims on to the key
+			if !hasOwnsClaimIn(j.Perms) {
+				j.Perms = append(j.Perms, ownsClaims(b.Claims.Perms)...)
+			}
+		}
+
+		token, key, err := keys.Create(j.Name, j.Subject, j.Perms)
+		if errors.Is(err, apikeys.ErrKeyExists) {
+			apiError(rw, http.StatusConflict, "Api key already exists")
+			return
+		}
+		if err != nil {
+			log.Printf("[Violet] Failed to create api key: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to create api key")
+			return
+		}
+		rw.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(rw).Encode(struct {
+			apikeys.Key
+			Token string `json:"token"`
+		}{key, token})
+	})
+}
+
+// apiKeyDelete revokes the api key
+func apiKeyDelete(verify mjwt.Verifier, keys *apikeys.Keys) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:api-keys", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		err := keys.Delete(params.ByName("name"))
+		if errors.Is(err, sql.ErrNoRows) {
+			apiError(rw, http.StatusNotFound, "Unknown api key")
+			return
+		}
+		if err != nil {
+			log.Printf("[Violet] Failed to delete api key: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to delete api key")
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+}
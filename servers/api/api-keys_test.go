@@ -0,0 +1,81 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/MrMelon54/violet/apikeys"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/MrMelon54/violet/utils/fake"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNewApiServer_ApiKeys(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestNewApiServer_ApiKeys?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	keys := apikeys.New(db)
+
+	// only api keys are configured
+	apiConf := &conf.Conf{
+		Domains: &fake.Domains{},
+		Acme:    utils.NewAcmeChallenge(),
+		ApiKeys: keys,
+	}
+	api := &testApi{t: t, conf: apiConf, srv: NewApiServer(apiConf, utils.MultiCompilable{})}
+	admin, _, err := keys.Create("admin", "", []string{"violet:api-keys", "violet:compile"})
+	assert.NoError(t, err)
+
+	// permissions not held by the creator can't be granted
+	rec := api.do(http.MethodPost, "/api-key", admin, strings.NewReader(`{"name":"ci","perms":["violet:domains"]}`))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// the subject of the creator is used
+	rec = api.do(http.MethodPost, "/api-key", admin, strings.NewReader(`{"name":"ci","subject":"other","perms":["violet:compile"]}`))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var created struct {
+		Subject string `json:"subject"`
+		Token   string `json:"token"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	assert.Equal(t, "key:admin", created.Subject)
+
+	// the name must be unique
+	rec = api.do(http.MethodPost, "/api-key", admin, strings.NewReader(`{"name":"ci"}`))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// the new key is scoped to its permissions
+	rec = api.do(http.MethodPost, "/compile", created.Token, nil)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = api.do(http.MethodGet, "/api-key", created.Token, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// mjwt tokens are rejected without a verifier
+	rec = api.do(http.MethodGet, "/api-key", fake.GenSnakeOilKey("violet:api-keys"), nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// tenants can't create keys without their ownership claims
+	tenant, _, err := keys.Create("tenant", "", []string{"violet:api-keys", "violet:domains", "violet:route", "owns=a.com"})
+	assert.NoError(t, err)
+	rec = api.do(http.MethodPost, "/api-key", tenant, strings.NewReader(`{"name":"tenant-ci","perms":["violet:domains","violet:route"]}`))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var scoped struct {
+		Perms []string `json:"perms"`
+		Token string   `json:"token"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&scoped))
+	assert.Equal(t, []string{"violet:domains", "violet:route", "owns=a.com"}, scoped.Perms)
+	rec = api.do(http.MethodPut, "/domain/example.org", scoped.Token, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// revoked keys stop working
+	rec = api.do(http.MethodDelete, "/api-key/ci", admin, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = api.do(http.MethodPost, "/compile", created.Token, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
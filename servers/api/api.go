@@ -2,12 +2,24 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/MrMelon54/mjwt"
 	"github.com/MrMelon54/mjwt/claims"
+	domainsPkg "github.com/MrMelon54/violet/domains"
+	"github.com/MrMelon54/violet/router"
 	"github.com/MrMelon54/violet/servers/conf"
 	"github.com/MrMelon54/violet/utils"
 	"github.com/julienschmidt/httprouter"
+	"github.com/rs/cors"
+	"golang.org/x/net/idna"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"io/fs"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,61 +27,610 @@ import (
 // endpoints for the software
 //
 // `/compile` - reloads all domains, routes and redirects
+//
+// `/health` - reports whether the control plane is usable
 func NewApiServer(conf *conf.Conf, compileTarget utils.MultiCompilable) *http.Server {
-	r := httprouter.New()
+	srv, _ := NewApiServers(conf, compileTarget)
+	return srv
+}
+
+// NewApiServers creates the API http server and the gRPC server offering the
+// management operations, the gRPC server is nil unless GrpcListen is set.
+// Both servers share the audit log, event stream and compile jobs.
+func NewApiServers(conf *conf.Conf, compileTarget utils.MultiCompilable) (*http.Server, *grpc.Server) {
+	r := &apiRouter{r: httprouter.New(), audit: conf.Audit, events: newEventHub(conf.Webhooks), metrics: newApiMetrics(conf.Certs), access: newAccessLog(conf.ApiAccessLog)}
+	r.r.NotFound = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		apiError(rw, http.StatusNotFound, "Unknown endpoint")
+	})
+	r.r.MethodNotAllowed = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		apiError(rw, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+	limits := newApiLimits(conf.ApiRateLimit, conf.ApiAuthFailures)
+	verify := newKeyVerifier(conf.Signer, conf.ApiKeys)
 
 	// Endpoint for compile action
-	r.POST("/compile", checkAuthWithPerm(conf.Signer, "violet:compile", func(rw http.ResponseWriter, req *http.Request, _ httprouter.Params, b AuthClaims) {
+	jobs := newCompileJobs(compileTarget, func(job compileJob) {
+		r.metrics.CompileFinished(job.Status)
+		r.events.Publish(apiEvent{Type: "compile", Path: "/compile/" + strconv.FormatInt(job.Id, 10), Status: job.Status})
+	})
+	if conf.Router != nil {
+		jobs.generation = conf.Router.Generation
+	}
+	r.POST("/compile", endpointDoc{"Reload all domains, routes and redirects", "violet:compile"}, checkAuthWithPerm(verify, "violet:compile", func(rw http.ResponseWriter, req *http.Request, _ httprouter.Params, b AuthClaims) {
 		// Trigger the compile action
-		compileTarget.Compile()
+		id := jobs.Start()
 		rw.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(rw).Encode(map[string]int64{"id": id})
 	}))
+	r.GET("/compile/:id", endpointDoc{"Get the status of a compile job or the last job using `last`", "violet:compile"}, checkAuthWithPerm(verify, "violet:compile", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		job, ok := jobs.Get(params.ByName("id"))
+		if !ok {
+			apiError(rw, http.StatusNotFound, "Unknown compile job")
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(job)
+	}))
+
+	// Endpoint for load balancers and monitors
+	r.GET("/health", endpointDoc{"Get the health state, callers with a token also get the database connectivity, last compile status, configuration generation and listener states", ""}, healthCheck(verify, conf.DB, jobs, conf.Router, conf.Listeners))
+
+	// Endpoint for debugging tokens
+	r.GET("/whoami", endpointDoc{"Get the subject, audience, expiry and permissions of the token", anyPerm}, whoami(verify))
 
 	// Endpoint for domains
-	domainFunc := domainManage(conf.Signer, conf.Domains)
-	r.PUT("/domain/:domain", domainFunc)
-	r.DELETE("/domain/:domain", domainFunc)
+	domainFunc := domainManage(verify, conf.Domains)
+	r.GET("/domain", endpointDoc{"List registered domains", "violet:domains"}, domainList(verify, conf.Domains))
+	r.PATCH("/domain/:domain", endpointDoc{"Update the settings of a domain", "violet:domains"}, domainSettings(verify, conf.Domains))
+	r.POST("/domain/:domain/verify", endpointDoc{"Verify ownership of a domain using DNS", "violet:domains"}, domainVerify(verify, conf.Domains))
+	r.POST("/domain/:domain/purge", endpointDoc{"Remove a disabled domain and its routes", "violet:domains"}, domainPurge(verify, conf.Domains, conf.Router))
+	r.GET("/domain/:domain/audit", endpointDoc{"Get the audit trail of a domain", "violet:domains"}, domainAudit(verify, conf.Domains))
+	r.GET("/domain-export", endpointDoc{"Export domains as JSON or CSV", "violet:domains"}, domainExport(verify, conf.Domains))
+	r.POST("/domain-import", endpointDoc{"Import domains from JSON or CSV", "violet:domains-import"}, domainImport(verify, conf.Domains))
+	r.POST("/domain-batch", endpointDoc{"Add or disable multiple domains", "violet:domains"}, domainBatch(verify, conf.Domains))
+	if eventProvider, ok := conf.Domains.(domainEventProvider); ok {
+		eventProvider.SetEventHook(func(e domainsPkg.Event) {
+			r.events.Publish(apiEvent{Type: "domain", Path: "/domain/" + e.Domain, Domain: e.Domain, Action: e.Action, Time: e.Time.UTC()})
+		})
+		r.GET("/domain-events", endpointDoc{"Stream domain changes as server-sent events", "violet:domains"}, domainEvents(verify, r.events))
+	}
+	r.PUT("/domain/:domain", endpointDoc{"Add or enable a domain", "violet:domains"}, domainFunc)
+	r.DELETE("/domain/:domain", endpointDoc{"Disable a domain", "violet:domains"}, domainFunc)
 
-	SetupTargetApis(r, conf.Signer, conf.Router)
+	SetupTargetApis(r, verify, conf.Domains, conf.Router, conf.AutoRegister, conf.ApiRequireIfMatch)
+	SetupCertApis(r, verify, conf.Certs)
+	SetupFaviconApis(r, verify, conf.Domains, conf.Favicons)
+	SetupConfigApis(r, verify, conf.DB, conf.Domains, conf.Router, conf.Favicons, conf.ErrorPages)
+	SetupErrorPageApis(r, verify, conf.Domains, conf.ErrorPages)
 
 	// Endpoint for acme-challenge
-	acmeChallengeFunc := acmeChallengeManage(conf.Signer, conf.Domains, conf.Acme)
-	r.PUT("/acme-challenge/:domain/:key/:value", acmeChallengeFunc)
-	r.DELETE("/acme-challenge/:domain/:key", acmeChallengeFunc)
+	acmeChallengeFunc := acmeChallengeManage(verify, conf.Domains, conf.Acme)
+	r.GET("/acme-challenge/:domain", endpointDoc{"List the stored ACME HTTP challenges and their ages", "violet:acme-challenge"}, acmeChallengeList(verify, conf.Domains, conf.Acme))
+	r.PUT("/acme-challenge/:domain/:key/:value", endpointDoc{"Add an ACME HTTP challenge", "violet:acme-challenge"}, acmeChallengeFunc)
+	r.DELETE("/acme-challenge/:domain/:key", endpointDoc{"Remove an ACME HTTP challenge", "violet:acme-challenge"}, acmeChallengeFunc)
+
+	// Endpoint for streaming changes
+	r.GET("/events", endpointDoc{"Stream configuration changes and compiles as server-sent events", "violet:events"}, configEvents(verify, r.events))
+
+	// Endpoint for the audit log
+	if conf.Audit != nil {
+		r.GET("/audit", endpointDoc{"List changes made using the API and changes to domains", "violet:audit"}, auditList(verify, conf.Audit))
+	}
+
+	// Endpoint for traffic statistics
+	if conf.Stats != nil {
+		r.GET("/stats/:host", endpointDoc{"Get the recent request counts, status classes and p50/p95 latency of a host", "violet:stats"}, hostStats(verify, conf.Domains, conf.Stats))
+	}
+
+	// Endpoint for api keys
+	if conf.ApiKeys != nil {
+		r.GET("/api-key", endpointDoc{"List api keys", "violet:api-keys"}, apiKeyList(verify, conf.ApiKeys))
+		r.POST("/api-key", endpointDoc{"Create an api key, the key is only returned once", "violet:api-keys"}, apiKeyCreate(verify, conf.ApiKeys))
+		r.DELETE("/api-key/:name", endpointDoc{"Revoke an api key", "violet:api-keys"}, apiKeyDelete(verify, conf.ApiKeys))
+	}
+
+	// Endpoint for prometheus metrics
+	r.GET("/metrics", endpointDoc{"Get API metrics in the Prometheus text format", "violet:metrics"}, metricsHandler(verify, r.metrics))
+
+	// Endpoint for the OpenAPI document
+	r.serveOpenApi()
+
+	// Create the gRPC server
+	var srvGrpc *grpc.Server
+	if conf.GrpcListen != "" && conf.Router != nil {
+		var creds credentials.TransportCredentials
+		if conf.ApiTls != nil {
+			creds = credentials.NewTLS(conf.ApiTls)
+		}
+		srvGrpc = newGrpcServer(&grpcServer{
+			verify:       verify,
+			domains:      conf.Domains,
+			manager:      conf.Router,
+			jobs:         jobs,
+			events:       r.events,
+			audit:        conf.Audit,
+			access:       r.access,
+			autoRegister: conf.AutoRegister,
+			limits:       limits,
+		}, creds)
+	}
 
 	// Create and run http server
-	return &http.Server{
+	srv := &http.Server{
 		Addr:              conf.ApiListen,
-		Handler:           r,
+		Handler:           setupApiCors(conf.ApiCorsOrigins, conf.ApiCorsMethods, limits.Handle(r)),
+		TLSConfig:         conf.ApiTls,
 		ReadTimeout:       time.Minute,
 		ReadHeaderTimeout: time.Minute,
 		WriteTimeout:      time.Minute,
 		IdleTimeout:       time.Minute,
 		MaxHeaderBytes:    2500,
 	}
+	conf.ApiLimits.Apply(srv)
+	return srv, srvGrpc
 }
 
-// apiError outputs a generic JSON error message
-func apiError(rw http.ResponseWriter, code int, m string) {
-	rw.WriteHeader(code)
-	_ = json.NewEncoder(rw).Encode(map[string]string{
-		"error": m,
-	})
+// setupApiCors adds the cors headers for browser based clients hosted on the
+// allowed origins, the handler is unchanged if no origins are allowed
+func setupApiCors(origins, methods []string, next http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return next
+	}
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	return cors.New(cors.Options{
+		AllowedOrigins: origins,
+		AllowedMethods: methods,
+		AllowedHeaders: []string{"Content-Type", "Authorization", "If-Match", "If-None-Match"},
+		ExposedHeaders: []string{"X-Total-Count", "ETag"},
+		MaxAge:         600,
+	}).Handler(next)
+}
+
+// domainEventProvider is implemented by domain providers which report each
+// change to the domain list
+type domainEventProvider interface {
+	SetEventHook(hook func(domainsPkg.Event))
 }
 
 func domainManage(verify mjwt.Verifier, domains utils.DomainProvider) httprouter.Handle {
 	return checkAuthWithPerm(verify, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
-		// add domain with active state
-		domains.Put(params.ByName("domain"), req.Method == http.MethodPut)
+		domain, ok := utils.NormaliseDomain(params.ByName("domain"))
+		if !ok {
+			apiError(rw, http.StatusBadRequest, "Invalid domain")
+			return
+		}
+		owner, ok := checkDomainTenant(rw, domains, domain, b)
+		if !ok {
+			return
+		}
+
+		if req.Method == http.MethodDelete {
+			deleteDomain(domains, domain, b)
+			return
+		}
+
+		// output the challenge if the domain must be verified before activation
+		if c := putDomain(domains, domain, owner, b); c != nil {
+			rw.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(rw).Encode(c)
+		}
+	})
+}
+
+// putDomain adds the domain with the active state and records the subject as
+// the owner of unowned domains, the challenge is returned if the domain must
+// be verified before activation
+func putDomain(domains utils.DomainProvider, domain, owner string, b AuthClaims) *utils.DomainChallenge {
+	domains.Put(domain, true)
+	if owner == "" {
+		if err := domains.SetOwner(domain, b.Subject); err != nil {
+			log.Printf("[Violet] Failed to set domain owner: %s\n", err)
+		}
+	}
+	domains.Compile()
+	if c, err := domains.GetChallenge(domain); err == nil && c != nil {
+		return c
+	}
+	return nil
+}
+
+// deleteDomain only disables the domain so routes are kept until purged
+func deleteDomain(domains utils.DomainProvider, domain string, b AuthClaims) {
+	domains.Delete(domain, b.Subject)
+	domains.Compile()
+}
+
+// domainVerify checks the DNS challenge for the domain and activates it
+func domainVerify(verify mjwt.Verifier, domains utils.DomainProvider) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		domain := params.ByName("domain")
+		if _, ok := checkDomainTenant(rw, domains, domain, b); !ok {
+			return
+		}
+
+		err := domains.Verify(domain)
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrNotExist):
+			apiError(rw, http.StatusNotFound, "Domain not found")
+			return
+		case errors.Is(err, domainsPkg.ErrVerifyFailed):
+			apiError(rw, http.StatusBadRequest, err.Error())
+			return
+		default:
+			log.Printf("[Violet] Failed to verify domain: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to verify domain")
+			return
+		}
+		domains.Compile()
+		rw.WriteHeader(http.StatusOK)
+	})
+}
+
+// domainPurge removes a disabled domain along with the routes and redirects
+// which are no longer covered by another domain
+func domainPurge(verify mjwt.Verifier, domains utils.DomainProvider, manager *router.Manager) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		domain, ok := utils.NormaliseDomain(params.ByName("domain"))
+		if !ok {
+			apiError(rw, http.StatusBadRequest, "Invalid domain")
+			return
+		}
+		if _, ok := checkDomainTenant(rw, domains, domain, b); !ok {
+			return
+		}
+
+		err := domains.Purge(domain, b.Subject)
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrNotExist):
+			apiError(rw, http.StatusNotFound, "Domain not found")
+			return
+		case errors.Is(err, domainsPkg.ErrDomainActive):
+			apiError(rw, http.StatusConflict, err.Error())
+			return
+		default:
+			log.Printf("[Violet] Failed to purge domain: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to purge domain")
+			return
+		}
+		domains.Compile()
+
+		if manager != nil {
+			// keep sources under subdomains which are registered separately
+			keep := func(host string) bool {
+				for ; len(host) > len(domain); host = host[strings.IndexByte(host, '.')+1:] {
+					if _, err := domains.LoadSettings(host); err == nil {
+						return true
+					}
+				}
+				return false
+			}
+			if err := manager.PurgeHost(domain, keep); err != nil {
+				log.Printf("[Violet] Failed to purge domain routes: %s\n", err)
+				apiError(rw, http.StatusInternalServerError, "Failed to purge domain routes")
+				return
+			}
+			manager.Compile()
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+}
+
+// domainAudit outputs the audit trail for the domain
+func domainAudit(verify mjwt.Verifier, domains utils.DomainProvider) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		domain := params.ByName("domain")
+		if _, ok := checkDomainTenant(rw, domains, domain, b); !ok {
+			return
+		}
+		list, err := domains.Audit(domain)
+		if err != nil {
+			log.Printf("[Violet] Failed to get domain audit: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get domain audit from database")
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(list)
+	})
+}
+
+// domainEvents sends each change to the domain list as a server-sent event
+// until the client disconnects
+func domainEvents(verify mjwt.Verifier, hub *eventHub) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		ch, cancel := hub.Subscribe()
+		defer cancel()
+		streamEvents(rw, req, ch, func(e apiEvent) string {
+			if e.Type != "domain" {
+				return ""
+			}
+			return e.Action
+		})
+	})
+}
+
+// domainList outputs the domains owned by the token matching the `q` search
+// filter, the `offset` and `limit` query parameters are used for pagination
+func domainList(verify mjwt.Verifier, domains utils.DomainProvider) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		q := req.URL.Query()
+		offset, limit, ok := parsePagination(rw, q, 50)
+		if !ok {
+			return
+		}
+		list, total, code, msg := listOwnedDomains(domains, q.Get("q"), offset, limit, b)
+		if code != 0 {
+			apiError(rw, code, msg)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(struct {
+			Total   int                 `json:"total"`
+			Domains []utils.DomainEntry `json:"domains"`
+		}{total, list})
+	})
+}
+
+// listOwnedDomains returns the page of domains owned by the token matching the
+// search string and the total number of matching domains, the status code and
+// error message are returned if the domains can't be loaded
+func listOwnedDomains(domains utils.DomainProvider, search string, offset, limit int, b AuthClaims) ([]utils.DomainEntry, int, int, string) {
+	owned, err := ownedDomains(domains, b)
+	if err != nil {
+		log.Printf("[Violet] Failed to get domain owner: %s\n", err)
+		return nil, 0, http.StatusInternalServerError, "Failed to get domain from database"
+	}
+	if owned == nil {
+		list, total, err := domains.List(search, offset, limit)
+		if err != nil {
+			log.Printf("[Violet] Failed to list domains: %s\n", err)
+			return nil, 0, http.StatusInternalServerError, "Failed to get domains from database"
+		}
+		return list, total, 0, ""
+	}
+
+	// the owned domains are filtered before the page is selected
+	list, _, err := domains.List(search, 0, -1)
+	if err != nil {
+		log.Printf("[Violet] Failed to list domains: %s\n", err)
+		return nil, 0, http.StatusInternalServerError, "Failed to get domains from database"
+	}
+	filtered := make([]utils.DomainEntry, 0, len(list))
+	for _, i := range list {
+		if domainVisible(i, owned, b) {
+			filtered = append(filtered, i)
+		}
+	}
+	total := len(filtered)
+	if offset > total {
+		offset = total
+	}
+	if limit > total-offset {
+		limit = total - offset
+	}
+	return filtered[offset : offset+limit], total, 0, ""
+}
+
+// domainVisible returns true if the domain is on one of the owned domains and
+// wasn't registered by another tenant, owned is nil if every domain is visible
+func domainVisible(e utils.DomainEntry, owned []string, b AuthClaims) bool {
+	if owned == nil {
+		return true
+	}
+	return hostInDomains(e.Domain, owned) && (e.Owner == "" || e.Owner == b.Subject)
+}
+
+// parsePagination reads the offset and limit query parameters, the limit is
+// used if the request doesn't contain a limit. An error message is output if
+// either parameter is invalid.
+func parsePagination(rw http.ResponseWriter, q url.Values, limit int) (int, int, bool) {
+	offset := 0
+	var err error
+	if v := q.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			apiError(rw, http.StatusBadRequest, "Invalid offset")
+			return 0, 0, false
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			apiError(rw, http.StatusBadRequest, "Invalid limit")
+			return 0, 0, false
+		}
+	}
+	return offset, limit, true
+}
+
+// domainExport outputs every domain owned by the token with its settings as
+// JSON or as CSV if the `format` query parameter is `csv`
+func domainExport(verify mjwt.Verifier, domains utils.DomainProvider) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		list, err := domains.Export()
+		if err != nil {
+			log.Printf("[Violet] Failed to export domains: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get domains from database")
+			return
+		}
+		owned, err := ownedDomains(domains, b)
+		if err != nil {
+			log.Printf("[Violet] Failed to get domain owner: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get domain from database")
+			return
+		}
+		if owned != nil {
+			filtered := make([]utils.DomainRecord, 0, len(list))
+			for _, i := range list {
+				if domainVisible(i.DomainEntry, owned, b) {
+					filtered = append(filtered, i)
+				}
+			}
+			list = filtered
+		}
+		if req.URL.Query().Get("format") == "csv" {
+			rw.Header().Set("Content-Type", "text/csv")
+			rw.WriteHeader(http.StatusOK)
+			_ = domainsPkg.WriteCsv(rw, list)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(list)
+	})
+}
+
+// domainImport adds or replaces the domains in the request body, a CSV body is
+// expected if the content type is `text/csv` otherwise the body is JSON
+func domainImport(verify mjwt.Verifier, domains utils.DomainProvider) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:domains-import", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		var list []utils.DomainRecord
+		var err error
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "text/csv") {
+			list, err = domainsPkg.ReadCsv(req.Body)
+		} else {
+			list, err = domainsPkg.ReadJson(req.Body)
+		}
+		if err != nil {
+			apiError(rw, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		err = domains.Import(list)
+		switch {
+		case err == nil:
+		case errors.Is(err, domainsPkg.ErrInvalidRecord):
+			apiError(rw, http.StatusBadRequest, err.Error())
+			return
+		default:
+			log.Printf("[Violet] Failed to import domains: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to save domains to database")
+			return
+		}
 		domains.Compile()
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(map[string]int{"imported": len(list)})
+	})
+}
+
+// domainBatch adds and disables the domains in the request body in a single
+// transaction and outputs the challenges of domains waiting for verification
+func domainBatch(verify mjwt.Verifier, domains utils.DomainProvider) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		var j batchJson[string]
+		if err := json.NewDecoder(req.Body).Decode(&j); err != nil {
+			apiBodyError(rw, err)
+			return
+		}
+		for _, list := range [][]string{j.Put, j.Delete} {
+			for i := range list {
+				domain, ok := utils.NormaliseDomain(list[i])
+				if !ok {
+					apiError(rw, http.StatusBadRequest, "Invalid domain")
+					return
+				}
+				if _, ok := checkDomainTenant(rw, domains, domain, b); !ok {
+					return
+				}
+				list[i] = domain
+			}
+		}
+
+		err := domains.Batch(j.Put, j.Delete, b.Subject)
+		switch {
+		case err == nil:
+		case errors.Is(err, domainsPkg.ErrInvalidRecord):
+			apiError(rw, http.StatusBadRequest, err.Error())
+			return
+		default:
+			log.Printf("[Violet] Failed to apply domains: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to save domains to database")
+			return
+		}
+		domains.Compile()
+
+		// output the challenges if any domains must be verified before activation
+		challenges := make(map[string]*utils.DomainChallenge)
+		for _, domain := range j.Put {
+			if c, err := domains.GetChallenge(domain); err == nil && c != nil {
+				challenges[domain] = c
+			}
+		}
+		if len(challenges) > 0 {
+			rw.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(rw).Encode(challenges)
+		}
+	})
+}
+
+// domainSettings updates the settings provided in the request body and outputs
+// the new settings for the domain
+func domainSettings(verify mjwt.Verifier, domains utils.DomainProvider) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		domain := params.ByName("domain")
+		if _, ok := checkDomainTenant(rw, domains, domain, b); !ok {
+			return
+		}
+
+		settings, err := domains.LoadSettings(domain)
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrNotExist):
+			apiError(rw, http.StatusNotFound, "Domain not found")
+			return
+		default:
+			log.Printf("[Violet] Failed to load domain settings: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get domain from database")
+			return
+		}
+
+		setAuditOld(req, settings)
+
+		// fields missing from the body keep their current value
+		if err := json.NewDecoder(req.Body).Decode(&settings); err != nil {
+			apiBodyError(rw, err)
+			return
+		}
+		if settings.WildcardDepth < 1 {
+			apiError(rw, http.StatusBadRequest, "Wildcard depth must be at least 1")
+			return
+		}
+		if settings.DefaultBackend != "" && !validDefaultBackend(settings.DefaultBackend) {
+			apiError(rw, http.StatusBadRequest, "Invalid default backend")
+			return
+		}
+		if settings.Expires < 0 {
+			apiError(rw, http.StatusBadRequest, "Expiry must not be negative")
+			return
+		}
+		if strings.ContainsAny(settings.Hsts, "\r\n") {
+			apiError(rw, http.StatusBadRequest, "Invalid HSTS policy")
+			return
+		}
+		if !utils.ValidAddrList(settings.MaintenanceAllow) {
+			apiError(rw, http.StatusBadRequest, "Invalid maintenance allow list")
+			return
+		}
+
+		if err := domains.PutSettings(domain, settings); err != nil {
+			log.Printf("[Violet] Failed to save domain settings: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to save domain settings")
+			return
+		}
+		domains.Compile()
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(settings)
 	})
 }
 
 func acmeChallengeManage(verify mjwt.Verifier, domains utils.DomainProvider, acme utils.AcmeChallengeProvider) httprouter.Handle {
 	return checkAuthWithPerm(verify, "violet:acme-challenge", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
-		domain := params.ByName("domain")
-		if !domains.IsValid(domain) {
-			utils.RespondVioletError(rw, http.StatusBadRequest, "Invalid ACME challenge domain")
+		domain, ok := utils.NormaliseDomain(params.ByName("domain"))
+		if !ok || !domains.IsValid(domain) {
+			apiError(rw, http.StatusBadRequest, "Invalid ACME challenge domain")
 			return
 		}
 		if req.Method == http.MethodPut {
@@ -81,13 +642,114 @@ func acmeChallengeManage(verify mjwt.Verifier, domains utils.DomainProvider, acm
 	})
 }
 
+// acmeChallengeList outputs the challenges stored for the domain, stale
+// challenges have already expired and are not included
+func acmeChallengeList(verify mjwt.Verifier, domains utils.DomainProvider, acme utils.AcmeChallengeProvider) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:acme-challenge", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		domain, ok := utils.NormaliseDomain(params.ByName("domain"))
+		if !ok || !domains.IsValid(domain) {
+			apiError(rw, http.StatusBadRequest, "Invalid ACME challenge domain")
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(acme.List(domain))
+	})
+}
+
+// validDefaultBackend returns true if the backend is a `host[:port][/path]`
+// destination with an optional http or https scheme
+func validDefaultBackend(backend string) bool {
+	backend = strings.TrimPrefix(strings.TrimPrefix(backend, "https://"), "http://")
+	host, _ := utils.SplitHostPath(backend)
+	return host != "" && !strings.Contains(backend, "://") && !strings.ContainsAny(backend, " \t\r\n")
+}
+
+// checkDomainTenant validates the token owns the domain using the `owns=<fqdn>`
+// claim and the owner recorded in the domain list, an error message is output
+// if the token cannot manage the domain. Tokens without any `owns=<fqdn>`
+// claims are unrestricted. The recorded owner is returned.
+func checkDomainTenant(rw http.ResponseWriter, domains utils.DomainProvider, domain string, b AuthClaims) (string, bool) {
+	owner, code, msg := domainTenantErr(domains, domain, b)
+	if code != 0 {
+		apiError(rw, code, msg)
+		return "", false
+	}
+	return owner, true
+}
+
+// parseHostParam reads the host parameter and checks the token owns the host,
+// an error message is output if the host can't be modified
+func parseHostParam(rw http.ResponseWriter, domains utils.DomainProvider, params httprouter.Params, b AuthClaims) (string, bool) {
+	host, ok := utils.NormaliseDomain(params.ByName("host"))
+	if !ok || host == "" {
+		apiError(rw, http.StatusBadRequest, "Invalid host")
+		return "", false
+	}
+	if _, ok := checkDomainTenant(rw, domains, host, b); !ok {
+		return "", false
+	}
+	return host, true
+}
+
+// domainTenantErr returns the current owner of the domain or the status code
+// and error message if the token can't modify the domain
+func domainTenantErr(domains utils.DomainProvider, domain string, b AuthClaims) (string, int, string) {
+	restricted := !b.Local && hasOwnsClaim(b.Claims.Perms)
+	if restricted && !validateDomainOwnershipClaims(domain, b.Claims.Perms) {
+		return "", http.StatusBadRequest, "Token cannot modify the specified domain"
+	}
+	owner, err := domains.GetOwner(domain)
+	if err != nil {
+		log.Printf("[Violet] Failed to get domain owner: %s\n", err)
+		return "", http.StatusInternalServerError, "Failed to get domain from database"
+	}
+	if restricted && owner != "" && owner != b.Subject {
+		return "", http.StatusForbidden, "Domain is owned by another tenant"
+	}
+	return owner, 0, ""
+}
+
+// hasOwnsClaim returns true if the permissions contain an `owns=<fqdn>` claim
+func hasOwnsClaim(perms *claims.PermStorage) bool {
+	return hasOwnsClaimIn(permList(perms))
+}
+
+// hasOwnsClaimIn returns true if the list contains an `owns=<fqdn>` claim
+func hasOwnsClaimIn(perms []string) bool {
+	for _, i := range perms {
+		if strings.HasPrefix(i, "owns=") {
+			return true
+		}
+	}
+	return false
+}
+
+// ownsClaims returns the `owns=<fqdn>` claims from the permissions
+func ownsClaims(perms *claims.PermStorage) []string {
+	var owns []string
+	for _, i := range permList(perms) {
+		if strings.HasPrefix(i, "owns=") {
+			owns = append(owns, i)
+		}
+	}
+	return owns
+}
+
 // validateDomainOwnershipClaims validates if the claims contain the
-// `owns=<fqdn>` field with the matching top level domain
+// `owns=<fqdn>` field with the matching top level domain, the fqdn in the
+// claim can be in either unicode or punycode form
 func validateDomainOwnershipClaims(a string, perms *claims.PermStorage) bool {
+	a, ok := utils.NormaliseDomain(a)
+	if !ok {
+		return false
+	}
 	if fqdn, ok := utils.GetTopFqdn(a); ok {
 		if perms.Has("owns=" + fqdn) {
 			return true
 		}
+		if u, err := idna.ToUnicode(fqdn); err == nil && perms.Has("owns="+u) {
+			return true
+		}
 	}
 	return false
 }
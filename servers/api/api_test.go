@@ -1,15 +1,85 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"github.com/MrMelon54/mjwt/claims"
 	"github.com/MrMelon54/violet/servers/conf"
 	"github.com/MrMelon54/violet/utils"
 	"github.com/MrMelon54/violet/utils/fake"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// testApi is an API server used by the tests
+type testApi struct {
+	t    *testing.T
+	conf *conf.Conf
+	srv  *http.Server
+}
+
+// newTestApi creates an API server from the configuration filled in by newTestConf
+func newTestApi(t *testing.T, apiConf *conf.Conf, compile ...utils.Compilable) *testApi {
+	apiConf = newTestConf(apiConf)
+	return &testApi{t: t, conf: apiConf, srv: NewApiServer(apiConf, compile)}
+}
+
+// newTestConf uses fake domains, an ACME challenge store and the snake oil
+// signer unless they are set in the configuration
+func newTestConf(apiConf *conf.Conf) *conf.Conf {
+	if apiConf == nil {
+		apiConf = &conf.Conf{}
+	}
+	if apiConf.Domains == nil {
+		apiConf.Domains = &fake.Domains{}
+	}
+	if apiConf.Acme == nil {
+		apiConf.Acme = utils.NewAcmeChallenge()
+	}
+	if apiConf.Signer == nil {
+		apiConf.Signer = fake.SnakeOilProv
+	}
+	return apiConf
+}
+
+// newTestRequest creates a request for the API server, the key is sent as the
+// bearer token unless it is empty
+func newTestRequest(method, p, key string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, "https://example.com"+p, body)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	return req
+}
+
+// do sends a request created by newTestRequest to the API server
+func (a *testApi) do(method, p, key string, body io.Reader) *httptest.ResponseRecorder {
+	return a.serve(newTestRequest(method, p, key, body))
+}
+
+// serve sends the request to the API server
+func (a *testApi) serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	a.srv.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// getJson sends a GET request, checks the response is 200 OK and returns the
+// decoded body
+func getJson[T any](a *testApi, p, key string) T {
+	var out T
+	rec := a.do(http.MethodGet, p, key, nil)
+	assert.Equal(a.t, http.StatusOK, rec.Code)
+	assert.NoError(a.t, json.NewDecoder(rec.Body).Decode(&out))
+	return out
+}
+
 func TestNewApiServer_Compile(t *testing.T) {
 	apiConf := &conf.Conf{
 		Domains: &fake.Domains{},
@@ -34,7 +104,124 @@ func TestNewApiServer_Compile(t *testing.T) {
 	srv.Handler.ServeHTTP(rec, req)
 	res = rec.Result()
 	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	assert.JSONEq(t, `{"id":1}`, rec.Body.String())
+
+	// wait for the job to finish
+	var job compileJob
+	assert.Eventually(t, func() bool {
+		req, err := http.NewRequest(http.MethodGet, "https://example.com/v1/compile/last", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+fake.GenSnakeOilKey("violet:compile"))
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&job))
+		return job.Finished != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), job.Id)
+	assert.Equal(t, "done", job.Status)
+	assert.Equal(t, []compileResult{{Name: "fake", Duration: job.Results[0].Duration}}, job.Results)
 	assert.True(t, f.Done)
+
+	// unknown job
+	req, err = http.NewRequest(http.MethodGet, "https://example.com/v1/compile/2", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+fake.GenSnakeOilKey("violet:compile"))
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestNewApiServer_Whoami(t *testing.T) {
+	api := newTestApi(t, nil)
+
+	rec := api.do(http.MethodGet, "/v1/whoami", "", nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = api.do(http.MethodGet, "/v1/whoami", fake.GenSnakeOilKey("violet:route:read", "owns=example.com"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var out struct {
+		Subject string     `json:"subject"`
+		Expires *time.Time `json:"expires"`
+		Perms   []string   `json:"perms"`
+		Local   bool       `json:"local"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&out))
+	assert.Equal(t, "abc", out.Subject)
+	assert.NotNil(t, out.Expires)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), *out.Expires, time.Minute)
+	assert.Equal(t, []string{"owns=example.com", "violet:route:read"}, out.Perms)
+	assert.False(t, out.Local)
+}
+
+func TestCompileJobs_Failed(t *testing.T) {
+	finished := make(chan compileJob, 1)
+	jobs := newCompileJobs(utils.MultiCompilable{&fake.Compilable{}, &fake.Compilable{Err: errors.New("bad config")}}, func(job compileJob) {
+		finished <- job
+	})
+	id := jobs.Start()
+	job := <-finished
+	assert.Equal(t, id, job.Id)
+	assert.Equal(t, "failed", job.Status)
+	assert.NotNil(t, job.Finished)
+	assert.Len(t, job.Results, 2)
+	assert.Empty(t, job.Results[0].Error)
+	assert.Equal(t, "bad config", job.Results[1].Error)
+
+	last, ok := jobs.Get("last")
+	assert.True(t, ok)
+	assert.Equal(t, job, last)
+	_, ok = jobs.Get("abc")
+	assert.False(t, ok)
+}
+
+// gateCompilable records the most compiles running at the same time, each
+// compile waits for a value from the gate
+type gateCompilable struct {
+	running, max atomic.Int32
+	gate         chan struct{}
+}
+
+func (g *gateCompilable) Compile() { _ = g.CompileSync() }
+func (g *gateCompilable) CompileSync() error {
+	n := g.running.Add(1)
+	defer g.running.Add(-1)
+	if n > g.max.Load() {
+		g.max.Store(n)
+	}
+	<-g.gate
+	return nil
+}
+
+func TestCompileJobs_Queue(t *testing.T) {
+	c := &gateCompilable{gate: make(chan struct{})}
+	finished := make(chan compileJob, 3)
+	jobs := newCompileJobs(utils.MultiCompilable{c}, func(job compileJob) {
+		finished <- job
+	})
+	first := jobs.Start()
+	assert.Eventually(t, func() bool {
+		job, _ := jobs.Get("1")
+		return job.Status == "running"
+	}, time.Second, 10*time.Millisecond)
+
+	// jobs started while another job runs are merged into one queued job
+	second := jobs.Start()
+	assert.Equal(t, second, jobs.Start())
+	job, ok := jobs.Get("2")
+	assert.True(t, ok)
+	assert.Equal(t, "queued", job.Status)
+
+	c.gate <- struct{}{}
+	job = <-finished
+	assert.Equal(t, first, job.Id)
+	assert.Equal(t, "done", job.Status)
+	c.gate <- struct{}{}
+	job = <-finished
+	assert.Equal(t, second, job.Id)
+	assert.Equal(t, "done", job.Status)
+	assert.Equal(t, int32(1), c.max.Load())
 }
 
 func TestNewApiServer_AcmeChallenge_Put(t *testing.T) {
@@ -79,6 +266,7 @@ func TestNewApiServer_AcmeChallenge_Put(t *testing.T) {
 	res = rec.Result()
 	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
 	assert.Equal(t, "Invalid ACME challenge domain", res.Header.Get("X-Violet-Error"))
+	assert.JSONEq(t, `{"error":"Invalid ACME challenge domain","code":"bad_request"}`, rec.Body.String())
 }
 
 func TestNewApiServer_AcmeChallenge_Delete(t *testing.T) {
@@ -124,4 +312,227 @@ func TestNewApiServer_AcmeChallenge_Delete(t *testing.T) {
 	res = rec.Result()
 	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
 	assert.Equal(t, "Invalid ACME challenge domain", res.Header.Get("X-Violet-Error"))
+	assert.JSONEq(t, `{"error":"Invalid ACME challenge domain","code":"bad_request"}`, rec.Body.String())
+}
+
+func TestNewApiServer_AcmeChallenge_List(t *testing.T) {
+	api := newTestApi(t, nil)
+	api.conf.Acme.Put("example.com", "123", "123abc")
+
+	rec := api.do(http.MethodGet, "/v1/acme-challenge/example.com", fake.GenSnakeOilKey("violet:acme-challenge"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var list []utils.AcmeChallengeEntry
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&list))
+	assert.Len(t, list, 1)
+	assert.Equal(t, "123", list[0].Key)
+	assert.Equal(t, "123abc", list[0].Value)
+
+	// Invalid domain
+	rec = api.do(http.MethodGet, "/v1/acme-challenge/notexample.com", fake.GenSnakeOilKey("violet:acme-challenge"), nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestNewApiServer_DomainList(t *testing.T) {
+	api := newTestApi(t, nil)
+	domainsKey := fake.GenSnakeOilKey("violet:domains")
+
+	rec := api.do(http.MethodGet, "/domain?q=example&offset=0&limit=10", "", nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = api.do(http.MethodGet, "/domain?q=example&offset=0&limit=10", domainsKey, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"total":1,"domains":[{"domain":"example.com","active":true}]}`, rec.Body.String())
+
+	// Tenants only see their own domains
+	rec = api.do(http.MethodGet, "/domain", fake.GenSnakeOilKey("violet:domains", "owns=example.com"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"total":1,"domains":[{"domain":"example.com","active":true}]}`, rec.Body.String())
+	rec = api.do(http.MethodGet, "/domain?offset=5", fake.GenSnakeOilKey("violet:domains", "owns=example.com"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"total":1,"domains":[]}`, rec.Body.String())
+	rec = api.do(http.MethodGet, "/domain", fake.GenSnakeOilKey("violet:domains", "owns=example.org"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"total":0,"domains":[]}`, rec.Body.String())
+
+	// Invalid pagination
+	rec = api.do(http.MethodGet, "/domain?limit=0", domainsKey, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestNewApiServer_DomainSettings(t *testing.T) {
+	domains := &fake.Domains{}
+	api := newTestApi(t, &conf.Conf{Domains: domains})
+	domainsKey := fake.GenSnakeOilKey("violet:domains")
+
+	// Only the provided fields are changed
+	rec := api.do(http.MethodPatch, "/domain/example.com", domainsKey, strings.NewReader(`{"hsts":"max-age=63072000"}`))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, &utils.DomainSettings{ForceHttps: true, Hsts: "max-age=63072000", WildcardDepth: 1}, domains.Settings)
+
+	// Invalid wildcard depth
+	rec = api.do(http.MethodPatch, "/domain/example.com", domainsKey, strings.NewReader(`{"wildcard_depth":0}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Invalid maintenance allow list
+	rec = api.do(http.MethodPatch, "/domain/example.com", domainsKey, strings.NewReader(`{"maintenance_allow":"10.0.0.0/8,office"}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Unknown domain
+	rec = api.do(http.MethodPatch, "/domain/notexample.com", domainsKey, strings.NewReader(`{}`))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestValidateDomainOwnershipClaims(t *testing.T) {
+	perms := claims.NewPermStorage()
+	perms.Set("owns=example.co.uk")
+	assert.True(t, validateDomainOwnershipClaims("example.co.uk", perms))
+	assert.True(t, validateDomainOwnershipClaims("www.example.co.uk", perms))
+	assert.False(t, validateDomainOwnershipClaims("notexample.co.uk", perms))
+
+	// unicode and punycode forms are compared consistently
+	perms.Set("owns=bücher.example")
+	assert.True(t, validateDomainOwnershipClaims("www.xn--bcher-kva.example", perms))
+	assert.True(t, validateDomainOwnershipClaims("www.Bücher.example", perms))
+	assert.False(t, validateDomainOwnershipClaims("invalid domain.example", perms))
+
+	// owning a public suffix doesn't grant access to every domain under it
+	perms.Set("owns=co.uk")
+	assert.False(t, validateDomainOwnershipClaims("notexample.co.uk", perms))
+}
+
+func TestNewApiServer_DomainVerify(t *testing.T) {
+	api := newTestApi(t, nil)
+
+	rec := api.do(http.MethodPost, "/domain/example.com/verify", "", nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = api.do(http.MethodPost, "/domain/example.com/verify", fake.GenSnakeOilKey("violet:domains", "owns=example.com"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestNewApiServer_DomainTenant(t *testing.T) {
+	domains := &fake.Domains{}
+	api := newTestApi(t, &conf.Conf{Domains: domains})
+
+	// Token owning a different domain
+	rec := api.do(http.MethodPut, "/domain/example.com", fake.GenSnakeOilKey("violet:domains", "owns=example.org"), nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// The first tenant to add the domain becomes the owner
+	rec = api.do(http.MethodPut, "/domain/example.com", fake.GenSnakeOilKey("violet:domains", "owns=example.com"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "abc", domains.Owner)
+
+	// Other tenants can't manage the domain or its routes
+	otherKey := fake.GenSnakeOilKeyFor("other", "violet:domains", "violet:route", "owns=example.com")
+	rec = api.do(http.MethodDelete, "/domain/example.com", otherKey, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = api.do(http.MethodPost, "/route", otherKey, strings.NewReader(`{"src":"example.com","dst":"127.0.0.1:8080"}`))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Tokens without ownership claims are unrestricted
+	rec = api.do(http.MethodDelete, "/domain/example.com", fake.GenSnakeOilKeyFor("admin", "violet:domains"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestNewApiServer_DomainImportExport(t *testing.T) {
+	domains := &fake.Domains{}
+	api := newTestApi(t, &conf.Conf{Domains: domains})
+
+	rec := api.do(http.MethodGet, "/domain-export?format=csv", fake.GenSnakeOilKey("violet:domains"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "domain,active,owner,force_https,hsts,default_backend,wildcard_depth,rate_limit,expires,maintenance,maintenance_page,maintenance_retry_after,maintenance_allow\nexample.com,true,,true,,,1,0,0,false,,0,\n", rec.Body.String())
+
+	// Tenants only export their own domains
+	rec = api.do(http.MethodGet, "/domain-export", fake.GenSnakeOilKey("violet:domains", "owns=example.org"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+	domains.Owner = "other"
+	rec = api.do(http.MethodGet, "/domain-export", fake.GenSnakeOilKey("violet:domains", "owns=example.com"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+	domains.Owner = ""
+
+	// Importing requires a separate permission
+	importCsv := func(key string) *httptest.ResponseRecorder {
+		req := newTestRequest(http.MethodPost, "/domain-import", key, strings.NewReader("domain\nexample.org\n"))
+		req.Header.Set("Content-Type", "text/csv")
+		return api.serve(req)
+	}
+	rec = importCsv(fake.GenSnakeOilKey("violet:domains"))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = importCsv(fake.GenSnakeOilKey("violet:domains-import"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"imported":1}`, rec.Body.String())
+	assert.Len(t, domains.Imported, 1)
+
+	// Invalid JSON body
+	rec = api.do(http.MethodPost, "/domain-import", fake.GenSnakeOilKey("violet:domains-import"), strings.NewReader(`{}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestNewApiServer_DomainPurge(t *testing.T) {
+	api := newTestApi(t, nil)
+	domainsKey := fake.GenSnakeOilKey("violet:domains", "owns=example.com", "owns=example.org")
+
+	rec := api.do(http.MethodPost, "/domain/example.com/purge", domainsKey, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = api.do(http.MethodPost, "/domain/example.org/purge", domainsKey, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = api.do(http.MethodGet, "/domain/example.com/audit", domainsKey, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+}
+
+func TestNewApiServer_DomainBatch(t *testing.T) {
+	domains := &fake.Domains{}
+	api := newTestApi(t, &conf.Conf{Domains: domains})
+	key := fake.GenSnakeOilKey("violet:domains", "owns=example.com", "owns=bücher.example")
+
+	rec := api.do(http.MethodPost, "/domain-batch", key, strings.NewReader(`{"put":["example.com","Bücher.example"],"delete":["www.example.com"]}`))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"example.com", "xn--bcher-kva.example"}, domains.BatchPut)
+	assert.Equal(t, []string{"www.example.com"}, domains.BatchDelete)
+
+	// one domain the token doesn't own rejects the whole batch
+	rec = api.do(http.MethodPost, "/domain-batch", key, strings.NewReader(`{"put":["example.net"],"delete":["example.org"]}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Len(t, domains.BatchPut, 2)
+}
+
+func TestNewApiServer_Cors(t *testing.T) {
+	api := newTestApi(t, &conf.Conf{ApiCorsOrigins: []string{"https://admin.example.com"}})
+
+	// preflight from an allowed origin
+	req := newTestRequest(http.MethodOptions, "/v1/domain/example.com", "", nil)
+	req.Header.Set("Origin", "https://admin.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	req.Header.Set("Access-Control-Request-Headers", "Authorization")
+	rec := api.serve(req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://admin.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, http.MethodPut, rec.Header().Get("Access-Control-Allow-Methods"))
+
+	// other origins don't receive the headers
+	req = newTestRequest(http.MethodGet, "/v1/domain", fake.GenSnakeOilKey("violet:domains"), nil)
+	req.Header.Set("Origin", "https://evil.example.org")
+	rec = api.serve(req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestNewApiServer_Limits(t *testing.T) {
+	srv := newTestApi(t, nil).srv
+	assert.Equal(t, time.Minute, srv.WriteTimeout)
+	assert.Equal(t, 2500, srv.MaxHeaderBytes)
+
+	srv = newTestApi(t, &conf.Conf{ApiLimits: conf.ServerLimits{WriteTimeout: 10 * time.Minute, MaxHeaderBytes: 16384}}).srv
+	assert.Equal(t, time.Minute, srv.ReadTimeout)
+	assert.Equal(t, 10*time.Minute, srv.WriteTimeout)
+	assert.Equal(t, 16384, srv.MaxHeaderBytes)
 }
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/MrMelon54/mjwt"
+	"github.com/MrMelon54/violet/audit"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAuditBody is the largest request body stored in the audit log
+const maxAuditBody = 64 << 10
+
+// secretBodyPaths are the endpoints receiving private keys or credentials, the
+// request bodies of these endpoints are not stored in the audit log
+var secretBodyPaths = []string{"/cert/", "/api-key"}
+
+// storesAuditBody returns true if the request body of the path can be stored
+func storesAuditBody(p string) bool {
+	for _, i := range secretBodyPaths {
+		if strings.HasPrefix(p, i) {
+			return false
+		}
+	}
+	return true
+}
+
+type auditKey struct{}
+
+// auditRecord collects the actor and previous value while handling a request
+type auditRecord struct {
+	actor string
+	old   string
+}
+
+// statusWriter keeps the status code written by the handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush allows streaming responses through the writer
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// recordChanges records each successful request made by an authenticated token
+// in the audit log and publishes it to the event hub, either may be nil
+func recordChanges(l *audit.Log, events *eventHub, h httprouter.Handle) httprouter.Handle {
+	return func(rw http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		// keep a copy of the start of the body and pass the full body to the handler
+		p := strings.TrimPrefix(req.URL.Path, apiVersion)
+		var body []byte
+		if l != nil && storesAuditBody(p) {
+			body, _ = io.ReadAll(io.LimitReader(req.Body, maxAuditBody+1))
+			req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+			if len(body) > maxAuditBody {
+				body = body[:maxAuditBody]
+			}
+		}
+
+		r := &auditRecord{}
+		sw := &statusWriter{ResponseWriter: rw}
+		h(sw, req.WithContext(context.WithValue(req.Context(), auditKey{}, r)), params)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		if r.actor == "" || sw.status >= 400 {
+			return
+		}
+		if events != nil {
+			events.Publish(apiEvent{Type: "change", Actor: r.actor, Method: req.Method, Path: p})
+		}
+		if l == nil {
+			return
+		}
+		err := l.Record(audit.Entry{
+			Actor:  r.actor,
+			Method: req.Method,
+			Path:   p,
+			Status: sw.status,
+			Old:    r.old,
+			New:    string(body),
+		})
+		if err != nil {
+			log.Printf("[Violet] Failed to record audit entry: %s\n", err)
+		}
+	}
+}
+
+// setAuditActor sets the actor recorded for the request in the audit and
+// access logs
+func setAuditActor(req *http.Request, actor string) {
+	setAccessActor(req, actor)
+	if r, ok := req.Context().Value(auditKey{}).(*auditRecord); ok {
+		r.actor = actor
+	}
+}
+
+// setAuditOld sets the value before the change recorded for the request
+func setAuditOld(req *http.Request, v any) {
+	if r, ok := req.Context().Value(auditKey{}).(*auditRecord); ok {
+		if b, err := json.Marshal(v); err == nil {
+			r.old = string(b)
+		}
+	}
+}
+
+// auditList outputs the audit log entries matching the query parameters
+func auditList(verify mjwt.Verifier, l *audit.Log) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:audit", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		q := req.URL.Query()
+		offset, limit, ok := parsePagination(rw, q, 50)
+		if !ok {
+			return
+		}
+		filter := audit.Filter{Actor: q.Get("actor"), Method: q.Get("method"), Path: q.Get("path")}
+		for _, i := range []struct {
+			name string
+			t    *time.Time
+		}{{"since", &filter.Since}, {"until", &filter.Until}} {
+			v := q.Get(i.name)
+			if v == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				apiError(rw, http.StatusBadRequest, "Invalid "+i.name)
+				return
+			}
+			*i.t = time.Unix(n, 0)
+		}
+
+		list, total, err := l.List(filter, offset, limit)
+		if err != nil {
+			log.Printf("[Violet] Failed to list audit entries: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get audit entries from database")
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(struct {
+			Total   int           `json:"total"`
+			Entries []audit.Entry `json:"entries"`
+		}{total, list})
+	})
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"database/sql"
+	"github.com/MrMelon54/violet/audit"
+	"github.com/MrMelon54/violet/proxy"
+	"github.com/MrMelon54/violet/router"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/target"
+	"github.com/MrMelon54/violet/utils/fake"
+	"github.com/julienschmidt/httprouter"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewApiServer_Audit(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestNewApiServer_Audit?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}))
+
+	api := newTestApi(t, &conf.Conf{Router: manager, Audit: audit.New(db)})
+	key := fake.GenSnakeOilKey("violet:route", "owns=example.com")
+
+	// successful change
+	rec := api.do(http.MethodPatch, "/v1/route/1", key, strings.NewReader(`{"dst":"127.0.0.1:9090"}`))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// failed changes and reads are not recorded
+	rec = api.do(http.MethodPatch, "/route/1", key, strings.NewReader(`{"src":"example.org/"}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = api.do(http.MethodGet, "/route", key, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the audit log requires a separate permission
+	rec = api.do(http.MethodGet, "/v1/audit?actor=abc&path=/route", key, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	out := getJson[struct {
+		Total   int           `json:"total"`
+		Entries []audit.Entry `json:"entries"`
+	}](api, "/v1/audit?actor=abc&path=/route", fake.GenSnakeOilKey("violet:audit"))
+	assert.Equal(t, 1, out.Total)
+	e := out.Entries[0]
+	assert.Equal(t, "abc", e.Actor)
+	assert.Equal(t, http.MethodPatch, e.Method)
+	assert.Equal(t, "/route/1", e.Path)
+	assert.Equal(t, http.StatusOK, e.Status)
+	assert.JSONEq(t, `{"id":1,"src":"example.com/","dst":"127.0.0.1:8080","flags":0,"active":true}`, e.Old)
+	assert.JSONEq(t, `{"dst":"127.0.0.1:9090"}`, e.New)
+}
+
+func TestRecordChanges_SecretBodies(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestRecordChanges_SecretBodies?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	l := audit.New(db)
+	h := recordChanges(l, nil, func(rw http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		setAuditActor(req, "abc")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	for _, p := range []string{"/v1/cert/example.com", "/v1/api-key", "/v1/route"} {
+		h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "https://example.com"+p, strings.NewReader(`{"key":"secret"}`)), nil)
+	}
+
+	// private keys and credentials are not stored
+	list, total, err := l.List(audit.Filter{}, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	bodies := make(map[string]string)
+	for _, e := range list {
+		bodies[e.Path] = e.New
+	}
+	assert.Equal(t, map[string]string{"/cert/example.com": "", "/api-key": "", "/route": `{"key":"secret"}`}, bodies)
+}
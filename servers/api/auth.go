@@ -1,14 +1,42 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/MrMelon54/mjwt"
 	"github.com/MrMelon54/mjwt/auth"
+	"github.com/MrMelon54/mjwt/claims"
+	"github.com/MrMelon54/violet/apikeys"
 	"github.com/MrMelon54/violet/utils"
 	"github.com/julienschmidt/httprouter"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-type AuthClaims mjwt.BaseTypeClaims[auth.AccessTokenClaims]
+var errInvalidToken = errors.New("invalid token")
+
+type AuthClaims struct {
+	mjwt.BaseTypeClaims[auth.AccessTokenClaims]
+	Local bool // trusted local user connected to the unix socket
+}
+
+// keyVerifier accepts API keys as bearer tokens alongside MJWT tokens, the
+// embedded mjwt.Verifier is nil when only API keys are used
+type keyVerifier struct {
+	mjwt.Verifier
+	keys *apikeys.Keys
+}
+
+// newKeyVerifier returns the mjwt.Verifier unchanged if API keys are disabled
+func newKeyVerifier(verify mjwt.Verifier, keys *apikeys.Keys) mjwt.Verifier {
+	if keys == nil {
+		return verify
+	}
+	return &keyVerifier{Verifier: verify, keys: keys}
+}
 
 type AuthCallback func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims)
 
@@ -16,6 +44,17 @@ type AuthCallback func(rw http.ResponseWriter, req *http.Request, params httprou
 // error message or continues to the next handler
 func checkAuth(verify mjwt.Verifier, cb AuthCallback) httprouter.Handle {
 	return func(rw http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		// trusted local users don't need a token
+		if uid, ok := localPeerUid(req.Context()); ok {
+			subject := "uid:" + strconv.FormatUint(uint64(uid), 10)
+			b := AuthClaims{Local: true}
+			b.Subject = subject
+			b.Claims.Perms = claims.NewPermStorage()
+			setAuditActor(req, subject)
+			cb(rw, req, params, b)
+			return
+		}
+
 		// Get bearer token
 		bearer := utils.GetBearer(req)
 		if bearer == "" {
@@ -23,27 +62,124 @@ func checkAuth(verify mjwt.Verifier, cb AuthCallback) httprouter.Handle {
 			return
 		}
 
-		// Read claims from mjwt
-		_, b, err := mjwt.ExtractClaims[auth.AccessTokenClaims](verify, bearer)
+		b, err := verifyBearer(verify, bearer)
 		if err != nil {
+			setAuthFailed(req)
 			apiError(rw, http.StatusForbidden, "Invalid token")
 			return
 		}
+		setAuditActor(req, b.Subject)
+		cb(rw, req, params, b)
+	}
+}
 
-		cb(rw, req, params, AuthClaims(b))
+// verifyBearer returns the claims of an API key or MJWT token
+func verifyBearer(verify mjwt.Verifier, bearer string) (AuthClaims, error) {
+	// API keys use a prefix which can't appear at the start of a jwt
+	if kv, ok := verify.(*keyVerifier); ok {
+		if strings.HasPrefix(bearer, apikeys.Prefix) {
+			key, err := kv.keys.Lookup(bearer)
+			if err != nil {
+				if !errors.Is(err, apikeys.ErrInvalidKey) {
+					log.Printf("[Violet] Failed to lookup api key: %s\n", err)
+				}
+				return AuthClaims{}, err
+			}
+			b := AuthClaims{}
+			b.Subject = key.Subject
+			b.Claims.Perms = claims.NewPermStorage()
+			for _, perm := range key.Perms {
+				b.Claims.Perms.Set(perm)
+			}
+			return b, nil
+		}
+		verify = kv.Verifier
 	}
+	if verify == nil {
+		return AuthClaims{}, errInvalidToken
+	}
+
+	// Read claims from mjwt
+	_, b, err := mjwt.ExtractClaims[auth.AccessTokenClaims](verify, bearer)
+	if err != nil {
+		return AuthClaims{}, err
+	}
+	return AuthClaims{BaseTypeClaims: b}, nil
 }
 
 // checkAuthWithPerm validates the bearer token and checks if it contains a
 // required permission and returns an error message or continues to the next
-// handler
+// handler, GET requests only need the `:read` scope of the permission and
+// other requests need the `:write` scope
 func checkAuthWithPerm(verify mjwt.Verifier, perm string, cb AuthCallback) httprouter.Handle {
 	return checkAuth(verify, func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
 		// check perms
-		if !b.Claims.Perms.Has(perm) {
+		if !b.Local && !hasPerm(b.Claims.Perms, scopedPerm(perm, req.Method)) {
 			apiError(rw, http.StatusForbidden, "No permission")
 			return
 		}
 		cb(rw, req, params, b)
 	})
 }
+
+// scopedPerm returns the read or write scope of the permission for the method
+func scopedPerm(perm, method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return perm + ":read"
+	}
+	return perm + ":write"
+}
+
+// hasPerm returns true if the permission is granted, `violet:x` grants both
+// `violet:x:read` and `violet:x:write` and the write scope includes the read
+// scope
+func hasPerm(perms *claims.PermStorage, perm string) bool {
+	if perms.Has(perm) {
+		return true
+	}
+	if base, ok := strings.CutSuffix(perm, ":read"); ok {
+		return perms.Has(base) || perms.Has(base+":write")
+	}
+	if base, ok := strings.CutSuffix(perm, ":write"); ok {
+		return perms.Has(base)
+	}
+	return false
+}
+
+// permList returns the sorted permissions
+func permList(perms *claims.PermStorage) []string {
+	list := make([]string, 0)
+	if perms == nil {
+		return list
+	}
+	// PermStorage only exposes the list using its JSON encoding
+	raw, err := json.Marshal(perms)
+	if err != nil {
+		return list
+	}
+	_ = json.Unmarshal(raw, &list)
+	return list
+}
+
+// whoami outputs the subject, audience, expiry and permissions of the token
+func whoami(verify mjwt.Verifier) httprouter.Handle {
+	return checkAuth(verify, func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		out := struct {
+			Subject  string     `json:"subject"`
+			Audience []string   `json:"audience,omitempty"`
+			Expires  *time.Time `json:"expires,omitempty"`
+			Perms    []string   `json:"perms"`
+			Local    bool       `json:"local"`
+		}{
+			Subject:  b.Subject,
+			Audience: b.Audience,
+			Perms:    permList(b.Claims.Perms),
+			Local:    b.Local,
+		}
+		if b.ExpiresAt != nil {
+			out.Expires = &b.ExpiresAt.Time
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(out)
+	})
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/MrMelon54/certgen"
+	"github.com/MrMelon54/mjwt"
+	"github.com/MrMelon54/violet/certs"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"io/fs"
+	"log"
+	"net/http"
+	"time"
+)
+
+type certJson struct {
+	Cert string `json:"cert"` // PEM encoded certificate chain
+	Key  string `json:"key"`  // PEM encoded private key
+}
+
+type certInfoJson struct {
+	Names      []string  `json:"names"`       // DNS names covered by the leaf
+	Issuer     string    `json:"issuer"`      // issuer distinguished name
+	NotAfter   time.Time `json:"not_after"`   // expiry date of the leaf
+	KeyType    string    `json:"key_type"`    // public key algorithm
+	SelfSigned bool      `json:"self_signed"` // leaf is signed by itself
+	Problems   []string  `json:"problems"`    // problems found when loading the chain
+}
+
+// newCertInfoJson generates the certificate metadata from the leaf
+func newCertInfoJson(leaf *x509.Certificate) certInfoJson {
+	return certInfoJson{
+		Names:      leaf.DNSNames,
+		Issuer:     leaf.Issuer.String(),
+		NotAfter:   leaf.NotAfter,
+		KeyType:    leaf.PublicKeyAlgorithm.String(),
+		SelfSigned: bytes.Equal(leaf.RawIssuer, leaf.RawSubject) && leaf.CheckSignatureFrom(leaf) == nil,
+	}
+}
+
+// certDiffProvider is implemented by certificate providers which report the
+// changes made by each compile
+type certDiffProvider interface {
+	LastCompileDiff() certs.CompileDiff
+	SubscribeCompileDiff() (<-chan certs.CompileDiff, func())
+}
+
+// certPins generates the base64 encoded SHA-256 SPKI hashes for each domain,
+// the leaf pins are listed before the pins of the rest of the chain
+func certPins(all []*tls.Certificate) map[string][]string {
+	pins := make(map[string][]string)
+	chainPins := make(map[string][]string)
+	for _, cert := range all {
+		leaf := certgen.TlsLeaf(cert)
+		if leaf == nil {
+			continue
+		}
+		leafPin := spkiPin(leaf)
+		var rest []string
+		for _, raw := range cert.Certificate[1:] {
+			if c, err := x509.ParseCertificate(raw); err == nil {
+				rest = append(rest, spkiPin(c))
+			}
+		}
+		for _, name := range leaf.DNSNames {
+			pins[name] = appendUnique(pins[name], leafPin)
+			chainPins[name] = appendUnique(chainPins[name], rest...)
+		}
+	}
+	for name := range pins {
+		for _, i := range chainPins[name] {
+			pins[name] = appendUnique(pins[name], i)
+		}
+	}
+	return pins
+}
+
+// spkiPin returns the base64 encoded SHA-256 hash of the public key info
+func spkiPin(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// appendUnique appends the values which are not already in the slice
+func appendUnique(s []string, v ...string) []string {
+outer:
+	for _, i := range v {
+		for _, j := range s {
+			if i == j {
+				continue outer
+			}
+		}
+		s = append(s, i)
+	}
+	return s
+}
+
+// selfSignedCaProvider is implemented by certificate providers which sign
+// self-signed certificates with a CA
+type selfSignedCaProvider interface {
+	GetSelfSignedCa() []byte
+}
+
+func SetupCertApis(r *apiRouter, verify mjwt.Verifier, certProvider utils.CertProvider) {
+	// Endpoint for certificates
+	r.GET("/cert", endpointDoc{"List loaded certificates", "violet:certs"}, checkAuthWithPerm(verify, "violet:certs", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		all := certProvider.GetAllCerts()
+		infos := make([]certInfoJson, 0, len(all))
+		for _, cert := range all {
+			if leaf := certgen.TlsLeaf(cert); leaf != nil {
+				info := newCertInfoJson(leaf)
+				info.Problems = certProvider.GetCertProblems(cert)
+				infos = append(infos, info)
+			}
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(infos)
+	}))
+	r.GET("/cert-pins", endpointDoc{"List the SPKI pins of loaded certificates", "violet:certs"}, checkAuthWithPerm(verify, "violet:certs", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(certPins(certProvider.GetAllCerts()))
+	}))
+	r.PUT("/cert/:domain", endpointDoc{"Upload a certificate and private key", "violet:certs"}, checkAuthWithPerm(verify, "violet:certs", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		domain, ok := utils.NormaliseDomain(params.ByName("domain"))
+		if !ok || (!b.Local && !validateDomainOwnershipClaims(domain, b.Claims.Perms)) {
+			apiError(rw, http.StatusBadRequest, "Token cannot modify the specified domain")
+			return
+		}
+
+		var j certJson
+		if err := json.NewDecoder(req.Body).Decode(&j); err != nil {
+			apiBodyError(rw, err)
+			return
+		}
+
+		err := certProvider.PutCert(domain, []byte(j.Cert), []byte(j.Key))
+		switch {
+		case err == nil:
+		case errors.Is(err, certs.ErrInvalidCert), errors.Is(err, certs.ErrInvalidDomain):
+			apiError(rw, http.StatusBadRequest, err.Error())
+			return
+		default:
+			log.Printf("[Violet] Failed to save certificate: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to save certificate")
+			return
+		}
+		certProvider.Compile()
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	r.DELETE("/cert/:domain", endpointDoc{"Delete a certificate", "violet:certs"}, checkAuthWithPerm(verify, "violet:certs", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		domain, ok := utils.NormaliseDomain(params.ByName("domain"))
+		if !ok || (!b.Local && !validateDomainOwnershipClaims(domain, b.Claims.Perms)) {
+			apiError(rw, http.StatusBadRequest, "Token cannot modify the specified domain")
+			return
+		}
+
+		err := certProvider.DeleteCert(domain)
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrNotExist):
+			apiError(rw, http.StatusNotFound, "Certificate not found")
+			return
+		case errors.Is(err, certs.ErrInvalidDomain):
+			apiError(rw, http.StatusBadRequest, err.Error())
+			return
+		default:
+			log.Printf("[Violet] Failed to delete certificate: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to delete certificate")
+			return
+		}
+		certProvider.Compile()
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	r.POST("/cert/:domain/reload", endpointDoc{"Reload a certificate from the store", "violet:certs"}, checkAuthWithPerm(verify, "violet:certs", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		domain, ok := utils.NormaliseDomain(params.ByName("domain"))
+		if !ok || (!b.Local && !validateDomainOwnershipClaims(domain, b.Claims.Perms)) {
+			apiError(rw, http.StatusBadRequest, "Token cannot modify the specified domain")
+			return
+		}
+
+		err := certProvider.ReloadCert(domain)
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrNotExist):
+			apiError(rw, http.StatusNotFound, "Certificate not found")
+			return
+		case errors.Is(err, certs.ErrInvalidCert), errors.Is(err, certs.ErrInvalidDomain):
+			apiError(rw, http.StatusBadRequest, err.Error())
+			return
+		default:
+			log.Printf("[Violet] Failed to reload certificate: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to reload certificate")
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	if caProvider, ok := certProvider.(selfSignedCaProvider); ok {
+		r.GET("/self-signed-ca.pem", endpointDoc{"Download the self-signed CA certificate", "violet:certs"}, checkAuthWithPerm(verify, "violet:certs", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+			caPem := caProvider.GetSelfSignedCa()
+			if caPem == nil {
+				apiError(rw, http.StatusNotFound, "Self-signed certificates are disabled")
+				return
+			}
+			rw.Header().Set("Content-Type", "application/x-pem-file")
+			rw.Header().Set("Content-Disposition", "attachment; filename=\"violet-ca.pem\"")
+			rw.WriteHeader(http.StatusOK)
+			_, _ = rw.Write(caPem)
+		}))
+	}
+	if diffProvider, ok := certProvider.(certDiffProvider); ok {
+		setupCertDiffApis(r, verify, diffProvider)
+	}
+}
+
+// setupCertDiffApis adds the endpoints for the changes made by each compile
+func setupCertDiffApis(r *apiRouter, verify mjwt.Verifier, diffProvider certDiffProvider) {
+	r.GET("/cert-diff", endpointDoc{"Get the changes made by the last certificate compile", "violet:certs"}, checkAuthWithPerm(verify, "violet:certs", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(diffProvider.LastCompileDiff())
+	}))
+	r.GET("/cert-diff/events", endpointDoc{"Stream certificate compile changes as server-sent events", "violet:certs"}, checkAuthWithPerm(verify, "violet:certs", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		ch, cancel := diffProvider.SubscribeCompileDiff()
+		defer cancel()
+		streamEvents(rw, req, ch, func(certs.CompileDiff) string { return "compile" })
+	}))
+}
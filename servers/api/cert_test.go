@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"github.com/MrMelon54/certgen"
+	"github.com/MrMelon54/violet/certs"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSetupCertApis(t *testing.T) {
+	ca, err := certgen.MakeCaTls(2048, pkix.Name{CommonName: "ca.violet.test"}, big.NewInt(0), func(now time.Time) time.Time {
+		return now.AddDate(1, 0, 0)
+	})
+	assert.NoError(t, err)
+	serverTls, err := certgen.MakeServerTls(ca, 2048, pkix.Name{CommonName: "test.example.com"}, big.NewInt(1), func(now time.Time) time.Time {
+		return now.AddDate(1, 0, 0)
+	}, []string{"test.example.com"}, nil)
+	assert.NoError(t, err)
+
+	api := newTestApi(t, &conf.Conf{Certs: certs.New(certs.DirFS(t.TempDir()), certs.DirFS(t.TempDir()), false)})
+	certKey := fake.GenSnakeOilKey("violet:certs", "owns=example.com")
+
+	body, err := json.Marshal(certJson{Cert: string(serverTls.GetCertPem()), Key: string(serverTls.GetKeyPem())})
+	assert.NoError(t, err)
+
+	// Missing permission
+	rec := api.do(http.MethodPut, "/cert/test.example.com", fake.GenSnakeOilKey("owns=example.com"), bytes.NewReader(body))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Valid upload
+	rec = api.do(http.MethodPut, "/cert/test.example.com", certKey, bytes.NewReader(body))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	// Listing shows the uploaded certificate
+	api.conf.Certs.Compile()
+	assert.Eventually(t, func() bool { return len(api.conf.Certs.GetAllCerts()) == 1 }, time.Second, 10*time.Millisecond)
+	infos := getJson[[]certInfoJson](api, "/cert", certKey)
+	assert.Len(t, infos, 1)
+	assert.Equal(t, []string{"test.example.com"}, infos[0].Names)
+	assert.Equal(t, "CN=ca.violet.test", infos[0].Issuer)
+	assert.Equal(t, "RSA", infos[0].KeyType)
+	assert.False(t, infos[0].SelfSigned)
+
+	// Domain not owned
+	rec = api.do(http.MethodPut, "/cert/test.notexample.com", certKey, bytes.NewReader(body))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Delete
+	rec = api.do(http.MethodDelete, "/cert/test.example.com", certKey, nil)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	rec = api.do(http.MethodDelete, "/cert/test.example.com", certKey, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestSetupCertApis_CompileDiff(t *testing.T) {
+	ca, err := certgen.MakeCaTls(2048, pkix.Name{CommonName: "ca.violet.test"}, big.NewInt(0), func(now time.Time) time.Time {
+		return now.AddDate(1, 0, 0)
+	})
+	assert.NoError(t, err)
+	serverTls, err := certgen.MakeServerTls(ca, 2048, pkix.Name{CommonName: "test.example.com"}, big.NewInt(1), func(now time.Time) time.Time {
+		return now.AddDate(1, 0, 0)
+	}, []string{"test.example.com"}, nil)
+	assert.NoError(t, err)
+
+	certDir, keyDir := certs.DirFS(t.TempDir()), certs.DirFS(t.TempDir())
+	assert.NoError(t, certDir.WriteFile("test.example.com.cert.pem", serverTls.GetCertPem()))
+	assert.NoError(t, keyDir.WriteFile("test.example.com.key.pem", serverTls.GetKeyPem()))
+	certProvider := certs.New(certDir, keyDir, false)
+
+	api := newTestApi(t, &conf.Conf{Certs: certProvider})
+	certProvider.Compile()
+	assert.Eventually(t, func() bool { return len(certProvider.GetAllCerts()) == 1 }, time.Second, 10*time.Millisecond)
+
+	rec := api.do(http.MethodGet, "/cert-diff", fake.GenSnakeOilKey("violet:certs"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var diff certs.CompileDiff
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&diff))
+	assert.Equal(t, []string{"test.example.com"}, diff.Added)
+	assert.Empty(t, diff.Removed)
+	assert.Empty(t, diff.Changed)
+}
+
+func TestSetupCertApis_Reload(t *testing.T) {
+	api := newTestApi(t, &conf.Conf{Certs: certs.New(certs.DirFS(t.TempDir()), certs.DirFS(t.TempDir()), false)})
+	certKey := fake.GenSnakeOilKey("violet:certs", "owns=example.com")
+
+	// Missing certificate
+	rec := api.do(http.MethodPost, "/cert/test.example.com/reload", certKey, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Domain not owned
+	rec = api.do(http.MethodPost, "/cert/test.notexample.com/reload", certKey, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestCertPins(t *testing.T) {
+	ca, err := certgen.MakeCaTls(2048, pkix.Name{CommonName: "ca.violet.test"}, big.NewInt(0), func(now time.Time) time.Time {
+		return now.AddDate(1, 0, 0)
+	})
+	assert.NoError(t, err)
+	serverTls, err := certgen.MakeServerTls(ca, 2048, pkix.Name{CommonName: "test.example.com"}, big.NewInt(1), func(now time.Time) time.Time {
+		return now.AddDate(1, 0, 0)
+	}, []string{"test.example.com", "www.example.com"}, nil)
+	assert.NoError(t, err)
+
+	cert := serverTls.GetTlsLeaf()
+	cert.Certificate = append(cert.Certificate, ca.GetTlsLeaf().Certificate[0])
+	leafPin := spkiPin(serverTls.GetTlsLeaf().Leaf)
+	caPin := spkiPin(ca.GetTlsLeaf().Leaf)
+
+	pins := certPins([]*tls.Certificate{&cert})
+	assert.Equal(t, map[string][]string{
+		"test.example.com": {leafPin, caPin},
+		"www.example.com":  {leafPin, caPin},
+	}, pins)
+}
+
+func TestSetupCertApis_SelfSignedCa(t *testing.T) {
+	api := newTestApi(t, &conf.Conf{Certs: certs.New(nil, nil, false)})
+	key := fake.GenSnakeOilKey("violet:certs")
+
+	// Missing token
+	assert.Equal(t, http.StatusForbidden, api.do(http.MethodGet, "/self-signed-ca.pem", "", nil).Code)
+
+	// Disabled
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodGet, "/self-signed-ca.pem", key, nil).Code)
+
+	// Enabled
+	selfSigned := certs.New(nil, nil, true)
+	api = newTestApi(t, &conf.Conf{Certs: selfSigned})
+	rec := api.do(http.MethodGet, "/self-signed-ca.pem", key, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-pem-file", rec.Header().Get("Content-Type"))
+	assert.Equal(t, selfSigned.GetSelfSignedCa(), rec.Body.Bytes())
+}
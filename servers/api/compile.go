@@ -0,0 +1,159 @@
+package api
+
+import (
+	"github.com/MrMelon54/violet/utils"
+	"path"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxCompileJobs is the number of finished compile jobs kept for the status
+// endpoint
+const maxCompileJobs = 20
+
+// compileResult is the result of compiling a single component
+type compileResult struct {
+	Name     string `json:"name"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration_ms"`
+}
+
+// compileJob is a compile started using the API
+type compileJob struct {
+	Id       int64           `json:"id"`
+	Status   string          `json:"status"` // queued, running, done or failed
+	Started  time.Time       `json:"started"`
+	Finished *time.Time      `json:"finished,omitempty"`
+	Results  []compileResult `json:"results"`
+
+	// configuration generation served after the job, 0 if unknown
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+// compileJobs runs compile jobs one at a time and keeps the most recent jobs
+type compileJobs struct {
+	s       *sync.RWMutex
+	target  utils.MultiCompilable
+	next    int64
+	jobs    map[int64]*compileJob
+	pending *compileJob          // job waiting for the running job to finish
+	running bool                 // true while the worker is running jobs
+	done    func(job compileJob) // called after each job finishes
+
+	// returns the current configuration generation, nil if unknown
+	generation func() uint64
+}
+
+func newCompileJobs(target utils.MultiCompilable, done func(job compileJob)) *compileJobs {
+	return &compileJobs{s: &sync.RWMutex{}, target: target, jobs: make(map[int64]*compileJob), done: done}
+}
+
+// Start queues a compile job to run in the background and returns the id, a
+// job which is already waiting to run is used instead of adding another
+func (c *compileJobs) Start() int64 {
+	c.s.Lock()
+	defer c.s.Unlock()
+	if c.pending != nil {
+		return c.pending.Id
+	}
+	c.next++
+	job := &compileJob{Id: c.next, Status: "queued", Started: time.Now().UTC(), Results: []compileResult{}}
+	c.jobs[job.Id] = job
+	c.pending = job
+	if !c.running {
+		c.running = true
+		go c.worker()
+	}
+	return job.Id
+}
+
+// worker runs the pending jobs until there are none left
+func (c *compileJobs) worker() {
+	for {
+		c.s.Lock()
+		job := c.pending
+		if job == nil {
+			c.running = false
+			c.s.Unlock()
+			return
+		}
+		c.pending = nil
+		job.Status = "running"
+		c.s.Unlock()
+
+		c.run(job)
+	}
+}
+
+// run compiles each target in order and records the results
+func (c *compileJobs) run(job *compileJob) {
+	results := make([]compileResult, 0, len(c.target))
+	status := "done"
+	for _, i := range c.target {
+		start := time.Now()
+		r := compileResult{Name: compilableName(i)}
+		if s, ok := i.(utils.SyncCompilable); ok {
+			if err := s.CompileSync(); err != nil {
+				r.Error = err.Error()
+				status = "failed"
+			}
+		} else {
+			// the result of asynchronous compiles is unknown
+			i.Compile()
+		}
+		r.Duration = time.Since(start).Milliseconds()
+		results = append(results, r)
+	}
+
+	finished := time.Now().UTC()
+	var gen uint64
+	if c.generation != nil {
+		gen = c.generation()
+	}
+	c.s.Lock()
+	job.Status = status
+	job.Generation = gen
+	job.Finished = &finished
+	job.Results = results
+	out := *job
+	// jobs run in order so every older job has finished
+	delete(c.jobs, job.Id-maxCompileJobs)
+	c.s.Unlock()
+
+	if c.done != nil {
+		c.done(out)
+	}
+}
+
+// Get returns a copy of the job with the id, the id can be `last` for the most
+// recent job
+func (c *compileJobs) Get(id string) (compileJob, bool) {
+	c.s.RLock()
+	defer c.s.RUnlock()
+	var n int64
+	if id == "last" {
+		n = c.next
+	} else {
+		var err error
+		n, err = strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return compileJob{}, false
+		}
+	}
+	job, ok := c.jobs[n]
+	if !ok {
+		return compileJob{}, false
+	}
+	return *job, true
+}
+
+// compilableName returns the package name of the compilable
+func compilableName(c utils.Compilable) string {
+	t := reflect.TypeOf(c)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return path.Base(t.PkgPath())
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MrMelon54/mjwt"
+	domainsPkg "github.com/MrMelon54/violet/domains"
+	errorPages "github.com/MrMelon54/violet/error-pages"
+	"github.com/MrMelon54/violet/favicons"
+	"github.com/MrMelon54/violet/router"
+	"github.com/MrMelon54/violet/target"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"log"
+	"net/http"
+)
+
+// configDomainImporter is implemented by domain providers which can import
+// domains using a transaction shared with other tables
+type configDomainImporter interface {
+	ImportTx(tx *sql.Tx, records []utils.DomainRecord) (func(), error)
+}
+
+// configExport is the full configuration output by the export endpoint
+type configExport struct {
+	Domains    []utils.DomainRecord        `json:"domains"`
+	Routes     []target.RouteWithActive    `json:"routes"`
+	Redirects  []target.RedirectWithActive `json:"redirects"`
+	Favicons   []favicons.FaviconRecord    `json:"favicons"`
+	ErrorPages []errorPages.PageRecord     `json:"error_pages"` // pages stored in the database, the error page directory is not exported
+	Sorry      []string                    `json:"sorry"`       // hosts serving the sorry page
+}
+
+// configRoute is a route in the import document, missing active fields are
+// treated as active
+type configRoute struct {
+	target.Route
+	Active *bool `json:"active"`
+}
+
+// configRedirect is a redirect in the import document, missing active fields
+// are treated as active
+type configRedirect struct {
+	target.Redirect
+	Active *bool `json:"active"`
+}
+
+// configImport is the document accepted by the import endpoint, the domains
+// are decoded using domainsPkg.ReadJson so missing fields use the defaults
+type configImport struct {
+	Domains    json.RawMessage          `json:"domains"`
+	Routes     []configRoute            `json:"routes"`
+	Redirects  []configRedirect         `json:"redirects"`
+	Favicons   []favicons.FaviconRecord `json:"favicons"`
+	ErrorPages []errorPages.PageRecord  `json:"error_pages"`
+	Sorry      []string                 `json:"sorry"`
+}
+
+// SetupConfigApis adds the endpoints for exporting and importing the full
+// configuration, import is only added if the domain provider supports shared
+// transactions
+func SetupConfigApis(r *apiRouter, verify mjwt.Verifier, db *sql.DB, domains utils.DomainProvider, manager *router.Manager, icons *favicons.Favicons, pages *errorPages.ErrorPages) {
+	if manager == nil {
+		return
+	}
+	r.GET("/export", endpointDoc{"Export domains, routes, redirects, favicons, error pages and sorry hosts as JSON", "violet:config"}, configExportHandler(verify, domains, manager, icons, pages))
+	if importer, ok := domains.(configDomainImporter); ok && db != nil {
+		r.POST("/import", endpointDoc{"Import domains, routes, redirects, favicons, error pages and sorry hosts in a single transaction", "violet:config"}, configImportHandler(verify, db, domains, importer, manager, icons, pages))
+	}
+}
+
+// configExportHandler outputs the full configuration as a single JSON document
+func configExportHandler(verify mjwt.Verifier, domains utils.DomainProvider, manager *router.Manager, icons *favicons.Favicons, pages *errorPages.ErrorPages) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:config", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		out := configExport{Favicons: []favicons.FaviconRecord{}, ErrorPages: []errorPages.PageRecord{}, Sorry: []string{}}
+		var err error
+		if out.Domains, err = domains.Export(); err != nil {
+			log.Printf("[Violet] Failed to export domains: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get domains from database")
+			return
+		}
+		if out.Routes, err = manager.GetAllRoutes(); err != nil {
+			log.Printf("[Violet] Failed to export routes: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get routes from database")
+			return
+		}
+		if out.Redirects, err = manager.GetAllRedirects(); err != nil {
+			log.Printf("[Violet] Failed to export redirects: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get redirects from database")
+			return
+		}
+		if icons != nil {
+			if out.Favicons, err = icons.Export(); err != nil {
+				log.Printf("[Violet] Failed to export favicons: %s\n", err)
+				apiError(rw, http.StatusInternalServerError, "Failed to get favicons from database")
+				return
+			}
+		}
+		if pages != nil && pages.HasDatabase() {
+			if out.ErrorPages, err = pages.List(); err != nil {
+				log.Printf("[Violet] Failed to export error pages: %s\n", err)
+				apiError(rw, http.StatusInternalServerError, "Failed to get error pages from database")
+				return
+			}
+			out.Sorry = pages.SorryHosts()
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(out)
+	})
+}
+
+// configImportHandler adds or replaces the domains, routes, redirects, favicons
+// and error pages and enables the sorry hosts in the request body, no changes
+// are made if any entry is invalid
+func configImportHandler(verify mjwt.Verifier, db *sql.DB, domains utils.DomainProvider, importer configDomainImporter, manager *router.Manager, icons *favicons.Favicons, pages *errorPages.ErrorPages) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:config", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		var j configImport
+		if err := json.NewDecoder(req.Body).Decode(&j); err != nil {
+			apiBodyError(rw, err)
+			return
+		}
+
+		domainList := make([]utils.DomainRecord, 0)
+		if len(j.Domains) > 0 && !bytes.Equal(j.Domains, []byte("null")) {
+			var err error
+			domainList, err = domainsPkg.ReadJson(bytes.NewReader(j.Domains))
+			if err != nil {
+				apiError(rw, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		routes := make([]target.RouteWithActive, len(j.Routes))
+		for i, a := range j.Routes {
+			a.Flags = a.Flags.NormaliseRouteFlags()
+			if fields := a.Route.ValidateFields(); len(fields) > 0 {
+				apiValidationError(rw, http.StatusBadRequest, importFields("routes", i, fields))
+				return
+			}
+			routes[i] = target.RouteWithActive{Route: a.Route, Active: a.Active == nil || *a.Active}
+		}
+		redirects := make([]target.RedirectWithActive, len(j.Redirects))
+		for i, a := range j.Redirects {
+			a.Flags = a.Flags.NormaliseRedirectFlags()
+			if fields := a.Redirect.ValidateFields(); len(fields) > 0 {
+				apiValidationError(rw, http.StatusBadRequest, importFields("redirects", i, fields))
+				return
+			}
+			redirects[i] = target.RedirectWithActive{Redirect: a.Redirect, Active: a.Active == nil || *a.Active}
+		}
+		if len(j.Favicons) > 0 && icons == nil {
+			apiError(rw, http.StatusBadRequest, "Favicons are disabled")
+			return
+		}
+		if (len(j.ErrorPages) > 0 || len(j.Sorry) > 0) && (pages == nil || !pages.HasDatabase()) {
+			apiError(rw, http.StatusBadRequest, "Error pages are not stored in the database")
+			return
+		}
+
+		publish, err := importConfig(db, importer, manager, icons, pages, domainList, routes, redirects, j)
+		switch {
+		case err == nil:
+		case errors.Is(err, domainsPkg.ErrInvalidRecord), errors.Is(err, favicons.ErrInvalidRecord), errors.Is(err, errorPages.ErrInvalidPage):
+			apiError(rw, http.StatusBadRequest, err.Error())
+			return
+		default:
+			log.Printf("[Violet] Failed to import config: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to save config to database")
+			return
+		}
+		publish()
+
+		domains.Compile()
+		manager.Compile()
+		if icons != nil {
+			icons.Compile()
+		}
+		if len(j.ErrorPages) > 0 {
+			pages.Compile()
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(map[string]int{
+			"domains":     len(domainList),
+			"routes":      len(routes),
+			"redirects":   len(redirects),
+			"favicons":    len(j.Favicons),
+			"error_pages": len(j.ErrorPages),
+			"sorry":       len(j.Sorry),
+		})
+	})
+}
+
+// importFields prefixes the field errors with the position of the entry in
+// the import document, for example routes[0].src
+func importFields(list string, i int, fields []target.FieldError) []target.FieldError {
+	for n := range fields {
+		fields[n].Field = fmt.Sprintf("%s[%d].%s", list, i, fields[n].Field)
+	}
+	return fields
+}
+
+// importConfig writes every part of the configuration in a single transaction
+// and returns the function which publishes the domain and sorry host changes
+func importConfig(db *sql.DB, importer configDomainImporter, manager *router.Manager, icons *favicons.Favicons, pages *errorPages.ErrorPages, domainList []utils.DomainRecord, routes []target.RouteWithActive, redirects []target.RedirectWithActive, j configImport) (func(), error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	publish, err := importer.ImportTx(tx, domainList)
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.ImportTx(tx, routes, redirects); err != nil {
+		return nil, err
+	}
+	if icons != nil {
+		if err := icons.ImportTx(tx, j.Favicons); err != nil {
+			return nil, err
+		}
+	}
+	publishSorry := func() {}
+	if pages != nil && pages.HasDatabase() {
+		if publishSorry, err = pages.ImportTx(tx, j.ErrorPages, j.Sorry); err != nil {
+			return nil, err
+		}
+	}
+	return func() {
+		publish()
+		publishSorry()
+	}, tx.Commit()
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/MrMelon54/violet/domains"
+	errorPages "github.com/MrMelon54/violet/error-pages"
+	"github.com/MrMelon54/violet/favicons"
+	"github.com/MrMelon54/violet/proxy"
+	"github.com/MrMelon54/violet/router"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/target"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/MrMelon54/violet/utils/fake"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetupConfigApis(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupConfigApis?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}))
+	pages := errorPages.New(nil)
+	assert.NoError(t, pages.SetDatabase(db))
+
+	api := newTestApi(t, &conf.Conf{
+		Domains:    domains.New(db),
+		DB:         db,
+		Router:     manager,
+		Favicons:   favicons.New(db, nil),
+		ErrorPages: pages,
+	})
+	key := fake.GenSnakeOilKey("violet:config")
+
+	post := func(body string) *httptest.ResponseRecorder {
+		return api.do(http.MethodPost, "/v1/import", key, strings.NewReader(body))
+	}
+	export := func() configExport {
+		return getJson[configExport](api, "/v1/export", key)
+	}
+
+	// an invalid redirect makes no changes
+	rec := post(`{"domains":[{"domain":"example.com"}],"redirects":[{"src":"www.example.com/","dst":"example.com","code":200}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, []utils.DomainRecord{}, export().Domains)
+
+	// an invalid error page or sorry host makes no changes
+	rec = post(`{"domains":[{"domain":"example.com"}],"error_pages":[{"code":700,"html":"oops"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = post(`{"domains":[{"domain":"example.com"}],"sorry":["Example.com"]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, []utils.DomainRecord{}, export().Domains)
+	assert.False(t, pages.IsSorry("example.com"))
+
+	rec = post(`{
+  "domains": [{"domain": "example.com"}],
+  "routes": [{"src": "example.com/", "dst": "127.0.0.1:9090"}, {"src": "old.example.com/", "dst": "127.0.0.1:8080", "active": false}],
+  "redirects": [{"src": "www.example.com/", "dst": "example.com", "code": 302}],
+  "favicons": [{"host": "example.com", "ico": "https://example.com/favicon.ico"}],
+  "error_pages": [{"code": 404, "html": "missing"}, {"host": "example.com", "code": -1, "html": "down"}],
+  "sorry": ["example.com"]
+}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"domains":1,"routes":2,"redirects":1,"favicons":1,"error_pages":2,"sorry":1}`, rec.Body.String())
+	assert.True(t, pages.IsSorry("example.com"))
+
+	out := export()
+	assert.Equal(t, "example.com", out.Domains[0].Domain)
+	assert.True(t, out.Domains[0].Active)
+	assert.Equal(t, 1, out.Domains[0].WildcardDepth)
+	assert.Equal(t, []target.RouteWithActive{
+		{Id: 1, Route: target.Route{Src: "example.com/", Dst: "127.0.0.1:9090"}, Active: true},
+		{Id: 3, Route: target.Route{Src: "old.example.com/", Dst: "127.0.0.1:8080"}, Active: false},
+	}, out.Routes)
+	assert.Equal(t, []target.RedirectWithActive{
+		{Id: 1, Redirect: target.Redirect{Src: "www.example.com/", Dst: "example.com", Code: http.StatusFound}, Active: true},
+	}, out.Redirects)
+	assert.Equal(t, []favicons.FaviconRecord{{Host: "example.com", Ico: "https://example.com/favicon.ico"}}, out.Favicons)
+	assert.Equal(t, []errorPages.PageRecord{{Code: 404, Html: "missing"}, {Host: "example.com", Code: -1, Html: "down"}}, out.ErrorPages)
+	assert.Equal(t, []string{"example.com"}, out.Sorry)
+
+	// the export can be imported again
+	j, err := json.Marshal(out)
+	assert.NoError(t, err)
+	rec = post(string(j))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, out.ErrorPages, export().ErrorPages)
+}
+
+func TestSetupConfigApis_ErrorPagesWithoutDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupConfigApis_ErrorPagesWithoutDatabase?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	api := newTestApi(t, &conf.Conf{
+		Domains:    domains.New(db),
+		DB:         db,
+		Router:     router.NewManager(db, proxy.NewHybridTransport()),
+		ErrorPages: errorPages.New(nil),
+	})
+
+	// documents with error pages are rejected instead of dropping the pages
+	rec := api.do(http.MethodPost, "/v1/import", fake.GenSnakeOilKey("violet:config"), strings.NewReader(`{"sorry":["example.com"]}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Error pages are not stored in the database")
+}
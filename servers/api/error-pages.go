@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/MrMelon54/mjwt"
+	errorPages "github.com/MrMelon54/violet/error-pages"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// maxErrorPageUpload is the largest error page accepted by the API
+const maxErrorPageUpload = 1 << 20
+
+// SetupErrorPageApis adds the endpoints for the sorry page and managing the
+// error pages stored in the database, the error page endpoints are only added
+// if database storage is enabled. Without a database the sorry hosts are kept
+// until violet restarts.
+func SetupErrorPageApis(r *apiRouter, verify mjwt.Verifier, domains utils.DomainProvider, pages *errorPages.ErrorPages) {
+	if pages == nil {
+		return
+	}
+	r.GET("/sorry", endpointDoc{"List the hosts serving the sorry page", "violet:sorry"}, sorryList(verify, domains, pages))
+	r.PUT("/sorry/:host", endpointDoc{"Serve the sorry page for every request to the host", "violet:sorry"}, sorrySet(verify, domains, pages, true))
+	r.DELETE("/sorry/:host", endpointDoc{"Stop serving the sorry page for the host", "violet:sorry"}, sorrySet(verify, domains, pages, false))
+	if !pages.HasDatabase() {
+		return
+	}
+	r.GET("/error-page", endpointDoc{"List the error pages of hosts stored in the database", "violet:error-pages"}, errorPageList(verify, domains, pages))
+	r.PUT("/error-page/:host/:code", endpointDoc{"Set the html error page of a host for a status code, `4xx`, `any`, `maintenance`, `upstream-tls` or `sorry`", "violet:error-pages"}, errorPagePut(verify, domains, pages))
+	r.DELETE("/error-page/:host/:code", endpointDoc{"Remove the error page of a host for a status code, `4xx`, `any`, `maintenance`, `upstream-tls` or `sorry`", "violet:error-pages"}, errorPageDelete(verify, domains, pages))
+	r.GET("/error-page-default", endpointDoc{"List the global error pages stored in the database", "violet:error-pages-default"}, errorPageList(verify, nil, pages))
+	r.PUT("/error-page-default/:code", endpointDoc{"Set the global html error page for a status code, `4xx`, `any`, `maintenance`, `upstream-tls` or `sorry`", "violet:error-pages-default"}, errorPagePut(verify, nil, pages))
+	r.DELETE("/error-page-default/:code", endpointDoc{"Remove the global error page for a status code, `4xx`, `any`, `maintenance`, `upstream-tls` or `sorry`", "violet:error-pages-default"}, errorPageDelete(verify, nil, pages))
+}
+
+// errorPagePerm returns the permission of the host or global endpoints, the
+// global endpoints have no domain provider
+func errorPagePerm(domains utils.DomainProvider) string {
+	if domains == nil {
+		return "violet:error-pages-default"
+	}
+	return "violet:error-pages"
+}
+
+// errorPageList outputs the host error pages on domains owned by the token or
+// the global error pages if the domain provider is nil
+func errorPageList(verify mjwt.Verifier, domains utils.DomainProvider, pages *errorPages.ErrorPages) httprouter.Handle {
+	return checkAuthWithPerm(verify, errorPagePerm(domains), func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		list, err := pages.List()
+		if err != nil {
+			log.Printf("[Violet] Failed to list error pages: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get error pages from database")
+			return
+		}
+		var owned []string
+		if domains != nil {
+			owned, err = ownedDomains(domains, b)
+			if err != nil {
+				log.Printf("[Violet] Failed to get domain owner: %s\n", err)
+				apiError(rw, http.StatusInternalServerError, "Failed to get domain from database")
+				return
+			}
+		}
+		filtered := make([]errorPages.PageRecord, 0, len(list))
+		for _, i := range list {
+			switch {
+			case domains == nil:
+				if i.Host == "" {
+					filtered = append(filtered, i)
+				}
+			case i.Host != "" && (owned == nil || hostInDomains(i.Host, owned)):
+				filtered = append(filtered, i)
+			}
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(filtered)
+	})
+}
+
+// errorPagePut replaces the error page with the html in the request body
+func errorPagePut(verify mjwt.Verifier, domains utils.DomainProvider, pages *errorPages.ErrorPages) httprouter.Handle {
+	return checkAuthWithPerm(verify, errorPagePerm(domains), func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		host, code, ok := parseErrorPage(rw, domains, params, b)
+		if !ok {
+			return
+		}
+		raw, err := io.ReadAll(http.MaxBytesReader(rw, req.Body, maxErrorPageUpload))
+		if err != nil || len(raw) == 0 {
+			apiError(rw, http.StatusBadRequest, "Invalid error page upload")
+			return
+		}
+
+		record := errorPages.PageRecord{Host: host, Code: code, Html: string(raw)}
+		err = pages.Put(record)
+		switch {
+		case err == nil:
+		case errors.Is(err, errorPages.ErrInvalidPage):
+			apiError(rw, http.StatusBadRequest, err.Error())
+			return
+		default:
+			log.Printf("[Violet] Failed to save error page: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to save error page to database")
+			return
+		}
+		pages.Compile()
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(record)
+	})
+}
+
+// errorPageDelete removes the error page
+func errorPageDelete(verify mjwt.Verifier, domains utils.DomainProvider, pages *errorPages.ErrorPages) httprouter.Handle {
+	return checkAuthWithPerm(verify, errorPagePerm(domains), func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		host, code, ok := parseErrorPage(rw, domains, params, b)
+		if !ok {
+			return
+		}
+		err := pages.Delete(host, code)
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrNotExist):
+			apiError(rw, http.StatusNotFound, "Unknown error page")
+			return
+		default:
+			log.Printf("[Violet] Failed to delete error page: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to delete error page from database")
+			return
+		}
+		pages.Compile()
+		rw.WriteHeader(http.StatusOK)
+	})
+}
+
+// parseErrorPage reads the host and code parameters, the host is empty for the
+// global endpoints, the code `any` is the catch-all page, classes such as `4xx`
+// are the class pages, `maintenance` is the maintenance page, `upstream-tls` is
+// the backend tls failure page and `sorry` is the sorry page. An error message
+// is output if the page can't be modified.
+func parseErrorPage(rw http.ResponseWriter, domains utils.DomainProvider, params httprouter.Params, b AuthClaims) (string, int, bool) {
+	var host string
+	if domains != nil {
+		var ok bool
+		if host, ok = parseHostParam(rw, domains, params, b); !ok {
+			return "", 0, false
+		}
+	}
+	switch c := params.ByName("code"); c {
+	case "any":
+		return host, errorPages.AnyStatus, true
+	case "maintenance":
+		return host, errorPages.MaintenanceStatus, true
+	case "upstream-tls":
+		return host, errorPages.UpstreamTlsStatus, true
+	case "sorry":
+		return host, errorPages.SorryStatus, true
+	case "1xx", "2xx", "3xx", "4xx", "5xx":
+		return host, errorPages.ClassStatus(int(c[0]-'0') * 100), true
+	default:
+		code, err := strconv.Atoi(c)
+		if err != nil || code < 100 || !errorPages.ValidCode(code) {
+			apiError(rw, http.StatusBadRequest, "Invalid status code")
+			return "", 0, false
+		}
+		return host, code, true
+	}
+}
+
+// sorryList outputs the hosts serving the sorry page on domains owned by the
+// token
+func sorryList(verify mjwt.Verifier, domains utils.DomainProvider, pages *errorPages.ErrorPages) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:sorry", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		owned, err := ownedDomains(domains, b)
+		if err != nil {
+			log.Printf("[Violet] Failed to get domain owner: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get domain from database")
+			return
+		}
+		hosts := pages.SorryHosts()
+		filtered := make([]string, 0, len(hosts))
+		for _, host := range hosts {
+			if owned == nil || hostInDomains(host, owned) {
+				filtered = append(filtered, host)
+			}
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(filtered)
+	})
+}
+
+// sorrySet enables or disables the sorry page of the host
+func sorrySet(verify mjwt.Verifier, domains utils.DomainProvider, pages *errorPages.ErrorPages, enabled bool) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:sorry", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		host, ok := parseHostParam(rw, domains, params, b)
+		if !ok {
+			return
+		}
+		if err := pages.SetSorry(host, enabled); err != nil {
+			log.Printf("[Violet] Failed to save sorry host: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to save sorry host to database")
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"database/sql"
+	errorPages "github.com/MrMelon54/violet/error-pages"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils/fake"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetupErrorPageApis(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupErrorPageApis?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	pages := errorPages.New(nil)
+	assert.NoError(t, pages.SetDatabase(db))
+
+	api := newTestApi(t, &conf.Conf{ErrorPages: pages})
+	key := fake.GenSnakeOilKey("violet:error-pages", "owns=example.com")
+	globalKey := fake.GenSnakeOilKey("violet:error-pages-default")
+
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPut, "/v1/error-page/www.example.org/404", key, strings.NewReader("404")).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPut, "/v1/error-page/www.example.com/0", key, strings.NewReader("404")).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPut, "/v1/error-page/www.example.com/404", key, strings.NewReader("{{.Status")).Code)
+	assert.Equal(t, http.StatusForbidden, api.do(http.MethodPut, "/v1/error-page-default/404", key, strings.NewReader("404")).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPut, "/v1/error-page-default/6xx", globalKey, strings.NewReader("6xx")).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPut, "/v1/error-page-default/4", globalKey, strings.NewReader("4xx")).Code)
+
+	assert.Equal(t, http.StatusOK, api.do(http.MethodPut, "/v1/error-page/www.example.com/any", key, strings.NewReader("{{.Status}} on {{.Host}}")).Code)
+	assert.Equal(t, http.StatusOK, api.do(http.MethodPut, "/v1/error-page-default/503", globalKey, strings.NewReader("maintenance")).Code)
+	assert.Equal(t, []errorPages.PageRecord{{Host: "www.example.com", Code: 0, Html: "{{.Status}} on {{.Host}}"}}, getJson[[]errorPages.PageRecord](api, "/v1/error-page", key))
+	assert.Equal(t, []errorPages.PageRecord{}, getJson[[]errorPages.PageRecord](api, "/v1/error-page", fake.GenSnakeOilKey("violet:error-pages", "owns=example.org")))
+	assert.Equal(t, []errorPages.PageRecord{{Code: 503, Html: "maintenance"}}, getJson[[]errorPages.PageRecord](api, "/v1/error-page-default", globalKey))
+
+	// the stored pages are served after compiling
+	assert.NoError(t, pages.CompileSync())
+	rec := httptest.NewRecorder()
+	pages.ServeHostError(rec, "www.example.com", http.StatusNotFound)
+	assert.Equal(t, "404 on www.example.com", rec.Body.String())
+
+	// class pages are stored with the first digit of the class
+	assert.Equal(t, http.StatusOK, api.do(http.MethodPut, "/v1/error-page-default/4xx", globalKey, strings.NewReader("client error")).Code)
+	assert.NoError(t, pages.CompileSync())
+	rec = httptest.NewRecorder()
+	pages.ServeHostError(rec, "www.example.org", http.StatusGone)
+	assert.Equal(t, "client error", rec.Body.String())
+	assert.Equal(t, http.StatusOK, api.do(http.MethodDelete, "/v1/error-page-default/4xx", globalKey, nil).Code)
+
+	// pages can use the assets in the error page directory
+	assert.Equal(t, http.StatusOK, api.do(http.MethodPut, "/v1/error-page-default/500", globalKey, strings.NewReader(`<img src="{{asset "logo.png"}}">`)).Code)
+	assert.Equal(t, http.StatusOK, api.do(http.MethodDelete, "/v1/error-page-default/500", globalKey, nil).Code)
+
+	assert.Equal(t, http.StatusOK, api.do(http.MethodDelete, "/v1/error-page/www.example.com/any", key, nil).Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodDelete, "/v1/error-page/www.example.com/any", key, nil).Code)
+	assert.Equal(t, []errorPages.PageRecord{}, getJson[[]errorPages.PageRecord](api, "/v1/error-page", key))
+}
+
+func TestSetupErrorPageApis_Sorry(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupErrorPageApis_Sorry?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	pages := errorPages.New(nil)
+	assert.NoError(t, pages.SetDatabase(db))
+
+	api := newTestApi(t, &conf.Conf{ErrorPages: pages})
+	key := fake.GenSnakeOilKey("violet:sorry", "owns=example.com")
+
+	assert.Equal(t, http.StatusForbidden, api.do(http.MethodPut, "/v1/sorry/www.example.com", fake.GenSnakeOilKey("violet:error-pages", "owns=example.com"), nil).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPut, "/v1/sorry/www.example.org", key, nil).Code)
+
+	// the sorry page is enabled immediately without compiling
+	assert.Equal(t, http.StatusOK, api.do(http.MethodPut, "/v1/sorry/www.example.com", key, nil).Code)
+	assert.True(t, pages.IsSorry("www.example.com:443"))
+	assert.Equal(t, []string{"www.example.com"}, getJson[[]string](api, "/v1/sorry", key))
+	assert.Equal(t, []string{}, getJson[[]string](api, "/v1/sorry", fake.GenSnakeOilKey("violet:sorry", "owns=example.org")))
+
+	// the hosts are loaded from the database
+	reload := errorPages.New(nil)
+	assert.NoError(t, reload.SetDatabase(db))
+	assert.True(t, reload.IsSorry("www.example.com"))
+
+	assert.Equal(t, http.StatusOK, api.do(http.MethodDelete, "/v1/sorry/www.example.com", key, nil).Code)
+	assert.False(t, pages.IsSorry("www.example.com"))
+	assert.Equal(t, []string{}, getJson[[]string](api, "/v1/sorry", key))
+}
+
+func TestSetupErrorPageApis_SorryWithoutDatabase(t *testing.T) {
+	pages := errorPages.New(nil)
+	api := newTestApi(t, &conf.Conf{ErrorPages: pages})
+
+	// the sorry page only needs the error pages
+	assert.Equal(t, http.StatusOK, api.do(http.MethodPut, "/v1/sorry/www.example.com", fake.GenSnakeOilKey("violet:sorry", "owns=example.com"), nil).Code)
+	assert.True(t, pages.IsSorry("www.example.com"))
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodGet, "/v1/error-page", fake.GenSnakeOilKey("violet:error-pages"), nil).Code)
+}
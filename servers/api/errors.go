@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MrMelon54/violet/target"
+	"net/http"
+	"strings"
+)
+
+// Machine-readable error codes which aren't derived from the status
+const (
+	errCodeInvalidBody      = "invalid_body"
+	errCodeValidationFailed = "validation_failed"
+)
+
+// apiErrorBody is the body of every error response, Error is a message for
+// people and Code is stable for programs. Fields lists the problems with each
+// field of the request body when known.
+type apiErrorBody struct {
+	Error  string              `json:"error"`
+	Code   string              `json:"code"`
+	Fields []target.FieldError `json:"fields,omitempty"`
+}
+
+// apiError writes an error response using the code derived from the status
+func apiError(rw http.ResponseWriter, status int, m string) {
+	writeApiError(rw, status, apiErrorBody{Error: m, Code: statusErrorCode(status)})
+}
+
+// apiValidationError writes the field errors as a validation failure, the
+// message joins each field name and message
+func apiValidationError(rw http.ResponseWriter, status int, fields []target.FieldError) {
+	msgs := make([]string, len(fields))
+	for i, f := range fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	writeApiError(rw, status, apiErrorBody{Error: strings.Join(msgs, ", "), Code: errCodeValidationFailed, Fields: fields})
+}
+
+// apiBodyError writes the error from decoding a JSON request body, type errors
+// include the field and the JSON type which was received
+func apiBodyError(rw http.ResponseWriter, err error) {
+	e := apiErrorBody{Error: "Invalid request body", Code: errCodeInvalidBody}
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		e.Fields = []target.FieldError{{Field: typeErr.Field, Message: "expected " + typeErr.Type.String(), Value: typeErr.Value}}
+	case errors.As(err, &syntaxErr):
+		e.Error = fmt.Sprintf("Invalid request body: %s at offset %d", syntaxErr, syntaxErr.Offset)
+	}
+	writeApiError(rw, http.StatusBadRequest, e)
+}
+
+// writeApiError writes the error body as JSON, the message is also kept in the
+// X-Violet-Error header for older clients
+func writeApiError(rw http.ResponseWriter, status int, e apiErrorBody) {
+	rw.Header().Set("X-Violet-Error", e.Error)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(e)
+}
+
+// statusErrorCode converts the status text into a snake case code, for example
+// 404 becomes not_found
+func statusErrorCode(status int) string {
+	t := http.StatusText(status)
+	if t == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(t), " ", "_")
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"github.com/MrMelon54/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStatusErrorCode(t *testing.T) {
+	assert.Equal(t, "not_found", statusErrorCode(http.StatusNotFound))
+	assert.Equal(t, "precondition_required", statusErrorCode(http.StatusPreconditionRequired))
+	assert.Equal(t, "error", statusErrorCode(599))
+}
+
+func TestNewApiServer_Errors(t *testing.T) {
+	api := newTestApi(t, nil)
+	key := fake.GenSnakeOilKey("violet:domains")
+
+	rec := api.do(http.MethodPut, "/v1/domain/exa%20mple.com", key, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":"Invalid domain","code":"bad_request"}`, rec.Body.String())
+
+	// type errors include the field and received JSON type
+	rec = api.do(http.MethodPost, "/v1/domain-batch", key, strings.NewReader(`{"put":"example.com"}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":"Invalid request body","code":"invalid_body","fields":[{"field":"put","message":"expected []string","value":"string"}]}`, rec.Body.String())
+
+	rec = api.do(http.MethodPost, "/v1/domain-batch", key, strings.NewReader(`{"put":`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"invalid_body"`)
+
+	// unknown endpoints and methods are JSON errors
+	rec = api.do(http.MethodGet, "/v1/unknown", key, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.JSONEq(t, `{"error":"Unknown endpoint","code":"not_found"}`, rec.Body.String())
+	rec = api.do(http.MethodDelete, "/v1/domain", key, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Contains(t, rec.Header().Get("Allow"), http.MethodGet)
+	assert.JSONEq(t, `{"error":"Method not allowed","code":"method_not_allowed"}`, rec.Body.String())
+}
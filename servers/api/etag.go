@@ -0,0 +1,71 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// entityTag returns the strong ETag of the JSON encoding of the value
+func entityTag(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return bytesEntityTag(b)
+}
+
+// bytesEntityTag returns the strong ETag of the encoded value
+func bytesEntityTag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// writeEntity outputs the JSON encoding of the value with the ETag generated
+// from the same encoding, so the tag always describes the body sent
+func writeEntity(rw http.ResponseWriter, code int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		apiError(rw, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+	rw.Header().Set("ETag", bytesEntityTag(b))
+	rw.WriteHeader(code)
+	_, _ = rw.Write(append(b, '\n'))
+}
+
+// checkPreconditions compares the If-Match and If-None-Match headers with the
+// current value, exists is false if the value is being created. In strict mode
+// updates of existing values without an If-Match header are rejected.
+func checkPreconditions(rw http.ResponseWriter, req *http.Request, current any, exists, strict bool) bool {
+	if req.Header.Get("If-None-Match") == "*" && exists {
+		apiError(rw, http.StatusPreconditionFailed, "Resource already exists")
+		return false
+	}
+	h := req.Header.Get("If-Match")
+	if h == "" {
+		if strict && exists {
+			apiError(rw, http.StatusPreconditionRequired, "If-Match header is required")
+			return false
+		}
+		return true
+	}
+	if !exists || (strings.TrimSpace(h) != "*" && !matchEntityTag(h, entityTag(current))) {
+		apiError(rw, http.StatusPreconditionFailed, "Resource has been modified")
+		return false
+	}
+	return true
+}
+
+// matchEntityTag returns true if the comma separated list contains the tag,
+// weak tags don't match as updates use strong comparison
+func matchEntityTag(list, tag string) bool {
+	for _, i := range strings.Split(list, ",") {
+		if strings.TrimSpace(i) == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MrMelon54/mjwt"
+	"github.com/MrMelon54/violet/webhooks"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiEvent describes a change made using the API, a change to the domain list
+// or a compile of the configuration
+type apiEvent struct {
+	Type   string    `json:"type"` // change, domain or compile
+	Actor  string    `json:"actor,omitempty"`
+	Method string    `json:"method,omitempty"`
+	Path   string    `json:"path,omitempty"`
+	Status string    `json:"status,omitempty"` // status of the compile job
+	Domain string    `json:"domain,omitempty"` // domain of the domain event
+	Action string    `json:"action,omitempty"` // add, remove, enable or disable
+	Time   time.Time `json:"time"`
+}
+
+// eventHub sends each event to the subscribed streams and webhooks
+type eventHub struct {
+	s     *sync.RWMutex
+	subs  map[chan apiEvent]struct{}
+	hooks *webhooks.Webhooks
+}
+
+func newEventHub(hooks *webhooks.Webhooks) *eventHub {
+	return &eventHub{s: &sync.RWMutex{}, subs: make(map[chan apiEvent]struct{}), hooks: hooks}
+}
+
+// Subscribe returns a channel which receives each event, the returned function
+// must be called to unsubscribe.
+//
+// Slow subscribers miss events instead of blocking changes.
+func (h *eventHub) Subscribe() (<-chan apiEvent, func()) {
+	ch := make(chan apiEvent, 8)
+	h.s.Lock()
+	h.subs[ch] = struct{}{}
+	h.s.Unlock()
+	return ch, func() {
+		h.s.Lock()
+		delete(h.subs, ch)
+		h.s.Unlock()
+	}
+}
+
+// Publish sends the event to every subscriber, the current time is used if the
+// event doesn't have a time
+func (h *eventHub) Publish(e apiEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if t := webhookEvent(e); t != "" {
+		h.hooks.Send(t, e)
+	}
+	h.s.RLock()
+	defer h.s.RUnlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// webhookEvent returns the webhook event type for changes to routes and
+// redirects made using the REST or gRPC API, changes to the domain list and
+// compiles, other events return an empty string
+func webhookEvent(e apiEvent) string {
+	switch e.Type {
+	case "compile":
+		return webhooks.Compile
+	case "domain":
+		return webhooks.DomainChange
+	case "change":
+		p := e.Path
+		if e.Method == "GRPC" {
+			// gRPC methods are named like /violet.v1.Violet/PutRoute
+			p = strings.ToLower(path.Base(p))
+			p = strings.TrimPrefix(strings.TrimPrefix(p, "put"), "delete")
+		}
+		p = strings.TrimPrefix(p, "/")
+		if strings.HasPrefix(p, "route") || strings.HasPrefix(p, "redirect") {
+			return webhooks.RouteChange
+		}
+	}
+	return ""
+}
+
+// configEvents streams the configuration changes, domain changes and compiles
+// as server-sent events
+func configEvents(verify mjwt.Verifier, hub *eventHub) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:events", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		ch, cancel := hub.Subscribe()
+		defer cancel()
+		streamEvents(rw, req, ch, func(e apiEvent) string { return e.Type })
+	})
+}
+
+// streamEvents writes each event from the channel as a server-sent event until
+// the request is closed, name returns the event name and events with an empty
+// name are skipped
+func streamEvents[T any](rw http.ResponseWriter, req *http.Request, ch <-chan T, name func(T) string) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		apiError(rw, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	// the stream stays open longer than the server write timeout
+	if err := http.NewResponseController(rw).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		apiError(rw, http.StatusInternalServerError, "Failed to clear the write deadline")
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case e := <-ch:
+			n := name(e)
+			if n == "" {
+				continue
+			}
+			j, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			_, _ = fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", n, j)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"github.com/MrMelon54/violet/domains"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils/fake"
+	"github.com/MrMelon54/violet/webhooks"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewApiServer_Events(t *testing.T) {
+	srv := httptest.NewServer(newTestApi(t, nil).srv.Handler)
+	defer srv.Close()
+
+	// the stream requires a separate permission
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/events", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+fake.GenSnakeOilKey("violet:domains"))
+	resp, err := srv.Client().Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	req.Header.Set("Authorization", "Bearer "+fake.GenSnakeOilKey("violet:events"))
+	resp, err = srv.Client().Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// a failed change isn't sent
+	put := func(key string) {
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/v1/domain/example.com", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := srv.Client().Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	put(fake.GenSnakeOilKey("violet:domains"))
+	put(fake.GenSnakeOilKey("violet:domains", "owns=example.com"))
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "event: change\n", line)
+	line, err = r.ReadString('\n')
+	assert.NoError(t, err)
+	var e apiEvent
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e))
+	assert.Equal(t, "abc", e.Actor)
+	assert.Equal(t, http.MethodPut, e.Method)
+	assert.Equal(t, "/domain/example.com", e.Path)
+}
+
+func TestNewApiServer_EventsWriteTimeout(t *testing.T) {
+	srv := httptest.NewUnstartedServer(newTestApi(t, nil).srv.Handler)
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/events", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+fake.GenSnakeOilKey("violet:events"))
+	resp, err := srv.Client().Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the stream is still open after the write timeout
+	time.Sleep(200 * time.Millisecond)
+	req, err = http.NewRequest(http.MethodPut, srv.URL+"/v1/domain/example.com", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+fake.GenSnakeOilKey("violet:domains"))
+	putResp, err := srv.Client().Do(req)
+	assert.NoError(t, err)
+	_ = putResp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "event: change\n", line)
+}
+
+func TestNewApiServer_DomainEvents(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestNewApiServer_DomainEvents?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	allowedDomains := domains.New(db)
+	srv := httptest.NewServer(newTestApi(t, &conf.Conf{Domains: allowedDomains}).srv.Handler)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/domain-events", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+fake.GenSnakeOilKey("violet:domains"))
+	resp, err := srv.Client().Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// changes outside the api are published and other events are skipped
+	allowedDomains.Put("example.com", true)
+	allowedDomains.Delete("example.com", "abc")
+	put, err := http.NewRequest(http.MethodPut, srv.URL+"/v1/domain/example.com", nil)
+	assert.NoError(t, err)
+	put.Header.Set("Authorization", "Bearer "+fake.GenSnakeOilKey("violet:domains"))
+	putResp, err := srv.Client().Do(put)
+	assert.NoError(t, err)
+	_ = putResp.Body.Close()
+
+	r := bufio.NewReader(resp.Body)
+	for _, action := range []string{"add", "disable", "enable"} {
+		line, err := r.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "event: "+action+"\n", line)
+		line, err = r.ReadString('\n')
+		assert.NoError(t, err)
+		var e apiEvent
+		assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e))
+		assert.Equal(t, "domain", e.Type)
+		assert.Equal(t, "example.com", e.Domain)
+		assert.Equal(t, action, e.Action)
+		_, err = r.ReadString('\n')
+		assert.NoError(t, err)
+	}
+}
+
+func TestWebhookEvent(t *testing.T) {
+	for _, i := range []struct {
+		e    apiEvent
+		want string
+	}{
+		{apiEvent{Type: "compile", Path: "/compile/1"}, webhooks.Compile},
+		{apiEvent{Type: "change", Method: http.MethodPut, Path: "/route/1"}, webhooks.RouteChange},
+		{apiEvent{Type: "change", Method: http.MethodPost, Path: "/redirect-batch"}, webhooks.RouteChange},
+		{apiEvent{Type: "change", Method: "GRPC", Path: "/violet.v1.Violet/DeleteRedirect"}, webhooks.RouteChange},
+		{apiEvent{Type: "change", Method: "GRPC", Path: "/violet.v1.Violet/PutDomain"}, ""},
+		{apiEvent{Type: "change", Method: http.MethodPut, Path: "/domain/example.com"}, ""},
+		{apiEvent{Type: "domain", Path: "/domain/example.com", Domain: "example.com", Action: "add"}, webhooks.DomainChange},
+	} {
+		assert.Equal(t, i.want, webhookEvent(i.e), i.e.Path)
+	}
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/MrMelon54/mjwt"
+	"github.com/MrMelon54/violet/favicons"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFaviconUpload is the largest icon accepted as a raw upload
+const maxFaviconUpload = 1 << 20
+
+// SetupFaviconApis adds the endpoints for managing favicon overrides
+func SetupFaviconApis(r *apiRouter, verify mjwt.Verifier, domains utils.DomainProvider, icons *favicons.Favicons) {
+	if icons == nil {
+		return
+	}
+	r.GET("/favicon", endpointDoc{"List favicon overrides", "violet:favicon"}, faviconList(verify, domains, icons))
+	r.GET("/favicon-errors", endpointDoc{"List favicon overrides which failed to compile", "violet:favicon"}, faviconErrors(verify, domains, icons))
+	r.GET("/favicon/:host/preview", endpointDoc{"Get the compiled svg, png or ico icon served for a host or path prefix", "violet:favicon"}, faviconPreview(verify, domains, icons))
+	r.PUT("/favicon/:host", endpointDoc{"Set the favicon sources of a host using JSON urls, a raw SVG, PNG or ICO upload or a multipart form", "violet:favicon"}, faviconPut(verify, domains, icons))
+	r.DELETE("/favicon/:host", endpointDoc{"Remove the favicon override of a host or path prefix", "violet:favicon"}, faviconDelete(verify, domains, icons))
+}
+
+// faviconList outputs the favicon overrides on domains owned by the token
+func faviconList(verify mjwt.Verifier, domains utils.DomainProvider, icons *favicons.Favicons) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:favicon", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		list, err := icons.Export()
+		if err != nil {
+			log.Printf("[Violet] Failed to list favicons: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get favicons from database")
+			return
+		}
+		owned, err := ownedDomains(domains, b)
+		if err != nil {
+			log.Printf("[Violet] Failed to get domain owner: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get domain from database")
+			return
+		}
+		if owned != nil {
+			filtered := make([]favicons.FaviconRecord, 0, len(list))
+			for _, i := range list {
+				if hostInDomains(i.Host, owned) {
+					filtered = append(filtered, i)
+				}
+			}
+			list = filtered
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(list)
+	})
+}
+
+// faviconErrors outputs the favicon overrides on domains owned by the token
+// which failed during the last compile, the default favicon is only listed for
+// tokens without an owner
+func faviconErrors(verify mjwt.Verifier, domains utils.DomainProvider, icons *favicons.Favicons) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:favicon", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		list := icons.Errors()
+		owned, err := ownedDomains(domains, b)
+		if err != nil {
+			log.Printf("[Violet] Failed to get domain owner: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get domain from database")
+			return
+		}
+		if owned != nil {
+			filtered := make([]favicons.EntryError, 0, len(list))
+			for _, i := range list {
+				if i.Host != "" && hostInDomains(i.Host, owned) {
+					filtered = append(filtered, i)
+				}
+			}
+			list = filtered
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(list)
+	})
+}
+
+// faviconPreview outputs the compiled icon served for the host and path prefix
+// including wildcard, discovered and default icons. The format query is svg,
+// png or ico and defaults to png, the size query selects a generated png size.
+func faviconPreview(verify mjwt.Verifier, domains utils.DomainProvider, icons *favicons.Favicons) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:favicon", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		host, ok := parseHostParam(rw, domains, params, b)
+		if !ok {
+			return
+		}
+		q := req.URL.Query()
+		prefix, ok := parseFaviconPath(rw, q.Get("path"))
+		if !ok {
+			return
+		}
+
+		name, ok := previewIconPath(q.Get("format"), q.Get("size"))
+		if !ok {
+			apiError(rw, http.StatusBadRequest, "Invalid format or size")
+			return
+		}
+		list := icons.FindIconsAt(host, prefix)
+		if list == nil {
+			apiError(rw, http.StatusNotFound, "No favicon for host")
+			return
+		}
+		img, contentType, err := list.ProduceForRequest(name, "")
+		if err != nil {
+			apiError(rw, http.StatusNotFound, "Icon format not available")
+			return
+		}
+		rw.Header().Set("Content-Type", contentType)
+		rw.Header().Set("ETag", `"`+img.Hash+`"`)
+		rw.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(img.Raw))
+	})
+}
+
+// previewIconPath returns the icon path of the format and size, the size is
+// only valid for png icons
+func previewIconPath(format, size string) (string, bool) {
+	if size != "" {
+		if format != "" && format != "png" {
+			return "", false
+		}
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return "", false
+		}
+		for _, i := range favicons.IconSizes {
+			if i.Size == n {
+				return i.Path, true
+			}
+		}
+		return "", false
+	}
+	switch format {
+	case "", "png":
+		return "/favicon.png", true
+	case "svg", "ico":
+		return "/favicon." + format, true
+	}
+	return "", false
+}
+
+// faviconPut replaces the favicon sources of the host using the JSON body or
+// replaces a single source with the uploaded icon, the format of the upload
+// is chosen using the content type. Multipart forms upload the svg, png, ico
+// and dark_svg fields together. The path query or JSON field limits the
+// override to a path prefix.
+func faviconPut(verify mjwt.Verifier, domains utils.DomainProvider, icons *favicons.Favicons) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:favicon", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		host, ok := parseHostParam(rw, domains, params, b)
+		if !ok {
+			return
+		}
+		prefix, ok := parseFaviconPath(rw, req.URL.Query().Get("path"))
+		if !ok {
+			return
+		}
+
+		var record favicons.FaviconRecord
+		contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		switch contentType {
+		case "image/svg+xml", "image/png", "image/x-icon", "image/vnd.microsoft.icon":
+			raw, err := io.ReadAll(http.MaxBytesReader(rw, req.Body, maxFaviconUpload))
+			if err != nil || len(raw) == 0 {
+				apiError(rw, http.StatusBadRequest, "Invalid icon upload")
+				return
+			}
+
+			// other formats keep their current source
+			record, ok = currentFavicon(rw, req, icons, host, prefix)
+			if !ok {
+				return
+			}
+			setFaviconUpload(&record, contentType, raw)
+		case "multipart/form-data":
+			// the svg, png and ico fields upload several formats at once
+			req.Body = http.MaxBytesReader(rw, req.Body, 5*maxFaviconUpload)
+			if err := req.ParseMultipartForm(maxFaviconUpload); err != nil {
+				apiError(rw, http.StatusBadRequest, "Invalid icon upload")
+				return
+			}
+			record, ok = currentFavicon(rw, req, icons, host, prefix)
+			if !ok {
+				return
+			}
+			for _, i := range []struct {
+				field, contentType string
+				dst                *string
+			}{{"svg", "image/svg+xml", &record.Svg}, {"png", "image/png", &record.Png}, {"ico", "image/x-icon", &record.Ico}, {"dark_svg", "image/svg+xml", &record.DarkSvg}} {
+				file, _, err := req.FormFile(i.field)
+				if errors.Is(err, http.ErrMissingFile) {
+					continue
+				}
+				var raw []byte
+				if err == nil {
+					raw, err = io.ReadAll(io.LimitReader(file, maxFaviconUpload+1))
+					_ = file.Close()
+				}
+				if err != nil || len(raw) == 0 || len(raw) > maxFaviconUpload {
+					apiError(rw, http.StatusBadRequest, "Invalid "+i.field+" icon upload")
+					return
+				}
+				*i.dst = favicons.DataUrl(i.contentType, raw)
+			}
+		default:
+			if err := json.NewDecoder(req.Body).Decode(&record); err != nil {
+				apiBodyError(rw, err)
+				return
+			}
+			if !req.URL.Query().Has("path") {
+				if prefix, ok = parseFaviconPath(rw, record.Path); !ok {
+					return
+				}
+			}
+		}
+		record.Host = host
+		record.Path = prefix
+		if record.Svg == "" && record.Png == "" && record.Ico == "" {
+			apiError(rw, http.StatusBadRequest, "At least one favicon source is required")
+			return
+		}
+
+		err := icons.Put(record)
+		switch {
+		case err == nil:
+		case errors.Is(err, favicons.ErrInvalidRecord):
+			apiError(rw, http.StatusBadRequest, err.Error())
+			return
+		default:
+			log.Printf("[Violet] Failed to save favicon: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to save favicon to database")
+			return
+		}
+		icons.Compile()
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(record)
+	})
+}
+
+// currentFavicon returns the favicon sources of the host so uploads only
+// replace the uploaded formats, an error message is output on failure
+func currentFavicon(rw http.ResponseWriter, req *http.Request, icons *favicons.Favicons, host, prefix string) (favicons.FaviconRecord, bool) {
+	record, err := icons.Get(host, prefix)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("[Violet] Failed to get favicon: %s\n", err)
+		apiError(rw, http.StatusInternalServerError, "Failed to get favicon from database")
+		return record, false
+	}
+	setAuditOld(req, record)
+	return record, true
+}
+
+// setFaviconUpload stores the uploaded icon as a data url in the source for the
+// content type
+func setFaviconUpload(record *favicons.FaviconRecord, contentType string, raw []byte) {
+	data := favicons.DataUrl(contentType, raw)
+	switch contentType {
+	case "image/svg+xml":
+		record.Svg = data
+	case "image/png":
+		record.Png = data
+	default:
+		record.Ico = data
+	}
+}
+
+// faviconDelete removes the favicon override of the host or the path prefix in
+// the path query
+func faviconDelete(verify mjwt.Verifier, domains utils.DomainProvider, icons *favicons.Favicons) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:favicon", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		host, ok := parseHostParam(rw, domains, params, b)
+		if !ok {
+			return
+		}
+		prefix, ok := parseFaviconPath(rw, req.URL.Query().Get("path"))
+		if !ok {
+			return
+		}
+		err := icons.Delete(host, prefix)
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrNotExist):
+			apiError(rw, http.StatusNotFound, "Unknown favicon")
+			return
+		default:
+			log.Printf("[Violet] Failed to delete favicon: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to delete favicon from database")
+			return
+		}
+		icons.Compile()
+		rw.WriteHeader(http.StatusOK)
+	})
+}
+
+// parseFaviconPath cleans the path prefix of an override, an error message is
+// output if the prefix is invalid
+func parseFaviconPath(rw http.ResponseWriter, p string) (string, bool) {
+	prefix, ok := favicons.CleanIconPath(p)
+	if !ok {
+		apiError(rw, http.StatusBadRequest, "Invalid path")
+		return "", false
+	}
+	return prefix, true
+}
+
+// hostInDomains returns true if the host is one of the domains or a subdomain
+func hostInDomains(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"github.com/MrMelon54/violet/favicons"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils/fake"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSetupFaviconApis(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupFaviconApis?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	icons := favicons.New(db, nil)
+
+	api := newTestApi(t, &conf.Conf{Favicons: icons})
+	key := fake.GenSnakeOilKey("violet:favicon", "owns=example.com")
+	put := func(p, contentType string, body io.Reader) int {
+		req := newTestRequest(http.MethodPut, "/v1"+p, key, body)
+		req.Header.Set("Content-Type", contentType)
+		return api.serve(req).Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, put("/favicon/www.example.com", "application/json", strings.NewReader(`{"svg":"ftp://example.com/logo.svg"}`)))
+	assert.Equal(t, http.StatusBadRequest, put("/favicon/www.example.org", "application/json", strings.NewReader(`{"svg":"https://example.org/logo.svg"}`)))
+
+	assert.Equal(t, http.StatusOK, put("/favicon/www.example.com", "application/json", strings.NewReader(`{"svg":"https://example.com/logo.svg"}`)))
+
+	// a raw upload replaces a single format
+	pngData := "\x89PNG\r\n\x1a\npng data"
+	assert.Equal(t, http.StatusOK, put("/favicon/www.example.com", "image/png", strings.NewReader(pngData)))
+	want := favicons.FaviconRecord{Host: "www.example.com", Svg: "https://example.com/logo.svg", Png: favicons.DataUrl("image/png", []byte(pngData))}
+	assert.Equal(t, []favicons.FaviconRecord{want}, getJson[[]favicons.FaviconRecord](api, "/v1/favicon", key))
+	assert.Equal(t, []favicons.FaviconRecord{}, getJson[[]favicons.FaviconRecord](api, "/v1/favicon", fake.GenSnakeOilKey("violet:favicon", "owns=example.org")))
+
+	// uploads must match the format
+	assert.Equal(t, http.StatusBadRequest, put("/favicon/www.example.com", "image/x-icon", strings.NewReader("png data")))
+	assert.Equal(t, []favicons.FaviconRecord{want}, getJson[[]favicons.FaviconRecord](api, "/v1/favicon", key))
+
+	// a multipart upload replaces several formats
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	for field, data := range map[string]string{"svg": "<svg/>", "ico": "\x00\x00\x01\x00ico data", "dark_svg": "<svg id='dark'/>"} {
+		fw, err := mw.CreateFormFile(field, "favicon."+field)
+		assert.NoError(t, err)
+		_, _ = fw.Write([]byte(data))
+	}
+	assert.NoError(t, mw.Close())
+	assert.Equal(t, http.StatusOK, put("/favicon/www.example.com", mw.FormDataContentType(), &form))
+	want.Svg = favicons.DataUrl("image/svg+xml", []byte("<svg/>"))
+	want.Ico = favicons.DataUrl("image/x-icon", []byte("\x00\x00\x01\x00ico data"))
+	want.DarkSvg = favicons.DataUrl("image/svg+xml", []byte("<svg id='dark'/>"))
+	assert.Equal(t, []favicons.FaviconRecord{want}, getJson[[]favicons.FaviconRecord](api, "/v1/favicon", key))
+
+	// overrides for a path prefix are separate
+	assert.Equal(t, http.StatusOK, put("/favicon/www.example.com?path=/app1/", "image/svg+xml", strings.NewReader("<svg/>")))
+	assert.Equal(t, http.StatusBadRequest, put("/favicon/www.example.com", "application/json", strings.NewReader(`{"path":"app2","svg":"https://example.com/logo.svg"}`)))
+	app1 := favicons.FaviconRecord{Host: "www.example.com", Path: "/app1", Svg: favicons.DataUrl("image/svg+xml", []byte("<svg/>"))}
+	assert.Equal(t, []favicons.FaviconRecord{want, app1}, getJson[[]favicons.FaviconRecord](api, "/v1/favicon", key))
+	assert.Equal(t, http.StatusOK, api.do(http.MethodDelete, "/v1/favicon/www.example.com?path=/app1", key, nil).Code)
+	assert.Equal(t, []favicons.FaviconRecord{want}, getJson[[]favicons.FaviconRecord](api, "/v1/favicon", key))
+
+	assert.Equal(t, http.StatusOK, api.do(http.MethodDelete, "/v1/favicon/www.example.com", key, nil).Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodDelete, "/v1/favicon/www.example.com", key, nil).Code)
+	assert.Equal(t, []favicons.FaviconRecord{}, getJson[[]favicons.FaviconRecord](api, "/v1/favicon", key))
+
+	// broken icons are listed after a compile
+	assert.Equal(t, http.StatusOK, put("/favicon/www.example.com", "image/png", strings.NewReader(pngData)))
+	assert.Error(t, icons.CompileSync())
+	failed := getJson[[]favicons.EntryError](api, "/v1/favicon-errors", key)
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, "www.example.com", failed[0].Host)
+		assert.NotEmpty(t, failed[0].Error)
+	}
+	assert.Equal(t, []favicons.EntryError{}, getJson[[]favicons.EntryError](api, "/v1/favicon-errors", fake.GenSnakeOilKey("violet:favicon", "owns=example.org")))
+}
+
+func TestFaviconPreview(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestFaviconPreview?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	icons := favicons.New(db, nil)
+
+	api := newTestApi(t, &conf.Conf{Favicons: icons})
+	key := fake.GenSnakeOilKey("violet:favicon", "owns=example.com")
+
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodGet, "/v1/favicon/www.example.com/preview", key, nil).Code)
+
+	svg := `<svg width="10" height="10" xmlns="http://www.w3.org/2000/svg"><rect width="10" height="10" fill="red"/></svg>`
+	assert.NoError(t, icons.Put(favicons.FaviconRecord{Host: "www.example.com", Svg: favicons.DataUrl("image/svg+xml", []byte(svg))}))
+	assert.NoError(t, icons.CompileSync())
+	list := icons.GetIcons("www.example.com")
+
+	rec := api.do(http.MethodGet, "/v1/favicon/www.example.com/preview", key, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `"`+list.Png.Hash+`"`, rec.Header().Get("ETag"))
+	assert.Equal(t, list.Png.Raw, rec.Body.Bytes())
+
+	rec = api.do(http.MethodGet, "/v1/favicon/www.example.com/preview?format=svg", key, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, svg, rec.Body.String())
+
+	rec = api.do(http.MethodGet, "/v1/favicon/www.example.com/preview?size=32", key, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, list.Sized[32].Raw, rec.Body.Bytes())
+
+	// apps under a path prefix without an override keep their own icons
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodGet, "/v1/favicon/www.example.com/preview?path=/app1", key, nil).Code)
+
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodGet, "/v1/favicon/www.example.com/preview?format=gif", key, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodGet, "/v1/favicon/www.example.com/preview?format=ico&size=32", key, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodGet, "/v1/favicon/www.example.com/preview?size=33", key, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodGet, "/v1/favicon/www.example.org/preview", key, nil).Code)
+}
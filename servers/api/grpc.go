@@ -0,0 +1,536 @@
+package api
+
+import (
+	"context"
+	"github.com/MrMelon54/mjwt"
+	"github.com/MrMelon54/violet/audit"
+	"github.com/MrMelon54/violet/router"
+	"github.com/MrMelon54/violet/servers/api/violetpb"
+	"github.com/MrMelon54/violet/target"
+	"github.com/MrMelon54/violet/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type grpcActorKey struct{}
+
+// grpcServer implements the management operations of the API server over
+// gRPC, changes are recorded in the same audit log, event hub and access log
+// as the HTTP endpoints
+type grpcServer struct {
+	violetpb.UnimplementedVioletServer
+	verify       mjwt.Verifier
+	domains      utils.DomainProvider
+	manager      *router.Manager
+	jobs         *compileJobs
+	events       *eventHub
+	audit        *audit.Log
+	access       *accessLog
+	autoRegister bool
+	limits       *apiLimits
+}
+
+// newGrpcServer creates the gRPC server, the API TLS config is used if set.
+// Calls are checked against the same rate limit and authentication lockout as
+// the HTTP endpoints.
+func newGrpcServer(s *grpcServer, tlsConf credentials.TransportCredentials) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.recordUnary, s.limitUnary),
+		grpc.ChainStreamInterceptor(s.recordStream, s.limitStream),
+	}
+	if tlsConf != nil {
+		opts = append(opts, grpc.Creds(tlsConf))
+	}
+	srv := grpc.NewServer(opts...)
+	violetpb.RegisterVioletServer(srv, s)
+	return srv
+}
+
+// grpcReadOnly returns true for the calls which only need the `:read` scope
+func grpcReadOnly(fullMethod string) bool {
+	name := path.Base(fullMethod)
+	return strings.HasPrefix(name, "List") || strings.HasPrefix(name, "Get") || name == "Watch"
+}
+
+// recordUnary records successful changes in the audit log and event hub and
+// every call in the access log
+func (s *grpcServer) recordUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	actor := new(string)
+	resp, err := handler(context.WithValue(ctx, grpcActorKey{}, actor), req)
+
+	if err == nil && *actor != "" && !grpcReadOnly(info.FullMethod) {
+		s.events.Publish(apiEvent{Type: "change", Actor: *actor, Method: "GRPC", Path: info.FullMethod})
+		if s.audit != nil {
+			var body []byte
+			if m, ok := req.(proto.Message); ok {
+				body, _ = protojson.Marshal(m)
+			}
+			err := s.audit.Record(audit.Entry{
+				Actor:  *actor,
+				Method: "GRPC",
+				Path:   info.FullMethod,
+				Status: http.StatusOK,
+				New:    string(body),
+			})
+			if err != nil {
+				log.Printf("[Violet] Failed to record audit entry: %s\n", err)
+			}
+		}
+	}
+	s.logAccess(ctx, start, *actor, info.FullMethod, err)
+	return resp, err
+}
+
+// grpcContextStream passes the context values to the stream handler
+type grpcContextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcContextStream) Context() context.Context { return s.ctx }
+
+// recordStream records every stream in the access log once it finishes
+func (s *grpcServer) recordStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	actor := new(string)
+	err := handler(srv, &grpcContextStream{ss, context.WithValue(ss.Context(), grpcActorKey{}, actor)})
+	s.logAccess(ss.Context(), start, *actor, info.FullMethod, err)
+	return err
+}
+
+// limitUnary rejects calls from rate limited or blocked addresses and records
+// the failed authentication attempts
+func (s *grpcServer) limitUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var resp any
+	err := s.limitCall(ctx, func(ctx context.Context) (err error) {
+		resp, err = handler(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// limitStream rejects streams from rate limited or blocked addresses and
+// records the failed authentication attempts
+func (s *grpcServer) limitStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return s.limitCall(ss.Context(), func(ctx context.Context) error {
+		return handler(srv, &grpcContextStream{ss, ctx})
+	})
+}
+
+func (s *grpcServer) limitCall(ctx context.Context, call func(ctx context.Context) error) error {
+	if s.limits == nil {
+		return call(ctx)
+	}
+	ip := grpcPeerIp(ctx)
+	if s.limits.store != nil {
+		_, _, _, ok, err := s.limits.store.Take(ctx, ip)
+		if err != nil {
+			return status.Error(codes.Internal, "Failed to check rate limit")
+		}
+		if !ok {
+			return status.Error(codes.ResourceExhausted, "Rate limit exceeded")
+		}
+	}
+	if s.limits.lockout == nil {
+		return call(ctx)
+	}
+	if _, ok := s.limits.lockout.lockedUntil(ip, time.Now()); ok {
+		return status.Error(codes.ResourceExhausted, "Too many failed authentication attempts")
+	}
+	failed := new(bool)
+	err := call(context.WithValue(ctx, authFailKey{}, failed))
+	if *failed {
+		s.limits.lockout.fail(ip, time.Now())
+	}
+	return err
+}
+
+// grpcPeerIp returns the ip address of the client
+func grpcPeerIp(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	ip := p.Addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		return host
+	}
+	return ip
+}
+
+func (s *grpcServer) logAccess(ctx context.Context, start time.Time, actor, fullMethod string, err error) {
+	if s.access == nil {
+		return
+	}
+	ip := grpcPeerIp(ctx)
+	code := grpcHttpStatus(status.Code(err))
+	s.access.write(accessEntry{
+		Time:     start.UTC(),
+		Actor:    actor,
+		Method:   "GRPC",
+		Endpoint: fullMethod,
+		Path:     fullMethod,
+		Status:   code,
+		Outcome:  accessOutcome(code),
+		Ip:       ip,
+		Duration: time.Since(start).Milliseconds(),
+	})
+}
+
+// auth validates the bearer token in the `authorization` metadata and checks
+// the token has the permission with the scope needed by the call
+func (s *grpcServer) auth(ctx context.Context, perm string) (AuthClaims, error) {
+	bearer := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, i := range md.Get("authorization") {
+			if v, ok := strings.CutPrefix(i, "Bearer "); ok {
+				bearer = v
+			}
+		}
+	}
+	if bearer == "" {
+		return AuthClaims{}, status.Error(codes.Unauthenticated, "Missing bearer token")
+	}
+	b, err := verifyBearer(s.verify, bearer)
+	if err != nil {
+		setAuthFailedCtx(ctx)
+		return AuthClaims{}, status.Error(codes.Unauthenticated, "Invalid token")
+	}
+	if actor, ok := ctx.Value(grpcActorKey{}).(*string); ok {
+		*actor = b.Subject
+	}
+
+	method := http.MethodPost
+	if name, ok := grpc.Method(ctx); ok && grpcReadOnly(name) {
+		method = http.MethodGet
+	}
+	if !hasPerm(b.Claims.Perms, scopedPerm(perm, method)) {
+		return AuthClaims{}, status.Error(codes.PermissionDenied, "No permission")
+	}
+	return b, nil
+}
+
+// grpcStatus converts the status code and message used by the HTTP endpoints
+func grpcStatus(code int, msg string) error {
+	switch code {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, msg)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, msg)
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, msg)
+	case http.StatusConflict:
+		return status.Error(codes.FailedPrecondition, msg)
+	default:
+		return status.Error(codes.Internal, msg)
+	}
+}
+
+// grpcHttpStatus returns the HTTP status code recorded in the access log
+func grpcHttpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.FailedPrecondition:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// grpcPagination checks the offset and limit, def is used if the limit is unset
+func grpcPagination(offset, limit int32, def int) (int, int, error) {
+	if offset < 0 {
+		return 0, 0, status.Error(codes.InvalidArgument, "Invalid offset")
+	}
+	if limit == 0 {
+		return int(offset), def, nil
+	}
+	if limit < 1 || limit > 500 {
+		return 0, 0, status.Error(codes.InvalidArgument, "Invalid limit")
+	}
+	return int(offset), int(limit), nil
+}
+
+// domainTenant normalises the domain and checks the token can modify it, the
+// recorded owner is returned
+func (s *grpcServer) domainTenant(domain string, b AuthClaims) (string, string, error) {
+	domain, ok := utils.NormaliseDomain(domain)
+	if !ok || domain == "" {
+		return "", "", status.Error(codes.InvalidArgument, "Invalid domain")
+	}
+	owner, code, msg := domainTenantErr(s.domains, domain, b)
+	if code != 0 {
+		return "", "", grpcStatus(code, msg)
+	}
+	return domain, owner, nil
+}
+
+func (s *grpcServer) ListDomains(ctx context.Context, req *violetpb.ListDomainsRequest) (*violetpb.ListDomainsResponse, error) {
+	b, err := s.auth(ctx, "violet:domains")
+	if err != nil {
+		return nil, err
+	}
+	offset, limit, err := grpcPagination(req.Offset, req.Limit, 50)
+	if err != nil {
+		return nil, err
+	}
+	list, total, code, msg := listOwnedDomains(s.domains, req.Query, offset, limit, b)
+	if code != 0 {
+		return nil, grpcStatus(code, msg)
+	}
+	out := &violetpb.ListDomainsResponse{Total: int32(total), Domains: make([]*violetpb.Domain, len(list))}
+	for i, d := range list {
+		out.Domains[i] = &violetpb.Domain{Domain: d.Domain, Active: d.Active, Owner: d.Owner}
+	}
+	return out, nil
+}
+
+func (s *grpcServer) PutDomain(ctx context.Context, req *violetpb.PutDomainRequest) (*violetpb.PutDomainResponse, error) {
+	b, err := s.auth(ctx, "violet:domains")
+	if err != nil {
+		return nil, err
+	}
+	domain, owner, err := s.domainTenant(req.Domain, b)
+	if err != nil {
+		return nil, err
+	}
+
+	// output the challenge if the domain must be verified before activation
+	out := &violetpb.PutDomainResponse{}
+	if c := putDomain(s.domains, domain, owner, b); c != nil {
+		out.Challenge = &violetpb.DomainChallenge{Name: c.Name, Type: c.Type, Value: c.Value}
+	}
+	return out, nil
+}
+
+func (s *grpcServer) DeleteDomain(ctx context.Context, req *violetpb.DeleteDomainRequest) (*violetpb.DeleteDomainResponse, error) {
+	b, err := s.auth(ctx, "violet:domains")
+	if err != nil {
+		return nil, err
+	}
+	domain, _, err := s.domainTenant(req.Domain, b)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteDomain(s.domains, domain, b)
+	return &violetpb.DeleteDomainResponse{}, nil
+}
+
+// targetFilter converts the list request into a filter on the domains owned by
+// the token
+func (s *grpcServer) targetFilter(req *violetpb.ListTargetsRequest, b AuthClaims) (router.TargetFilter, int, int, error) {
+	offset, limit, err := grpcPagination(req.Offset, req.Limit, -1)
+	if err != nil {
+		return router.TargetFilter{}, 0, 0, err
+	}
+	filter := router.TargetFilter{
+		Host:    req.Host,
+		Dst:     req.Dst,
+		Flags:   target.Flags(req.Flags),
+		Deleted: req.Deleted,
+	}
+	if code, msg := filterOwned(s.domains, &filter, b); code != 0 {
+		return router.TargetFilter{}, 0, 0, grpcStatus(code, msg)
+	}
+	return filter, offset, limit, nil
+}
+
+// sourceTenant checks the token can modify the source
+func (s *grpcServer) sourceTenant(src string, b AuthClaims) error {
+	if code, msg := sourceTenantErr(s.domains, src, b); code != 0 {
+		return grpcStatus(code, msg)
+	}
+	return nil
+}
+
+func (s *grpcServer) ListRoutes(ctx context.Context, req *violetpb.ListTargetsRequest) (*violetpb.ListRoutesResponse, error) {
+	b, err := s.auth(ctx, "violet:route")
+	if err != nil {
+		return nil, err
+	}
+	filter, offset, limit, err := s.targetFilter(req, b)
+	if err != nil {
+		return nil, err
+	}
+	routes, total, err := s.manager.ListRoutes(filter, offset, limit)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Failed to get routes from database")
+	}
+	out := &violetpb.ListRoutesResponse{Total: int32(total), Routes: make([]*violetpb.Route, len(routes))}
+	for i, r := range routes {
+		out.Routes[i] = &violetpb.Route{Id: r.Id, Src: r.Src, Dst: r.Dst, Flags: uint64(r.Flags), Active: r.Active, Deleted: r.Deleted}
+	}
+	return out, nil
+}
+
+func (s *grpcServer) PutRoute(ctx context.Context, req *violetpb.PutRouteRequest) (*violetpb.PutRouteResponse, error) {
+	b, err := s.auth(ctx, "violet:route")
+	if err != nil {
+		return nil, err
+	}
+	if req.Route == nil {
+		return nil, status.Error(codes.InvalidArgument, "Missing route")
+	}
+	route := target.Route{Src: req.Route.Src, Dst: req.Route.Dst, Flags: target.Flags(req.Route.Flags)}
+	if err := s.sourceTenant(route.Src, b); err != nil {
+		return nil, err
+	}
+	if code, msg := insertRoute(s.domains, s.manager, route, b, s.autoRegister); code != 0 {
+		return nil, grpcStatus(code, msg)
+	}
+	return &violetpb.PutRouteResponse{}, nil
+}
+
+func (s *grpcServer) DeleteRoute(ctx context.Context, req *violetpb.DeleteTargetRequest) (*violetpb.DeleteTargetResponse, error) {
+	b, err := s.auth(ctx, "violet:route")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sourceTenant(req.Src, b); err != nil {
+		return nil, err
+	}
+	if code, msg := deleteRoute(s.manager, req.Src); code != 0 {
+		return nil, grpcStatus(code, msg)
+	}
+	return &violetpb.DeleteTargetResponse{}, nil
+}
+
+func (s *grpcServer) ListRedirects(ctx context.Context, req *violetpb.ListTargetsRequest) (*violetpb.ListRedirectsResponse, error) {
+	b, err := s.auth(ctx, "violet:redirect")
+	if err != nil {
+		return nil, err
+	}
+	filter, offset, limit, err := s.targetFilter(req, b)
+	if err != nil {
+		return nil, err
+	}
+	redirects, total, err := s.manager.ListRedirects(filter, offset, limit)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Failed to get redirects from database")
+	}
+	out := &violetpb.ListRedirectsResponse{Total: int32(total), Redirects: make([]*violetpb.Redirect, len(redirects))}
+	for i, r := range redirects {
+		out.Redirects[i] = &violetpb.Redirect{Id: r.Id, Src: r.Src, Dst: r.Dst, Flags: uint64(r.Flags), Code: int32(r.Code), Active: r.Active, Deleted: r.Deleted}
+	}
+	return out, nil
+}
+
+func (s *grpcServer) PutRedirect(ctx context.Context, req *violetpb.PutRedirectRequest) (*violetpb.PutRedirectResponse, error) {
+	b, err := s.auth(ctx, "violet:redirect")
+	if err != nil {
+		return nil, err
+	}
+	if req.Redirect == nil {
+		return nil, status.Error(codes.InvalidArgument, "Missing redirect")
+	}
+	redirect := target.Redirect{Src: req.Redirect.Src, Dst: req.Redirect.Dst, Flags: target.Flags(req.Redirect.Flags), Code: int(req.Redirect.Code)}
+	if err := s.sourceTenant(redirect.Src, b); err != nil {
+		return nil, err
+	}
+	if code, msg := insertRedirect(s.domains, s.manager, redirect, b, s.autoRegister); code != 0 {
+		return nil, grpcStatus(code, msg)
+	}
+	return &violetpb.PutRedirectResponse{}, nil
+}
+
+func (s *grpcServer) DeleteRedirect(ctx context.Context, req *violetpb.DeleteTargetRequest) (*violetpb.DeleteTargetResponse, error) {
+	b, err := s.auth(ctx, "violet:redirect")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sourceTenant(req.Src, b); err != nil {
+		return nil, err
+	}
+	if code, msg := deleteRedirect(s.manager, req.Src); code != 0 {
+		return nil, grpcStatus(code, msg)
+	}
+	return &violetpb.DeleteTargetResponse{}, nil
+}
+
+func (s *grpcServer) Compile(ctx context.Context, _ *violetpb.CompileRequest) (*violetpb.CompileResponse, error) {
+	if _, err := s.auth(ctx, "violet:compile"); err != nil {
+		return nil, err
+	}
+	return &violetpb.CompileResponse{Id: s.jobs.Start()}, nil
+}
+
+func (s *grpcServer) GetCompile(ctx context.Context, req *violetpb.GetCompileRequest) (*violetpb.CompileJob, error) {
+	if _, err := s.auth(ctx, "violet:compile"); err != nil {
+		return nil, err
+	}
+	id := "last"
+	if req.Id != 0 {
+		id = strconv.FormatInt(req.Id, 10)
+	}
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "Unknown compile job")
+	}
+	out := &violetpb.CompileJob{
+		Id:         job.Id,
+		Status:     job.Status,
+		Started:    timestamppb.New(job.Started),
+		Results:    make([]*violetpb.CompileResult, len(job.Results)),
+		Generation: job.Generation,
+	}
+	if job.Finished != nil {
+		out.Finished = timestamppb.New(*job.Finished)
+	}
+	for i, r := range job.Results {
+		out.Results[i] = &violetpb.CompileResult{Name: r.Name, Error: r.Error, DurationMs: r.Duration}
+	}
+	return out, nil
+}
+
+func (s *grpcServer) Watch(_ *violetpb.WatchRequest, stream violetpb.Violet_WatchServer) error {
+	if _, err := s.auth(stream.Context(), "violet:events"); err != nil {
+		return err
+	}
+	ch, cancel := s.events.Subscribe()
+	defer cancel()
+
+	// send the headers so the client knows the watch has started
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e := <-ch:
+			err := stream.Send(&violetpb.Event{Type: e.Type, Actor: e.Actor, Method: e.Method, Path: e.Path, Time: timestamppb.New(e.Time)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"github.com/MrMelon54/violet/proxy"
+	"github.com/MrMelon54/violet/router"
+	"github.com/MrMelon54/violet/servers/api/violetpb"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/MrMelon54/violet/utils/fake"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewApiServers_Grpc(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestNewApiServers_Grpc?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+
+	_, srv := NewApiServers(newTestConf(&conf.Conf{GrpcListen: "bufconn", Router: manager}), utils.MultiCompilable{manager})
+	assert.NotNil(t, srv)
+
+	lis := bufconn.Listen(1 << 20)
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	cc, err := grpc.NewClient("passthrough:///bufconn", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+	assert.NoError(t, err)
+	defer cc.Close()
+	client := violetpb.NewVioletClient(cc)
+
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+key)
+	}
+	key := withKey(fake.GenSnakeOilKey("violet:route", "violet:events", "violet:compile", "owns=example.com"))
+
+	_, err = client.ListRoutes(context.Background(), &violetpb.ListTargetsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.PutRoute(withKey(fake.GenSnakeOilKey("violet:route:read", "owns=example.com")), &violetpb.PutRouteRequest{Route: &violetpb.Route{Src: "example.com", Dst: "127.0.0.1:8080"}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.PutRoute(key, &violetpb.PutRouteRequest{Route: &violetpb.Route{Src: "example.org", Dst: "127.0.0.1:8080"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// changes are streamed to watchers
+	watchCtx, cancel := context.WithTimeout(key, 5*time.Second)
+	defer cancel()
+	watch, err := client.Watch(watchCtx, &violetpb.WatchRequest{})
+	assert.NoError(t, err)
+	_, err = watch.Header()
+	assert.NoError(t, err)
+
+	_, err = client.PutRoute(key, &violetpb.PutRouteRequest{Route: &violetpb.Route{Src: "example.com", Dst: "127.0.0.1:8080"}})
+	assert.NoError(t, err)
+	e, err := watch.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "change", e.Type)
+	assert.Equal(t, violetpb.Violet_PutRoute_FullMethodName, e.Path)
+
+	routes, err := client.ListRoutes(key, &violetpb.ListTargetsRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), routes.Total)
+	assert.Equal(t, "example.com", routes.Routes[0].Src)
+	assert.True(t, routes.Routes[0].Active)
+	routes, err = client.ListRoutes(withKey(fake.GenSnakeOilKey("violet:route", "owns=example.org")), &violetpb.ListTargetsRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), routes.Total)
+
+	_, err = client.DeleteRoute(key, &violetpb.DeleteTargetRequest{Src: "example.com"})
+	assert.NoError(t, err)
+	routes, err = client.ListRoutes(key, &violetpb.ListTargetsRequest{Deleted: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), routes.Total)
+
+	compile, err := client.Compile(key, &violetpb.CompileRequest{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		job, err := client.GetCompile(key, &violetpb.GetCompileRequest{Id: compile.Id})
+		return err == nil && job.Status == "done" && job.Generation > 0
+	}, time.Second, 10*time.Millisecond)
+	_, err = client.GetCompile(key, &violetpb.GetCompileRequest{Id: 100})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestNewApiServers_GrpcLimits(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestNewApiServers_GrpcLimits?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+
+	_, srv := NewApiServers(newTestConf(&conf.Conf{
+		GrpcListen:      "bufconn",
+		Router:          manager,
+		ApiRateLimit:    5,
+		ApiAuthFailures: 2,
+	}), utils.MultiCompilable{manager})
+
+	lis := bufconn.Listen(1 << 20)
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	cc, err := grpc.NewClient("passthrough:///bufconn", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+	assert.NoError(t, err)
+	defer cc.Close()
+	client := violetpb.NewVioletClient(cc)
+
+	list := func(key string) codes.Code {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+key)
+		_, err := client.ListRoutes(ctx, &violetpb.ListTargetsRequest{})
+		return status.Code(err)
+	}
+
+	// valid tokens without the permission are not failures
+	assert.Equal(t, codes.PermissionDenied, list(fake.GenSnakeOilKey("violet:domains")))
+	assert.Equal(t, codes.OK, list(fake.GenSnakeOilKey("violet:route")))
+
+	// the address is blocked after two invalid tokens
+	assert.Equal(t, codes.Unauthenticated, list("abc"))
+	assert.Equal(t, codes.Unauthenticated, list("abc"))
+	assert.Equal(t, codes.ResourceExhausted, list(fake.GenSnakeOilKey("violet:route")))
+
+	// the calls share the rate limit of the address
+	st, ok := status.FromError(func() error {
+		_, err := client.Compile(context.Background(), &violetpb.CompileRequest{})
+		return err
+	}())
+	assert.True(t, ok)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	assert.Equal(t, "Rate limit exceeded", st.Message())
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/MrMelon54/mjwt"
+	"github.com/MrMelon54/violet/router"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"log"
+	"net/http"
+	"time"
+)
+
+// healthTimeout is the maximum duration of the database check
+const healthTimeout = 5 * time.Second
+
+// healthCompile is the last compile job in the health output
+type healthCompile struct {
+	Id       int64      `json:"id"`
+	Status   string     `json:"status"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// healthStatus is the output of the health endpoint
+type healthStatus struct {
+	Healthy    bool                  `json:"healthy"`
+	Database   string                `json:"database"` // ok, unavailable or disabled
+	Compile    *healthCompile        `json:"compile"`  // nil before the first compile job
+	Generation uint64                `json:"generation"`
+	Listeners  []utils.ListenerState `json:"listeners"`
+}
+
+// healthState is the output of the health endpoint for callers without a token
+type healthState struct {
+	Healthy bool `json:"healthy"`
+}
+
+// healthCheck outputs the database connectivity, last compile status,
+// configuration generation and listener states, the status code is 503 if any
+// of them are failing. Callers without a valid token only receive the state so
+// the listener addresses are not public.
+func healthCheck(verify mjwt.Verifier, db *sql.DB, jobs *compileJobs, manager *router.Manager, listeners *utils.ListenerStates) httprouter.Handle {
+	return func(rw http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		h := healthStatus{Healthy: true, Database: "disabled", Listeners: []utils.ListenerState{}}
+
+		if db != nil {
+			ctx, cancel := context.WithTimeout(req.Context(), healthTimeout)
+			err := db.PingContext(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("[API] Health check failed to ping database: %s\n", err)
+				h.Database = "unavailable"
+				h.Healthy = false
+			} else {
+				h.Database = "ok"
+			}
+		}
+
+		if job, ok := jobs.Get("last"); ok {
+			h.Compile = &healthCompile{Id: job.Id, Status: job.Status, Finished: job.Finished}
+			if job.Status == "failed" {
+				h.Healthy = false
+			}
+		}
+
+		if manager != nil {
+			h.Generation = manager.Generation()
+		}
+
+		if listeners != nil {
+			h.Listeners = listeners.List()
+			for _, i := range h.Listeners {
+				if i.State != utils.ListenerListening {
+					h.Healthy = false
+				}
+			}
+		}
+
+		rw.Header().Set("Cache-Control", "no-store")
+		if h.Healthy {
+			rw.WriteHeader(http.StatusOK)
+		} else {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if !hasValidAuth(verify, req) {
+			_ = json.NewEncoder(rw).Encode(healthState{Healthy: h.Healthy})
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(h)
+	}
+}
+
+// hasValidAuth returns true for trusted local users and requests with a valid
+// token, invalid tokens are counted as failed authentication attempts
+func hasValidAuth(verify mjwt.Verifier, req *http.Request) bool {
+	if _, ok := localPeerUid(req.Context()); ok {
+		return true
+	}
+	bearer := utils.GetBearer(req)
+	if bearer == "" {
+		return false
+	}
+	if _, err := verifyBearer(verify, bearer); err != nil {
+		setAuthFailed(req)
+		return false
+	}
+	return true
+}
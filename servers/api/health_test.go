@@ -0,0 +1,89 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/MrMelon54/violet/proxy"
+	"github.com/MrMelon54/violet/router"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/MrMelon54/violet/utils/fake"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type failCompilable struct{}
+
+func (f failCompilable) Compile()           {}
+func (f failCompilable) CompileSync() error { return errors.New("fail") }
+
+func TestNewApiServer_Health(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestNewApiServer_Health?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	api := newTestApi(t, &conf.Conf{DB: db, Listeners: utils.NewListenerStates()}, failCompilable{})
+	key := fake.GenSnakeOilKey("violet:compile")
+
+	health := func() (int, healthStatus) {
+		rec := api.do(http.MethodGet, "/v1/health", key, nil)
+		var h healthStatus
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&h))
+		return rec.Code, h
+	}
+
+	code, h := health()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthStatus{Healthy: true, Database: "ok", Listeners: []utils.ListenerState{}}, h)
+
+	// callers without a token only get the state
+	rec := api.do(http.MethodGet, "/v1/health", "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"healthy":true}`, rec.Body.String())
+
+	// an invalid token is treated as no token
+	rec = api.do(http.MethodGet, "/v1/health", "abc", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"healthy":true}`, rec.Body.String())
+
+	// a failed compile makes the control plane unhealthy
+	api.do(http.MethodPost, "/v1/compile", key, nil)
+	assert.Eventually(t, func() bool {
+		_, h = health()
+		return h.Compile != nil && h.Compile.Finished != nil
+	}, time.Second, 10*time.Millisecond)
+	code, h = health()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, h.Healthy)
+	assert.Equal(t, "failed", h.Compile.Status)
+
+	// a closed database is unavailable
+	assert.NoError(t, db.Close())
+	code, h = health()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", h.Database)
+}
+
+func TestNewApiServer_Generation(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestNewApiServer_Generation?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+
+	api := newTestApi(t, &conf.Conf{DB: db, Router: manager}, manager)
+	key := fake.GenSnakeOilKey("violet:compile")
+	api.do(http.MethodPost, "/v1/compile", key, nil)
+
+	var job compileJob
+	assert.Eventually(t, func() bool {
+		job = getJson[compileJob](api, "/v1/compile/last", key)
+		return job.Finished != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "done", job.Status)
+	assert.Equal(t, uint64(1), job.Generation)
+
+	h := getJson[healthStatus](api, "/v1/health", key)
+	assert.Equal(t, uint64(1), h.Generation)
+}
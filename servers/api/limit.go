@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/httplimit"
+	"github.com/sethvargo/go-limiter/memorystore"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// apiLockoutDuration is how long an address is blocked after too many failed
+// authentication attempts
+const apiLockoutDuration = 15 * time.Minute
+
+// maxLockoutEntries is the number of tracked addresses before expired entries
+// are removed
+const maxLockoutEntries = 1024
+
+type authFailKey struct{}
+
+// authFailures is the recent failed authentication attempts from an address
+type authFailures struct {
+	count uint64
+	last  time.Time
+	until time.Time
+}
+
+// authLockout blocks addresses after too many failed authentication attempts
+type authLockout struct {
+	s        *sync.Mutex
+	max      uint64
+	failures map[string]*authFailures
+}
+
+func newAuthLockout(max uint64) *authLockout {
+	return &authLockout{s: &sync.Mutex{}, max: max, failures: make(map[string]*authFailures)}
+}
+
+// Handle rejects requests from blocked addresses and records the failed
+// authentication attempts reported by checkAuth
+func (a *authLockout) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if _, ok := localPeerUid(req.Context()); ok {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		ip := remoteIp(req)
+		if until, ok := a.lockedUntil(ip, time.Now()); ok {
+			rw.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+			apiError(rw, http.StatusTooManyRequests, "Too many failed authentication attempts")
+			return
+		}
+
+		failed := new(bool)
+		next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), authFailKey{}, failed)))
+		if *failed {
+			a.fail(ip, time.Now())
+		}
+	})
+}
+
+// lockedUntil returns the end of the lockout if the address is blocked
+func (a *authLockout) lockedUntil(ip string, now time.Time) (time.Time, bool) {
+	a.s.Lock()
+	defer a.s.Unlock()
+	f, ok := a.failures[ip]
+	if !ok || !now.Before(f.until) {
+		return time.Time{}, false
+	}
+	return f.until, true
+}
+
+// fail records a failed attempt and blocks the address once the maximum number
+// of failures is reached within the lockout duration
+func (a *authLockout) fail(ip string, now time.Time) {
+	a.s.Lock()
+	defer a.s.Unlock()
+	if len(a.failures) >= maxLockoutEntries {
+		for k, v := range a.failures {
+			if now.Sub(v.last) > apiLockoutDuration && !now.Before(v.until) {
+				delete(a.failures, k)
+			}
+		}
+	}
+
+	f, ok := a.failures[ip]
+	if !ok || now.Sub(f.last) > apiLockoutDuration {
+		f = &authFailures{}
+		a.failures[ip] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= a.max {
+		f.count = 0
+		f.until = now.Add(apiLockoutDuration)
+	}
+}
+
+// setAuthFailed reports a failed authentication attempt for the request
+func setAuthFailed(req *http.Request) {
+	setAuthFailedCtx(req.Context())
+}
+
+// setAuthFailedCtx reports a failed authentication attempt for the HTTP request
+// or gRPC call using the context
+func setAuthFailedCtx(ctx context.Context) {
+	if failed, ok := ctx.Value(authFailKey{}).(*bool); ok {
+		*failed = true
+	}
+}
+
+// remoteIp returns the ip address of the client
+func remoteIp(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// apiLimits is the per address rate limit and authentication lockout shared by
+// the HTTP and gRPC servers, a nil store or lockout is disabled
+type apiLimits struct {
+	store   limiter.Store
+	lockout *authLockout
+}
+
+// newApiLimits creates the rate limit and authentication lockout, either is
+// disabled if zero
+func newApiLimits(rateLimit, authFailures uint64) *apiLimits {
+	l := &apiLimits{}
+	if authFailures > 0 {
+		l.lockout = newAuthLockout(authFailures)
+	}
+	if rateLimit > 0 {
+		store, err := memorystore.New(&memorystore.Config{
+			Tokens:   rateLimit,
+			Interval: time.Minute,
+		})
+		if err != nil {
+			log.Fatalln(err)
+		}
+		l.store = store
+	}
+	return l
+}
+
+// Handle adds the rate limit and authentication lockout to the HTTP handler,
+// the rate limit headers match the httplimit middleware and the 429 response
+// is a JSON error
+func (l *apiLimits) Handle(next http.Handler) http.Handler {
+	if l.lockout != nil {
+		next = l.lockout.Handle(next)
+	}
+	if l.store == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// trusted local users are not limited
+		if _, ok := localPeerUid(req.Context()); ok {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		limit, remaining, reset, ok, err := l.store.Take(req.Context(), remoteIp(req))
+		if err != nil {
+			apiError(rw, http.StatusInternalServerError, "Failed to check rate limit")
+			return
+		}
+		resetTime := time.Unix(0, int64(reset)).UTC()
+		rw.Header().Set(httplimit.HeaderRateLimitLimit, strconv.FormatUint(limit, 10))
+		rw.Header().Set(httplimit.HeaderRateLimitRemaining, strconv.FormatUint(remaining, 10))
+		rw.Header().Set(httplimit.HeaderRateLimitReset, resetTime.Format(time.RFC1123))
+		if !ok {
+			rw.Header().Set(httplimit.HeaderRetryAfter, resetTime.Format(time.RFC1123))
+			apiError(rw, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthLockout(t *testing.T) {
+	api := newTestApi(t, &conf.Conf{ApiAuthFailures: 2})
+	get := func(addr, key string) int {
+		req := newTestRequest(http.MethodGet, "/v1/domain", key, nil)
+		req.RemoteAddr = addr
+		return api.serve(req).Code
+	}
+
+	// valid tokens without the permission are not failures
+	assert.Equal(t, http.StatusForbidden, get("1.2.3.4:1000", fake.GenSnakeOilKey("violet:route")))
+	assert.Equal(t, http.StatusForbidden, get("1.2.3.4:1000", fake.GenSnakeOilKey("violet:route")))
+	assert.Equal(t, http.StatusOK, get("1.2.3.4:1000", fake.GenSnakeOilKey("violet:domains")))
+
+	// the address is blocked after two invalid tokens
+	assert.Equal(t, http.StatusForbidden, get("1.2.3.4:1000", "abc"))
+	assert.Equal(t, http.StatusForbidden, get("1.2.3.4:1001", "abc"))
+	assert.Equal(t, http.StatusTooManyRequests, get("1.2.3.4:1002", fake.GenSnakeOilKey("violet:domains")))
+
+	// other addresses are unaffected
+	assert.Equal(t, http.StatusOK, get("5.6.7.8:1000", fake.GenSnakeOilKey("violet:domains")))
+}
+
+func TestAuthLockout_Expiry(t *testing.T) {
+	a := newAuthLockout(2)
+	now := time.Now()
+	a.fail("1.2.3.4", now)
+	_, ok := a.lockedUntil("1.2.3.4", now)
+	assert.False(t, ok)
+
+	// failures older than the lockout duration are forgotten
+	a.fail("1.2.3.4", now.Add(apiLockoutDuration+time.Second))
+	_, ok = a.lockedUntil("1.2.3.4", now.Add(apiLockoutDuration+time.Second))
+	assert.False(t, ok)
+
+	a.fail("1.2.3.4", now.Add(apiLockoutDuration+2*time.Second))
+	until, ok := a.lockedUntil("1.2.3.4", now.Add(apiLockoutDuration+2*time.Second))
+	assert.True(t, ok)
+	_, ok = a.lockedUntil("1.2.3.4", until)
+	assert.False(t, ok)
+}
+
+func TestApiLimits_RateLimit(t *testing.T) {
+	h := newApiLimits(2, 0).Handle(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	codes := make([]int, 0, 3)
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "https://example.com/v1/compile", nil)
+		req.RemoteAddr = "1.2.3.4:1000"
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error":"Rate limit exceeded","code":"too_many_requests"}`, rec.Body.String())
+}
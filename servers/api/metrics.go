@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/MrMelon54/certgen"
+	"github.com/MrMelon54/mjwt"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// apiDurationBuckets are the upper bounds in seconds of the request duration
+// histogram
+var apiDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// apiRequestKey identifies an endpoint using the registered path so the number
+// of series doesn't grow with the requested paths
+type apiRequestKey struct {
+	method string
+	path   string
+}
+
+// apiRequestStats are the counts and durations of requests to an endpoint
+type apiRequestStats struct {
+	codes   map[int]uint64
+	buckets []uint64 // requests no longer than each bucket, excluding smaller buckets
+	sum     float64
+	count   uint64
+}
+
+// apiMetrics collects the metrics for the API server
+type apiMetrics struct {
+	s            *sync.Mutex
+	requests     map[apiRequestKey]*apiRequestStats
+	authFailures uint64
+	compiles     map[string]uint64
+	certs        utils.CertProvider // nil if certificates aren't served
+}
+
+func newApiMetrics(certs utils.CertProvider) *apiMetrics {
+	return &apiMetrics{
+		s:        &sync.Mutex{},
+		requests: make(map[apiRequestKey]*apiRequestStats),
+		compiles: make(map[string]uint64),
+		certs:    certs,
+	}
+}
+
+// Instrument records the status code and duration of each request to the
+// endpoint and the failed authentication attempts
+func (m *apiMetrics) Instrument(method, p string, h httprouter.Handle) httprouter.Handle {
+	key := apiRequestKey{method, p}
+	return func(rw http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: rw}
+		failed := new(bool)
+		h(sw, req.WithContext(context.WithValue(req.Context(), authFailKey{}, failed)), params)
+
+		// pass the failure on to the lockout
+		if *failed {
+			setAuthFailed(req)
+		}
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		m.observe(key, sw.status, time.Since(start), *failed)
+	}
+}
+
+func (m *apiMetrics) observe(key apiRequestKey, code int, d time.Duration, authFailed bool) {
+	m.s.Lock()
+	defer m.s.Unlock()
+	r, ok := m.requests[key]
+	if !ok {
+		r = &apiRequestStats{codes: make(map[int]uint64), buckets: make([]uint64, len(apiDurationBuckets))}
+		m.requests[key] = r
+	}
+	r.codes[code]++
+	seconds := d.Seconds()
+	for i, b := range apiDurationBuckets {
+		if seconds <= b {
+			r.buckets[i]++
+			break
+		}
+	}
+	r.sum += seconds
+	r.count++
+	if authFailed {
+		m.authFailures++
+	}
+}
+
+// CompileFinished counts a finished compile job with the status
+func (m *apiMetrics) CompileFinished(status string) {
+	m.s.Lock()
+	m.compiles[status]++
+	m.s.Unlock()
+}
+
+// Output outputs the metrics in the Prometheus text format
+func (m *apiMetrics) Output(w io.Writer) {
+	m.s.Lock()
+	defer m.s.Unlock()
+
+	keys := make([]apiRequestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].path == keys[j].path {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].path < keys[j].path
+	})
+
+	_, _ = fmt.Fprintln(w, "# HELP violet_api_requests_total Number of API requests by endpoint and status code.")
+	_, _ = fmt.Fprintln(w, "# TYPE violet_api_requests_total counter")
+	for _, k := range keys {
+		r := m.requests[k]
+		codes := make([]int, 0, len(r.codes))
+		for c := range r.codes {
+			codes = append(codes, c)
+		}
+		sort.Ints(codes)
+		for _, c := range codes {
+			_, _ = fmt.Fprintf(w, "violet_api_requests_total{method=%q,path=%q,code=\"%d\"} %d\n", k.method, k.path, c, r.codes[c])
+		}
+	}
+
+	_, _ = fmt.Fprintln(w, "# HELP violet_api_request_duration_seconds Duration of API requests by endpoint.")
+	_, _ = fmt.Fprintln(w, "# TYPE violet_api_request_duration_seconds histogram")
+	for _, k := range keys {
+		r := m.requests[k]
+		var total uint64
+		for i, b := range apiDurationBuckets {
+			total += r.buckets[i]
+			_, _ = fmt.Fprintf(w, "violet_api_request_duration_seconds_bucket{method=%q,path=%q,le=%q} %d\n", k.method, k.path, strconv.FormatFloat(b, 'g', -1, 64), total)
+		}
+		_, _ = fmt.Fprintf(w, "violet_api_request_duration_seconds_bucket{method=%q,path=%q,le=\"+Inf\"} %d\n", k.method, k.path, r.count)
+		_, _ = fmt.Fprintf(w, "violet_api_request_duration_seconds_sum{method=%q,path=%q} %s\n", k.method, k.path, strconv.FormatFloat(r.sum, 'g', -1, 64))
+		_, _ = fmt.Fprintf(w, "violet_api_request_duration_seconds_count{method=%q,path=%q} %d\n", k.method, k.path, r.count)
+	}
+
+	_, _ = fmt.Fprintln(w, "# HELP violet_api_auth_failures_total Number of API requests with an invalid token.")
+	_, _ = fmt.Fprintln(w, "# TYPE violet_api_auth_failures_total counter")
+	_, _ = fmt.Fprintf(w, "violet_api_auth_failures_total %d\n", m.authFailures)
+
+	_, _ = fmt.Fprintln(w, "# HELP violet_api_compiles_total Number of compile jobs by result.")
+	_, _ = fmt.Fprintln(w, "# TYPE violet_api_compiles_total counter")
+	for _, status := range []string{"done", "failed"} {
+		_, _ = fmt.Fprintf(w, "violet_api_compiles_total{status=%q} %d\n", status, m.compiles[status])
+	}
+
+	if m.certs == nil {
+		return
+	}
+	_, _ = fmt.Fprintln(w, "# HELP violet_cert_expiry_seconds Seconds until each served certificate expires.")
+	_, _ = fmt.Fprintln(w, "# TYPE violet_cert_expiry_seconds gauge")
+	now := time.Now()
+	for _, cert := range m.certs.GetAllCerts() {
+		leaf := certgen.TlsLeaf(cert)
+		if leaf == nil {
+			continue
+		}
+		name := leaf.Subject.CommonName
+		if len(leaf.DNSNames) > 0 {
+			name = leaf.DNSNames[0]
+		}
+		// the serial number keeps the series of renewed certificates apart
+		_, _ = fmt.Fprintf(w, "violet_cert_expiry_seconds{name=%q,serial=%q} %d\n", name, leaf.SerialNumber.String(), int64(leaf.NotAfter.Sub(now).Seconds()))
+	}
+}
+
+// metricsHandler outputs the API metrics in the Prometheus text format
+func metricsHandler(verify mjwt.Verifier, m *apiMetrics) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:metrics", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		rw.WriteHeader(http.StatusOK)
+		m.Output(rw)
+	})
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"crypto/x509/pkix"
+	"github.com/MrMelon54/certgen"
+	"github.com/MrMelon54/violet/certs"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewApiServer_Metrics(t *testing.T) {
+	api := newTestApi(t, nil)
+
+	assert.Equal(t, http.StatusOK, api.do(http.MethodGet, "/v1/domain", fake.GenSnakeOilKey("violet:domains"), nil).Code)
+	assert.Equal(t, http.StatusForbidden, api.do(http.MethodGet, "/v1/domain", "abc", nil).Code)
+	assert.Equal(t, http.StatusForbidden, api.do(http.MethodGet, "/metrics", fake.GenSnakeOilKey("violet:domains"), nil).Code)
+
+	rec := api.do(http.MethodGet, "/v1/metrics", fake.GenSnakeOilKey("violet:metrics"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "violet_api_requests_total{method=\"GET\",path=\"/domain\",code=\"200\"} 1\n")
+	assert.Contains(t, body, "violet_api_requests_total{method=\"GET\",path=\"/domain\",code=\"403\"} 1\n")
+	assert.Contains(t, body, "violet_api_requests_total{method=\"GET\",path=\"/metrics\",code=\"403\"} 1\n")
+	assert.Contains(t, body, "violet_api_request_duration_seconds_count{method=\"GET\",path=\"/domain\"} 2\n")
+	assert.Contains(t, body, "violet_api_auth_failures_total 1\n")
+	assert.Contains(t, body, "violet_api_compiles_total{status=\"done\"} 0\n")
+}
+
+func TestNewApiServer_CertMetrics(t *testing.T) {
+	ca, err := certgen.MakeCaTls(2048, pkix.Name{CommonName: "ca.violet.test"}, big.NewInt(0), func(now time.Time) time.Time {
+		return now.AddDate(1, 0, 0)
+	})
+	assert.NoError(t, err)
+	serverTls, err := certgen.MakeServerTls(ca, 2048, pkix.Name{CommonName: "test.example.com"}, big.NewInt(5), func(now time.Time) time.Time {
+		return now.AddDate(0, 0, 10)
+	}, []string{"test.example.com"}, nil)
+	assert.NoError(t, err)
+
+	certDir, keyDir := certs.DirFS(t.TempDir()), certs.DirFS(t.TempDir())
+	assert.NoError(t, certDir.WriteFile("test.example.com.cert.pem", serverTls.GetCertPem()))
+	assert.NoError(t, keyDir.WriteFile("test.example.com.key.pem", serverTls.GetKeyPem()))
+	certProvider := certs.New(certDir, keyDir, false)
+	assert.NoError(t, certProvider.CompileSync())
+
+	api := newTestApi(t, &conf.Conf{Certs: certProvider})
+	rec := api.do(http.MethodGet, "/v1/metrics", fake.GenSnakeOilKey("violet:metrics"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	m := regexp.MustCompile(`violet_cert_expiry_seconds\{name="test.example.com",serial="5"} (\d+)\n`).FindStringSubmatch(rec.Body.String())
+	if assert.Len(t, m, 2) {
+		seconds, err := strconv.ParseInt(m[1], 10, 64)
+		assert.NoError(t, err)
+		assert.InDelta(t, (10 * 24 * time.Hour).Seconds(), seconds, time.Hour.Seconds())
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/MrMelon54/violet/audit"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+)
+
+// apiVersion is the prefix for the current version of the API
+const apiVersion = "/v1"
+
+// anyPerm is used in the endpointDoc for endpoints which accept any valid token
+const anyPerm = "*"
+
+// endpointDoc describes an endpoint in the OpenAPI document
+type endpointDoc struct {
+	Summary string
+	Perm    string // permission required by the token, empty for public endpoints
+}
+
+// apiEndpoint is a registered endpoint
+type apiEndpoint struct {
+	method string
+	path   string
+	doc    endpointDoc
+}
+
+// apiRouter registers each endpoint under the version prefix and keeps the
+// endpoint descriptions for the OpenAPI document.
+//
+// Endpoints are also registered without the prefix so older clients continue
+// to work.
+type apiRouter struct {
+	r         *httprouter.Router
+	audit     *audit.Log // records changes made by other methods than GET
+	events    *eventHub  // receives changes made by other methods than GET
+	metrics   *apiMetrics
+	access    *accessLog // records every request, nil disables
+	endpoints []apiEndpoint
+	exact     map[string]httprouter.Handle // method and path of endpoints matched before the router
+}
+
+// wrap adds the audit log, events, metrics and access log to the handler
+func (a *apiRouter) wrap(method, p string, h httprouter.Handle) httprouter.Handle {
+	if method != http.MethodGet {
+		h = recordChanges(a.audit, a.events, h)
+	}
+	if a.metrics != nil {
+		h = a.metrics.Instrument(method, p, h)
+	}
+	return a.access.Wrap(method, p, h)
+}
+
+func (a *apiRouter) handle(method, p string, doc endpointDoc, h httprouter.Handle) {
+	h = a.wrap(method, p, h)
+	a.r.Handle(method, apiVersion+p, h)
+	a.r.Handle(method, p, h)
+	a.endpoints = append(a.endpoints, apiEndpoint{method, p, doc})
+}
+
+// handleExact registers an endpoint with a static segment in the same position
+// as a parameter of another endpoint, httprouter doesn't allow both so these
+// paths are matched before the router
+func (a *apiRouter) handleExact(method, p string, doc endpointDoc, h httprouter.Handle) {
+	if a.exact == nil {
+		a.exact = make(map[string]httprouter.Handle)
+	}
+	h = a.wrap(method, p, h)
+	a.exact[method+" "+apiVersion+p] = h
+	a.exact[method+" "+p] = h
+	a.endpoints = append(a.endpoints, apiEndpoint{method, p, doc})
+}
+
+func (a *apiRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if h, ok := a.exact[req.Method+" "+req.URL.Path]; ok {
+		h(rw, req, nil)
+		return
+	}
+	a.r.ServeHTTP(rw, req)
+}
+
+func (a *apiRouter) GET(p string, doc endpointDoc, h httprouter.Handle) {
+	a.handle(http.MethodGet, p, doc, h)
+}
+func (a *apiRouter) POST(p string, doc endpointDoc, h httprouter.Handle) {
+	a.handle(http.MethodPost, p, doc, h)
+}
+func (a *apiRouter) PUT(p string, doc endpointDoc, h httprouter.Handle) {
+	a.handle(http.MethodPut, p, doc, h)
+}
+func (a *apiRouter) PATCH(p string, doc endpointDoc, h httprouter.Handle) {
+	a.handle(http.MethodPatch, p, doc, h)
+}
+func (a *apiRouter) DELETE(p string, doc endpointDoc, h httprouter.Handle) {
+	a.handle(http.MethodDelete, p, doc, h)
+}
+
+// serveOpenApi adds the endpoint which outputs the OpenAPI document, this must
+// be called after all other endpoints are registered
+func (a *apiRouter) serveOpenApi() {
+	doc, err := json.Marshal(a.openApi())
+	if err != nil {
+		panic(err)
+	}
+	a.r.GET(apiVersion+"/openapi.json", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(doc)
+	})
+}
+
+// openApi generates an OpenAPI 3 document from the registered endpoints
+func (a *apiRouter) openApi() map[string]any {
+	paths := make(map[string]map[string]any)
+	for _, i := range a.endpoints {
+		// convert httprouter parameters into OpenAPI parameters
+		segments := strings.Split(i.path, "/")
+		params := make([]map[string]any, 0)
+		for n, s := range segments {
+			if strings.HasPrefix(s, ":") {
+				params = append(params, map[string]any{
+					"name":     s[1:],
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]any{"type": "string"},
+				})
+				segments[n] = "{" + s[1:] + "}"
+			}
+		}
+		p := strings.Join(segments, "/")
+
+		op := map[string]any{
+			"summary":    i.doc.Summary,
+			"parameters": params,
+			"responses": map[string]any{
+				"default": map[string]any{
+					"description": "JSON response or error message",
+					"content": map[string]any{
+						"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+					},
+				},
+			},
+		}
+		switch i.doc.Perm {
+		case "":
+		case anyPerm:
+			op["description"] = "Requires a valid token."
+			op["security"] = []map[string][]string{{"bearerAuth": {}}}
+		default:
+			op["description"] = "Requires the `" + i.doc.Perm + "` or `" + scopedPerm(i.doc.Perm, i.method) + "` permission."
+			op["security"] = []map[string][]string{{"bearerAuth": {}}}
+		}
+		if paths[p] == nil {
+			paths[p] = make(map[string]any)
+		}
+		paths[p][strings.ToLower(i.method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Violet API",
+			"version": strings.TrimPrefix(apiVersion, "/"),
+		},
+		"servers": []map[string]any{{"url": apiVersion}},
+		"paths":   paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+			"schemas": map[string]any{
+				"Error": map[string]any{
+					"type":     "object",
+					"required": []string{"error", "code"},
+					"properties": map[string]any{
+						"error": map[string]any{"type": "string", "description": "message for people"},
+						"code":  map[string]any{"type": "string", "description": "stable code such as `not_found`, `invalid_body` or `validation_failed`"},
+						"fields": map[string]any{
+							"type": "array",
+							"items": map[string]any{
+								"type": "object",
+								"properties": map[string]any{
+									"field":   map[string]any{"type": "string"},
+									"message": map[string]any{"type": "string"},
+									"value":   map[string]any{"description": "the offending value"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"github.com/MrMelon54/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestNewApiServer_OpenApi(t *testing.T) {
+	api := newTestApi(t, nil)
+
+	type openApiDoc struct {
+		OpenApi string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Summary    string `json:"summary"`
+			Parameters []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+			Security []map[string][]string `json:"security"`
+		} `json:"paths"`
+	}
+	doc := getJson[openApiDoc](api, "/v1/openapi.json", "")
+	assert.Equal(t, "3.0.3", doc.OpenApi)
+	op := doc.Paths["/domain/{domain}"]["patch"]
+	assert.Equal(t, "Update the settings of a domain", op.Summary)
+	assert.Len(t, op.Parameters, 1)
+	assert.Equal(t, "domain", op.Parameters[0].Name)
+	assert.Equal(t, "path", op.Parameters[0].In)
+	assert.Len(t, op.Security, 1)
+	assert.Contains(t, doc.Paths, "/route")
+	assert.Contains(t, doc.Paths, "/cert/{domain}/reload")
+
+	// endpoints are available with and without the version prefix
+	for _, p := range []string{"/v1/compile", "/compile"} {
+		assert.Equal(t, http.StatusAccepted, api.do(http.MethodPost, p, fake.GenSnakeOilKey("violet:compile"), nil).Code)
+	}
+}
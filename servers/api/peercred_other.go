@@ -0,0 +1,13 @@
+//go:build !linux
+
+package api
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUid returns the uid of the process connected to the unix socket
+func peerUid(net.Conn) (uint32, error) {
+	return 0, errors.New("peer credentials are not supported on this platform")
+}
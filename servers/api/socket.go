@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"github.com/MrMelon54/violet/servers/conf"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+type localPeerKey struct{}
+
+// NewApiSocketServer creates a http server for the api handler which is used
+// with a unix socket, requests from the trusted uids are allowed without a
+// bearer token. The limits are shared with the api server.
+func NewApiSocketServer(handler http.Handler, uids []uint32, limits conf.ServerLimits) *http.Server {
+	trusted := make(map[uint32]struct{}, len(uids))
+	for _, i := range uids {
+		trusted[i] = struct{}{}
+	}
+	srv := &http.Server{
+		Handler: handler,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			uid, err := peerUid(c)
+			if err != nil {
+				log.Printf("[API] Failed to read peer credentials: %s\n", err)
+				return ctx
+			}
+			if _, ok := trusted[uid]; ok {
+				return context.WithValue(ctx, localPeerKey{}, uid)
+			}
+			return ctx
+		},
+		ReadTimeout:       time.Minute,
+		ReadHeaderTimeout: time.Minute,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       time.Minute,
+		MaxHeaderBytes:    2500,
+	}
+	limits.Apply(srv)
+	return srv
+}
+
+// localPeerUid returns the uid of the trusted local user making the request
+func localPeerUid(ctx context.Context) (uint32, bool) {
+	uid, ok := ctx.Value(localPeerKey{}).(uint32)
+	return uid, ok
+}
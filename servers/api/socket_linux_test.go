@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestNewApiSocketServer(t *testing.T) {
+	domains := &fake.Domains{}
+	handler := newTestApi(t, &conf.Conf{Domains: domains}).srv.Handler
+
+	put := func(uids []uint32) int {
+		p := filepath.Join(t.TempDir(), "api.sock")
+		l, err := net.Listen("unix", p)
+		assert.NoError(t, err)
+		srv := NewApiSocketServer(handler, uids, conf.ServerLimits{})
+		go func() { _ = srv.Serve(l) }()
+		defer srv.Close()
+
+		client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", p)
+		}}}
+		req, err := http.NewRequest(http.MethodPut, "http://localhost/v1/domain/example.com", nil)
+		assert.NoError(t, err)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// untrusted users still need a token
+	assert.Equal(t, http.StatusForbidden, put(nil))
+	assert.Empty(t, domains.Owner)
+
+	uid := uint32(os.Getuid())
+	assert.Equal(t, http.StatusOK, put([]uint32{uid}))
+	assert.Equal(t, "uid:"+strconv.FormatUint(uint64(uid), 10), domains.Owner)
+}
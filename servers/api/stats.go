@@ -0,0 +1,26 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/MrMelon54/mjwt"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// hostStats outputs the recent request counts, status classes and latency of
+// a host owned by the token
+func hostStats(verify mjwt.Verifier, domains utils.DomainProvider, stats *utils.HostStats) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:stats", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		host, ok := utils.NormaliseDomain(params.ByName("host"))
+		if !ok || host == "" {
+			apiError(rw, http.StatusBadRequest, "Invalid host")
+			return
+		}
+		if _, ok := checkDomainTenant(rw, domains, host, b); !ok {
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(stats.Get(host))
+	})
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/MrMelon54/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewApiServer_Stats(t *testing.T) {
+	stats := utils.NewHostStats()
+	stats.Record("example.com", http.StatusOK, 20*time.Millisecond)
+	stats.Record("example.com", http.StatusBadGateway, 40*time.Millisecond)
+
+	api := newTestApi(t, &conf.Conf{Stats: stats})
+
+	assert.Equal(t, http.StatusForbidden, api.do(http.MethodGet, "/v1/stats/example.com", fake.GenSnakeOilKey("owns=example.com"), nil).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodGet, "/v1/stats/example.com", fake.GenSnakeOilKey("violet:stats", "owns=example.org"), nil).Code)
+
+	out := getJson[utils.HostStatsSummary](api, "/v1/stats/example.com", fake.GenSnakeOilKey("violet:stats", "owns=example.com"))
+	assert.Equal(t, uint64(2), out.Requests)
+	assert.Equal(t, uint64(1), out.Status["2xx"])
+	assert.Equal(t, uint64(1), out.Status["5xx"])
+	assert.Equal(t, float64(20), out.P50)
+	assert.Equal(t, float64(40), out.P95)
+}
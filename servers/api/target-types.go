@@ -13,10 +13,23 @@ func (s sourceJson) GetSource() string { return s.Src }
 type routeSource target.Route
 
 func (r routeSource) GetSource() string { return r.Src }
+func (r routeSource) ValidateFields() []target.FieldError {
+	return target.Route(r).ValidateFields()
+}
 
 type redirectSource target.Redirect
 
 func (r redirectSource) GetSource() string { return r.Src }
+func (r redirectSource) ValidateFields() []target.FieldError {
+	return target.Redirect(r).ValidateFields()
+}
+
+// batchJson is the body of the batch endpoints, entries in put are added or
+// updated and the sources in delete are disabled
+type batchJson[T any] struct {
+	Put    []T      `json:"put"`
+	Delete []string `json:"delete"`
+}
 
 var (
 	_ sourceGetter = sourceJson{}
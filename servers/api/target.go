@@ -2,98 +2,735 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/MrMelon54/mjwt"
 	"github.com/MrMelon54/violet/router"
 	"github.com/MrMelon54/violet/target"
 	"github.com/MrMelon54/violet/utils"
 	"github.com/julienschmidt/httprouter"
+	"io/fs"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
-func SetupTargetApis(r *httprouter.Router, verify mjwt.Verifier, manager *router.Manager) {
+// SetupTargetApis adds the route and redirect endpoints, if autoRegister is
+// true then the host of new routes and redirects is added to the domain list.
+// If requireIfMatch is true then updates by id must include an If-Match header.
+func SetupTargetApis(r *apiRouter, verify mjwt.Verifier, domains utils.DomainProvider, manager *router.Manager, autoRegister, requireIfMatch bool) {
 	// Endpoint for routes
-	r.GET("/route", checkAuthWithPerm(verify, "violet:route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
-		routes, err := manager.GetAllRoutes()
+	r.GET("/route", endpointDoc{"List routes or deleted routes using `deleted=true`", "violet:route"}, checkAuthWithPerm(verify, "violet:route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		filter, offset, limit, ok := parseTargetFilter(rw, req, domains, b)
+		if !ok {
+			return
+		}
+		routes, total, err := manager.ListRoutes(filter, offset, limit)
 		if err != nil {
 			apiError(rw, http.StatusInternalServerError, "Failed to get routes from database")
 			return
 		}
+		rw.Header().Set("X-Total-Count", strconv.Itoa(total))
 		rw.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(rw).Encode(routes)
 	}))
-	r.POST("/route", parseJsonAndCheckOwnership[routeSource](verify, "route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t routeSource) {
-		err := manager.InsertRoute(target.Route(t))
+	r.GET("/route/:id", endpointDoc{"Get a route by id", "violet:route"}, checkAuthWithPerm(verify, "violet:route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		route, err := manager.GetRoute(id)
+		if !checkTargetErr(rw, err, "route") || !checkSourceTenant(rw, domains, route.Src, b) {
+			return
+		}
+		writeEntity(rw, http.StatusOK, route)
+	}))
+	r.PATCH("/route/:id", endpointDoc{"Update fields of a route", "violet:route"}, checkAuthWithPerm(verify, "violet:route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		route, err := manager.GetRoute(id)
+		if !checkTargetErr(rw, err, "route") || !checkPreconditions(rw, req, route, true, requireIfMatch) {
+			return
+		}
+		if !patchTarget(rw, req, domains, b, &route, func() string { return route.Src }) {
+			return
+		}
+		route.Id = id
+		route.Flags = route.Flags.NormaliseRouteFlags()
+		if !checkUpdateErr(rw, manager.UpdateRoute(route), "route") {
+			return
+		}
+		manager.Compile()
+		writeEntity(rw, http.StatusOK, route)
+	}))
+	r.PUT("/route/:id", endpointDoc{"Create or replace a route with a client supplied id", "violet:route"}, checkAuthWithPerm(verify, "violet:route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		var j configRoute
+		if !decodePutTarget(rw, req, &j) {
+			return
+		}
+		j.Flags = j.Flags.NormaliseRouteFlags()
+		if !checkPutTarget(rw, domains, b, j.Route.ValidateFields(), j.Src) {
+			return
+		}
+		old, err := manager.GetRoute(id)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !checkTargetErr(rw, err, "route") {
+			return
+		}
+		if !checkPreconditions(rw, req, old, err == nil, requireIfMatch) {
+			return
+		}
+		if err == nil {
+			if !checkSourceTenant(rw, domains, old.Src, b) {
+				return
+			}
+			setAuditOld(req, old)
+		}
+		route := target.RouteWithActive{Id: id, Route: j.Route, Active: j.Active == nil || *j.Active}
+		created, err := manager.PutRoute(route)
+		if !checkUpdateErr(rw, err, "route") {
+			return
+		}
+		if created && autoRegister {
+			registerSourceHost(domains, route.Src, b.Subject)
+		}
+		manager.Compile()
+		writePutTarget(rw, created, route)
+	}))
+	r.GET("/route/:id/history", endpointDoc{"Get every version of a route", "violet:route"}, checkAuthWithPerm(verify, "violet:route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		route, err := manager.GetRoute(id)
+		if !checkTargetErr(rw, err, "route") || !checkSourceTenant(rw, domains, route.Src, b) {
+			return
+		}
+		list, err := manager.RouteHistory(id)
 		if err != nil {
-			log.Printf("[Violet] Failed to insert route into database: %s\n", err)
-			apiError(rw, http.StatusInternalServerError, "Failed to insert route into database")
+			log.Printf("[Violet] Failed to get route history from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get route history from database")
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(list)
+	}))
+	r.POST("/route/:id/rollback/:version", endpointDoc{"Restore a previous version of a route", "violet:route"}, checkAuthWithPerm(verify, "violet:route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		version, ok := parseTargetVersion(rw, params)
+		if !ok {
+			return
+		}
+		route, err := manager.GetRoute(id)
+		if !checkTargetErr(rw, err, "route") || !checkSourceTenant(rw, domains, route.Src, b) {
+			return
+		}
+		old, err := manager.GetRouteVersion(id, version)
+		if !checkTargetErr(rw, err, "route version") || !checkSourceTenant(rw, domains, old.Src, b) {
+			return
+		}
+		setAuditOld(req, route)
+		if !checkUpdateErr(rw, manager.UpdateRoute(old), "route") {
+			return
+		}
+		manager.Compile()
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(old)
+	}))
+	r.POST("/route/:id/restore", endpointDoc{"Restore a route from the recycle bin", "violet:route"}, checkAuthWithPerm(verify, "violet:route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		route, err := manager.GetRoute(id)
+		if !checkTargetErr(rw, err, "route") || !checkSourceTenant(rw, domains, route.Src, b) {
+			return
+		}
+		if route.Deleted == 0 {
+			apiError(rw, http.StatusConflict, "Route is not deleted")
+			return
+		}
+		if !checkUpdateErr(rw, manager.RestoreRoute(id), "route") {
 			return
 		}
 		manager.Compile()
+		route.Deleted = 0
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(route)
+	}))
+	r.POST("/route", endpointDoc{"Add or update a route", "violet:route"}, parseJsonAndCheckOwnership[routeSource](verify, domains, "route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t routeSource) {
+		if code, msg := insertRoute(domains, manager, target.Route(t), b, autoRegister); code != 0 {
+			apiError(rw, code, msg)
+		}
 	}))
-	r.DELETE("/route", parseJsonAndCheckOwnership[sourceJson](verify, "route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t sourceJson) {
-		err := manager.DeleteRoute(t.Src)
+	r.handleExact(http.MethodPost, "/route/validate", endpointDoc{"Check a route without saving it", "violet:route"}, validateTarget[routeSource](verify, domains, "route"))
+	r.DELETE("/route", endpointDoc{"Move a route to the recycle bin", "violet:route"}, parseJsonAndCheckOwnership[sourceJson](verify, domains, "route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t sourceJson) {
+		if code, msg := deleteRoute(manager, t.Src); code != 0 {
+			apiError(rw, code, msg)
+		}
+	}))
+	r.POST("/route-batch", endpointDoc{"Add, update or delete multiple routes", "violet:route"}, parseBatchAndCheckOwnership[routeSource](verify, domains, "route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t batchJson[routeSource]) {
+		put := make([]target.Route, len(t.Put))
+		for i := range t.Put {
+			put[i] = target.Route(t.Put[i])
+			put[i].Flags = put[i].Flags.NormaliseRouteFlags()
+		}
+		err := manager.ApplyRoutes(put, t.Delete)
 		if err != nil {
-			log.Printf("[Violet] Failed to delete route from database: %s\n", err)
-			apiError(rw, http.StatusInternalServerError, "Failed to delete route from database")
+			log.Printf("[Violet] Failed to apply routes to database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to apply routes to database")
 			return
 		}
+		if autoRegister {
+			for _, i := range put {
+				registerSourceHost(domains, i.Src, b.Subject)
+			}
+		}
 		manager.Compile()
 	}))
 
 	// Endpoint for redirects
-	r.GET("/redirect", checkAuthWithPerm(verify, "violet:redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
-		redirects, err := manager.GetAllRedirects()
+	r.GET("/redirect", endpointDoc{"List redirects or deleted redirects using `deleted=true`", "violet:redirect"}, checkAuthWithPerm(verify, "violet:redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		filter, offset, limit, ok := parseTargetFilter(rw, req, domains, b)
+		if !ok {
+			return
+		}
+		redirects, total, err := manager.ListRedirects(filter, offset, limit)
 		if err != nil {
 			apiError(rw, http.StatusInternalServerError, "Failed to get redirects from database")
 			return
 		}
+		rw.Header().Set("X-Total-Count", strconv.Itoa(total))
 		rw.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(rw).Encode(redirects)
 	}))
-	r.POST("/redirect", parseJsonAndCheckOwnership[redirectSource](verify, "redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t redirectSource) {
-		err := manager.InsertRedirect(target.Redirect(t))
+	r.GET("/redirect/:id", endpointDoc{"Get a redirect by id", "violet:redirect"}, checkAuthWithPerm(verify, "violet:redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		redirect, err := manager.GetRedirect(id)
+		if !checkTargetErr(rw, err, "redirect") || !checkSourceTenant(rw, domains, redirect.Src, b) {
+			return
+		}
+		writeEntity(rw, http.StatusOK, redirect)
+	}))
+	r.PATCH("/redirect/:id", endpointDoc{"Update fields of a redirect", "violet:redirect"}, checkAuthWithPerm(verify, "violet:redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		redirect, err := manager.GetRedirect(id)
+		if !checkTargetErr(rw, err, "redirect") || !checkPreconditions(rw, req, redirect, true, requireIfMatch) {
+			return
+		}
+		if !patchTarget(rw, req, domains, b, &redirect, func() string { return redirect.Src }) {
+			return
+		}
+		redirect.Id = id
+		redirect.Flags = redirect.Flags.NormaliseRedirectFlags()
+		if !checkUpdateErr(rw, manager.UpdateRedirect(redirect), "redirect") {
+			return
+		}
+		manager.Compile()
+		writeEntity(rw, http.StatusOK, redirect)
+	}))
+	r.PUT("/redirect/:id", endpointDoc{"Create or replace a redirect with a client supplied id", "violet:redirect"}, checkAuthWithPerm(verify, "violet:redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		var j configRedirect
+		if !decodePutTarget(rw, req, &j) {
+			return
+		}
+		j.Flags = j.Flags.NormaliseRedirectFlags()
+		if !checkPutTarget(rw, domains, b, j.Redirect.ValidateFields(), j.Src) {
+			return
+		}
+		old, err := manager.GetRedirect(id)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !checkTargetErr(rw, err, "redirect") {
+			return
+		}
+		if !checkPreconditions(rw, req, old, err == nil, requireIfMatch) {
+			return
+		}
+		if err == nil {
+			if !checkSourceTenant(rw, domains, old.Src, b) {
+				return
+			}
+			setAuditOld(req, old)
+		}
+		redirect := target.RedirectWithActive{Id: id, Redirect: j.Redirect, Active: j.Active == nil || *j.Active}
+		created, err := manager.PutRedirect(redirect)
+		if !checkUpdateErr(rw, err, "redirect") {
+			return
+		}
+		if created && autoRegister {
+			registerSourceHost(domains, redirect.Src, b.Subject)
+		}
+		manager.Compile()
+		writePutTarget(rw, created, redirect)
+	}))
+	r.GET("/redirect/:id/history", endpointDoc{"Get every version of a redirect", "violet:redirect"}, checkAuthWithPerm(verify, "violet:redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		redirect, err := manager.GetRedirect(id)
+		if !checkTargetErr(rw, err, "redirect") || !checkSourceTenant(rw, domains, redirect.Src, b) {
+			return
+		}
+		list, err := manager.RedirectHistory(id)
 		if err != nil {
-			log.Printf("[Violet] Failed to insert redirect into database: %s\n", err)
-			apiError(rw, http.StatusInternalServerError, "Failed to insert redirect into database")
+			log.Printf("[Violet] Failed to get redirect history from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get redirect history from database")
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(list)
+	}))
+	r.POST("/redirect/:id/rollback/:version", endpointDoc{"Restore a previous version of a redirect", "violet:redirect"}, checkAuthWithPerm(verify, "violet:redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		version, ok := parseTargetVersion(rw, params)
+		if !ok {
+			return
+		}
+		redirect, err := manager.GetRedirect(id)
+		if !checkTargetErr(rw, err, "redirect") || !checkSourceTenant(rw, domains, redirect.Src, b) {
+			return
+		}
+		old, err := manager.GetRedirectVersion(id, version)
+		if !checkTargetErr(rw, err, "redirect version") || !checkSourceTenant(rw, domains, old.Src, b) {
+			return
+		}
+		setAuditOld(req, redirect)
+		if !checkUpdateErr(rw, manager.UpdateRedirect(old), "redirect") {
+			return
+		}
+		manager.Compile()
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(old)
+	}))
+	r.POST("/redirect/:id/restore", endpointDoc{"Restore a redirect from the recycle bin", "violet:redirect"}, checkAuthWithPerm(verify, "violet:redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, ok := parseTargetId(rw, params)
+		if !ok {
+			return
+		}
+		redirect, err := manager.GetRedirect(id)
+		if !checkTargetErr(rw, err, "redirect") || !checkSourceTenant(rw, domains, redirect.Src, b) {
+			return
+		}
+		if redirect.Deleted == 0 {
+			apiError(rw, http.StatusConflict, "Redirect is not deleted")
+			return
+		}
+		if !checkUpdateErr(rw, manager.RestoreRedirect(id), "redirect") {
 			return
 		}
 		manager.Compile()
+		redirect.Deleted = 0
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(redirect)
+	}))
+	r.POST("/redirect", endpointDoc{"Add or update a redirect", "violet:redirect"}, parseJsonAndCheckOwnership[redirectSource](verify, domains, "redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t redirectSource) {
+		if code, msg := insertRedirect(domains, manager, target.Redirect(t), b, autoRegister); code != 0 {
+			apiError(rw, code, msg)
+		}
+	}))
+	r.handleExact(http.MethodPost, "/redirect/validate", endpointDoc{"Check a redirect without saving it", "violet:redirect"}, validateTarget[redirectSource](verify, domains, "redirect"))
+	r.DELETE("/redirect", endpointDoc{"Move a redirect to the recycle bin", "violet:redirect"}, parseJsonAndCheckOwnership[sourceJson](verify, domains, "redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t sourceJson) {
+		if code, msg := deleteRedirect(manager, t.Src); code != 0 {
+			apiError(rw, code, msg)
+		}
 	}))
-	r.DELETE("/redirect", parseJsonAndCheckOwnership[sourceJson](verify, "redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t sourceJson) {
-		err := manager.DeleteRedirect(t.Src)
+	r.POST("/redirect-batch", endpointDoc{"Add, update or delete multiple redirects", "violet:redirect"}, parseBatchAndCheckOwnership[redirectSource](verify, domains, "redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t batchJson[redirectSource]) {
+		put := make([]target.Redirect, len(t.Put))
+		for i := range t.Put {
+			put[i] = target.Redirect(t.Put[i])
+			put[i].Flags = put[i].Flags.NormaliseRedirectFlags()
+		}
+		err := manager.ApplyRedirects(put, t.Delete)
 		if err != nil {
-			log.Printf("[Violet] Failed to delete redirect from database: %s\n", err)
-			apiError(rw, http.StatusInternalServerError, "Failed to delete redirect from database")
+			log.Printf("[Violet] Failed to apply redirects to database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to apply redirects to database")
 			return
 		}
+		if autoRegister {
+			for _, i := range put {
+				registerSourceHost(domains, i.Src, b.Subject)
+			}
+		}
 		manager.Compile()
 	}))
 }
 
+// parseTargetFilter reads the filter and pagination query parameters for the
+// route and redirect lists, every entry is returned if no limit is provided.
+// The total number of matching entries is sent in the `X-Total-Count` header.
+// Deleted entries are only listed if the `deleted` parameter is `true`.
+// Only entries on domains owned by the token are included.
+func parseTargetFilter(rw http.ResponseWriter, req *http.Request, domains utils.DomainProvider, b AuthClaims) (router.TargetFilter, int, int, bool) {
+	q := req.URL.Query()
+	offset, limit, ok := parsePagination(rw, q, -1)
+	if !ok {
+		return router.TargetFilter{}, 0, 0, false
+	}
+	filter := router.TargetFilter{Host: q.Get("host"), Dst: q.Get("dst"), Deleted: q.Get("deleted") == "true"}
+	if v := q.Get("flags"); v != "" {
+		flags, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			apiError(rw, http.StatusBadRequest, "Invalid flags")
+			return router.TargetFilter{}, 0, 0, false
+		}
+		filter.Flags = target.Flags(flags)
+	}
+	if code, msg := filterOwned(domains, &filter, b); code != 0 {
+		apiError(rw, code, msg)
+		return router.TargetFilter{}, 0, 0, false
+	}
+	return filter, offset, limit, true
+}
+
+// filterOwned limits the filter to the domains owned by the token, the status
+// code and error message are returned if the owners can't be loaded. Like
+// sourceTenantErr tokens without ownership claims don't own any domains, only
+// trusted local users and tokens with `violet:admin` can see every tenant.
+func filterOwned(domains utils.DomainProvider, filter *router.TargetFilter, b AuthClaims) (int, string) {
+	owned, err := ownedDomains(domains, b)
+	if err != nil {
+		log.Printf("[Violet] Failed to get domain owner: %s\n", err)
+		return http.StatusInternalServerError, "Failed to get domain from database"
+	}
+	if owned == nil && !b.Local && !hasPerm(b.Claims.Perms, "violet:admin") {
+		owned = make([]string, 0)
+	}
+	filter.Domains = owned
+	return 0, ""
+}
+
+// ownedDomains returns the domains from the `owns=<fqdn>` claims which aren't
+// owned by another tenant, nil is returned for trusted local users and tokens
+// without ownership claims as they can see every domain
+func ownedDomains(domains utils.DomainProvider, b AuthClaims) ([]string, error) {
+	if b.Local || !hasOwnsClaim(b.Claims.Perms) {
+		return nil, nil
+	}
+	owned := make([]string, 0)
+	for _, i := range permList(b.Claims.Perms) {
+		fqdn, ok := strings.CutPrefix(i, "owns=")
+		if !ok {
+			continue
+		}
+		fqdn, ok = utils.NormaliseDomain(fqdn)
+		if !ok {
+			continue
+		}
+		owner, err := domains.GetOwner(fqdn)
+		if err != nil {
+			return nil, err
+		}
+		if owner == "" || owner == b.Subject {
+			owned = append(owned, fqdn)
+		}
+	}
+	return owned, nil
+}
+
+// parseTargetId reads the id parameter, an error message is output if the id
+// is invalid
+func parseTargetId(rw http.ResponseWriter, params httprouter.Params) (int64, bool) {
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil || id < 1 {
+		apiError(rw, http.StatusBadRequest, "Invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+// parseTargetVersion reads the version parameter, an error message is output
+// if the version is invalid
+func parseTargetVersion(rw http.ResponseWriter, params httprouter.Params) (int64, bool) {
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 64)
+	if err != nil || version < 1 {
+		apiError(rw, http.StatusBadRequest, "Invalid version")
+		return 0, false
+	}
+	return version, true
+}
+
+// decodePutTarget reads the body of a put request
+func decodePutTarget(rw http.ResponseWriter, req *http.Request, j any) bool {
+	if err := json.NewDecoder(req.Body).Decode(j); err != nil {
+		apiBodyError(rw, err)
+		return false
+	}
+	return true
+}
+
+// checkPutTarget rejects a put request with validation errors or a source
+// which isn't owned by the tenant
+func checkPutTarget(rw http.ResponseWriter, domains utils.DomainProvider, b AuthClaims, fields []target.FieldError, src string) bool {
+	if len(fields) > 0 {
+		apiValidationError(rw, http.StatusBadRequest, fields)
+		return false
+	}
+	return checkSourceTenant(rw, domains, src, b)
+}
+
+// writePutTarget outputs the entry saved by a put request using 201 Created if
+// the id was unused and 200 OK if an existing entry was replaced
+func writePutTarget(rw http.ResponseWriter, created bool, v any) {
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+	}
+	writeEntity(rw, code, v)
+}
+
+// checkTargetErr outputs an error message for errors returned when reading a
+// route or redirect
+func checkTargetErr(rw http.ResponseWriter, err error, t string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, fs.ErrNotExist):
+		apiError(rw, http.StatusNotFound, "Unknown "+t)
+	default:
+		log.Printf("[Violet] Failed to get %s from database: %s\n", t, err)
+		apiError(rw, http.StatusInternalServerError, "Failed to get "+t+" from database")
+	}
+	return false
+}
+
 type AuthWithJsonCallback[T any] func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t T)
 
-func parseJsonAndCheckOwnership[T sourceGetter](verify mjwt.Verifier, t string, cb AuthWithJsonCallback[T]) httprouter.Handle {
+func parseJsonAndCheckOwnership[T sourceGetter](verify mjwt.Verifier, domains utils.DomainProvider, t string, cb AuthWithJsonCallback[T]) httprouter.Handle {
 	return checkAuthWithPerm(verify, "violet:"+t, func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
 		var j T
-		if json.NewDecoder(req.Body).Decode(&j) != nil {
-			apiError(rw, http.StatusBadRequest, "Invalid request body")
+		if err := json.NewDecoder(req.Body).Decode(&j); err != nil {
+			apiBodyError(rw, err)
 			return
 		}
 
-		// check token owns this domain
-		host, _ := utils.SplitHostPath(j.GetSource())
-		if strings.IndexByte(host, ':') != -1 {
-			apiError(rw, http.StatusBadRequest, "Invalid route source")
+		if !checkSourceTenant(rw, domains, j.GetSource(), b) {
 			return
 		}
 
-		if !validateDomainOwnershipClaims(host, b.Claims.Perms) {
-			apiError(rw, http.StatusBadRequest, "Token cannot modify the specified domain")
+		cb(rw, req, params, b, j)
+	})
+}
+
+// parseBatchAndCheckOwnership decodes a batch request, the token must own the
+// host of every source in the batch
+func parseBatchAndCheckOwnership[T sourceGetter](verify mjwt.Verifier, domains utils.DomainProvider, t string, cb AuthWithJsonCallback[batchJson[T]]) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:"+t, func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		var j batchJson[T]
+		if err := json.NewDecoder(req.Body).Decode(&j); err != nil {
+			apiBodyError(rw, err)
 			return
 		}
 
+		for _, i := range j.Put {
+			if !checkSourceTenant(rw, domains, i.GetSource(), b) {
+				return
+			}
+		}
+		for _, src := range j.Delete {
+			if !checkSourceTenant(rw, domains, src, b) {
+				return
+			}
+		}
+
 		cb(rw, req, params, b, j)
 	})
 }
+
+// checkSourceTenant checks the token owns the host of the source, an error
+// message is output if the source can't be modified
+func checkSourceTenant(rw http.ResponseWriter, domains utils.DomainProvider, src string, b AuthClaims) bool {
+	code, msg := sourceTenantErr(domains, src, b)
+	if code != 0 {
+		apiError(rw, code, msg)
+		return false
+	}
+	return true
+}
+
+// sourceTenantErr returns the status code and error message if the token can't
+// modify the source
+func sourceTenantErr(domains utils.DomainProvider, src string, b AuthClaims) (int, string) {
+	host, _ := utils.SplitHostPath(src)
+	if strings.IndexByte(host, ':') != -1 {
+		return http.StatusBadRequest, "Invalid route source"
+	}
+	// routes and redirects always need the ownership claim
+	if !b.Local && !validateDomainOwnershipClaims(host, b.Claims.Perms) {
+		return http.StatusBadRequest, "Token cannot modify the specified domain"
+	}
+	_, code, msg := domainTenantErr(domains, host, b)
+	return code, msg
+}
+
+// validator is implemented by routes and redirects
+type validator interface {
+	sourceGetter
+	ValidateFields() []target.FieldError
+}
+
+// validateTarget reports the problems with a route or redirect without saving
+// it, the status is 422 if there are any problems
+func validateTarget[T validator](verify mjwt.Verifier, domains utils.DomainProvider, t string) httprouter.Handle {
+	return checkAuthWithPerm(verify, "violet:"+t, func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		var j T
+		if err := json.NewDecoder(req.Body).Decode(&j); err != nil {
+			apiBodyError(rw, err)
+			return
+		}
+
+		fields := j.ValidateFields()
+		if code, msg := sourceTenantErr(domains, j.GetSource(), b); code != 0 {
+			fields = append(fields, target.FieldError{Field: "src", Message: msg, Value: j.GetSource()})
+		}
+		errs := make([]string, len(fields))
+		for i, f := range fields {
+			errs[i] = f.Message
+		}
+
+		code := http.StatusOK
+		if len(errs) > 0 {
+			code = http.StatusUnprocessableEntity
+		}
+		rw.WriteHeader(code)
+		_ = json.NewEncoder(rw).Encode(struct {
+			Valid  bool                `json:"valid"`
+			Errors []string            `json:"errors"`
+			Fields []target.FieldError `json:"fields"`
+		}{len(errs) == 0, errs, fields})
+	})
+}
+
+// patchTarget decodes the request body over the current entry, the token must
+// own the host of both the current and the new source
+func patchTarget[T any](rw http.ResponseWriter, req *http.Request, domains utils.DomainProvider, b AuthClaims, a *T, src func() string) bool {
+	if !checkSourceTenant(rw, domains, src(), b) {
+		return false
+	}
+	old := src()
+	setAuditOld(req, a)
+	if err := json.NewDecoder(req.Body).Decode(a); err != nil {
+		apiBodyError(rw, err)
+		return false
+	}
+	if src() != old && !checkSourceTenant(rw, domains, src(), b) {
+		return false
+	}
+	return true
+}
+
+// checkUpdateErr outputs an error message for errors returned when updating a
+// route or redirect
+func checkUpdateErr(rw http.ResponseWriter, err error, t string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, fs.ErrNotExist):
+		apiError(rw, http.StatusNotFound, "Unknown "+t)
+	case errors.Is(err, router.ErrSourceExists):
+		apiError(rw, http.StatusConflict, err.Error())
+	default:
+		log.Printf("[Violet] Failed to update %s in database: %s\n", t, err)
+		apiError(rw, http.StatusInternalServerError, "Failed to update "+t+" in database")
+	}
+	return false
+}
+
+// registerSourceHost adds the host of the source as an active domain owned by
+// the token subject, hosts which are already valid or were previously
+// registered and disabled are left unchanged
+func registerSourceHost(domains utils.DomainProvider, src, owner string) {
+	host, _ := utils.SplitHostPath(src)
+	host, ok := utils.NormaliseDomain(strings.TrimPrefix(host, "*."))
+	if !ok || domains.IsValid(host) {
+		return
+	}
+	if _, err := domains.LoadSettings(host); err == nil {
+		return
+	}
+	domains.Put(host, true)
+	if err := domains.SetOwner(host, owner); err != nil {
+		log.Printf("[Violet] Failed to set domain owner: %s\n", err)
+	}
+	domains.Compile()
+}
+
+// insertRoute normalises the flags, validates and saves the route then
+// registers the host of the source if enabled. The status code and error
+// message are returned if the route can't be saved.
+func insertRoute(domains utils.DomainProvider, manager *router.Manager, route target.Route, b AuthClaims, autoRegister bool) (int, string) {
+	route.Flags = route.Flags.NormaliseRouteFlags()
+	if errs := route.Validate(); len(errs) > 0 {
+		return http.StatusBadRequest, strings.Join(errs, ", ")
+	}
+	if err := manager.InsertRoute(route); err != nil {
+		log.Printf("[Violet] Failed to insert route into database: %s\n", err)
+		return http.StatusInternalServerError, "Failed to insert route into database"
+	}
+	if autoRegister {
+		registerSourceHost(domains, route.Src, b.Subject)
+	}
+	manager.Compile()
+	return 0, ""
+}
+
+// deleteRoute moves the route to the recycle bin, the status code and error
+// message are returned if the route can't be deleted
+func deleteRoute(manager *router.Manager, src string) (int, string) {
+	if err := manager.DeleteRoute(src); err != nil {
+		log.Printf("[Violet] Failed to delete route from database: %s\n", err)
+		return http.StatusInternalServerError, "Failed to delete route from database"
+	}
+	manager.Compile()
+	return 0, ""
+}
+
+// insertRedirect normalises the flags, validates and saves the redirect then
+// registers the host of the source if enabled. The status code and error
+// message are returned if the redirect can't be saved.
+func insertRedirect(domains utils.DomainProvider, manager *router.Manager, redirect target.Redirect, b AuthClaims, autoRegister bool) (int, string) {
+	redirect.Flags = redirect.Flags.NormaliseRedirectFlags()
+	if errs := redirect.Validate(); len(errs) > 0 {
+		return http.StatusBadRequest, strings.Join(errs, ", ")
+	}
+	if err := manager.InsertRedirect(redirect); err != nil {
+		log.Printf("[Violet] Failed to insert redirect into database: %s\n", err)
+		return http.StatusInternalServerError, "Failed to insert redirect into database"
+	}
+	if autoRegister {
+		registerSourceHost(domains, redirect.Src, b.Subject)
+	}
+	manager.Compile()
+	return 0, ""
+}
+
+// deleteRedirect moves the redirect to the recycle bin, the status code and
+// error message are returned if the redirect can't be deleted
+func deleteRedirect(manager *router.Manager, src string) (int, string) {
+	if err := manager.DeleteRedirect(src); err != nil {
+		log.Printf("[Violet] Failed to delete redirect from database: %s\n", err)
+		return http.StatusInternalServerError, "Failed to delete redirect from database"
+	}
+	manager.Compile()
+	return 0, ""
+}
@@ -0,0 +1,454 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"github.com/MrMelon54/violet/domains"
+	"github.com/MrMelon54/violet/proxy"
+	"github.com/MrMelon54/violet/router"
+	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/target"
+	"github.com/MrMelon54/violet/utils"
+	"github.com/MrMelon54/violet/utils/fake"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterSourceHost(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestRegisterSourceHost?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	d := domains.New(db)
+
+	registerSourceHost(d, "new.example.com/hello", "abc")
+	registerSourceHost(d, "*.wild.example.com/", "abc")
+	owner, err := d.GetOwner("new.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", owner)
+	owner, err = d.GetOwner("wild.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", owner)
+
+	// disabled domains are not enabled again
+	d.Delete("old.example.com", "abc")
+	registerSourceHost(d, "old.example.com/", "abc")
+	list, _, err := d.List("", 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []utils.DomainEntry{
+		{Domain: "new.example.com", Active: true, Owner: "abc"},
+		{Domain: "old.example.com"},
+		{Domain: "wild.example.com", Active: true, Owner: "abc"},
+	}, list)
+}
+
+func TestSetupTargetApis_GetById(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupTargetApis_GetById?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}))
+	assert.NoError(t, manager.InsertRedirect(target.Redirect{Src: "www.example.com/", Dst: "example.com", Code: http.StatusFound}))
+
+	api := newTestApi(t, &conf.Conf{Router: manager})
+	key := fake.GenSnakeOilKey("violet:route", "violet:redirect", "owns=example.com")
+
+	rec := api.do(http.MethodGet, "/v1/route", key, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"id":1,"src":"example.com/","dst":"127.0.0.1:8080","flags":0,"active":true}]`, rec.Body.String())
+
+	rec = api.do(http.MethodGet, "/v1/route/1", key, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":1,"src":"example.com/","dst":"127.0.0.1:8080","flags":0,"active":true}`, rec.Body.String())
+
+	rec = api.do(http.MethodGet, "/v1/redirect/1", key, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":1,"src":"www.example.com/","dst":"example.com","flags":0,"code":302,"active":true}`, rec.Body.String())
+
+	// unknown and invalid ids
+	for p, code := range map[string]int{"/v1/route/2": http.StatusNotFound, "/v1/redirect/abc": http.StatusBadRequest} {
+		assert.Equal(t, code, api.do(http.MethodGet, p, key, nil).Code, p)
+	}
+
+	// other tenants can't see the routes and redirects
+	other := fake.GenSnakeOilKey("violet:route", "violet:redirect", "owns=example.org")
+	rec = api.do(http.MethodGet, "/v1/route", other, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	rec = api.do(http.MethodGet, "/v1/redirect/1", other, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// tokens without ownership claims need the admin permission to see every tenant
+	rec = api.do(http.MethodGet, "/v1/route", fake.GenSnakeOilKey("violet:route"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+	rec = api.do(http.MethodGet, "/v1/route", fake.GenSnakeOilKey("violet:route", "violet:admin"), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"id":1,"src":"example.com/","dst":"127.0.0.1:8080","flags":0,"active":true}]`, rec.Body.String())
+
+	// domains registered by another subject are hidden
+	api.conf.Domains.(*fake.Domains).Owner = "other"
+	rec = api.do(http.MethodGet, "/v1/route", key, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+}
+
+func TestSetupTargetApis_Patch(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupTargetApis_Patch?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}))
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/a", Dst: "127.0.0.1:8081"}))
+	assert.NoError(t, manager.InsertRedirect(target.Redirect{Src: "www.example.com/", Dst: "example.com", Code: http.StatusFound}))
+
+	api := newTestApi(t, &conf.Conf{Router: manager})
+	key := fake.GenSnakeOilKey("violet:route", "violet:redirect", "owns=example.com")
+
+	patch := func(p, body, key string) *httptest.ResponseRecorder {
+		return api.do(http.MethodPatch, p, key, strings.NewReader(body))
+	}
+
+	// only the destination is changed
+	rec := patch("/v1/route/1", `{"dst":"127.0.0.1:9090"}`, key)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":1,"src":"example.com/","dst":"127.0.0.1:9090","flags":0,"active":true}`, rec.Body.String())
+	route, err := manager.GetRoute(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9090", route.Dst)
+
+	// only the active flag is changed
+	rec = patch("/v1/redirect/1", `{"active":false}`, key)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":1,"src":"www.example.com/","dst":"example.com","flags":0,"code":302,"active":false}`, rec.Body.String())
+
+	// source used by another route
+	rec = patch("/v1/route/1", `{"src":"example.com/a"}`, key)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// new source on a domain the token doesn't own
+	rec = patch("/v1/route/1", `{"src":"example.org/"}`, key)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// token doesn't own the current source
+	rec = patch("/v1/route/1", `{"dst":"127.0.0.1:1"}`, fake.GenSnakeOilKey("violet:route"))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// unknown id
+	rec = patch("/v1/route/5", `{"dst":"127.0.0.1:1"}`, key)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestSetupTargetApis_Batch(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupTargetApis_Batch?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/old", Dst: "127.0.0.1:8080"}))
+
+	api := newTestApi(t, &conf.Conf{Router: manager})
+	key := fake.GenSnakeOilKey("violet:route", "violet:redirect", "owns=example.com")
+
+	batch := func(p, body string) *httptest.ResponseRecorder {
+		return api.do(http.MethodPost, p, key, strings.NewReader(body))
+	}
+
+	rec := batch("/v1/route-batch", `{"put":[{"src":"example.com/a","dst":"127.0.0.1:8081","flags":1024},{"src":"www.example.com/","dst":"127.0.0.1:8082"}],"delete":["example.com/old"]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	routes, err := manager.GetAllRoutes()
+	assert.NoError(t, err)
+	assert.Equal(t, []target.RouteWithActive{
+		{Id: 2, Route: target.Route{Src: "example.com/a", Dst: "127.0.0.1:8081"}, Active: true},
+		{Id: 3, Route: target.Route{Src: "www.example.com/", Dst: "127.0.0.1:8082"}, Active: true},
+	}, routes)
+
+	// unused flag bits are not stored
+	var flags target.Flags
+	assert.NoError(t, db.QueryRow(`SELECT flags FROM routes WHERE source = ?`, "example.com/a").Scan(&flags))
+	assert.Equal(t, target.Flags(0), flags)
+
+	// deleted routes are moved to the recycle bin
+	deleted, _, err := manager.ListRoutes(router.TargetFilter{Deleted: true}, 0, -1)
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 1)
+	assert.Equal(t, "example.com/old", deleted[0].Src)
+	assert.True(t, deleted[0].Active)
+	assert.NotZero(t, deleted[0].Deleted)
+
+	// one source on a domain the token doesn't own rejects the whole batch
+	rec = batch("/v1/redirect-batch", `{"put":[{"src":"example.com/b","dst":"example.com","code":302},{"src":"example.org/","dst":"example.com","code":302}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	redirects, err := manager.GetAllRedirects()
+	assert.NoError(t, err)
+	assert.Len(t, redirects, 0)
+
+	rec = batch("/v1/redirect-batch", `{"put":[{"src":"example.com/b","dst":"example.com","code":302,"flags":5}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	redirects, err = manager.GetAllRedirects()
+	assert.NoError(t, err)
+	assert.Len(t, redirects, 1)
+	assert.NoError(t, db.QueryRow(`SELECT flags FROM redirects WHERE source = ?`, "example.com/b").Scan(&flags))
+	assert.Equal(t, target.FlagPre, flags)
+}
+
+func TestSetupTargetApis_ListFilter(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupTargetApis_ListFilter?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}))
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/a", Dst: "127.0.0.1:8081", Flags: target.FlagPre}))
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.org/", Dst: "127.0.0.1:8082"}))
+
+	api := newTestApi(t, &conf.Conf{Router: manager})
+	key := fake.GenSnakeOilKey("violet:route", "violet:redirect", "owns=example.com", "owns=example.org")
+
+	list := func(p string) *httptest.ResponseRecorder {
+		return api.do(http.MethodGet, p, key, nil)
+	}
+
+	rec := list("/v1/route?host=example.com&limit=1&offset=1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("X-Total-Count"))
+	assert.JSONEq(t, `[{"id":2,"src":"example.com/a","dst":"127.0.0.1:8081","flags":1,"active":true}]`, rec.Body.String())
+
+	rec = list("/v1/route?dst=8082")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("X-Total-Count"))
+
+	rec = list("/v1/route?flags=1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("X-Total-Count"))
+
+	rec = list("/v1/redirect")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("X-Total-Count"))
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	for _, p := range []string{"/v1/route?limit=0", "/v1/route?offset=-1", "/v1/redirect?flags=abc"} {
+		assert.Equal(t, http.StatusBadRequest, list(p).Code, p)
+	}
+}
+
+func TestSetupTargetApis_Validate(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupTargetApis_Validate?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+
+	api := newTestApi(t, &conf.Conf{Router: manager})
+	key := fake.GenSnakeOilKey("violet:route", "violet:redirect", "owns=example.com")
+
+	validate := func(p, body string) *httptest.ResponseRecorder {
+		return api.do(http.MethodPost, p, key, strings.NewReader(body))
+	}
+
+	rec := validate("/v1/route/validate", `{"src":"example.com/","dst":"127.0.0.1:8080","flags":1}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"valid":true,"errors":[],"fields":[]}`, rec.Body.String())
+
+	rec = validate("/v1/route/validate", `{"src":"example.org/","dst":"127.0.0.1:abc"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `{"valid":false,"errors":["invalid destination port","Token cannot modify the specified domain"],"fields":[{"field":"dst","message":"invalid destination port","value":"127.0.0.1:abc"},{"field":"src","message":"Token cannot modify the specified domain","value":"example.org/"}]}`, rec.Body.String())
+
+	rec = validate("/v1/redirect/validate", `{"src":"example.com/","dst":"www.example.com","code":200}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `{"valid":false,"errors":["invalid redirect code: 200"],"fields":[{"field":"code","message":"invalid redirect code: 200","value":200}]}`, rec.Body.String())
+
+	// nothing is saved
+	routes, err := manager.GetAllRoutes()
+	assert.NoError(t, err)
+	assert.Len(t, routes, 0)
+}
+
+func TestSetupTargetApis_ReadOnlyScope(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupTargetApis_ReadOnlyScope?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+
+	api := newTestApi(t, &conf.Conf{Router: manager})
+
+	do := func(method, key string) int {
+		var body io.Reader
+		if method == http.MethodPost {
+			body = strings.NewReader(`{"src":"example.com/","dst":"127.0.0.1:8080"}`)
+		}
+		return api.do(method, "/v1/route", key, body).Code
+	}
+
+	read := fake.GenSnakeOilKey("violet:route:read", "owns=example.com")
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, read))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, read))
+
+	// the write scope includes reading
+	write := fake.GenSnakeOilKey("violet:route:write", "owns=example.com")
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, write))
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, write))
+
+	// scopes don't apply to other resources
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, fake.GenSnakeOilKey("violet:redirect:read", "owns=example.com")))
+}
+
+func TestSetupTargetApis_Rollback(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupTargetApis_Rollback?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}))
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8081"}))
+
+	api := newTestApi(t, &conf.Conf{Router: manager})
+	key := fake.GenSnakeOilKey("violet:route", "owns=example.com")
+
+	do := func(method, p string) *httptest.ResponseRecorder {
+		return api.do(method, p, key, nil)
+	}
+
+	rec := do(http.MethodPost, "/v1/route/1/rollback/1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":1,"src":"example.com/","dst":"127.0.0.1:8080","flags":0,"active":true}`, rec.Body.String())
+	route, err := manager.GetRoute(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8080", route.Dst)
+
+	// the rollback is recorded as a new version
+	rec = do(http.MethodGet, "/v1/route/1/history")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var list []router.RouteVersion
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&list))
+	assert.Len(t, list, 3)
+	assert.Equal(t, int64(3), list[2].Version)
+	assert.Equal(t, "127.0.0.1:8080", list[2].Dst)
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/route/1/rollback/9").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/route/1/rollback/abc").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/v1/route/2/history").Code)
+
+	// the validate endpoint is still reachable alongside the id parameter
+	rec = api.do(http.MethodPost, "/v1/route/validate", key, strings.NewReader(`{"src":"example.com/","dst":"127.0.0.1:8080"}`))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestSetupTargetApis_Restore(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupTargetApis_Restore?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}))
+
+	api := newTestApi(t, &conf.Conf{Router: manager})
+	key := fake.GenSnakeOilKey("violet:route", "owns=example.com")
+
+	do := func(method, p, body string) *httptest.ResponseRecorder {
+		return api.do(method, p, key, strings.NewReader(body))
+	}
+
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/v1/route/1/restore", "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/v1/route", `{"src":"example.com/"}`).Code)
+	assert.JSONEq(t, `[]`, do(http.MethodGet, "/v1/route", "").Body.String())
+
+	rec := do(http.MethodGet, "/v1/route?deleted=true", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"deleted":`)
+
+	rec = do(http.MethodPost, "/v1/route/1/restore", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":1,"src":"example.com/","dst":"127.0.0.1:8080","flags":0,"active":true}`, rec.Body.String())
+	assert.JSONEq(t, `[{"id":1,"src":"example.com/","dst":"127.0.0.1:8080","flags":0,"active":true}]`, do(http.MethodGet, "/v1/route", "").Body.String())
+}
+
+func TestSetupTargetApis_Put(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupTargetApis_Put?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.org/", Dst: "127.0.0.1:8080"}))
+
+	api := newTestApi(t, &conf.Conf{Router: manager})
+	key := fake.GenSnakeOilKey("violet:route", "violet:redirect", "owns=example.com")
+
+	put := func(p, body string) *httptest.ResponseRecorder {
+		return api.do(http.MethodPut, "/v1"+p, key, strings.NewReader(body))
+	}
+
+	// the first put creates the route and repeating it only updates
+	rec := put("/route/10", `{"src":"example.com/","dst":"127.0.0.1:8080"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"id":10,"src":"example.com/","dst":"127.0.0.1:8080","flags":0,"active":true}`, rec.Body.String())
+	rec = put("/route/10", `{"src":"example.com/","dst":"127.0.0.1:8080"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":10,"src":"example.com/","dst":"127.0.0.1:8080","flags":0,"active":true}`, rec.Body.String())
+	rec = put("/route/10", `{"src":"example.com/","dst":"127.0.0.1:9090","active":false}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	route, err := manager.GetRoute(10)
+	assert.NoError(t, err)
+	assert.Equal(t, target.RouteWithActive{Id: 10, Route: target.Route{Src: "example.com/", Dst: "127.0.0.1:9090"}}, route)
+
+	rec = put("/redirect/3", `{"src":"www.example.com/","dst":"example.com","code":302}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = put("/redirect/3", `{"src":"www.example.com/","dst":"example.com","code":301}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":3,"src":"www.example.com/","dst":"example.com","flags":0,"code":301,"active":true}`, rec.Body.String())
+
+	for _, c := range []struct {
+		p, body string
+		code    int
+	}{
+		{"/route/abc", `{"src":"example.com/","dst":"127.0.0.1:8080"}`, http.StatusBadRequest},
+		{"/route/11", `{"src":"example.com/","dst":"127.0.0.1:8080"}`, http.StatusConflict},
+		{"/route/11", `{"src":"api.example.com/"}`, http.StatusBadRequest},
+		{"/route/11", `{"src":"example.org/a","dst":"127.0.0.1:8080"}`, http.StatusBadRequest},
+		{"/route/1", `{"src":"api.example.com/","dst":"127.0.0.1:8080"}`, http.StatusBadRequest},
+		{"/redirect/4", `{"src":"old.example.com/","dst":"example.com","code":200}`, http.StatusBadRequest},
+	} {
+		assert.Equal(t, c.code, put(c.p, c.body).Code, c.p+" "+c.body)
+	}
+}
+
+func TestSetupTargetApis_IfMatch(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupTargetApis_IfMatch?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	manager := router.NewManager(db, proxy.NewHybridTransport())
+	assert.NoError(t, manager.InsertRoute(target.Route{Src: "example.com/", Dst: "127.0.0.1:8080"}))
+
+	api := newTestApi(t, &conf.Conf{Router: manager, ApiRequireIfMatch: true})
+	key := fake.GenSnakeOilKey("violet:route", "owns=example.com")
+
+	do := func(method, p, ifMatch, body string) *httptest.ResponseRecorder {
+		req := newTestRequest(method, "/v1"+p, key, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		return api.serve(req)
+	}
+
+	rec := do(http.MethodGet, "/route/1", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	tag := rec.Header().Get("ETag")
+	assert.NotEmpty(t, tag)
+
+	// the tag is generated from the body which was sent
+	assert.Equal(t, bytesEntityTag(bytes.TrimSuffix(rec.Body.Bytes(), []byte{'\n'})), tag)
+
+	// updates without a tag or with an old tag are rejected
+	assert.Equal(t, http.StatusPreconditionRequired, do(http.MethodPatch, "/route/1", "", `{"dst":"127.0.0.1:9090"}`).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodPatch, "/route/1", `"abc"`, `{"dst":"127.0.0.1:9090"}`).Code)
+
+	rec = do(http.MethodPatch, "/route/1", tag, `{"dst":"127.0.0.1:9090"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	newTag := rec.Header().Get("ETag")
+	assert.NotEqual(t, tag, newTag)
+	assert.Equal(t, newTag, do(http.MethodGet, "/route/1", "", "").Header().Get("ETag"))
+
+	// the second admin still has the old tag
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodPut, "/route/1", tag, `{"src":"example.com/","dst":"127.0.0.1:8081"}`).Code)
+	rec = do(http.MethodPut, "/route/1", newTag, `{"src":"example.com/","dst":"127.0.0.1:8081"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, rec.Header().Get("ETag"), do(http.MethodGet, "/route/1", "", "").Header().Get("ETag"))
+
+	// creating doesn't need a tag but If-Match requires an existing route
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodPut, "/route/2", "*", `{"src":"www.example.com/","dst":"127.0.0.1:8080"}`).Code)
+	assert.Equal(t, http.StatusCreated, do(http.MethodPut, "/route/2", "", `{"src":"www.example.com/","dst":"127.0.0.1:8080"}`).Code)
+
+	req := newTestRequest(http.MethodPut, "/v1/route/2", key, strings.NewReader(`{"src":"www.example.com/","dst":"127.0.0.1:8080"}`))
+	req.Header.Set("If-None-Match", "*")
+	rec = api.serve(req)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/MrMelon54/violet/utils"
+)
+
+// NewApiTlsConfig creates the tls config for the api server. The certificate
+// and key are used if provided, otherwise a certificate is found in the cert
+// provider using the sni. Client certificates signed by the CA are required if
+// a CA is provided.
+func NewApiTlsConfig(certs utils.CertProvider, certPem, keyPem, clientCaPem []byte) (*tls.Config, error) {
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(certPem) > 0 || len(keyPem) > 0 {
+		cert, err := tls.X509KeyPair(certPem, keyPem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load api certificate: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	} else if certs != nil {
+		c.GetCertificate = func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert := certs.GetCertForHello(info); cert != nil {
+				return cert, nil
+			}
+			if def := certs.GetDefaultCert(); def != nil {
+				return def, nil
+			}
+			return nil, fmt.Errorf("failed to find certificate for: '%s'", info.ServerName)
+		}
+	} else {
+		return nil, errors.New("missing api certificate")
+	}
+
+	if len(clientCaPem) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(clientCaPem) {
+			return nil, errors.New("failed to load api client CA")
+		}
+		c.ClientCAs = pool
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/MrMelon54/certgen"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewApiTlsConfig(t *testing.T) {
+	future := func(now time.Time) time.Time { return now.AddDate(1, 0, 0) }
+	ca, err := certgen.MakeCaTls(2048, pkix.Name{CommonName: "ca.violet.test"}, big.NewInt(0), future)
+	assert.NoError(t, err)
+	serverTls, err := certgen.MakeServerTls(ca, 2048, pkix.Name{CommonName: "api.example.com"}, big.NewInt(1), future, nil, []net.IP{net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	clientTls, err := certgen.MakeClientTls(ca, 2048, pkix.Name{CommonName: "admin"}, big.NewInt(2), future)
+	assert.NoError(t, err)
+
+	_, err = NewApiTlsConfig(nil, nil, nil, nil)
+	assert.Error(t, err)
+	_, err = NewApiTlsConfig(nil, serverTls.GetCertPem(), nil, nil)
+	assert.Error(t, err)
+
+	c, err := NewApiTlsConfig(nil, serverTls.GetCertPem(), serverTls.GetKeyPem(), ca.GetCertPem())
+	assert.NoError(t, err)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = c
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.GetCertPem())
+
+	// requests without a client certificate are rejected
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	_, err = client.Get(srv.URL)
+	assert.Error(t, err)
+
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{clientTls.GetTlsLeaf()}}}}
+	resp, err := client.Get(srv.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
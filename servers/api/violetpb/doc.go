@@ -0,0 +1,5 @@
+// Package violetpb contains the protobuf messages and gRPC service of the
+// management API, the code is generated from violet.proto.
+package violetpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative violet.proto
@@ -0,0 +1,583 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: violet.proto
+
+package violetpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Violet_ListDomains_FullMethodName    = "/violet.v1.Violet/ListDomains"
+	Violet_PutDomain_FullMethodName      = "/violet.v1.Violet/PutDomain"
+	Violet_DeleteDomain_FullMethodName   = "/violet.v1.Violet/DeleteDomain"
+	Violet_ListRoutes_FullMethodName     = "/violet.v1.Violet/ListRoutes"
+	Violet_PutRoute_FullMethodName       = "/violet.v1.Violet/PutRoute"
+	Violet_DeleteRoute_FullMethodName    = "/violet.v1.Violet/DeleteRoute"
+	Violet_ListRedirects_FullMethodName  = "/violet.v1.Violet/ListRedirects"
+	Violet_PutRedirect_FullMethodName    = "/violet.v1.Violet/PutRedirect"
+	Violet_DeleteRedirect_FullMethodName = "/violet.v1.Violet/DeleteRedirect"
+	Violet_Compile_FullMethodName        = "/violet.v1.Violet/Compile"
+	Violet_GetCompile_FullMethodName     = "/violet.v1.Violet/GetCompile"
+	Violet_Watch_FullMethodName          = "/violet.v1.Violet/Watch"
+)
+
+// VioletClient is the client API for Violet service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Violet offers the management operations of the API server over gRPC.
+//
+// Requests are authenticated using a bearer token in the `authorization`
+// metadata, the same permissions as the HTTP endpoints are required. List and
+// get calls need the `:read` scope and other calls need the `:write` scope.
+type VioletClient interface {
+	// ListDomains lists registered domains, requires `violet:domains`.
+	ListDomains(ctx context.Context, in *ListDomainsRequest, opts ...grpc.CallOption) (*ListDomainsResponse, error)
+	// PutDomain adds or enables a domain, requires `violet:domains`.
+	PutDomain(ctx context.Context, in *PutDomainRequest, opts ...grpc.CallOption) (*PutDomainResponse, error)
+	// DeleteDomain disables a domain, requires `violet:domains`.
+	DeleteDomain(ctx context.Context, in *DeleteDomainRequest, opts ...grpc.CallOption) (*DeleteDomainResponse, error)
+	// ListRoutes lists routes on domains owned by the token, requires `violet:route`.
+	ListRoutes(ctx context.Context, in *ListTargetsRequest, opts ...grpc.CallOption) (*ListRoutesResponse, error)
+	// PutRoute adds or updates a route, requires `violet:route`.
+	PutRoute(ctx context.Context, in *PutRouteRequest, opts ...grpc.CallOption) (*PutRouteResponse, error)
+	// DeleteRoute moves a route to the recycle bin, requires `violet:route`.
+	DeleteRoute(ctx context.Context, in *DeleteTargetRequest, opts ...grpc.CallOption) (*DeleteTargetResponse, error)
+	// ListRedirects lists redirects on domains owned by the token, requires `violet:redirect`.
+	ListRedirects(ctx context.Context, in *ListTargetsRequest, opts ...grpc.CallOption) (*ListRedirectsResponse, error)
+	// PutRedirect adds or updates a redirect, requires `violet:redirect`.
+	PutRedirect(ctx context.Context, in *PutRedirectRequest, opts ...grpc.CallOption) (*PutRedirectResponse, error)
+	// DeleteRedirect moves a redirect to the recycle bin, requires `violet:redirect`.
+	DeleteRedirect(ctx context.Context, in *DeleteTargetRequest, opts ...grpc.CallOption) (*DeleteTargetResponse, error)
+	// Compile reloads all domains, routes and redirects in the background,
+	// requires `violet:compile`.
+	Compile(ctx context.Context, in *CompileRequest, opts ...grpc.CallOption) (*CompileResponse, error)
+	// GetCompile returns the status of a compile job, requires `violet:compile`.
+	GetCompile(ctx context.Context, in *GetCompileRequest, opts ...grpc.CallOption) (*CompileJob, error)
+	// Watch streams configuration changes and compiles until the call is
+	// cancelled, requires `violet:events`.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+}
+
+type violetClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVioletClient(cc grpc.ClientConnInterface) VioletClient {
+	return &violetClient{cc}
+}
+
+func (c *violetClient) ListDomains(ctx context.Context, in *ListDomainsRequest, opts ...grpc.CallOption) (*ListDomainsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDomainsResponse)
+	err := c.cc.Invoke(ctx, Violet_ListDomains_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violetClient) PutDomain(ctx context.Context, in *PutDomainRequest, opts ...grpc.CallOption) (*PutDomainResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PutDomainResponse)
+	err := c.cc.Invoke(ctx, Violet_PutDomain_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violetClient) DeleteDomain(ctx context.Context, in *DeleteDomainRequest, opts ...grpc.CallOption) (*DeleteDomainResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteDomainResponse)
+	err := c.cc.Invoke(ctx, Violet_DeleteDomain_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violetClient) ListRoutes(ctx context.Context, in *ListTargetsRequest, opts ...grpc.CallOption) (*ListRoutesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListRoutesResponse)
+	err := c.cc.Invoke(ctx, Violet_ListRoutes_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violetClient) PutRoute(ctx context.Context, in *PutRouteRequest, opts ...grpc.CallOption) (*PutRouteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PutRouteResponse)
+	err := c.cc.Invoke(ctx, Violet_PutRoute_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violetClient) DeleteRoute(ctx context.Context, in *DeleteTargetRequest, opts ...grpc.CallOption) (*DeleteTargetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteTargetResponse)
+	err := c.cc.Invoke(ctx, Violet_DeleteRoute_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violetClient) ListRedirects(ctx context.Context, in *ListTargetsRequest, opts ...grpc.CallOption) (*ListRedirectsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListRedirectsResponse)
+	err := c.cc.Invoke(ctx, Violet_ListRedirects_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violetClient) PutRedirect(ctx context.Context, in *PutRedirectRequest, opts ...grpc.CallOption) (*PutRedirectResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PutRedirectResponse)
+	err := c.cc.Invoke(ctx, Violet_PutRedirect_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violetClient) DeleteRedirect(ctx context.Context, in *DeleteTargetRequest, opts ...grpc.CallOption) (*DeleteTargetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteTargetResponse)
+	err := c.cc.Invoke(ctx, Violet_DeleteRedirect_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violetClient) Compile(ctx context.Context, in *CompileRequest, opts ...grpc.CallOption) (*CompileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CompileResponse)
+	err := c.cc.Invoke(ctx, Violet_Compile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violetClient) GetCompile(ctx context.Context, in *GetCompileRequest, opts ...grpc.CallOption) (*CompileJob, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CompileJob)
+	err := c.cc.Invoke(ctx, Violet_GetCompile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *violetClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Violet_ServiceDesc.Streams[0], Violet_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Violet_WatchClient = grpc.ServerStreamingClient[Event]
+
+// VioletServer is the server API for Violet service.
+// All implementations must embed UnimplementedVioletServer
+// for forward compatibility.
+//
+// Violet offers the management operations of the API server over gRPC.
+//
+// Requests are authenticated using a bearer token in the `authorization`
+// metadata, the same permissions as the HTTP endpoints are required. List and
+// get calls need the `:read` scope and other calls need the `:write` scope.
+type VioletServer interface {
+	// ListDomains lists registered domains, requires `violet:domains`.
+	ListDomains(context.Context, *ListDomainsRequest) (*ListDomainsResponse, error)
+	// PutDomain adds or enables a domain, requires `violet:domains`.
+	PutDomain(context.Context, *PutDomainRequest) (*PutDomainResponse, error)
+	// DeleteDomain disables a domain, requires `violet:domains`.
+	DeleteDomain(context.Context, *DeleteDomainRequest) (*DeleteDomainResponse, error)
+	// ListRoutes lists routes on domains owned by the token, requires `violet:route`.
+	ListRoutes(context.Context, *ListTargetsRequest) (*ListRoutesResponse, error)
+	// PutRoute adds or updates a route, requires `violet:route`.
+	PutRoute(context.Context, *PutRouteRequest) (*PutRouteResponse, error)
+	// DeleteRoute moves a route to the recycle bin, requires `violet:route`.
+	DeleteRoute(context.Context, *DeleteTargetRequest) (*DeleteTargetResponse, error)
+	// ListRedirects lists redirects on domains owned by the token, requires `violet:redirect`.
+	ListRedirects(context.Context, *ListTargetsRequest) (*ListRedirectsResponse, error)
+	// PutRedirect adds or updates a redirect, requires `violet:redirect`.
+	PutRedirect(context.Context, *PutRedirectRequest) (*PutRedirectResponse, error)
+	// DeleteRedirect moves a redirect to the recycle bin, requires `violet:redirect`.
+	DeleteRedirect(context.Context, *DeleteTargetRequest) (*DeleteTargetResponse, error)
+	// Compile reloads all domains, routes and redirects in the background,
+	// requires `violet:compile`.
+	Compile(context.Context, *CompileRequest) (*CompileResponse, error)
+	// GetCompile returns the status of a compile job, requires `violet:compile`.
+	GetCompile(context.Context, *GetCompileRequest) (*CompileJob, error)
+	// Watch streams configuration changes and compiles until the call is
+	// cancelled, requires `violet:events`.
+	Watch(*WatchRequest, grpc.ServerStreamingServer[Event]) error
+	mustEmbedUnimplementedVioletServer()
+}
+
+// UnimplementedVioletServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedVioletServer struct{}
+
+func (UnimplementedVioletServer) ListDomains(context.Context, *ListDomainsRequest) (*ListDomainsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDomains not implemented")
+}
+func (UnimplementedVioletServer) PutDomain(context.Context, *PutDomainRequest) (*PutDomainResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutDomain not implemented")
+}
+func (UnimplementedVioletServer) DeleteDomain(context.Context, *DeleteDomainRequest) (*DeleteDomainResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteDomain not implemented")
+}
+func (UnimplementedVioletServer) ListRoutes(context.Context, *ListTargetsRequest) (*ListRoutesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRoutes not implemented")
+}
+func (UnimplementedVioletServer) PutRoute(context.Context, *PutRouteRequest) (*PutRouteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutRoute not implemented")
+}
+func (UnimplementedVioletServer) DeleteRoute(context.Context, *DeleteTargetRequest) (*DeleteTargetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteRoute not implemented")
+}
+func (UnimplementedVioletServer) ListRedirects(context.Context, *ListTargetsRequest) (*ListRedirectsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRedirects not implemented")
+}
+func (UnimplementedVioletServer) PutRedirect(context.Context, *PutRedirectRequest) (*PutRedirectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutRedirect not implemented")
+}
+func (UnimplementedVioletServer) DeleteRedirect(context.Context, *DeleteTargetRequest) (*DeleteTargetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteRedirect not implemented")
+}
+func (UnimplementedVioletServer) Compile(context.Context, *CompileRequest) (*CompileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Compile not implemented")
+}
+func (UnimplementedVioletServer) GetCompile(context.Context, *GetCompileRequest) (*CompileJob, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCompile not implemented")
+}
+func (UnimplementedVioletServer) Watch(*WatchRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedVioletServer) mustEmbedUnimplementedVioletServer() {}
+func (UnimplementedVioletServer) testEmbeddedByValue()                {}
+
+// UnsafeVioletServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VioletServer will
+// result in compilation errors.
+type UnsafeVioletServer interface {
+	mustEmbedUnimplementedVioletServer()
+}
+
+func RegisterVioletServer(s grpc.ServiceRegistrar, srv VioletServer) {
+	// If the following call pancis, it indicates UnimplementedVioletServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Violet_ServiceDesc, srv)
+}
+
+func _Violet_ListDomains_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDomainsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VioletServer).ListDomains(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Violet_ListDomains_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VioletServer).ListDomains(ctx, req.(*ListDomainsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violet_PutDomain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutDomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VioletServer).PutDomain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Violet_PutDomain_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VioletServer).PutDomain(ctx, req.(*PutDomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violet_DeleteDomain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteDomainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VioletServer).DeleteDomain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Violet_DeleteDomain_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VioletServer).DeleteDomain(ctx, req.(*DeleteDomainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violet_ListRoutes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTargetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VioletServer).ListRoutes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Violet_ListRoutes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VioletServer).ListRoutes(ctx, req.(*ListTargetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violet_PutRoute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRouteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VioletServer).PutRoute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Violet_PutRoute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VioletServer).PutRoute(ctx, req.(*PutRouteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violet_DeleteRoute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteTargetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VioletServer).DeleteRoute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Violet_DeleteRoute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VioletServer).DeleteRoute(ctx, req.(*DeleteTargetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violet_ListRedirects_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTargetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VioletServer).ListRedirects(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Violet_ListRedirects_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VioletServer).ListRedirects(ctx, req.(*ListTargetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violet_PutRedirect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRedirectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VioletServer).PutRedirect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Violet_PutRedirect_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VioletServer).PutRedirect(ctx, req.(*PutRedirectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violet_DeleteRedirect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteTargetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VioletServer).DeleteRedirect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Violet_DeleteRedirect_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VioletServer).DeleteRedirect(ctx, req.(*DeleteTargetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violet_Compile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VioletServer).Compile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Violet_Compile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VioletServer).Compile(ctx, req.(*CompileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violet_GetCompile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCompileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VioletServer).GetCompile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Violet_GetCompile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VioletServer).GetCompile(ctx, req.(*GetCompileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Violet_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(VioletServer).Watch(m, &grpc.GenericServerStream[WatchRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Violet_WatchServer = grpc.ServerStreamingServer[Event]
+
+// Violet_ServiceDesc is the grpc.ServiceDesc for Violet service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Violet_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "violet.v1.Violet",
+	HandlerType: (*VioletServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListDomains",
+			Handler:    _Violet_ListDomains_Handler,
+		},
+		{
+			MethodName: "PutDomain",
+			Handler:    _Violet_PutDomain_Handler,
+		},
+		{
+			MethodName: "DeleteDomain",
+			Handler:    _Violet_DeleteDomain_Handler,
+		},
+		{
+			MethodName: "ListRoutes",
+			Handler:    _Violet_ListRoutes_Handler,
+		},
+		{
+			MethodName: "PutRoute",
+			Handler:    _Violet_PutRoute_Handler,
+		},
+		{
+			MethodName: "DeleteRoute",
+			Handler:    _Violet_DeleteRoute_Handler,
+		},
+		{
+			MethodName: "ListRedirects",
+			Handler:    _Violet_ListRedirects_Handler,
+		},
+		{
+			MethodName: "PutRedirect",
+			Handler:    _Violet_PutRedirect_Handler,
+		},
+		{
+			MethodName: "DeleteRedirect",
+			Handler:    _Violet_DeleteRedirect_Handler,
+		},
+		{
+			MethodName: "Compile",
+			Handler:    _Violet_Compile_Handler,
+		},
+		{
+			MethodName: "GetCompile",
+			Handler:    _Violet_GetCompile_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Violet_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "violet.proto",
+}
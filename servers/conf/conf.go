@@ -1,26 +1,50 @@
 package conf
 
 import (
+	"crypto/tls"
 	"database/sql"
 	"github.com/MrMelon54/mjwt"
+	"github.com/MrMelon54/violet/apikeys"
+	"github.com/MrMelon54/violet/audit"
 	errorPages "github.com/MrMelon54/violet/error-pages"
 	"github.com/MrMelon54/violet/favicons"
 	"github.com/MrMelon54/violet/router"
 	"github.com/MrMelon54/violet/utils"
+	"github.com/MrMelon54/violet/webhooks"
+	"io"
 )
 
 // Conf stores the shared configuration for the API, HTTP and HTTPS servers.
 type Conf struct {
-	ApiListen   string // api server listen address
-	HttpListen  string // http server listen address
-	HttpsListen string // https server listen address
-	RateLimit   uint64 // rate limit per minute
-	DB          *sql.DB
-	Domains     utils.DomainProvider
-	Acme        utils.AcmeChallengeProvider
-	Certs       utils.CertProvider
-	Favicons    *favicons.Favicons
-	Signer      mjwt.Verifier
-	ErrorPages  *errorPages.ErrorPages
-	Router      *router.Manager
+	ApiListen         string       // api server listen address
+	HttpListen        string       // http server listen address
+	HttpsListen       string       // https server listen address
+	GrpcListen        string       // grpc management server listen address, empty disables
+	RateLimit         uint64       // rate limit per minute
+	FaviconCache      string       // Cache-Control header of served favicons, empty uses a day
+	MaxBodyBytes      int64        // larger request bodies receive the 413 page, zero is unlimited
+	MaxHeaderBytes    int          // larger request headers receive the 431 page, zero uses the server limit
+	RejectSni         bool         // reject unknown sni instead of using the default cert
+	AutoRegister      bool         // register the host of new routes and redirects as a domain
+	ApiCorsOrigins    []string     // origins allowed to call the api from a browser, empty disables cors
+	ApiCorsMethods    []string     // methods allowed for cors requests to the api
+	ApiTls            *tls.Config  // enables tls on the api server
+	ApiRateLimit      uint64       // api rate limit per minute for each address
+	ApiAuthFailures   uint64       // failed api authentication attempts before an address is blocked
+	ApiAccessLog      io.Writer    // receives a JSON line for every api request, nil disables
+	ApiRequireIfMatch bool         // reject updates of routes and redirects without an If-Match header
+	ApiLimits         ServerLimits // timeouts and header size of the api servers, zero fields keep the defaults
+	DB                *sql.DB
+	Domains           utils.DomainProvider
+	Acme              utils.AcmeChallengeProvider
+	Certs             utils.CertProvider
+	Favicons          *favicons.Favicons
+	Signer            mjwt.Verifier // nil when only api keys are used
+	ErrorPages        *errorPages.ErrorPages
+	Router            *router.Manager
+	Audit             *audit.Log            // records changes made using the API, nil disables
+	ApiKeys           *apikeys.Keys         // long-lived api keys, nil disables
+	Listeners         *utils.ListenerStates // state of the http servers, nil disables
+	Stats             *utils.HostStats      // traffic of each host served by the proxy, nil disables
+	Webhooks          *webhooks.Webhooks    // receives route changes and compiles, nil disables
 }
@@ -0,0 +1,35 @@
+package conf
+
+import (
+	"net/http"
+	"time"
+)
+
+// ServerLimits overrides the timeouts and maximum header size of a http
+// server, zero fields keep the value already set on the server.
+type ServerLimits struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+}
+
+// Apply sets the non-zero limits on the server
+func (l ServerLimits) Apply(srv *http.Server) {
+	if l.ReadTimeout > 0 {
+		srv.ReadTimeout = l.ReadTimeout
+	}
+	if l.ReadHeaderTimeout > 0 {
+		srv.ReadHeaderTimeout = l.ReadHeaderTimeout
+	}
+	if l.WriteTimeout > 0 {
+		srv.WriteTimeout = l.WriteTimeout
+	}
+	if l.IdleTimeout > 0 {
+		srv.IdleTimeout = l.IdleTimeout
+	}
+	if l.MaxHeaderBytes > 0 {
+		srv.MaxHeaderBytes = l.MaxHeaderBytes
+	}
+}
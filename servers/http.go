@@ -32,14 +32,14 @@ func NewHttpServer(conf *conf.Conf) *http.Server {
 
 		// check if the host is valid
 		if !conf.Domains.IsValid(req.Host) {
-			utils.RespondVioletError(rw, http.StatusBadRequest, "Invalid host")
+			respondError(conf.ErrorPages, rw, req, http.StatusBadRequest, "Invalid host")
 			return
 		}
 
 		// check if the key is valid
 		value := conf.Acme.Get(h, params.ByName("key"))
 		if value == "" {
-			rw.WriteHeader(http.StatusNotFound)
+			respondError(conf.ErrorPages, rw, req, http.StatusNotFound, "Unknown acme challenge")
 			return
 		}
 
@@ -48,8 +48,18 @@ func NewHttpServer(conf *conf.Conf) *http.Server {
 		_, _ = rw.Write([]byte(value))
 	})
 
-	// All other paths lead here and are forwarded to HTTPS
+	// All other paths lead here and are forwarded to HTTPS unless the domain
+	// allows plain http requests
+	var plainHandler http.Handler
+	if conf.Router != nil {
+		plainHandler = setupSorryMiddleware(conf.ErrorPages, setupMaintenanceMiddleware(conf.Domains, conf.ErrorPages, conf.Router))
+	}
 	r.NotFound = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if settings, ok := conf.Domains.GetSettings(req.Host); ok && !settings.ForceHttps && plainHandler != nil {
+			plainHandler.ServeHTTP(rw, req)
+			return
+		}
+
 		h := utils.GetDomainWithoutPort(req.Host)
 		u := &url.URL{
 			Scheme:   "https",
@@ -61,14 +71,19 @@ func NewHttpServer(conf *conf.Conf) *http.Server {
 		utils.FastRedirect(rw, req, u.String(), http.StatusPermanentRedirect)
 	})
 
-	// Create and run http server
+	r.MethodNotAllowed = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		respondError(conf.ErrorPages, rw, req, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+
+	// Create and run http server, the request limits also cover the acme
+	// challenges and redirects
 	return &http.Server{
 		Addr:              conf.HttpListen,
-		Handler:           r,
+		Handler:           setupRequestLimits(conf.MaxBodyBytes, conf.MaxHeaderBytes, conf.ErrorPages, r),
 		ReadTimeout:       time.Minute,
 		ReadHeaderTimeout: time.Minute,
 		WriteTimeout:      time.Minute,
 		IdleTimeout:       time.Minute,
-		MaxHeaderBytes:    2500,
+		MaxHeaderBytes:    serverHeaderLimit(2500, conf.MaxHeaderBytes),
 	}
 }
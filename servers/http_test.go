@@ -2,6 +2,10 @@ package servers
 
 import (
 	"bytes"
+	"database/sql"
+	errorPages "github.com/MrMelon54/violet/error-pages"
+	"github.com/MrMelon54/violet/proxy"
+	"github.com/MrMelon54/violet/router"
 	"github.com/MrMelon54/violet/servers/conf"
 	"github.com/MrMelon54/violet/utils"
 	"github.com/MrMelon54/violet/utils/fake"
@@ -9,7 +13,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestNewHttpServer_AcmeChallenge(t *testing.T) {
@@ -41,8 +47,73 @@ func TestNewHttpServer_AcmeChallenge(t *testing.T) {
 	srv.Handler.ServeHTTP(rec, req)
 	res = rec.Result()
 	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, "Unknown acme challenge", res.Header.Get("X-Violet-Error"))
 
-	all, err = io.ReadAll(res.Body)
+	// failures use the error pages
+	httpConf.ErrorPages = errorPages.New(fstest.MapFS{"404.html": {Data: []byte("missing {{.Host}}\n")}})
+	assert.NoError(t, httpConf.ErrorPages.CompileSync())
+	srv = NewHttpServer(httpConf)
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "missing example.com\n", rec.Body.String())
+
+	// other methods are not allowed
+	req, err = http.NewRequest(http.MethodPost, "https://example.com/.well-known/acme-challenge/456", nil)
+	assert.NoError(t, err)
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "Method not allowed", rec.Header().Get("X-Violet-Error"))
+	assert.Contains(t, rec.Body.String(), "405")
+}
+
+func TestNewHttpServer_ForceHttps(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	assert.NoError(t, err)
+
+	ft := &fakeTransport{}
+	domains := &fake.Domains{}
+	httpConf := &conf.Conf{
+		HttpsListen: "0.0.0.0:8443",
+		Domains:     domains,
+		Acme:        utils.NewAcmeChallenge(),
+		Signer:      fake.SnakeOilProv,
+		Router:      router.NewManager(db, proxy.NewHybridTransportWithCalls(ft, ft)),
+	}
+	srv := NewHttpServer(httpConf)
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/hello?a=b", nil)
 	assert.NoError(t, err)
-	assert.Equal(t, 0, bytes.Compare([]byte(""), all))
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+	assert.Equal(t, "https://example.com:8443/hello?a=b", rec.Header().Get("Location"))
+
+	// plain http requests are passed to the router
+	domains.Settings = &utils.DomainSettings{ForceHttps: false, WildcardDepth: 1}
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+}
+
+func TestNewHttpServer_RequestLimits(t *testing.T) {
+	httpConf := &conf.Conf{
+		Domains:        &fake.Domains{},
+		Acme:           utils.NewAcmeChallenge(),
+		Signer:         fake.SnakeOilProv,
+		MaxHeaderBytes: 1000,
+	}
+	srv := NewHttpServer(httpConf)
+	assert.Equal(t, 2500, srv.MaxHeaderBytes)
+	httpConf.Acme.Put("example.com", "456", "456def")
+
+	// the limit covers the acme challenges and redirects
+	for _, i := range []string{"http://example.com/.well-known/acme-challenge/456", "http://example.com/hello"} {
+		req := httptest.NewRequest(http.MethodGet, i, nil)
+		req.Header.Set("Cookie", strings.Repeat("a", 1000))
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, rec.Code, i)
+	}
 }
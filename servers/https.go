@@ -1,17 +1,23 @@
 package servers
 
 import (
+	"bytes"
 	"crypto/tls"
 	"fmt"
+	errorPages "github.com/MrMelon54/violet/error-pages"
 	"github.com/MrMelon54/violet/favicons"
+	"github.com/MrMelon54/violet/router"
 	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/target"
 	"github.com/MrMelon54/violet/utils"
 	"github.com/sethvargo/go-limiter/httplimit"
 	"github.com/sethvargo/go-limiter/memorystore"
 	"log"
 	"net"
 	"net/http"
-	"path"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,16 +26,22 @@ import (
 func NewHttpsServer(conf *conf.Conf) *http.Server {
 	return &http.Server{
 		Addr:    conf.HttpsListen,
-		Handler: setupRateLimiter(conf.RateLimit, setupFaviconMiddleware(conf.Favicons, conf.Router)),
+		Handler: conf.Stats.Middleware(conf.Domains.IsValid, setupRateLimiter(conf.RateLimit, conf.Domains, conf.ErrorPages, setupHstsMiddleware(conf.Domains, setupRequestLimits(conf.MaxBodyBytes, conf.MaxHeaderBytes, conf.ErrorPages, setupSorryMiddleware(conf.ErrorPages, setupMaintenanceMiddleware(conf.Domains, conf.ErrorPages, setupFaviconMiddleware(conf.Favicons, conf.FaviconCache, conf.ErrorPages, conf.Router))))))),
 		TLSConfig: &tls.Config{GetCertificate: func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
-			// error out on invalid domains
+			// use the default certificate for unknown hostnames unless rejected
 			if !conf.Domains.IsValid(info.ServerName) {
+				if def := conf.Certs.GetDefaultCert(); def != nil && !conf.RejectSni {
+					return def, nil
+				}
 				return nil, fmt.Errorf("invalid hostname used: '%s'", info.ServerName)
 			}
 
 			// find a certificate
-			cert := conf.Certs.GetCertForDomain(info.ServerName)
+			cert := conf.Certs.GetCertForHello(info)
 			if cert == nil {
+				if def := conf.Certs.GetDefaultCert(); def != nil {
+					return def, nil
+				}
 				return nil, fmt.Errorf("failed to find certificate for: '%s'", info.ServerName)
 			}
 
@@ -40,7 +52,7 @@ func NewHttpsServer(conf *conf.Conf) *http.Server {
 		ReadHeaderTimeout: 150 * time.Second,
 		WriteTimeout:      150 * time.Second,
 		IdleTimeout:       150 * time.Second,
-		MaxHeaderBytes:    4096000,
+		MaxHeaderBytes:    serverHeaderLimit(4096000, conf.MaxHeaderBytes),
 		ConnState: func(conn net.Conn, state http.ConnState) {
 			fmt.Printf("[HTTPS] %s => %s: %s\n", conn.LocalAddr(), conn.RemoteAddr(), state.String())
 		},
@@ -48,8 +60,77 @@ func NewHttpsServer(conf *conf.Conf) *http.Server {
 }
 
 // setupRateLimiter is an internal function to create a middleware to manage
-// rate limits.
-func setupRateLimiter(rateLimit uint64, next http.Handler) http.Handler {
+// rate limits, domains with a rate limit override use a separate limiter for
+// each host. Responses generated by the limiters use the error pages.
+func setupRateLimiter(rateLimit uint64, domains utils.DomainProvider, pages *errorPages.ErrorPages, next http.Handler) http.Handler {
+	limited := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// the limiter passes the wrapped writer to the next handler
+		if g, ok := rw.(*limiterResponse); ok {
+			rw = g.rw
+		}
+		next.ServeHTTP(rw, req)
+	})
+	global := newRateLimitMiddleware(rateLimit, httplimit.IPKeyFunc())
+	handler := global.Handle(limited)
+
+	// limiters for the overridden rate limits are created on first use
+	overrides := make(map[uint64]http.Handler)
+	var overrideLock sync.Mutex
+	hostKeyFunc := httplimit.KeyFunc(func(req *http.Request) (string, error) {
+		ip, err := httplimit.IPKeyFunc()(req)
+		return utils.GetDomainWithoutPort(req.Host) + "|" + ip, err
+	})
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if pages != nil {
+			rw = &limiterResponse{rw: rw, req: req, pages: pages}
+		}
+		settings, ok := domains.GetSettings(req.Host)
+		if !ok || settings.RateLimit == 0 || settings.RateLimit == rateLimit {
+			handler.ServeHTTP(rw, req)
+			return
+		}
+
+		overrideLock.Lock()
+		h, ok := overrides[settings.RateLimit]
+		if !ok {
+			h = newRateLimitMiddleware(settings.RateLimit, hostKeyFunc).Handle(limited)
+			overrides[settings.RateLimit] = h
+		}
+		overrideLock.Unlock()
+		h.ServeHTTP(rw, req)
+	})
+}
+
+// limiterResponse replaces the plain text responses written by the rate
+// limiter with the error page, the rate limit headers are kept
+type limiterResponse struct {
+	rw    http.ResponseWriter
+	req   *http.Request
+	pages *errorPages.ErrorPages
+	done  bool
+}
+
+func (l *limiterResponse) Header() http.Header { return l.rw.Header() }
+
+func (l *limiterResponse) WriteHeader(code int) {
+	if l.done {
+		return
+	}
+	l.done = true
+	l.rw.Header().Del("Content-Type")
+	l.rw.Header().Del("X-Content-Type-Options")
+	respondError(l.pages, l.rw, l.req, code, http.StatusText(code))
+}
+
+func (l *limiterResponse) Write(p []byte) (int, error) {
+	l.WriteHeader(http.StatusOK)
+	return len(p), nil
+}
+
+// newRateLimitMiddleware creates a rate limit middleware allowing the number
+// of requests per minute for each key
+func newRateLimitMiddleware(rateLimit uint64, keyFunc httplimit.KeyFunc) *httplimit.Middleware {
 	// create memory store
 	store, err := memorystore.New(&memorystore.Config{
 		Tokens:   rateLimit,
@@ -59,31 +140,158 @@ func setupRateLimiter(rateLimit uint64, next http.Handler) http.Handler {
 		log.Fatalln(err)
 	}
 
-	// create a middleware using ips as the key for rate limits
-	middleware, err := httplimit.NewMiddleware(store, httplimit.IPKeyFunc())
+	// create a middleware using the key func for rate limits
+	middleware, err := httplimit.NewMiddleware(store, keyFunc)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	return middleware.Handle(next)
+	return middleware
+}
+
+// setupHstsMiddleware adds the Strict-Transport-Security header for domains
+// with a HSTS policy
+func setupHstsMiddleware(domains utils.DomainProvider, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if settings, ok := domains.GetSettings(req.Host); ok && settings.Hsts != "" {
+			rw.Header().Set("Strict-Transport-Security", settings.Hsts)
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// setupSorryMiddleware responds with the sorry page for every request to hosts
+// with the sorry page enabled, the routes and maintenance settings of the host
+// are bypassed
+func setupSorryMiddleware(pages *errorPages.ErrorPages, next http.Handler) http.Handler {
+	if pages == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !pages.IsSorry(req.Host) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.Header().Set("X-Violet-Error", "Host is unavailable")
+		pages.ServeSorry(rw, req)
+	})
+}
+
+// setupMaintenanceMiddleware responds with the maintenance page for every
+// request to domains in maintenance mode
+func setupMaintenanceMiddleware(domains utils.DomainProvider, pages *errorPages.ErrorPages, next http.Handler) http.Handler {
+	maintain := MaintenanceHandler(domains, pages)
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		settings, ok := domains.GetSettings(req.Host)
+		if !ok || !settings.Maintenance {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		maintain(rw, req, next)
+	})
+}
+
+// MaintenanceHandler returns the handler used for domains in maintenance mode
+// and routes flagged for maintenance. Requests from the allowed addresses of
+// the domain are passed to next, other requests receive the custom page of the
+// domain if set otherwise the maintenance page of the host is used.
+func MaintenanceHandler(domains utils.DomainProvider, pages *errorPages.ErrorPages) router.MaintenanceHandler {
+	return func(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+		settings, _ := domains.GetSettings(req.Host)
+		if utils.AddrInList(settings.MaintenanceAllow, req.RemoteAddr) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		rw.Header().Set("Cache-Control", "no-store")
+		if settings.MaintenanceRetry > 0 {
+			rw.Header().Set("Retry-After", strconv.FormatUint(settings.MaintenanceRetry, 10))
+		}
+		switch {
+		case settings.MaintenancePage != "":
+			rw.Header().Set("Content-Type", "text/html; encoding=utf-8")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = rw.Write([]byte(settings.MaintenancePage))
+		case pages != nil:
+			pages.ServeMaintenance(rw, req)
+		default:
+			respondError(pages, rw, req, http.StatusServiceUnavailable, "Domain is under maintenance")
+		}
+	}
+}
+
+// UpstreamErrorHandler returns the handler which writes the error page when the
+// backend of a route is down, times out or fails the tls connection
+func UpstreamErrorHandler(pages *errorPages.ErrorPages) target.UpstreamErrorHandler {
+	return func(rw http.ResponseWriter, req *http.Request, failure target.UpstreamFailure) {
+		rw.Header().Set("X-Violet-Error", failure.String())
+		code := failure.Status()
+		if failure == target.UpstreamTls {
+			code = errorPages.UpstreamTlsStatus
+		}
+		pages.ServeUpstreamError(rw, req, code)
+	}
+}
+
+// ErrorPageHandler returns the handler which writes the error page for the
+// responses generated by the router
+func ErrorPageHandler(pages *errorPages.ErrorPages) router.ErrorHandler {
+	return func(rw http.ResponseWriter, req *http.Request, code int, msg string) {
+		respondError(pages, rw, req, code, msg)
+	}
+}
+
+// respondError writes the error page for a response generated by violet with
+// the message in the X-Violet-Error header, plain text is used without pages
+func respondError(pages *errorPages.ErrorPages, rw http.ResponseWriter, req *http.Request, code int, msg string) {
+	if pages == nil {
+		utils.RespondVioletError(rw, code, msg)
+		return
+	}
+	rw.Header().Set("X-Violet-Error", msg)
+	pages.ServeRequestError(rw, req, code)
 }
 
-func setupFaviconMiddleware(fav *favicons.Favicons, next http.Handler) http.Handler {
+// Cache-Control headers of served favicons
+const (
+	defaultFaviconCache   = "public, max-age=86400"
+	immutableFaviconCache = "public, max-age=31536000, immutable"
+)
+
+// setupFaviconMiddleware serves the favicons with the hash as the ETag, requests
+// with a `v` query matching the start of the hash are cached forever
+func setupFaviconMiddleware(fav *favicons.Favicons, cacheControl string, pages *errorPages.ErrorPages, next http.Handler) http.Handler {
+	if cacheControl == "" {
+		cacheControl = defaultFaviconCache
+	}
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		if req.Header.Get("X-Violet-Raw-Favicon") != "1" {
-			switch req.URL.Path {
-			case "/favicon.svg", "/favicon.png", "/favicon.ico":
-				icons := fav.GetIcons(req.Host)
-				if icons == nil {
-					break
+		prefix, name, isIcon := favicons.SplitIconPath(req.URL.Path)
+		if req.Header.Get("X-Violet-Raw-Favicon") != "1" && isIcon {
+			if icons := fav.FindIconsAt(req.Host, prefix); icons != nil {
+				img, contentType, err := icons.ProduceForRequest(name, req.Header.Get("Accept"))
+				if err != nil && favicons.IsCompanionPath(name) {
+					// the backend serves the companion files without a name or
+					// theme color
+					next.ServeHTTP(rw, req)
+					return
 				}
-				raw, contentType, err := icons.ProduceForExt(path.Ext(req.URL.Path))
 				if err != nil {
-					utils.RespondVioletError(rw, http.StatusTeapot, "No icon available")
+					respondError(pages, rw, req, http.StatusTeapot, "No icon available")
 					return
 				}
+				// png icons can be replaced with other formats
+				if strings.HasSuffix(req.URL.Path, ".png") {
+					rw.Header().Add("Vary", "Accept")
+				}
 				rw.Header().Set("Content-Type", contentType)
-				rw.WriteHeader(http.StatusOK)
-				_, _ = rw.Write(raw)
+				rw.Header().Set("ETag", `"`+img.Hash+`"`)
+				if v := req.URL.Query().Get("v"); len(v) >= 8 && strings.HasPrefix(img.Hash, v) {
+					rw.Header().Set("Cache-Control", immutableFaviconCache)
+				} else {
+					rw.Header().Set("Cache-Control", cacheControl)
+				}
+
+				// handles If-None-Match and HEAD requests
+				http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(img.Raw))
 				return
 			}
 		}
@@ -1,18 +1,28 @@
 package servers
 
 import (
+	"crypto/tls"
+	"crypto/x509/pkix"
 	"database/sql"
+	"github.com/MrMelon54/certgen"
 	"github.com/MrMelon54/violet/certs"
+	errorPages "github.com/MrMelon54/violet/error-pages"
+	"github.com/MrMelon54/violet/favicons"
 	"github.com/MrMelon54/violet/proxy"
 	"github.com/MrMelon54/violet/router"
 	"github.com/MrMelon54/violet/servers/conf"
+	"github.com/MrMelon54/violet/target"
+	"github.com/MrMelon54/violet/utils"
 	"github.com/MrMelon54/violet/utils/fake"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 type fakeTransport struct{}
@@ -59,3 +69,296 @@ func TestNewHttpsServer_RateLimit(t *testing.T) {
 	res := rec.Result()
 	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
 }
+
+func TestNewHttpsServer_ErrorPages(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	assert.NoError(t, err)
+
+	ft := &fakeTransport{}
+	pages := errorPages.New(fstest.MapFS{
+		"418.html": {Data: []byte("no route {{.Host}}\n")},
+		"429.html": {Data: []byte("slow down\n")},
+	})
+	assert.NoError(t, pages.CompileSync())
+	manager := router.NewManager(db, proxy.NewHybridTransportWithCalls(ft, ft))
+	manager.SetErrorPages(ErrorPageHandler(pages))
+	httpsConf := &conf.Conf{
+		RateLimit:  1,
+		Domains:    &fake.Domains{},
+		Certs:      certs.New(nil, nil, true),
+		Signer:     fake.SnakeOilProv,
+		ErrorPages: pages,
+		Router:     manager,
+	}
+	srv := NewHttpsServer(httpsConf)
+
+	req, err := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	req.RemoteAddr = "127.0.0.1:1448"
+	assert.NoError(t, err)
+
+	// responses from the router use the error pages
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+	assert.Equal(t, "No route", rec.Header().Get("X-Violet-Error"))
+	assert.Equal(t, "no route example.com\n", rec.Body.String())
+
+	// rate limited responses keep the rate limit headers
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "slow down\n", rec.Body.String())
+	assert.Equal(t, "text/html; encoding=utf-8", rec.Header().Get("Content-Type"))
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+}
+
+func TestNewHttpsServer_DefaultCert(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	assert.NoError(t, err)
+
+	serverTls, err := certgen.MakeServerTls(nil, 2048, pkix.Name{CommonName: "default.violet.test"}, big.NewInt(1), func(now time.Time) time.Time {
+		return now.AddDate(1, 0, 0)
+	}, []string{"default.violet.test"}, nil)
+	assert.NoError(t, err)
+
+	ft := &fakeTransport{}
+	allowedCerts := certs.New(fstest.MapFS{}, fstest.MapFS{}, false)
+	httpsConf := &conf.Conf{
+		Domains: &fake.Domains{},
+		Certs:   allowedCerts,
+		Signer:  fake.SnakeOilProv,
+		Router:  router.NewManager(db, proxy.NewHybridTransportWithCalls(ft, ft)),
+	}
+	srv := NewHttpsServer(httpsConf)
+
+	// no default certificate
+	_, err = srv.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "notexample.com"})
+	assert.Error(t, err)
+	_, err = srv.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	assert.Error(t, err)
+
+	// unknown and known hostnames use the default certificate
+	assert.NoError(t, allowedCerts.SetDefaultCert(serverTls.GetCertPem(), serverTls.GetKeyPem()))
+	cert, err := srv.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "notexample.com"})
+	assert.NoError(t, err)
+	assert.Same(t, allowedCerts.GetDefaultCert(), cert)
+	cert, err = srv.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	assert.NoError(t, err)
+	assert.Same(t, allowedCerts.GetDefaultCert(), cert)
+
+	// unknown hostnames are rejected
+	httpsConf.RejectSni = true
+	_, err = srv.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "notexample.com"})
+	assert.Error(t, err)
+	cert, err = srv.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	assert.NoError(t, err)
+	assert.Same(t, allowedCerts.GetDefaultCert(), cert)
+}
+
+func TestNewHttpsServer_DomainSettings(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	assert.NoError(t, err)
+
+	ft := &fakeTransport{}
+	httpsConf := &conf.Conf{
+		RateLimit: 5,
+		Domains:   &fake.Domains{Settings: &utils.DomainSettings{Hsts: "max-age=63072000", RateLimit: 2}},
+		Certs:     certs.New(nil, nil, true),
+		Signer:    fake.SnakeOilProv,
+		Router:    router.NewManager(db, proxy.NewHybridTransportWithCalls(ft, ft)),
+	}
+	srv := NewHttpsServer(httpsConf)
+
+	req, err := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	req.RemoteAddr = "127.0.0.1:1447"
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusTeapot, rec.Code)
+		assert.Equal(t, "max-age=63072000", rec.Header().Get("Strict-Transport-Security"))
+	}
+
+	// the domain rate limit is used instead of the global rate limit
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func TestNewHttpsServer_Maintenance(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	assert.NoError(t, err)
+
+	ft := &fakeTransport{}
+	domains := &fake.Domains{Settings: &utils.DomainSettings{Maintenance: true}}
+	httpsConf := &conf.Conf{
+		RateLimit: 5,
+		Domains:   domains,
+		Certs:     certs.New(nil, nil, true),
+		Signer:    fake.SnakeOilProv,
+		Router:    router.NewManager(db, proxy.NewHybridTransportWithCalls(ft, ft)),
+	}
+	srv := NewHttpsServer(httpsConf)
+
+	req, err := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	req.RemoteAddr = "127.0.0.1:1447"
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "Domain is under maintenance", rec.Header().Get("X-Violet-Error"))
+
+	// the custom page is used when set
+	domains.Settings.MaintenancePage = "<p>Back soon</p>"
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "<p>Back soon</p>", rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+
+	// the retry delay is sent and allowed addresses bypass maintenance
+	domains.Settings.MaintenanceRetry = 300
+	domains.Settings.MaintenanceAllow = "10.0.0.0/8"
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "300", rec.Header().Get("Retry-After"))
+
+	req.RemoteAddr = "10.0.0.1:1447"
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+}
+
+func TestSetupSorryMiddleware(t *testing.T) {
+	pages := errorPages.New(fstest.MapFS{"sorry.html": {Data: []byte("sorry\n")}})
+	assert.NoError(t, pages.CompileSync())
+	h := setupSorryMiddleware(pages, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// routes are bypassed while the sorry page is enabled
+	assert.NoError(t, pages.SetSorry("example.com", true))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "sorry\n", rec.Body.String())
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	assert.Equal(t, "Host is unavailable", rec.Header().Get("X-Violet-Error"))
+}
+
+func TestMaintenanceHandler(t *testing.T) {
+	pages := errorPages.New(fstest.MapFS{
+		"503.html":                     {Data: []byte("global 503\n")},
+		"maintenance.html":             {Data: []byte("back soon {{.Host}}\n")},
+		"example.com/maintenance.html": {Data: []byte("example.com maintenance\n")},
+	})
+	assert.NoError(t, pages.CompileSync())
+	domains := &fake.Domains{Settings: &utils.DomainSettings{MaintenanceRetry: 60}}
+	h := MaintenanceHandler(domains, pages)
+
+	serve := func(host string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "https://"+host+"/", nil), http.NotFoundHandler())
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		return rec
+	}
+
+	// the maintenance page of the host is used before the global page
+	rec := serve("example.com")
+	assert.Equal(t, "example.com maintenance\n", rec.Body.String())
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+	rec = serve("example.org")
+	assert.Equal(t, "back soon example.org\n", rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+}
+
+func TestUpstreamErrorHandler(t *testing.T) {
+	pages := errorPages.New(fstest.MapFS{"upstream-tls.html": {Data: []byte("tls failure\n")}})
+	assert.NoError(t, pages.CompileSync())
+	h := UpstreamErrorHandler(pages)
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "https://example.com/", nil), target.UpstreamTls)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "tls failure\n", rec.Body.String())
+	assert.Equal(t, "backend tls failure", rec.Header().Get("X-Violet-Error"))
+
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "https://example.com/", nil), target.UpstreamTimeout)
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Contains(t, rec.Body.String(), "took too long to respond")
+}
+
+func TestSetupFaviconMiddleware(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:TestSetupFaviconMiddleware?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	fav := favicons.New(db, nil)
+	svg := `<svg width="10" height="10" xmlns="http://www.w3.org/2000/svg"><rect width="10" height="10" fill="red"/></svg>`
+	assert.NoError(t, fav.Put(favicons.FaviconRecord{Host: "example.com", Svg: favicons.DataUrl("image/svg+xml", []byte(svg))}))
+	assert.NoError(t, fav.CompileSync())
+	icons := fav.GetIcons("example.com")
+	hash := icons.Svg.Hash
+
+	h := setupFaviconMiddleware(fav, "", nil, http.NotFoundHandler())
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/favicon.svg", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, svg, rec.Body.String())
+	assert.Equal(t, "image/svg+xml", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `"`+hash+`"`, rec.Header().Get("ETag"))
+	assert.Equal(t, defaultFaviconCache, rec.Header().Get("Cache-Control"))
+
+	// unchanged icons are not sent again
+	req.Header.Set("If-None-Match", `"`+hash+`"`)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, 0, rec.Body.Len())
+
+	// versioned urls are immutable
+	h = setupFaviconMiddleware(fav, "no-cache", nil, http.NotFoundHandler())
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/favicon.png?v="+icons.Png.Hash[:8], nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, immutableFaviconCache, rec.Header().Get("Cache-Control"))
+	assert.Equal(t, "Accept", rec.Header().Get("Vary"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/favicon.ico?v=00000000", nil))
+	assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+
+	// apps under a path prefix use their own icons
+	app := `<svg width="10" height="10" xmlns="http://www.w3.org/2000/svg"/>`
+	assert.NoError(t, fav.Put(favicons.FaviconRecord{Host: "example.com", Path: "/app1", Svg: favicons.DataUrl("image/svg+xml", []byte(app))}))
+	assert.NoError(t, fav.CompileSync())
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/app1/favicon.svg", nil))
+	assert.Equal(t, app, rec.Body.String())
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/app2/favicon.svg", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// companion files are generated with a name or theme color
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/site.webmanifest", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.NoError(t, fav.Put(favicons.FaviconRecord{Host: "example.com", Svg: favicons.DataUrl("image/svg+xml", []byte(svg)), ThemeColor: "#ff0000"}))
+	assert.NoError(t, fav.CompileSync())
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/site.webmanifest", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/manifest+json", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `"theme_color":"#ff0000"`)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://example.com/browserconfig.xml", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<TileColor>#ff0000</TileColor>")
+}
@@ -0,0 +1,142 @@
+package servers
+
+import (
+	"bufio"
+	"errors"
+	errorPages "github.com/MrMelon54/violet/error-pages"
+	"github.com/MrMelon54/violet/utils"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// headerLimitSlack is added to the header limit of the server so requests
+// slightly over the limit reach the middleware and receive the error page
+const headerLimitSlack = 64 << 10
+
+// serverHeaderLimit returns the maximum header size of the server, the size is
+// lowered to the configured limit with some slack so the error page can be
+// used. The default is never raised.
+func serverHeaderLimit(def, limit int) int {
+	if limit > 0 && limit+headerLimitSlack < def {
+		return limit + headerLimitSlack
+	}
+	return def
+}
+
+// setupRequestLimits responds with the 413 page for request bodies larger than
+// maxBody and the 431 page for headers larger than maxHeader, zero disables
+// each limit. The limit is available to error page templates.
+func setupRequestLimits(maxBody int64, maxHeader int, pages *errorPages.ErrorPages, next http.Handler) http.Handler {
+	if maxBody <= 0 && maxHeader <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if maxHeader > 0 && headerSize(req) > maxHeader {
+			respondLimitError(pages, rw, req, http.StatusRequestHeaderFieldsTooLarge, "Request headers too large", int64(maxHeader))
+			return
+		}
+		if maxBody <= 0 || req.ContentLength == 0 {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		if req.ContentLength > maxBody {
+			respondLimitError(pages, rw, req, http.StatusRequestEntityTooLarge, "Request body too large", maxBody)
+			return
+		}
+
+		// bodies without a length are checked while the backend reads them
+		body := &limitedBody{ReadCloser: http.MaxBytesReader(rw, req.Body, maxBody)}
+		req.Body = body
+		next.ServeHTTP(&limitedResponse{rw: rw, req: req, body: body, pages: pages, limit: maxBody}, req)
+	})
+}
+
+// respondLimitError writes the error page for a request exceeding a limit with
+// the message in the X-Violet-Error header
+func respondLimitError(pages *errorPages.ErrorPages, rw http.ResponseWriter, req *http.Request, code int, msg string, limit int64) {
+	if pages == nil {
+		utils.RespondVioletError(rw, code, msg)
+		return
+	}
+	rw.Header().Set("X-Violet-Error", msg)
+	pages.ServeLimitError(rw, req, code, limit)
+}
+
+// headerSize returns the size of the request line and headers as sent by the
+// client
+func headerSize(req *http.Request) int {
+	n := len(req.Method) + len(req.RequestURI) + len(req.Proto) + 4
+	n += len("Host: ") + len(req.Host) + 2
+	for k, v := range req.Header {
+		for _, i := range v {
+			n += len(k) + len(i) + 4
+		}
+	}
+	return n
+}
+
+// limitedBody records when the request body is larger than the limit, the
+// body is read by the transport so the flag is atomic
+type limitedBody struct {
+	io.ReadCloser
+	exceeded atomic.Bool
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		l.exceeded.Store(true)
+	}
+	return n, err
+}
+
+// limitedResponse replaces the error response written after the request body
+// exceeded the limit with the 413 page
+type limitedResponse struct {
+	rw       http.ResponseWriter
+	req      *http.Request
+	body     *limitedBody
+	pages    *errorPages.ErrorPages
+	limit    int64
+	wrote    bool
+	replaced bool
+}
+
+func (l *limitedResponse) Header() http.Header { return l.rw.Header() }
+
+func (l *limitedResponse) WriteHeader(code int) {
+	if l.wrote {
+		return
+	}
+	l.wrote = true
+	if l.body.exceeded.Load() {
+		l.replaced = true
+		l.rw.Header().Del("Content-Length")
+		respondLimitError(l.pages, l.rw, l.req, http.StatusRequestEntityTooLarge, "Request body too large", l.limit)
+		return
+	}
+	l.rw.WriteHeader(code)
+}
+
+func (l *limitedResponse) Write(p []byte) (int, error) {
+	l.WriteHeader(http.StatusOK)
+	if l.replaced {
+		return len(p), nil
+	}
+	return l.rw.Write(p)
+}
+
+// Unwrap allows http.ResponseController to flush the response
+func (l *limitedResponse) Unwrap() http.ResponseWriter { return l.rw }
+
+// Hijack allows the route to close the connection when copying the response
+// fails
+func (l *limitedResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := l.rw.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
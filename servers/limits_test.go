@@ -0,0 +1,70 @@
+package servers
+
+import (
+	errorPages "github.com/MrMelon54/violet/error-pages"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSetupRequestLimits(t *testing.T) {
+	pages := errorPages.New(fstest.MapFS{
+		"413.html": {Data: []byte("body over {{.Limit}} bytes\n")},
+		"431.html": {Data: []byte("headers over {{.Limit}} bytes\n")},
+	})
+	assert.NoError(t, pages.CompileSync())
+	h := setupRequestLimits(16, 512, pages, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// the route responds with 502 when the body can't be sent
+		if _, err := io.ReadAll(req.Body); err != nil {
+			rw.Header().Set("X-Violet-Error", "backend connection failed")
+			rw.WriteHeader(http.StatusBadGateway)
+			_, _ = rw.Write([]byte("bad gateway"))
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("ok"))
+	}))
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// requests within the limits are passed to the next handler
+	rec := serve(httptest.NewRequest(http.MethodPost, "https://example.com/", strings.NewReader("hello")))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok", rec.Body.String())
+
+	// bodies with a length are rejected before the next handler
+	rec = serve(httptest.NewRequest(http.MethodPost, "https://example.com/", strings.NewReader(strings.Repeat("a", 17))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Equal(t, "body over 16 bytes\n", rec.Body.String())
+	assert.Equal(t, "Request body too large", rec.Header().Get("X-Violet-Error"))
+
+	// bodies without a length are rejected while being read
+	req := httptest.NewRequest(http.MethodPost, "https://example.com/", io.NopCloser(strings.NewReader(strings.Repeat("a", 32))))
+	req.ContentLength = -1
+	rec = serve(req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Equal(t, "body over 16 bytes\n", rec.Body.String())
+	assert.Equal(t, "Request body too large", rec.Header().Get("X-Violet-Error"))
+
+	req = httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	req.Header.Set("Cookie", strings.Repeat("a", 512))
+	rec = serve(req)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, rec.Code)
+	assert.Equal(t, "headers over 512 bytes\n", rec.Body.String())
+	assert.Equal(t, "Request headers too large", rec.Header().Get("X-Violet-Error"))
+}
+
+func TestServerHeaderLimit(t *testing.T) {
+	assert.Equal(t, 4096000, serverHeaderLimit(4096000, 0))
+	assert.Equal(t, 8192+headerLimitSlack, serverHeaderLimit(4096000, 8192))
+	assert.Equal(t, 2500, serverHeaderLimit(2500, 8192))
+	assert.Equal(t, 2500, serverHeaderLimit(2500, 1000))
+}
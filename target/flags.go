@@ -10,10 +10,11 @@ const (
 	FlagForwardHost
 	FlagForwardAddr
 	FlagIgnoreCert
+	FlagMaintenance // serve the maintenance page instead of proxying
 )
 
 var (
-	routeFlagMask    = FlagPre | FlagAbs | FlagCors | FlagSecureMode | FlagForwardHost | FlagForwardAddr | FlagIgnoreCert
+	routeFlagMask    = FlagPre | FlagAbs | FlagCors | FlagSecureMode | FlagForwardHost | FlagForwardAddr | FlagIgnoreCert | FlagMaintenance
 	redirectFlagMask = FlagPre | FlagAbs
 )
 
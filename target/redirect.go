@@ -19,8 +19,10 @@ type Redirect struct {
 }
 
 type RedirectWithActive struct {
+	Id int64 `json:"id"`
 	Redirect
-	Active bool `json:"active"`
+	Active  bool  `json:"active"`
+	Deleted int64 `json:"deleted,omitempty"` // unix time when moved to the recycle bin
 }
 
 func (r Route) HasFlag(flag Flags) bool {
@@ -41,11 +41,14 @@ type Route struct {
 	Flags   Flags                  `json:"flags"` // extra flags
 	Headers http.Header            `json:"-"`     // extra headers
 	Proxy   *proxy.HybridTransport `json:"-"`     // reverse proxy handler
+	OnError UpstreamErrorHandler   `json:"-"`     // writes the response when the backend fails, nil uses a plain response
 }
 
 type RouteWithActive struct {
+	Id int64 `json:"id"`
 	Route
-	Active bool `json:"active"`
+	Active  bool  `json:"active"`
+	Deleted int64 `json:"deleted,omitempty"` // unix time when moved to the recycle bin
 }
 
 // UpdateHeaders takes an existing set of headers and overwrites them with the
@@ -154,7 +157,12 @@ func (r Route) internalServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 	if err != nil {
 		log.Printf("[ServeRoute::ServeHTTP()] Error receiving internal round trip response: %s\n", err)
-		utils.RespondVioletError(rw, http.StatusBadGateway, "error receiving internal round trip response")
+		failure := ClassifyUpstreamError(err)
+		if r.OnError != nil {
+			r.OnError(rw, req, failure)
+			return
+		}
+		utils.RespondVioletError(rw, failure.Status(), failure.String())
 		return
 	}
 
@@ -0,0 +1,68 @@
+package target
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// UpstreamFailure is the reason the backend of a route failed to respond
+type UpstreamFailure int
+
+const (
+	UpstreamDown    UpstreamFailure = iota // connection refused, reset or other failures
+	UpstreamTimeout                        // backend did not respond in time
+	UpstreamTls                            // tls handshake or certificate failure
+)
+
+// Status returns the status code sent for the failure
+func (f UpstreamFailure) Status() int {
+	if f == UpstreamTimeout {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// String returns the message sent in the X-Violet-Error header
+func (f UpstreamFailure) String() string {
+	switch f {
+	case UpstreamTimeout:
+		return "backend timed out"
+	case UpstreamTls:
+		return "backend tls failure"
+	}
+	return "backend connection failed"
+}
+
+// UpstreamErrorHandler writes the response when the backend of a route fails
+// to respond
+type UpstreamErrorHandler func(rw http.ResponseWriter, req *http.Request, failure UpstreamFailure)
+
+// ClassifyUpstreamError returns the failure for an error from the round trip to
+// the backend, timeouts during the tls handshake are treated as timeouts
+func ClassifyUpstreamError(err error) UpstreamFailure {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return UpstreamTimeout
+	}
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		opErr        *net.OpError
+	)
+	if errors.As(err, &verifyErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return UpstreamTls
+	}
+	// alerts sent by the backend are wrapped in a "remote error" operation
+	if errors.As(err, &opErr) && opErr.Op == "remote error" {
+		return UpstreamTls
+	}
+	return UpstreamDown
+}
@@ -0,0 +1,66 @@
+package target
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/MrMelon54/violet/proxy"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+)
+
+type failingTransport struct{ err error }
+
+func (f failingTransport) RoundTrip(*http.Request) (*http.Response, error) { return nil, f.err }
+
+func TestClassifyUpstreamError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	assert.Equal(t, UpstreamDown, ClassifyUpstreamError(refused))
+	assert.Equal(t, UpstreamTimeout, ClassifyUpstreamError(fmt.Errorf("read: %w", context.DeadlineExceeded)))
+	assert.Equal(t, UpstreamTimeout, ClassifyUpstreamError(&net.DNSError{Err: "timeout", IsTimeout: true}))
+	assert.Equal(t, UpstreamTls, ClassifyUpstreamError(fmt.Errorf("dial: %w", x509.UnknownAuthorityError{})))
+	assert.Equal(t, UpstreamTls, ClassifyUpstreamError(fmt.Errorf("handshake: %w", tls.AlertError(40))))
+	assert.Equal(t, UpstreamTls, ClassifyUpstreamError(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}))
+
+	// only typed errors are tls failures
+	assert.Equal(t, UpstreamDown, ClassifyUpstreamError(errors.New("tls: handshake failure")))
+
+	// alerts sent by the backend
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+	_, err := srv.Client().Get(srv.URL)
+	assert.Equal(t, UpstreamTls, ClassifyUpstreamError(err))
+
+	assert.Equal(t, http.StatusBadGateway, UpstreamDown.Status())
+	assert.Equal(t, http.StatusGatewayTimeout, UpstreamTimeout.Status())
+	assert.Equal(t, http.StatusBadGateway, UpstreamTls.Status())
+}
+
+func TestRoute_ServeHTTP_UpstreamError(t *testing.T) {
+	ft := failingTransport{err: fmt.Errorf("read: %w", context.DeadlineExceeded)}
+	route := Route{Dst: "1.1.1.1:8080", Proxy: proxy.NewHybridTransportWithCalls(ft, ft)}
+
+	// a plain response is used without a handler
+	rec := httptest.NewRecorder()
+	route.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://www.example.com/test", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Equal(t, "backend timed out", rec.Header().Get("X-Violet-Error"))
+
+	var got UpstreamFailure = -1
+	route.OnError = func(rw http.ResponseWriter, req *http.Request, failure UpstreamFailure) {
+		got = failure
+		rw.WriteHeader(failure.Status())
+	}
+	rec = httptest.NewRecorder()
+	route.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://www.example.com/test", nil))
+	assert.Equal(t, UpstreamTimeout, got)
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+}
@@ -0,0 +1,102 @@
+package target
+
+import (
+	"fmt"
+	"github.com/MrMelon54/violet/utils"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// FieldError is a problem with a single field, Field is the JSON name and
+// Value is the offending value.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Value   any    `json:"value"`
+}
+
+// Validate returns the problems which stop the route from working, the route
+// is valid if the list is empty.
+func (r Route) Validate() []string {
+	return fieldMessages(r.ValidateFields())
+}
+
+// ValidateFields returns the problems which stop the route from working along
+// with the field causing each problem.
+func (r Route) ValidateFields() []FieldError {
+	errs := validateSource(r.Src)
+	host, _ := utils.SplitHostPath(r.Dst)
+	if host == "" {
+		errs = append(errs, FieldError{"dst", "missing destination host", r.Dst})
+	} else {
+		errs = append(errs, validateHost("dst", "destination", r.Dst, host)...)
+	}
+	if r.Flags != r.Flags.NormaliseRouteFlags() {
+		errs = append(errs, FieldError{"flags", fmt.Sprintf("unknown flags: %d", r.Flags&^routeFlagMask), r.Flags})
+	}
+	return errs
+}
+
+// Validate returns the problems which stop the redirect from working, the
+// redirect is valid if the list is empty.
+func (r Redirect) Validate() []string {
+	return fieldMessages(r.ValidateFields())
+}
+
+// ValidateFields returns the problems which stop the redirect from working
+// along with the field causing each problem.
+func (r Redirect) ValidateFields() []FieldError {
+	errs := validateSource(r.Src)
+	if r.Dst == "" {
+		errs = append(errs, FieldError{"dst", "missing destination", r.Dst})
+	} else if host, _ := utils.SplitHostPath(r.Dst); host != "" {
+		// an empty host redirects to a path on the same host
+		errs = append(errs, validateHost("dst", "destination", r.Dst, host)...)
+	}
+	if r.Flags != r.Flags.NormaliseRedirectFlags() {
+		errs = append(errs, FieldError{"flags", fmt.Sprintf("unknown flags: %d", r.Flags&^redirectFlagMask), r.Flags})
+	}
+	switch r.Code {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		errs = append(errs, FieldError{"code", fmt.Sprintf("invalid redirect code: %d", r.Code), r.Code})
+	}
+	return errs
+}
+
+// fieldMessages returns the message of each field error
+func fieldMessages(errs []FieldError) []string {
+	out := make([]string, len(errs))
+	for i, e := range errs {
+		out[i] = e.Message
+	}
+	return out
+}
+
+// validateSource returns the problems with the source of a route or redirect
+func validateSource(src string) []FieldError {
+	host, p := utils.SplitHostPath(src)
+	if host == "" {
+		return []FieldError{{"src", "missing source host", src}}
+	}
+	errs := validateHost("src", "source", src, strings.TrimPrefix(host, "*."))
+	if _, err := url.ParseRequestURI(p); err != nil {
+		errs = append(errs, FieldError{"src", "invalid source path", src})
+	}
+	return errs
+}
+
+// validateHost returns the problems with the host and optional port, value is
+// the full field value
+func validateHost(field, name, value, host string) []FieldError {
+	domain, port, ok := utils.SplitDomainPort(host, 0)
+	errs := make([]FieldError, 0)
+	if !ok || port < 0 || port > 65535 {
+		errs = append(errs, FieldError{field, "invalid " + name + " port", value})
+	}
+	if _, ok := utils.NormaliseDomain(domain); !ok {
+		errs = append(errs, FieldError{field, "invalid " + name + " host", value})
+	}
+	return errs
+}
@@ -0,0 +1,33 @@
+package target
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRoute_Validate(t *testing.T) {
+	assert.Empty(t, Route{Src: "example.com/", Dst: "127.0.0.1:8080/hello", Flags: FlagPre | FlagSecureMode}.Validate())
+	assert.Empty(t, Route{Src: "*.example.com", Dst: "localhost"}.Validate())
+	assert.Equal(t, []string{"missing source host", "missing destination host"}, Route{}.Validate())
+	assert.Equal(t, []string{"invalid source host", "invalid destination port", "unknown flags: 256"}, Route{Src: "exa mple.com/", Dst: "127.0.0.1:abc", Flags: 1 << 8}.Validate())
+	assert.Equal(t, []string{"invalid destination port"}, Route{Src: "example.com", Dst: "127.0.0.1:70000"}.Validate())
+}
+
+func TestRedirect_Validate(t *testing.T) {
+	assert.Empty(t, Redirect{Src: "example.com/", Dst: "www.example.com", Code: 308}.Validate())
+	assert.Empty(t, Redirect{Src: "example.com/old", Dst: "/new"}.Validate())
+	assert.Equal(t, []string{"missing destination", "unknown flags: 4", "invalid redirect code: 200"}, Redirect{Src: "example.com", Flags: FlagCors, Code: 200}.Validate())
+}
+
+func TestRoute_ValidateFields(t *testing.T) {
+	assert.Empty(t, Route{Src: "example.com/", Dst: "127.0.0.1:8080"}.ValidateFields())
+	assert.Equal(t, []FieldError{
+		{Field: "src", Message: "invalid source host", Value: "exa mple.com/"},
+		{Field: "dst", Message: "invalid destination port", Value: "127.0.0.1:abc"},
+		{Field: "flags", Message: "unknown flags: 256", Value: Flags(1 << 8)},
+	}, Route{Src: "exa mple.com/", Dst: "127.0.0.1:abc", Flags: 1 << 8}.ValidateFields())
+	assert.Equal(t, []FieldError{
+		{Field: "dst", Message: "missing destination", Value: ""},
+		{Field: "code", Message: "invalid redirect code: 200", Value: 200},
+	}, Redirect{Src: "example.com", Code: 200}.ValidateFields())
+}
@@ -1,21 +1,45 @@
 package utils
 
-import "sync"
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// AcmeChallengeTtl is how long a challenge is kept before it is treated as
+// stale and removed, issuance flows finish well within this time
+const AcmeChallengeTtl = time.Hour
 
 type AcmeChallenges struct {
-	s *sync.RWMutex
-	d map[string]*AcmeStorage
+	s   *sync.RWMutex
+	d   map[string]*AcmeStorage
+	now func() time.Time
 }
 
 type AcmeStorage struct {
 	s *sync.RWMutex
-	v map[string]string
+	v map[string]acmeValue
+}
+
+// acmeValue is a stored challenge value and the time it was added
+type acmeValue struct {
+	value string
+	added time.Time
+}
+
+// AcmeChallengeEntry is a stored challenge returned by List
+type AcmeChallengeEntry struct {
+	Key   string    `json:"key"`
+	Value string    `json:"value"`
+	Added time.Time `json:"added"`
+	Age   int64     `json:"age"` // seconds since the challenge was added
 }
 
 func NewAcmeChallenge() *AcmeChallenges {
 	return &AcmeChallenges{
-		s: &sync.RWMutex{},
-		d: make(map[string]*AcmeStorage),
+		s:   &sync.RWMutex{},
+		d:   make(map[string]*AcmeStorage),
+		now: time.Now,
 	}
 }
 
@@ -25,23 +49,51 @@ func (a *AcmeChallenges) Get(domain, key string) string {
 	if m := a.d[domain]; m != nil {
 		m.s.RLock()
 		defer m.s.RUnlock()
-		return m.v[key]
+		if v, ok := m.v[key]; ok && a.now().Sub(v.added) < AcmeChallengeTtl {
+			return v.value
+		}
 	}
 	return ""
 }
 
+// List returns the challenges stored for the domain ordered by key, stale
+// challenges are not included
+func (a *AcmeChallenges) List(domain string) []AcmeChallengeEntry {
+	a.s.RLock()
+	defer a.s.RUnlock()
+	list := make([]AcmeChallengeEntry, 0)
+	m := a.d[domain]
+	if m == nil {
+		return list
+	}
+	now := a.now()
+	m.s.RLock()
+	for k, v := range m.v {
+		age := now.Sub(v.added)
+		if age >= AcmeChallengeTtl {
+			continue
+		}
+		list = append(list, AcmeChallengeEntry{Key: k, Value: v.value, Added: v.added, Age: int64(age.Seconds())})
+	}
+	m.s.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
 func (a *AcmeChallenges) Put(domain, key, value string) {
 	a.s.Lock()
+	now := a.now()
+	a.expire(now)
 	m := a.d[domain]
 	if m == nil {
 		m = &AcmeStorage{
 			s: &sync.RWMutex{},
-			v: make(map[string]string),
+			v: make(map[string]acmeValue),
 		}
 		a.d[domain] = m
 	}
 	m.s.Lock()
-	m.v[key] = value
+	m.v[key] = acmeValue{value, now}
 	m.s.Unlock()
 	a.s.Unlock()
 }
@@ -50,6 +102,27 @@ func (a *AcmeChallenges) Delete(domain, key string) {
 	a.s.Lock()
 	if m := a.d[domain]; m != nil {
 		delete(m.v, key)
+		if len(m.v) == 0 {
+			delete(a.d, domain)
+		}
 	}
 	a.s.Unlock()
 }
+
+// expire removes stale challenges and empty domains, the write lock must be
+// held by the caller
+func (a *AcmeChallenges) expire(now time.Time) {
+	for domain, m := range a.d {
+		m.s.Lock()
+		for k, v := range m.v {
+			if now.Sub(v.added) >= AcmeChallengeTtl {
+				delete(m.v, k)
+			}
+		}
+		empty := len(m.v) == 0
+		m.s.Unlock()
+		if empty {
+			delete(a.d, domain)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestAcmeChallenges(t *testing.T) {
@@ -25,3 +26,29 @@ func TestAcmeChallenges(t *testing.T) {
 	a.Delete("www.example.com", "123")
 	assert.Equal(t, "", a.Get("example.com", "123"))
 }
+
+func TestAcmeChallenges_Expiry(t *testing.T) {
+	a := NewAcmeChallenge()
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	a.Put("example.com", "123", "123abc")
+	now = now.Add(time.Minute)
+	a.Put("example.com", "456", "456def")
+	assert.Equal(t, []AcmeChallengeEntry{
+		{Key: "123", Value: "123abc", Added: now.Add(-time.Minute), Age: 60},
+		{Key: "456", Value: "456def", Added: now, Age: 0},
+	}, a.List("example.com"))
+
+	// stale challenges are hidden then removed on the next change
+	now = now.Add(AcmeChallengeTtl - 30*time.Second)
+	assert.Equal(t, "", a.Get("example.com", "123"))
+	assert.Equal(t, "456def", a.Get("example.com", "456"))
+	assert.Len(t, a.List("example.com"), 1)
+	a.Put("example.org", "789", "789ghi")
+	assert.Len(t, a.d["example.com"].v, 1)
+
+	now = now.Add(time.Minute)
+	a.Put("example.org", "789", "789ghi")
+	assert.Nil(t, a.d["example.com"])
+	assert.Equal(t, []AcmeChallengeEntry{}, a.List("example.com"))
+}
@@ -5,6 +5,13 @@ type Compilable interface {
 	Compile()
 }
 
+// SyncCompilable is a Compilable which can also compile synchronously and
+// return the error from the compile.
+type SyncCompilable interface {
+	Compilable
+	CompileSync() error
+}
+
 // MultiCompilable is a slice of multiple Compilable interfaces.
 type MultiCompilable []Compilable
 
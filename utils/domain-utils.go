@@ -1,10 +1,36 @@
 package utils
 
 import (
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
 	"strconv"
 	"strings"
 )
 
+// idnaProfile converts domains to punycode, underscores and wildcards are
+// allowed as they are used in service names and wildcard routes
+var idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+// NormaliseDomain converts the domain to the lowercase punycode form used when
+// storing and comparing domains, false is returned if the domain is invalid.
+//
+// Example.COM => example.com
+// bücher.example => xn--bcher-kva.example
+func NormaliseDomain(domain string) (string, bool) {
+	a, err := idnaProfile.ToASCII(domain)
+	if err != nil || a == "" {
+		return "", false
+	}
+	for _, r := range a {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.', r == '_', r == '*':
+		default:
+			return "", false
+		}
+	}
+	return a, true
+}
+
 // SplitDomainPort takes an input host and default port then outputs the domain,
 // port and true or empty values and false if the split failed
 func SplitDomainPort(host string, defaultPort int) (domain string, port int, ok bool) {
@@ -37,15 +63,20 @@ func GetDomainWithoutPort(domain string) string {
 }
 
 // ReplaceSubdomainWithWildcard returns the domain with the subdomain replaced
-// with a wildcard '*' character.
+// with a wildcard '*' character, wildcards directly under a public suffix are
+// not allowed.
 //
 // www.example.com => *.example.com
+// example.co.uk => false
 func ReplaceSubdomainWithWildcard(domain string) (string, bool) {
 	// if a valid index isn't found then return false
 	n := strings.IndexByte(domain, '.')
 	if n == -1 {
 		return "", false
 	}
+	if isPublicSuffix(GetDomainWithoutPort(domain[n+1:])) {
+		return "", false
+	}
 	return "*" + domain[n:], true
 }
 
@@ -61,27 +92,27 @@ func GetParentDomain(domain string) (string, bool) {
 	return domain[n+1:], true
 }
 
-// GetTopFqdn returns the top domain stripping off multiple layers of subdomains.
+// GetTopFqdn returns the registrable domain stripping off multiple layers of
+// subdomains, the public suffix list is used to find the registrable domain.
 //
 // hello.world.example.com => example.com
+// hello.world.example.co.uk => example.co.uk
 func GetTopFqdn(domain string) (string, bool) {
-	var countDot int
-	n := strings.LastIndexFunc(domain, func(r rune) bool {
-		// return true if this is the second '.'
-		// otherwise counts one and continues
-		if r == '.' {
-			if countDot == 1 {
-				return true
-			}
-			countDot++
-		}
-		return false
-	})
-	// if a valid index isn't found then return false
-	if n == -1 {
+	fqdn, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
 		return "", false
 	}
-	return domain[n+1:], true
+	return fqdn, true
+}
+
+// isPublicSuffix returns true if the domain is a public suffix
+//
+// com => true
+// co.uk => true
+// example.com => false
+func isPublicSuffix(domain string) bool {
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	return suffix == domain
 }
 
 // SplitHostPath extracts the host/path from the input
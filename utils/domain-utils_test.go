@@ -39,6 +39,16 @@ func TestReplaceSubdomainWithWildcard(t *testing.T) {
 	domain, ok = ReplaceSubdomainWithWildcard("www.example.com:5612")
 	assert.True(t, ok, "Output should be true")
 	assert.Equal(t, "*.example.com:5612", domain)
+
+	domain, ok = ReplaceSubdomainWithWildcard("www.example.co.uk")
+	assert.True(t, ok, "Output should be true")
+	assert.Equal(t, "*.example.co.uk", domain)
+
+	_, ok = ReplaceSubdomainWithWildcard("example.co.uk")
+	assert.False(t, ok, "Output should be false")
+
+	_, ok = ReplaceSubdomainWithWildcard("example.com")
+	assert.False(t, ok, "Output should be false")
 }
 
 func TestGetBaseDomain(t *testing.T) {
@@ -59,6 +69,17 @@ func TestGetTopFqdn(t *testing.T) {
 	domain, ok = GetTopFqdn("www.www.example.com")
 	assert.True(t, ok, "Output should be true")
 	assert.Equal(t, "example.com", domain)
+
+	domain, ok = GetTopFqdn("example.com")
+	assert.True(t, ok, "Output should be true")
+	assert.Equal(t, "example.com", domain)
+
+	domain, ok = GetTopFqdn("www.example.co.uk")
+	assert.True(t, ok, "Output should be true")
+	assert.Equal(t, "example.co.uk", domain)
+
+	_, ok = GetTopFqdn("co.uk")
+	assert.False(t, ok, "Output should be false")
 }
 
 func TestSplitHostPath(t *testing.T) {
@@ -97,3 +118,22 @@ func TestSplitHostPathQuery(t *testing.T) {
 	assert.Equal(t, "/", p)
 	assert.Equal(t, "a=b", q)
 }
+
+func TestNormaliseDomain(t *testing.T) {
+	for in, out := range map[string]string{
+		"Example.COM":           "example.com",
+		"bücher.example":        "xn--bcher-kva.example",
+		"xn--bcher-kva.example": "xn--bcher-kva.example",
+		"*.Example.com":         "*.example.com",
+		"_acme.example.com":     "_acme.example.com",
+	} {
+		domain, ok := NormaliseDomain(in)
+		assert.True(t, ok, "Output should be true")
+		assert.Equal(t, out, domain)
+	}
+
+	for _, in := range []string{"", "exa mple.com", "example.com/hello", "example.com:443"} {
+		_, ok := NormaliseDomain(in)
+		assert.False(t, ok, "Output should be false for %q", in)
+	}
+}
@@ -2,10 +2,17 @@ package fake
 
 import "github.com/MrMelon54/violet/utils"
 
-// Compilable implements utils.Compilable and stores if the Compile function
-// is called.
-type Compilable struct{ Done bool }
+// Compilable implements utils.SyncCompilable and stores if the Compile function
+// is called, Err is returned from CompileSync.
+type Compilable struct {
+	Done bool
+	Err  error
+}
 
 func (f *Compilable) Compile() { f.Done = true }
+func (f *Compilable) CompileSync() error {
+	f.Done = true
+	return f.Err
+}
 
-var _ utils.Compilable = &Compilable{}
+var _ utils.SyncCompilable = &Compilable{}
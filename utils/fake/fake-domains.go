@@ -1,13 +1,75 @@
 package fake
 
-import "github.com/MrMelon54/violet/utils"
+import (
+	"github.com/MrMelon54/violet/utils"
+	"io/fs"
+)
 
 // Domains implements DomainProvider and makes sure `example.com` is valid
-type Domains struct{}
+type Domains struct {
+	Settings *utils.DomainSettings // settings for `example.com`, nil uses the defaults
+	Owner    string                // owner of `example.com`
+	Imported []utils.DomainRecord  // records passed to Import
+
+	BatchPut    []string // domains added using Batch
+	BatchDelete []string // domains disabled using Batch
+}
 
 func (f *Domains) IsValid(host string) bool { return host == "example.com" }
 func (f *Domains) Put(string, bool)         {}
-func (f *Domains) Delete(string)            {}
-func (f *Domains) Compile()                 {}
+func (f *Domains) Delete(string, string)    {}
+func (f *Domains) Purge(domain, _ string) error {
+	if domain != "example.com" {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+func (f *Domains) Audit(string) ([]utils.DomainAudit, error) { return []utils.DomainAudit{}, nil }
+func (f *Domains) List(string, int, int) ([]utils.DomainEntry, int, error) {
+	return []utils.DomainEntry{{Domain: "example.com", Active: true}}, 1, nil
+}
+func (f *Domains) GetSettings(host string) (utils.DomainSettings, bool) {
+	if host != "example.com" {
+		return utils.DomainSettings{}, false
+	}
+	if f.Settings == nil {
+		return utils.DomainSettings{ForceHttps: true, WildcardDepth: 1}, true
+	}
+	return *f.Settings, true
+}
+func (f *Domains) LoadSettings(domain string) (utils.DomainSettings, error) {
+	if s, ok := f.GetSettings(domain); ok {
+		return s, nil
+	}
+	return utils.DomainSettings{}, fs.ErrNotExist
+}
+func (f *Domains) PutSettings(domain string, settings utils.DomainSettings) error {
+	if domain != "example.com" {
+		return fs.ErrNotExist
+	}
+	f.Settings = &settings
+	return nil
+}
+func (f *Domains) GetChallenge(string) (*utils.DomainChallenge, error) { return nil, nil }
+func (f *Domains) Verify(string) error                                 { return nil }
+func (f *Domains) SetOwner(_, owner string) error {
+	f.Owner = owner
+	return nil
+}
+func (f *Domains) GetOwner(string) (string, error) { return f.Owner, nil }
+func (f *Domains) Export() ([]utils.DomainRecord, error) {
+	settings, _ := f.GetSettings("example.com")
+	return []utils.DomainRecord{{DomainEntry: utils.DomainEntry{Domain: "example.com", Active: true, Owner: f.Owner}, DomainSettings: settings}}, nil
+}
+func (f *Domains) Import(records []utils.DomainRecord) error {
+	f.Imported = append(f.Imported, records...)
+	return nil
+}
+func (f *Domains) Batch(put, del []string, _ string) error {
+	f.BatchPut = append(f.BatchPut, put...)
+	f.BatchDelete = append(f.BatchDelete, del...)
+	return nil
+}
+func (f *Domains) Compile() {}
 
 var _ utils.DomainProvider = &Domains{}
@@ -19,10 +19,16 @@ func GenSnakeOilProv() mjwt.Signer {
 	return mjwt.NewMJwtSigner("violet.test", key)
 }
 
-func GenSnakeOilKey(perm string) string {
+func GenSnakeOilKey(perms ...string) string {
+	return GenSnakeOilKeyFor("abc", perms...)
+}
+
+func GenSnakeOilKeyFor(subject string, perms ...string) string {
 	p := claims.NewPermStorage()
-	p.Set(perm)
-	val, err := SnakeOilProv.GenerateJwt("abc", "abc", nil, 5*time.Minute, auth.AccessTokenClaims{Perms: p})
+	for _, i := range perms {
+		p.Set(i)
+	}
+	val, err := SnakeOilProv.GenerateJwt(subject, "abc", nil, 5*time.Minute, auth.AccessTokenClaims{Perms: p})
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HostStatsWindow is how long requests are included in the host statistics
+	HostStatsWindow = time.Hour
+	// hostStatsSamples is the number of recent latencies kept for each host
+	hostStatsSamples = 1024
+)
+
+// HostStatsSummary is the recent traffic of a host
+type HostStatsSummary struct {
+	Host     string            `json:"host"`
+	Window   int64             `json:"window"` // seconds of traffic included
+	Requests uint64            `json:"requests"`
+	Status   map[string]uint64 `json:"status"` // requests for each status class, 1xx to 5xx
+	P50      float64           `json:"p50_ms"`
+	P95      float64           `json:"p95_ms"`
+}
+
+// hostStatusClasses are the names of the status classes in HostStatsSummary
+var hostStatusClasses = [5]string{"1xx", "2xx", "3xx", "4xx", "5xx"}
+
+// hostStatsMinute is the number of requests for each status class in a minute
+type hostStatsMinute struct {
+	minute  int64
+	classes [5]uint64
+}
+
+type hostLatency struct {
+	at time.Time
+	d  time.Duration
+}
+
+type hostStats struct {
+	minutes [60]hostStatsMinute
+	samples []hostLatency
+	next    int
+}
+
+// HostStats records the status codes and latencies of proxied requests for
+// each host
+type HostStats struct {
+	s     *sync.Mutex
+	hosts map[string]*hostStats
+	now   func() time.Time
+}
+
+func NewHostStats() *HostStats {
+	return &HostStats{s: &sync.Mutex{}, hosts: make(map[string]*hostStats), now: time.Now}
+}
+
+// Record adds a request to the statistics of the host, nothing is recorded if
+// h is nil
+func (h *HostStats) Record(host string, code int, d time.Duration) {
+	if h == nil {
+		return
+	}
+	class := code/100 - 1
+	if class < 0 || class > 4 {
+		return
+	}
+	host = strings.ToLower(GetDomainWithoutPort(host))
+	now := h.now()
+	minute := now.Unix() / 60
+
+	h.s.Lock()
+	defer h.s.Unlock()
+	s, ok := h.hosts[host]
+	if !ok {
+		s = &hostStats{samples: make([]hostLatency, 0, hostStatsSamples)}
+		h.hosts[host] = s
+	}
+	m := &s.minutes[minute%int64(len(s.minutes))]
+	if m.minute != minute {
+		*m = hostStatsMinute{minute: minute}
+	}
+	m.classes[class]++
+
+	// replace the oldest latency once full
+	if len(s.samples) < hostStatsSamples {
+		s.samples = append(s.samples, hostLatency{now, d})
+	} else {
+		s.samples[s.next] = hostLatency{now, d}
+		s.next = (s.next + 1) % hostStatsSamples
+	}
+}
+
+// Get returns the traffic of the host within HostStatsWindow, the latency
+// percentiles use the most recent requests
+func (h *HostStats) Get(host string) HostStatsSummary {
+	host = strings.ToLower(GetDomainWithoutPort(host))
+	out := HostStatsSummary{
+		Host:   host,
+		Window: int64(HostStatsWindow / time.Second),
+		Status: make(map[string]uint64, len(hostStatusClasses)),
+	}
+	for _, i := range hostStatusClasses {
+		out.Status[i] = 0
+	}
+	now := h.now()
+	minute := now.Unix() / 60
+
+	h.s.Lock()
+	s, ok := h.hosts[host]
+	if !ok {
+		h.s.Unlock()
+		return out
+	}
+	for _, m := range s.minutes {
+		if minute-m.minute >= int64(len(s.minutes)) {
+			continue
+		}
+		for i, n := range m.classes {
+			out.Status[hostStatusClasses[i]] += n
+			out.Requests += n
+		}
+	}
+	latencies := make([]time.Duration, 0, len(s.samples))
+	for _, i := range s.samples {
+		if now.Sub(i.at) < HostStatsWindow {
+			latencies = append(latencies, i.d)
+		}
+	}
+	h.s.Unlock()
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		out.P50 = percentileMs(latencies, 0.50)
+		out.P95 = percentileMs(latencies, 0.95)
+	}
+	return out
+}
+
+// percentileMs returns the nearest-rank percentile of the sorted durations in
+// milliseconds
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	i := int(float64(len(sorted))*p+0.999999) - 1
+	if i < 0 {
+		i = 0
+	}
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// Middleware records the status and latency of each request, requests for
+// hosts rejected by valid aren't recorded so unknown hosts can't fill memory
+func (h *HostStats) Middleware(valid func(host string) bool, next http.Handler) http.Handler {
+	if h == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !valid(req.Host) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		start := time.Now()
+		sw := &hostStatsWriter{ResponseWriter: rw}
+		next.ServeHTTP(sw, req)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		h.Record(req.Host, sw.status, time.Since(start))
+	})
+}
+
+// hostStatsWriter captures the status code while still supporting flushing
+// and protocol upgrades
+type hostStatsWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *hostStatsWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *hostStatsWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *hostStatsWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *hostStatsWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		if w.status == 0 {
+			w.status = http.StatusSwitchingProtocols
+		}
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijacking is not supported")
+}
+
+func (w *hostStatsWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
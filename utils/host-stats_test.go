@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHostStats_Get(t *testing.T) {
+	now := time.Date(2023, time.July, 1, 12, 0, 0, 0, time.UTC)
+	h := NewHostStats()
+	h.now = func() time.Time { return now.Add(-2 * time.Hour) }
+	h.Record("example.com", http.StatusOK, time.Second)
+
+	h.now = func() time.Time { return now }
+	h.Record("example.com", http.StatusOK, 10*time.Millisecond)
+	for i := 1; i <= 18; i++ {
+		h.Record("EXAMPLE.com:443", http.StatusOK, time.Duration(i)*time.Millisecond)
+	}
+	h.Record("example.com", http.StatusNotFound, 50*time.Millisecond)
+	h.Record("example.com", http.StatusBadGateway, 100*time.Millisecond)
+	h.Record("example.com", 0, time.Millisecond)
+
+	assert.Equal(t, HostStatsSummary{
+		Host:     "example.com",
+		Window:   3600,
+		Requests: 21,
+		Status:   map[string]uint64{"1xx": 0, "2xx": 19, "3xx": 0, "4xx": 1, "5xx": 1},
+		P50:      10,
+		P95:      50,
+	}, h.Get("example.com"))
+
+	// requests older than the window are dropped
+	h.now = func() time.Time { return now.Add(time.Hour) }
+	assert.Equal(t, uint64(0), h.Get("example.com").Requests)
+	assert.Equal(t, float64(0), h.Get("example.com").P95)
+	assert.Equal(t, uint64(0), h.Get("example.org").Requests)
+}
+
+func TestHostStats_Middleware(t *testing.T) {
+	h := NewHostStats()
+	srv := h.Middleware(func(host string) bool { return host == "example.com" }, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte("ok"))
+	}))
+	for _, i := range []string{"https://example.com/", "https://example.com/missing", "https://example.org/"} {
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, i, nil))
+	}
+
+	s := h.Get("example.com")
+	assert.Equal(t, uint64(2), s.Requests)
+	assert.Equal(t, uint64(1), s.Status["2xx"])
+	assert.Equal(t, uint64(1), s.Status["4xx"])
+	assert.Equal(t, uint64(0), h.Get("example.org").Requests)
+
+	var nilStats *HostStats
+	nilStats.Record("example.com", http.StatusOK, time.Second)
+}
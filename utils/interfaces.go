@@ -1,21 +1,92 @@
 package utils
 
-import "crypto/tls"
+import (
+	"crypto/tls"
+	"time"
+)
 
 type DomainProvider interface {
 	IsValid(host string) bool
 	Put(domain string, active bool)
-	Delete(domain string)
+	Delete(domain, actor string)
+	Purge(domain, actor string) error
+	Audit(domain string) ([]DomainAudit, error)
+	List(search string, offset, limit int) ([]DomainEntry, int, error)
+	GetSettings(host string) (DomainSettings, bool)
+	LoadSettings(domain string) (DomainSettings, error)
+	PutSettings(domain string, settings DomainSettings) error
+	GetChallenge(domain string) (*DomainChallenge, error)
+	Verify(domain string) error
+	SetOwner(domain, owner string) error
+	GetOwner(host string) (string, error)
+	Export() ([]DomainRecord, error)
+	Import(records []DomainRecord) error
+	Batch(put, del []string, actor string) error
 	Compile()
 }
 
+// DomainChallenge is the DNS record required to verify ownership of a domain
+type DomainChallenge struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// DomainSettings are the per-domain options
+type DomainSettings struct {
+	ForceHttps      bool   `json:"force_https"`      // redirect http requests to https
+	Hsts            string `json:"hsts"`             // Strict-Transport-Security header value, empty to disable
+	DefaultBackend  string `json:"default_backend"`  // destination used when no route matches
+	WildcardDepth   int    `json:"wildcard_depth"`   // subdomain levels matched by a wildcard
+	RateLimit       uint64 `json:"rate_limit"`       // requests per minute, zero uses the global rate limit
+	Expires         int64  `json:"expires"`          // unix time when the domain is deactivated, zero never expires
+	Maintenance     bool   `json:"maintenance"`      // respond with 503 to every request
+	MaintenancePage string `json:"maintenance_page"` // html output during maintenance, empty uses the maintenance error page
+
+	// seconds sent in the Retry-After header during maintenance, zero omits
+	// the header
+	MaintenanceRetry uint64 `json:"maintenance_retry_after"`
+
+	// comma separated IP addresses and CIDR ranges which bypass maintenance
+	MaintenanceAllow string `json:"maintenance_allow"`
+}
+
+// DomainEntry is a single row from the domain list
+type DomainEntry struct {
+	Domain string `json:"domain"`
+	Active bool   `json:"active"`
+	Owner  string `json:"owner,omitempty"` // subject of the token which registered the domain
+}
+
+// DomainAudit is an action performed on a domain
+type DomainAudit struct {
+	Domain string    `json:"domain"`
+	Action string    `json:"action"` // disable, expire or purge
+	Actor  string    `json:"actor"`  // subject of the token which performed the action
+	Time   time.Time `json:"time"`
+}
+
+// DomainRecord is a domain with its settings used for bulk import and export
+type DomainRecord struct {
+	DomainEntry
+	DomainSettings
+}
+
 type AcmeChallengeProvider interface {
 	Get(domain, key string) string
+	List(domain string) []AcmeChallengeEntry
 	Put(domain, key, value string)
 	Delete(domain, key string)
 }
 
 type CertProvider interface {
 	GetCertForDomain(domain string) *tls.Certificate
+	GetCertForHello(info *tls.ClientHelloInfo) *tls.Certificate
+	GetDefaultCert() *tls.Certificate
+	GetAllCerts() []*tls.Certificate
+	GetCertProblems(cert *tls.Certificate) []string
+	PutCert(domain string, certPem, keyPem []byte) error
+	DeleteCert(domain string) error
+	ReloadCert(domain string) error
 	Compile()
 }
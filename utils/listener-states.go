@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	ListenerListening = "listening"
+	ListenerClosed    = "closed"
+	ListenerFailed    = "failed"
+)
+
+// ListenerState is the state of a http server started in the background
+type ListenerState struct {
+	Name  string `json:"name"`
+	Addr  string `json:"addr"`
+	State string `json:"state"` // listening, closed or failed
+}
+
+type listenerKey struct {
+	name string
+	addr string
+}
+
+// ListenerStates records the state of each http server started using
+// RunBackgroundHttp, RunBackgroundHttps or RunBackgroundUnix
+type ListenerStates struct {
+	s      *sync.RWMutex
+	states map[listenerKey]string
+}
+
+func NewListenerStates() *ListenerStates {
+	return &ListenerStates{s: &sync.RWMutex{}, states: make(map[listenerKey]string)}
+}
+
+// set records the state of the listener, nothing is recorded if l is nil
+func (l *ListenerStates) set(name, addr, state string) {
+	if l == nil {
+		return
+	}
+	l.s.Lock()
+	l.states[listenerKey{name, addr}] = state
+	l.s.Unlock()
+}
+
+// List returns the state of each listener sorted by name and address
+func (l *ListenerStates) List() []ListenerState {
+	l.s.RLock()
+	list := make([]ListenerState, 0, len(l.states))
+	for k, v := range l.states {
+		list = append(list, ListenerState{Name: k.name, Addr: k.addr, State: v})
+	}
+	l.s.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name == list[j].Name {
+			return list[i].Addr < list[j].Addr
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -2,7 +2,10 @@ package utils
 
 import (
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
 	"strings"
 )
 
@@ -18,16 +21,51 @@ func logHttpServerError(prefix string, err error) {
 	}
 }
 
+// serveBackground listens on the address and serves the http server, the
+// state of the listener is recorded if states is not nil.
+func serveBackground(prefix string, s *http.Server, states *ListenerStates, network, addr string, serve func(l net.Listener) error) {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		states.set(prefix, addr, ListenerFailed)
+		logHttpServerError(prefix, err)
+		return
+	}
+	states.set(prefix, addr, ListenerListening)
+	err = serve(l)
+	if err == nil || err == http.ErrServerClosed {
+		states.set(prefix, addr, ListenerClosed)
+	} else {
+		states.set(prefix, addr, ListenerFailed)
+	}
+	logHttpServerError(prefix, err)
+}
+
 // RunBackgroundHttp runs a http server and logs when the server closes or
 // errors.
-func RunBackgroundHttp(prefix string, s *http.Server) {
-	logHttpServerError(prefix, s.ListenAndServe())
+func RunBackgroundHttp(prefix string, s *http.Server, states *ListenerStates) {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	serveBackground(prefix, s, states, "tcp", addr, s.Serve)
 }
 
 // RunBackgroundHttps runs a http server with TLS encryption and logs when the
 // server closes or errors.
-func RunBackgroundHttps(prefix string, s *http.Server) {
-	logHttpServerError(prefix, s.ListenAndServeTLS("", ""))
+func RunBackgroundHttps(prefix string, s *http.Server, states *ListenerStates) {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	serveBackground(prefix, s, states, "tcp", addr, func(l net.Listener) error {
+		return s.ServeTLS(l, "", "")
+	})
+}
+
+// RunBackgroundServe listens on the tcp address and runs serve, this is used
+// for servers other than http.Server which return nil once stopped.
+func RunBackgroundServe(prefix, addr string, serve func(l net.Listener) error, states *ListenerStates) {
+	serveBackground(prefix, nil, states, "tcp", addr, serve)
 }
 
 // GetBearer returns the bearer from the Authorization header or an empty string
@@ -39,3 +77,68 @@ func GetBearer(req *http.Request) string {
 	}
 	return ""
 }
+
+// RunBackgroundUnix runs a http server on a unix socket and logs when the
+// server closes or errors. A stale socket file at the path is removed first.
+func RunBackgroundUnix(prefix string, s *http.Server, path string, states *ListenerStates) {
+	if stat, err := os.Lstat(path); err == nil && stat.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	serveBackground(prefix, s, states, "unix", path, s.Serve)
+}
+
+// parseAddrList parses the comma separated IP addresses and CIDR ranges, ok
+// is false if any entry is invalid
+func parseAddrList(list string) (prefixes []netip.Prefix, ok bool) {
+	for _, i := range strings.Split(list, ",") {
+		i = strings.TrimSpace(i)
+		if i == "" {
+			continue
+		}
+		if strings.Contains(i, "/") {
+			p, err := netip.ParsePrefix(i)
+			if err != nil {
+				return nil, false
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(i)
+		if err != nil {
+			return nil, false
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(a, a.BitLen()))
+	}
+	return prefixes, true
+}
+
+// ValidAddrList returns true if the comma separated list only contains IP
+// addresses and CIDR ranges
+func ValidAddrList(list string) bool {
+	_, ok := parseAddrList(list)
+	return ok
+}
+
+// AddrInList returns true if the IP of the remote address is in the comma
+// separated list of IP addresses and CIDR ranges
+func AddrInList(list, remoteAddr string) bool {
+	if list == "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	a, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	prefixes, _ := parseAddrList(list)
+	for _, p := range prefixes {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestGetBearer(t *testing.T) {
@@ -18,3 +19,42 @@ func TestGetBearer_Empty(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "", GetBearer(req))
 }
+
+func TestRunBackgroundHttp(t *testing.T) {
+	states := NewListenerStates()
+	s := &http.Server{Addr: "127.0.0.1:0"}
+	done := make(chan struct{})
+	go func() {
+		RunBackgroundHttp("HTTP", s, states)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		return len(states.List()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []ListenerState{{Name: "HTTP", Addr: "127.0.0.1:0", State: ListenerListening}}, states.List())
+
+	assert.NoError(t, s.Close())
+	<-done
+	assert.Equal(t, []ListenerState{{Name: "HTTP", Addr: "127.0.0.1:0", State: ListenerClosed}}, states.List())
+
+	// the address is invalid
+	RunBackgroundHttp("HTTPS", &http.Server{Addr: "127.0.0.1:-1"}, states)
+	assert.Equal(t, ListenerFailed, states.List()[1].State)
+}
+
+func TestAddrInList(t *testing.T) {
+	assert.True(t, ValidAddrList(""))
+	assert.True(t, ValidAddrList("192.0.2.1, 10.0.0.0/8,2001:db8::/32"))
+	assert.False(t, ValidAddrList("192.0.2.1,example.com"))
+	assert.False(t, ValidAddrList("10.0.0.0/33"))
+
+	list := "192.0.2.1, 10.0.0.0/8,2001:db8::/32"
+	assert.True(t, AddrInList(list, "192.0.2.1:1234"))
+	assert.True(t, AddrInList(list, "10.1.2.3:80"))
+	assert.True(t, AddrInList(list, "[::ffff:10.1.2.3]:80"))
+	assert.True(t, AddrInList(list, "[2001:db8::1]:443"))
+	assert.True(t, AddrInList(list, "192.0.2.1"))
+	assert.False(t, AddrInList(list, "192.0.2.2:1234"))
+	assert.False(t, AddrInList("", "192.0.2.1:1234"))
+	assert.False(t, AddrInList(list, "invalid"))
+}
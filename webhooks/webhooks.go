@@ -0,0 +1,198 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Event types sent to the webhooks
+const (
+	RouteChange  = "route.change"  // a route or redirect was changed using the API
+	Compile      = "compile"       // a compile job finished
+	CertRenew    = "cert.renew"    // certificates were replaced by a compile or reload
+	CertExpiry   = "cert.expiry"   // a certificate expires soon
+	DomainChange = "domain.change" // a domain was added, removed, enabled or disabled
+)
+
+// Headers added to each delivery
+const (
+	EventHeader     = "X-Violet-Event"
+	DeliveryHeader  = "X-Violet-Delivery"
+	SignatureHeader = "X-Violet-Signature"
+)
+
+var ErrInvalidHook = errors.New("invalid webhook")
+
+// Hook is a url which receives a signed JSON POST for the selected events
+type Hook struct {
+	Url    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"` // key for the HMAC-SHA256 signature, empty sends unsigned requests
+	Events []string `json:"events,omitempty"` // event types to send, empty sends every event
+}
+
+// Validate checks the url and event types
+func (h Hook) Validate() error {
+	u, err := url.Parse(h.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url '%s' must be http or https", ErrInvalidHook, h.Url)
+	}
+	for _, i := range h.Events {
+		switch i {
+		case RouteChange, Compile, CertRenew, CertExpiry, DomainChange:
+		default:
+			return fmt.Errorf("%w: unknown event '%s'", ErrInvalidHook, i)
+		}
+	}
+	return nil
+}
+
+// wants returns true if the hook receives the event type
+func (h Hook) wants(t string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, i := range h.Events {
+		if i == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the body of each delivery
+type Event struct {
+	Id   int64     `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// Webhooks sends events to the configured hooks, each hook has a queue so
+// deliveries arrive in order and failed deliveries are retried with backoff.
+type Webhooks struct {
+	client  *http.Client
+	queues  []hookQueue
+	id      atomic.Int64
+	retries int
+	backoff time.Duration
+}
+
+type hookQueue struct {
+	hook Hook
+	ch   chan Event
+}
+
+// defaultClient is used to send webhooks
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// New validates the hooks and starts a delivery goroutine for each one, nil is
+// returned if there are no hooks.
+func New(hooks []Hook) (*Webhooks, error) {
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	w := &Webhooks{client: defaultClient, queues: make([]hookQueue, len(hooks)), retries: 5, backoff: 2 * time.Second}
+	for i, h := range hooks {
+		if err := h.Validate(); err != nil {
+			return nil, err
+		}
+		w.queues[i] = hookQueue{hook: h, ch: make(chan Event, 64)}
+	}
+	for _, q := range w.queues {
+		go w.run(q)
+	}
+	return w, nil
+}
+
+// Send queues the event for each hook which receives the event type, a nil
+// Webhooks ignores events.
+//
+// Events are dropped if a hook has too many pending deliveries.
+func (w *Webhooks) Send(t string, data any) {
+	if w == nil {
+		return
+	}
+	e := Event{Id: w.id.Add(1), Type: t, Time: time.Now().UTC(), Data: data}
+	for _, q := range w.queues {
+		if !q.hook.wants(t) {
+			continue
+		}
+		select {
+		case q.ch <- e:
+		default:
+			log.Printf("[Webhooks] Dropped '%s' event for '%s': queue is full\n", t, q.hook.Url)
+		}
+	}
+}
+
+// run delivers the queued events in order
+func (w *Webhooks) run(q hookQueue) {
+	for e := range q.ch {
+		body, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("[Webhooks] Failed to encode '%s' event: %s\n", e.Type, err)
+			continue
+		}
+		w.deliver(q.hook, e, body)
+	}
+}
+
+// deliver posts the event until the hook responds with a 2xx status or the
+// retries run out, the delay doubles after each attempt
+func (w *Webhooks) deliver(h Hook, e Event, body []byte) {
+	delay := w.backoff
+	for attempt := 0; ; attempt++ {
+		err := w.post(h, e, body)
+		if err == nil {
+			return
+		}
+		if attempt >= w.retries {
+			log.Printf("[Webhooks] Giving up on '%s' event %d for '%s': %s\n", e.Type, e.Id, h.Url, err)
+			return
+		}
+		log.Printf("[Webhooks] Failed to send '%s' event %d to '%s', retrying in %s: %s\n", e.Type, e.Id, h.Url, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (w *Webhooks) post(h Hook, e Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, e.Type)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(e.Id, 10))
+	if h.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(h.Secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for the body, receivers compare it
+// with the header using hmac.Equal.
+func Sign(secret string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
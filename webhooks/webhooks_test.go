@@ -0,0 +1,74 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHook_Validate(t *testing.T) {
+	assert.NoError(t, Hook{Url: "https://example.com/hook"}.Validate())
+	assert.NoError(t, Hook{Url: "http://127.0.0.1:8080", Events: []string{RouteChange, CertExpiry, DomainChange}}.Validate())
+	assert.ErrorIs(t, Hook{Url: "ftp://example.com"}.Validate(), ErrInvalidHook)
+	assert.ErrorIs(t, Hook{Url: "/hook"}.Validate(), ErrInvalidHook)
+	assert.ErrorIs(t, Hook{Url: "https://example.com", Events: []string{"route.delete"}}.Validate(), ErrInvalidHook)
+}
+
+func TestWebhooks_Send(t *testing.T) {
+	var nilHooks *Webhooks
+	nilHooks.Send(Compile, nil)
+
+	type received struct {
+		event string
+		body  Event
+	}
+	ch := make(chan received, 8)
+	fails := 2
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// fail the first attempts to check the retries
+		if fails > 0 {
+			fails--
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		var e Event
+		assert.NoError(t, json.Unmarshal(body, &e))
+		assert.Equal(t, Sign("abc", body), req.Header.Get(SignatureHeader))
+		ch <- received{req.Header.Get(EventHeader), e}
+	}))
+	defer srv.Close()
+
+	w, err := New([]Hook{{Url: srv.URL, Secret: "abc", Events: []string{RouteChange, Compile}}})
+	assert.NoError(t, err)
+	w.backoff = time.Millisecond
+
+	w.Send(CertExpiry, "ignored")
+	w.Send(RouteChange, map[string]string{"path": "/route/1"})
+	w.Send(Compile, nil)
+
+	for _, i := range []struct {
+		typ string
+		id  int64
+	}{{RouteChange, 2}, {Compile, 3}} {
+		select {
+		case r := <-ch:
+			assert.Equal(t, i.typ, r.event)
+			assert.Equal(t, i.typ, r.body.Type)
+			assert.Equal(t, i.id, r.body.Id)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for webhook")
+		}
+	}
+	assert.Equal(t, 0, fails)
+
+	_, err = New([]Hook{{Url: "example.com"}})
+	assert.ErrorIs(t, err, ErrInvalidHook)
+	w, err = New(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, w)
+}